@@ -0,0 +1,280 @@
+package data
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+	"strconv"
+)
+
+// Bits are numbered from the most significant bit of the first byte, like
+// Redis does for SETBIT and friends.
+
+// MaxBitOffset is the largest bit offset a string accepts, the last bit of a
+// 512MB string.
+const MaxBitOffset = 1<<32 - 1
+
+var ErrBitfieldType = errors.New("Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+
+// GetBit returns the bit at offset, 0 past the end of b.
+func GetBit(b []byte, offset uint64) int {
+	if offset/8 >= uint64(len(b)) {
+		return 0
+	}
+	return int(b[offset/8]>>(7-offset%8)) & 1
+}
+
+// SetBit sets the bit at offset to bit, growing b with zero bytes when
+// needed. It returns the updated slice and the previous bit.
+func SetBit(b []byte, offset uint64, bit int) ([]byte, int) {
+	b = grow(b, offset/8+1)
+	old := GetBit(b, offset)
+	mask := byte(1) << (7 - offset%8)
+	if bit == 1 {
+		b[offset/8] |= mask
+	} else {
+		b[offset/8] &^= mask
+	}
+	return b, old
+}
+
+func grow(b []byte, size uint64) []byte {
+	if uint64(len(b)) >= size {
+		return b
+	}
+	return append(b, make([]byte, size-uint64(len(b)))...)
+}
+
+// bitRange converts start and end, byte indexes or bit indexes when inBits is
+// set and negative ones counting from the end, to the first and last bit
+// offsets of the range. ok is false when the range is empty.
+func bitRange(b []byte, start, end int64, inBits bool) (first, last int64, ok bool) {
+	total := int64(len(b))
+	if inBits {
+		total *= 8
+	}
+	if start < 0 {
+		start = max(total+start, 0)
+	}
+	if end < 0 {
+		end = max(total+end, 0)
+	}
+	end = min(end, total-1)
+	if start > end {
+		return 0, 0, false
+	}
+	if inBits {
+		return start, end, true
+	}
+	return start * 8, end*8 + 7, true
+}
+
+// BitCount counts the set bits between start and end included, see
+// bitRange.
+func BitCount(b []byte, start, end int64, inBits bool) int {
+	first, last, ok := bitRange(b, start, end, inBits)
+	if !ok {
+		return 0
+	}
+	count := 0
+	for i := first; i <= last; {
+		if i%8 == 0 && i+7 <= last {
+			count += bits.OnesCount8(b[i/8])
+			i += 8
+			continue
+		}
+		count += GetBit(b, uint64(i))
+		i += 1
+	}
+	return count
+}
+
+// BitPos returns the offset of the first bit set to bit between start and
+// end, see bitRange, or -1 when there is none. When looking for a clear bit
+// without an explicit end, the bits past the end of b count as clear.
+func BitPos(b []byte, bit int, start, end int64, endGiven, inBits bool) int64 {
+	first, last, ok := bitRange(b, start, end, inBits)
+	if !ok {
+		return -1
+	}
+	// the byte that does not hold a single wanted bit
+	skip := byte(0)
+	if bit == 0 {
+		skip = 0xff
+	}
+	for i := first; i <= last; {
+		if i%8 == 0 && i+7 <= last && b[i/8] == skip {
+			i += 8
+			continue
+		}
+		if GetBit(b, uint64(i)) == bit {
+			return i
+		}
+		i += 1
+	}
+	if bit == 0 && !endGiven {
+		return last + 1
+	}
+	return -1
+}
+
+// BitOp computes AND, OR, XOR, NOT or DIFF over srcs byte by byte, shorter
+// sources being padded with zeros. NOT takes a single source, DIFF returns
+// the bits of the first source set in none of the others.
+func BitOp(op string, srcs [][]byte) []byte {
+	size := 0
+	for _, src := range srcs {
+		size = max(size, len(src))
+	}
+	byteAt := func(src []byte, i int) byte {
+		if i < len(src) {
+			return src[i]
+		}
+		return 0
+	}
+
+	dst := make([]byte, size)
+	for i := range dst {
+		switch op {
+		case "not":
+			dst[i] = ^byteAt(srcs[0], i)
+		case "and":
+			dst[i] = 0xff
+			for _, src := range srcs {
+				dst[i] &= byteAt(src, i)
+			}
+		case "or":
+			for _, src := range srcs {
+				dst[i] |= byteAt(src, i)
+			}
+		case "xor":
+			for _, src := range srcs {
+				dst[i] ^= byteAt(src, i)
+			}
+		case "diff":
+			others := byte(0)
+			for _, src := range srcs[1:] {
+				others |= byteAt(src, i)
+			}
+			dst[i] = byteAt(srcs[0], i) &^ others
+		}
+	}
+	return dst
+}
+
+// BitfieldType is the type of a BITFIELD integer: i1 to i64, u1 to u63.
+type BitfieldType struct {
+	Width  int
+	Signed bool
+}
+
+// BitfieldOverflow is how BITFIELD handles results out of the range of the
+// type.
+type BitfieldOverflow int
+
+const (
+	OverflowWrap BitfieldOverflow = iota
+	OverflowSat
+	OverflowFail
+)
+
+func ParseBitfieldType(s string) (BitfieldType, error) {
+	if len(s) < 2 || (s[0] != 'i' && s[0] != 'I' && s[0] != 'u' && s[0] != 'U') {
+		return BitfieldType{}, ErrBitfieldType
+	}
+	t := BitfieldType{Signed: s[0] == 'i' || s[0] == 'I'}
+	width, err := strconv.Atoi(s[1:])
+	if err != nil || width < 1 || (t.Signed && width > 64) || (!t.Signed && width > 63) {
+		return BitfieldType{}, ErrBitfieldType
+	}
+	t.Width = width
+	return t, nil
+}
+
+// GetBitfield reads the integer of type t stored at offset.
+func GetBitfield(b []byte, offset uint64, t BitfieldType) int64 {
+	var v uint64
+	for i := 0; i < t.Width; i += 1 {
+		v = v<<1 | uint64(GetBit(b, offset+uint64(i)))
+	}
+	if t.Signed && t.Width < 64 && v>>(t.Width-1)&1 == 1 {
+		// sign extension
+		v |= math.MaxUint64 << t.Width
+	}
+	return int64(v)
+}
+
+// SetBitfield writes the low bits of value as an integer of type t at
+// offset, growing b when needed.
+func SetBitfield(b []byte, offset uint64, t BitfieldType, value int64) []byte {
+	b = grow(b, (offset+uint64(t.Width)+7)/8)
+	for i := 0; i < t.Width; i += 1 {
+		bit := int(uint64(value)>>(t.Width-1-i)) & 1
+		b, _ = SetBit(b, offset+uint64(i), bit)
+	}
+	return b
+}
+
+// Add returns value plus incr handled with the overflow mode, as BITFIELD
+// INCRBY and SET do. ok is false when the result overflows with
+// OverflowFail.
+func (t BitfieldType) Add(value, incr int64, overflow BitfieldOverflow) (result int64, ok bool) {
+	if t.Signed {
+		return t.addSigned(value, incr, overflow)
+	}
+	return t.addUnsigned(uint64(value), incr, overflow)
+}
+
+// addSigned mirrors checkSignedBitfieldOverflow of Redis.
+func (t BitfieldType) addSigned(value, incr int64, overflow BitfieldOverflow) (int64, bool) {
+	maxValue := int64(math.MaxInt64)
+	if t.Width < 64 {
+		maxValue = 1<<(t.Width-1) - 1
+	}
+	minValue := -maxValue - 1
+	maxIncr, minIncr := maxValue-value, minValue-value
+
+	up := value > maxValue || (t.Width != 64 && incr > maxIncr) || (value >= 0 && incr > 0 && incr > maxIncr)
+	down := value < minValue || (t.Width != 64 && incr < minIncr) || (value < 0 && incr < 0 && incr < minIncr)
+	switch {
+	case !up && !down:
+		return value + incr, true
+	case overflow == OverflowFail:
+		return 0, false
+	case overflow == OverflowSat && up:
+		return maxValue, true
+	case overflow == OverflowSat:
+		return minValue, true
+	}
+	// wrap around: keep the low bits and extend the sign
+	sum := uint64(value) + uint64(incr)
+	if t.Width < 64 {
+		mask := uint64(math.MaxUint64) << t.Width
+		if sum&(1<<(t.Width-1)) != 0 {
+			sum |= mask
+		} else {
+			sum &^= mask
+		}
+	}
+	return int64(sum), true
+}
+
+// addUnsigned mirrors checkUnsignedBitfieldOverflow of Redis.
+func (t BitfieldType) addUnsigned(value uint64, incr int64, overflow BitfieldOverflow) (int64, bool) {
+	maxValue := uint64(1)<<t.Width - 1
+	maxIncr, minIncr := int64(maxValue-value), -int64(value)
+
+	up := value > maxValue || (incr > 0 && incr > maxIncr)
+	down := incr < 0 && incr < minIncr
+	switch {
+	case !up && !down:
+		return int64(value + uint64(incr)), true
+	case overflow == OverflowFail:
+		return 0, false
+	case overflow == OverflowSat && up:
+		return int64(maxValue), true
+	case overflow == OverflowSat:
+		return 0, true
+	}
+	return int64((value + uint64(incr)) & maxValue), true
+}
@@ -0,0 +1,136 @@
+package data
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSetBit(t *testing.T) {
+	b, old := SetBit(nil, 7, 1)
+	if old != 0 || !slices.Equal(b, []byte{0x01}) {
+		t.Errorf("SetBit(nil, 7, 1) = %x, %d", b, old)
+	}
+	b, _ = SetBit(b, 17, 1)
+	if !slices.Equal(b, []byte{0x01, 0x00, 0x40}) {
+		t.Errorf("SetBit() should grow the string, got %x", b)
+	}
+	b, old = SetBit(b, 7, 0)
+	if old != 1 || GetBit(b, 7) != 0 || GetBit(b, 17) != 1 || GetBit(b, 1000) != 0 {
+		t.Errorf("SetBit(b, 7, 0) = %x, %d", b, old)
+	}
+}
+
+func TestBitCount(t *testing.T) {
+	b := []byte("foobar")
+	tests := []struct {
+		start, end int64
+		inBits     bool
+		want       int
+	}{
+		{0, -1, false, 26},
+		{0, 0, false, 4},
+		{1, 1, false, 6},
+		{1, 1, true, 1},
+		{5, 30, true, 17},
+		{-2, -1, false, 7},
+		{3, 1, false, 0},
+		{-100, 100, false, 26},
+	}
+	for _, tt := range tests {
+		if got := BitCount(b, tt.start, tt.end, tt.inBits); got != tt.want {
+			t.Errorf("BitCount(%d, %d, %v) = %d, want %d", tt.start, tt.end, tt.inBits, got, tt.want)
+		}
+	}
+}
+
+func TestBitPos(t *testing.T) {
+	tests := []struct {
+		b          []byte
+		bit        int
+		start, end int64
+		endGiven   bool
+		inBits     bool
+		want       int64
+	}{
+		{[]byte{0xff, 0xf0, 0x00}, 0, 0, -1, false, false, 12},
+		{[]byte{0x00, 0xff, 0xf0}, 1, 0, -1, false, false, 8},
+		{[]byte{0x00, 0xff, 0xf0}, 1, 2, -1, false, false, 16},
+		{[]byte{0x00, 0xff, 0xf0}, 1, 7, 15, true, true, 8},
+		{[]byte{0xff, 0xff}, 0, 0, -1, false, false, 16},
+		{[]byte{0xff, 0xff}, 0, 0, -1, true, false, -1},
+		{[]byte{0x00}, 1, 0, -1, false, false, -1},
+	}
+	for _, tt := range tests {
+		if got := BitPos(tt.b, tt.bit, tt.start, tt.end, tt.endGiven, tt.inBits); got != tt.want {
+			t.Errorf("BitPos(%x, %d, %d, %d) = %d, want %d", tt.b, tt.bit, tt.start, tt.end, got, tt.want)
+		}
+	}
+}
+
+func TestBitOp(t *testing.T) {
+	a, b := []byte{0xf0, 0x0f}, []byte{0xff}
+	tests := []struct {
+		op   string
+		srcs [][]byte
+		want []byte
+	}{
+		{"and", [][]byte{a, b}, []byte{0xf0, 0x00}},
+		{"or", [][]byte{a, b}, []byte{0xff, 0x0f}},
+		{"xor", [][]byte{a, b}, []byte{0x0f, 0x0f}},
+		{"not", [][]byte{a}, []byte{0x0f, 0xf0}},
+		{"diff", [][]byte{a, b}, []byte{0x00, 0x0f}},
+	}
+	for _, tt := range tests {
+		if got := BitOp(tt.op, tt.srcs); !slices.Equal(got, tt.want) {
+			t.Errorf("BitOp(%s) = %x, want %x", tt.op, got, tt.want)
+		}
+	}
+}
+
+func TestBitfield(t *testing.T) {
+	i8, _ := ParseBitfieldType("i8")
+	u4, _ := ParseBitfieldType("u4")
+	i64, _ := ParseBitfieldType("i64")
+	for _, s := range []string{"u64", "i65", "x8", "i0", "u"} {
+		if _, err := ParseBitfieldType(s); err == nil {
+			t.Errorf("ParseBitfieldType(%s) should fail", s)
+		}
+	}
+
+	b := SetBitfield(nil, 4, i8, -2)
+	if !slices.Equal(b, []byte{0x0f, 0xe0}) {
+		t.Errorf("SetBitfield() = %x", b)
+	}
+	if got := GetBitfield(b, 4, i8); got != -2 {
+		t.Errorf("GetBitfield(i8) = %d, want -2", got)
+	}
+	if got := GetBitfield(b, 4, u4); got != 15 {
+		t.Errorf("GetBitfield(u4) = %d, want 15", got)
+	}
+
+	tests := []struct {
+		typ         BitfieldType
+		value, incr int64
+		overflow    BitfieldOverflow
+		want        int64
+		ok          bool
+	}{
+		{i8, 100, 27, OverflowWrap, 127, true},
+		{i8, 100, 28, OverflowWrap, -128, true},
+		{i8, 100, 28, OverflowSat, 127, true},
+		{i8, -100, -29, OverflowSat, -128, true},
+		{i8, 100, 28, OverflowFail, 0, false},
+		{u4, 15, 1, OverflowWrap, 0, true},
+		{u4, 2, -3, OverflowWrap, 15, true},
+		{u4, 2, -3, OverflowSat, 0, true},
+		{u4, 20, 0, OverflowSat, 15, true},
+		{i64, 1<<63 - 1, 1, OverflowWrap, -1 << 63, true},
+		{i64, 1<<63 - 1, 1, OverflowFail, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := tt.typ.Add(tt.value, tt.incr, tt.overflow)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%+v.Add(%d, %d, %d) = %d, %v, want %d, %v", tt.typ, tt.value, tt.incr, tt.overflow, got, ok, tt.want, tt.ok)
+		}
+	}
+}
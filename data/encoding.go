@@ -0,0 +1,73 @@
+package data
+
+import "strconv"
+
+// EncodingLimits are the sizes up to which collections keep a compact
+// encoding, like Redis' *-max-listpack-* and set-max-intset-entries
+// parameters. A collection growing past them converts to its general
+// encoding, and keeps it even when it shrinks back.
+type EncodingLimits struct {
+	HashMaxListpackEntries int
+	HashMaxListpackValue   int
+	// ListMaxListpackSize bounds a packed list to that many elements when
+	// positive, or when negative to 4KB for -1 doubling up to 64KB for -5
+	ListMaxListpackSize int
+	// ListCompressDepth is how many chunks at each end of a list are left
+	// uncompressed, 0 for none to be compressed
+	ListCompressDepth      int
+	SetMaxIntsetEntries    int
+	ZsetMaxListpackEntries int
+	ZsetMaxListpackValue   int
+}
+
+// Limits are the encoding limits every collection checks as it grows. They
+// are changed with CONFIG SET.
+var Limits = EncodingLimits{
+	HashMaxListpackEntries: 128,
+	HashMaxListpackValue:   64,
+	ListMaxListpackSize:    -2,
+	ListCompressDepth:      0,
+	SetMaxIntsetEntries:    512,
+	ZsetMaxListpackEntries: 128,
+	ZsetMaxListpackValue:   64,
+}
+
+// listFits reports whether a packed list of count elements taking bytes
+// stays within ListMaxListpackSize.
+func (l EncodingLimits) listFits(count int, bytes int) bool {
+	if l.ListMaxListpackSize >= 0 {
+		return count <= max(l.ListMaxListpackSize, 1)
+	}
+	level := min(-l.ListMaxListpackSize, 5)
+	return bytes <= 4096<<(level-1)
+}
+
+// listpackEntrySize estimates the bytes an element of n bytes takes in a
+// listpack: its encoding header, the data and the back length.
+func listpackEntrySize(n int) int {
+	size := n + 1
+	switch {
+	case n >= 4096:
+		size += 4
+	case n >= 64:
+		size += 1
+	}
+	backlen := 1
+	for s := size >> 7; s > 0; s >>= 7 {
+		backlen += 1
+	}
+	return size + backlen
+}
+
+// parseIntsetMember returns the integer member stands for when it is one
+// an intset can hold, in its canonical decimal form.
+func parseIntsetMember(member string) (int64, bool) {
+	if len(member) == 0 || len(member) > 20 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(member, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != member {
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,292 @@
+package data
+
+import (
+	"bytes"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// withLimits changes the encoding limits for the rest of the test.
+func withLimits(t *testing.T, change func(l *EncodingLimits)) {
+	saved := Limits
+	change(&Limits)
+	t.Cleanup(func() { Limits = saved })
+}
+
+func TestSet_Intset(t *testing.T) {
+	withLimits(t, func(l *EncodingLimits) { l.SetMaxIntsetEntries = 4 })
+
+	s := NewSet()
+	for _, m := range []string{"3", "-1", "2", "3"} {
+		s.Add(m)
+	}
+	if got := s.Encoding(); got != "intset" {
+		t.Fatalf("Encoding() = %s, expected intset", got)
+	}
+	if !s.Contains("-1") || s.Contains("1") || s.Contains("02") {
+		t.Errorf("Contains() does not match the members %v", s.Members())
+	}
+	if s.Remove("02") || !s.Remove("2") || s.Len() != 2 {
+		t.Errorf("Remove() left %v", s.Members())
+	}
+
+	tests := []struct {
+		name   string
+		member string
+	}{
+		{"not an integer", "a"},
+		{"not canonical", "007"},
+		{"past the limit", "100"},
+	}
+	for _, tt := range tests {
+		s := NewSet()
+		for i := range 4 {
+			s.Add(strconv.Itoa(i))
+		}
+		s.Add(tt.member)
+		if got := s.Encoding(); got != "hashtable" {
+			t.Errorf("%s: Encoding() = %s, expected hashtable", tt.name, got)
+		}
+		if s.Len() != 5 || !s.Contains(tt.member) || !s.Contains("3") {
+			t.Errorf("%s: lost members converting, have %v", tt.name, s.Members())
+		}
+	}
+}
+
+func TestHash_Listpack(t *testing.T) {
+	withLimits(t, func(l *EncodingLimits) {
+		l.HashMaxListpackEntries = 3
+		l.HashMaxListpackValue = 8
+	})
+
+	h := NewHash()
+	h.Set("a", []byte("1"))
+	h.Set("b", []byte("2"))
+	h.Set("a", []byte("3"))
+	if got := h.Encoding(); got != "listpack" {
+		t.Fatalf("Encoding() = %s, expected listpack", got)
+	}
+	if v, _ := h.Get("a"); string(v) != "3" || h.Len() != 2 {
+		t.Errorf("Get(a) = %s with %d fields", v, h.Len())
+	}
+
+	long := h.Clone()
+	long.Set("c", []byte(strings.Repeat("x", 9)))
+	if got := long.Encoding(); got != "hashtable" {
+		t.Errorf("long value: Encoding() = %s, expected hashtable", got)
+	}
+
+	h.Set("c", []byte("4"))
+	h.Set("d", []byte("5"))
+	if got := h.Encoding(); got != "hashtable" {
+		t.Errorf("past the limit: Encoding() = %s, expected hashtable", got)
+	}
+	fields := h.Fields()
+	slices.Sort(fields)
+	if !slices.Equal(fields, []string{"a", "b", "c", "d"}) {
+		t.Errorf("Fields() = %v after converting", fields)
+	}
+	h.Delete("c")
+	h.Delete("d")
+	if got := h.Encoding(); got != "hashtable" {
+		t.Errorf("shrunk: Encoding() = %s, expected to stay hashtable", got)
+	}
+}
+
+func TestLinkedList_Listpack(t *testing.T) {
+	withLimits(t, func(l *EncodingLimits) { l.ListMaxListpackSize = 3 })
+
+	l := newList("a b c")
+	if got := l.Encoding(); got != "listpack" {
+		t.Fatalf("Encoding() = %s, expected listpack", got)
+	}
+	l.LeftPush([]byte("z"))
+	if got := l.Encoding(); got != "quicklist" {
+		t.Errorf("Encoding() = %s, expected quicklist", got)
+	}
+	if got := listString(l); got != "z a b c" {
+		t.Errorf("list is %q after converting", got)
+	}
+
+	withLimits(t, func(l *EncodingLimits) { l.ListMaxListpackSize = -1 })
+	l = NewLinkedList()
+	l.RightPush(make([]byte, 4000))
+	if got := l.Encoding(); got != "listpack" {
+		t.Errorf("Encoding() = %s, expected listpack under 4KB", got)
+	}
+	l.RightPush(make([]byte, 100))
+	if got := l.Encoding(); got != "quicklist" {
+		t.Errorf("Encoding() = %s, expected quicklist over 4KB", got)
+	}
+}
+
+// TestLinkedList_Chunks runs the same operations on lists cut in chunks of
+// different sizes, some of them compressed, expecting them to agree.
+func TestLinkedList_Chunks(t *testing.T) {
+	withLimits(t, func(*EncodingLimits) {})
+	lists := []struct {
+		list     *LinkedList
+		size     int
+		compress int
+	}{
+		{NewLinkedList(), 1 << 20, 0},
+		{NewLinkedList(), 1, 0},
+		{NewLinkedList(), 3, 1},
+		{NewLinkedList(), -1, 2},
+	}
+
+	chunks, compressed := 0, false
+	r := rand.New(rand.NewSource(1))
+	for i := range 5000 {
+		v := strings.Repeat(strconv.Itoa(r.Intn(5)), 20)
+		n := r.Intn(3)
+		lo, hi := r.Intn(200)-20, r.Intn(200)-20
+		for _, tt := range lists {
+			Limits.ListMaxListpackSize, Limits.ListCompressDepth = tt.size, tt.compress
+			l := tt.list
+			switch i % 10 {
+			case 0:
+				l.LeftPush([]byte(v))
+			case 1, 2, 3:
+				l.RightPush([]byte(v))
+			case 4:
+				l.LeftPop()
+			case 5:
+				l.Insert([]byte(v), []byte("x"), n == 0)
+			case 6:
+				l.Remove([]byte(v), n-1)
+			case 7:
+				l.LeftSet(lo, []byte(v+v))
+			case 8:
+				l.RightPop()
+			case 9:
+				if i%500 == 9 {
+					l.Trim(lo, -hi)
+				}
+			}
+		}
+
+		expected := listString(lists[0].list)
+		for _, tt := range lists[1:] {
+			if got := listString(tt.list); got != expected {
+				t.Fatalf("op %d: list in chunks of %d is %q, expected %q", i, tt.size, got, expected)
+			}
+			if tt.list.Len() != lists[0].list.Len() {
+				t.Fatalf("op %d: list in chunks of %d has length %d", i, tt.size, tt.list.Len())
+			}
+			start, stop := min(lo, hi), max(lo, hi)
+			if got, expected := tt.list.LeftRange(start, stop), lists[0].list.LeftRange(start, stop); !slices.EqualFunc(got, expected, bytes.Equal) {
+				t.Fatalf("op %d: LeftRange(%d, %d) in chunks of %d differs", i, start, stop, tt.size)
+			}
+			if !slices.Equal(tt.list.Positions([]byte(v), -1, 0, 0), lists[0].list.Positions([]byte(v), -1, 0, 0)) {
+				t.Fatalf("op %d: Positions() in chunks of %d differ", i, tt.size)
+			}
+			chunks = max(chunks, tt.list.chunks)
+			front, back := tt.list.head, tt.list.tail
+			for range tt.compress {
+				if front != nil && (front.compressed != nil || back.compressed != nil) {
+					t.Fatalf("op %d: a chunk within %d of the ends is compressed", i, tt.compress)
+				}
+				if front != nil {
+					front, back = front.next, back.prev
+				}
+			}
+			for c := tt.list.head; c != nil; c = c.next {
+				compressed = compressed || c.compressed != nil
+			}
+		}
+	}
+	if chunks < 10 {
+		t.Errorf("lists were cut in at most %d chunks", chunks)
+	}
+	if !compressed {
+		t.Errorf("no chunk was ever compressed")
+	}
+}
+
+func TestSortedSet_Listpack(t *testing.T) {
+	withLimits(t, func(l *EncodingLimits) {
+		l.ZsetMaxListpackEntries = 3
+		l.ZsetMaxListpackValue = 8
+	})
+
+	s := NewSortedSet[float64]()
+	s.Insert("b", 2)
+	s.Insert("a", 1)
+	s.Insert("c", 2)
+	if got := s.Encoding(); got != "listpack" {
+		t.Fatalf("Encoding() = %s, expected listpack", got)
+	}
+	if rank, _ := s.Rank("c"); rank != 2 {
+		t.Errorf("Rank(c) = %d, expected 2", rank)
+	}
+
+	long := s.Clone()
+	long.Remove("a")
+	long.Insert(strings.Repeat("x", 9), 0)
+	if got := long.Encoding(); got != "skiplist" {
+		t.Errorf("long member: Encoding() = %s, expected skiplist", got)
+	}
+
+	s.Insert("d", 0)
+	if got := s.Encoding(); got != "skiplist" {
+		t.Errorf("past the limit: Encoding() = %s, expected skiplist", got)
+	}
+	s.PopMin(2)
+	if got := s.Clone().Encoding(); got != "skiplist" {
+		t.Errorf("Clone().Encoding() = %s, expected skiplist", got)
+	}
+	if got := s.Range(0, s.Len()); len(got) != 2 || got[0].Value != "b" || got[1].Value != "c" {
+		t.Errorf("Range() = %v after converting", got)
+	}
+}
+
+// TestSortedSet_Encodings runs the same operations on a sorted set in either
+// encoding, expecting them to agree.
+func TestSortedSet_Encodings(t *testing.T) {
+	withLimits(t, func(l *EncodingLimits) { l.ZsetMaxListpackEntries = 1 << 20 })
+	packed := NewSortedSet[float64]()
+	skiplist := NewSortedSet[float64]()
+	skiplist.toSkiplist()
+
+	r := rand.New(rand.NewSource(1))
+	for i := range 3000 {
+		member := strconv.Itoa(r.Intn(60))
+		score := float64(r.Intn(10))
+		lo, hi := float64(r.Intn(10)), float64(r.Intn(10))
+		for _, s := range []*SortedSet[float64]{packed, skiplist} {
+			switch i % 6 {
+			case 0, 1:
+				s.Insert(member, score)
+			case 2:
+				s.Remove(member)
+			case 3:
+				s.IncrementScore(member, score)
+			case 4:
+				s.RemoveByScore(ScoreRange[float64]{Min: lo, Max: hi})
+			case 5:
+				s.PopMax(1)
+			}
+		}
+
+		got, expected := packed.Range(0, packed.Len()), skiplist.Range(0, skiplist.Len())
+		if !slices.Equal(got, expected) {
+			t.Fatalf("op %d: listpack has %v, skip list has %v", i, got, expected)
+		}
+		gotRank, _ := packed.ReverseRank(member)
+		expectedRank, _ := skiplist.ReverseRank(member)
+		if gotRank != expectedRank {
+			t.Fatalf("op %d: ReverseRank(%s) = %d in the listpack, %d in the skip list", i, member, gotRank, expectedRank)
+		}
+		r := ScoreRange[float64]{Min: lo, Max: hi}
+		if packed.CountByScore(r) != skiplist.CountByScore(r) {
+			t.Fatalf("op %d: CountByScore(%v) differs between the encodings", i, r)
+		}
+	}
+	if got := packed.Encoding(); got != "listpack" {
+		t.Errorf("Encoding() = %s, expected listpack", got)
+	}
+}
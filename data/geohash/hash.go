@@ -3,11 +3,16 @@ package geohash
 // Encode generates a geohash as uint64 for the given latitude, longitude and number of bits
 // Maximum bits is 52 (to fit in uint64 without precision loss in interleaving)
 func Encode(lat, lon float64, bits int) uint64 {
+	return encode(lat, lon, bits, 85.05112878)
+}
+
+// encode is Encode over latitudes from -maxLat to maxLat
+func encode(lat, lon float64, bits int, maxLat float64) uint64 {
 	if bits > 52 {
 		bits = 52
 	}
 
-	latRange := [2]float64{-85.05112878, 85.05112878}
+	latRange := [2]float64{-maxLat, maxLat}
 	lonRange := [2]float64{-180.0, 180.0}
 
 	var hash uint64
@@ -79,6 +84,24 @@ func Decode(hash uint64, bits int) (lat, lon float64) {
 	return
 }
 
+const base32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// String returns the standard 11 character geohash of the given latitude
+// and longitude. Unlike Encode it covers latitudes up to the poles, and the
+// last character, past the 52 bits, is always '0' as in Redis' GEOHASH
+func String(lat, lon float64) string {
+	hash := encode(lat, lon, 52, 90)
+	buf := make([]byte, 11)
+	for i := range buf {
+		idx := uint64(0)
+		if i < 10 {
+			idx = hash >> (52 - (i+1)*5) & 0x1f
+		}
+		buf[i] = base32[idx]
+	}
+	return string(buf)
+}
+
 // EncodeLat encodes latitude into binary representation with given precision
 func EncodeLat(lat float64, precision int) uint64 {
 	return encodeBinary(lat, -85.05112878, 85.05112878, precision)
@@ -277,3 +277,19 @@ func TestEncode_Paris(t *testing.T) {
 
 	t.Logf("Paris (%.7f, %.7f): hash=%d (binary: %052b)", lat, lon, hash, hash)
 }
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		lat, lon float64
+		expected string
+	}{
+		{38.115556, 13.361389, "sqc8b49rny0"},
+		{37.502669, 15.087269, "sqdtr74hyu0"},
+		{-90, -180, "00000000000"},
+	}
+	for _, tt := range tests {
+		if got := geohash.String(tt.lat, tt.lon); got != tt.expected {
+			t.Errorf("String(%v, %v) = %s, expected %s", tt.lat, tt.lon, got, tt.expected)
+		}
+	}
+}
@@ -1,7 +1,11 @@
 package data
 
 import (
+	"cmp"
+	"container/heap"
 	"fmt"
+	"math"
+	"slices"
 
 	"github.com/ttn-nguyen42/gedis/data/geohash"
 	"github.com/ttn-nguyen42/gedis/util"
@@ -39,10 +43,10 @@ func (i *GeoIndex) add(key string, lat, lon float64) bool {
 }
 
 func (i *GeoIndex) validate(lat, lon float64) error {
-	if lat <= -85.05112878 || lat >= 85.05112878 {
+	if lat < -85.05112878 || lat > 85.05112878 {
 		return fmt.Errorf("invalid latitude value")
 	}
-	if lon <= -180.0 || lon >= 180.0 {
+	if lon < -180.0 || lon > 180.0 {
 		return fmt.Errorf("invalid longitude value")
 	}
 	return nil
@@ -59,6 +63,15 @@ func (i *GeoIndex) Get(key string) (lat, lon float64, ok bool) {
 	return lat, lon, true
 }
 
+// Hash returns the standard geohash string of the position of key.
+func (i *GeoIndex) Hash(key string) (string, bool) {
+	lat, lon, ok := i.Get(key)
+	if !ok {
+		return "", false
+	}
+	return geohash.String(lat, lon), true
+}
+
 func (i *GeoIndex) Dist(key1 string, key2 string) (float64, error) {
 	k1Hash, ok := i.set.Score(key1)
 	if !ok {
@@ -78,55 +91,213 @@ func (i *GeoIndex) SortedSet() *SortedSet[float64] {
 	return i.set
 }
 
+// SearchRadius returns the members within radius meters of lat, lon.
 func (i *GeoIndex) SearchRadius(lat, lon, radius float64) ([]string, error) {
 	err := i.validate(lat, lon)
 	if err != nil {
 		return nil, err
 	}
-	return i.searchRadius(lat, lon, radius)
+	found := i.Search(GeoQuery{Lat: lat, Lon: lon, Radius: radius})
+	results := make([]string, len(found))
+	for idx, result := range found {
+		results[idx] = result.Member
+	}
+	return results, nil
 }
 
-func (i *GeoIndex) searchRadius(lat, lon, radius float64) ([]string, error) {
-	// Calculate appropriate precision for the search radius
-	// We use a coarser precision to create larger search boxes
-	searchBits := geohash.EstimateBitsForRadius(radius, i.bits)
+// GeoSort is the order of the results of a search, by distance.
+type GeoSort int
 
-	centerHash := geohash.Encode(lat, lon, searchBits)
+const (
+	GeoUnsorted GeoSort = iota
+	GeoAsc
+	GeoDesc
+)
 
-	// Get all 8 neighbors plus center (9 cells total)
-	neighbors := geohash.Neighbors(centerHash, searchBits)
-	searchHashes := append([]uint64{centerHash}, neighbors...)
+// GeoQuery is a search around Lat, Lon, in a circle of Radius meters or,
+// when Box is set, in a box of Width by Height meters.
+type GeoQuery struct {
+	Lat, Lon      float64
+	Radius        float64
+	Box           bool
+	Width, Height float64
+	// Count bounds the results to the nearest ones, or the farthest when
+	// sorted in descending order, unless it is zero. With Any, the first
+	// Count members found are returned instead.
+	Count int
+	Any   bool
+	Sort  GeoSort
+}
+
+// GeoResult is a member found by a search, with its distance in meters from
+// the center of the search.
+type GeoResult struct {
+	Member   string
+	Dist     float64
+	Hash     uint64
+	Lat, Lon float64
+}
+
+// Search returns the members within the area of q. Bounded by a Count they
+// are sorted ascending by default, otherwise in index order unless q.Sort
+// asks for an order.
+func (i *GeoIndex) Search(q GeoQuery) []GeoResult {
+	if q.Count > 0 && !q.Any && q.Sort == GeoUnsorted {
+		q.Sort = GeoAsc
+	}
+	// with a count, the worst of the results kept so far is at the top of
+	// the heap, to be dropped for a better one
+	results := &geoHeap{desc: q.Sort == GeoDesc}
+	bounded := q.Count > 0 && !q.Any
+
+	for _, cell := range i.cells(q) {
+		for _, node := range i.set.RangeByScore(cell, false, 0, -1) {
+			hash := uint64(node.Score)
+			lat, lon := geohash.Decode(hash, i.bits)
+			dist, ok := q.contains(lat, lon)
+			if !ok {
+				continue
+			}
+			result := GeoResult{Member: node.Value, Dist: dist, Hash: hash, Lat: lat, Lon: lon}
+			if !bounded {
+				results.items = append(results.items, result)
+				if q.Any && len(results.items) == q.Count {
+					return sortGeoResults(results.items, q.Sort)
+				}
+				continue
+			}
+			if len(results.items) < q.Count {
+				heap.Push(results, result)
+			} else if results.better(result, results.items[0]) {
+				results.items[0] = result
+				heap.Fix(results, 0)
+			}
+		}
+	}
+	return sortGeoResults(results.items, q.Sort)
+}
+
+// contains returns the distance from the center of q to lat, lon when it is
+// within the area of q. A box is measured along the latitude of the point,
+// as Redis does.
+func (q GeoQuery) contains(lat, lon float64) (float64, bool) {
+	if !q.Box {
+		dist := util.Haversine(q.Lat, q.Lon, lat, lon)
+		return dist, dist <= q.Radius
+	}
+	if util.Haversine(q.Lat, lon, lat, lon) > q.Height/2 {
+		return 0, false
+	}
+	if util.Haversine(lat, q.Lon, lat, lon) > q.Width/2 {
+		return 0, false
+	}
+	return util.Haversine(q.Lat, q.Lon, lat, lon), true
+}
 
-	// Calculate how many bits to shift to get from searchBits to full 52 bits
-	shift := i.bits - searchBits
+// bounds returns the latitudes and longitudes bounding the area of q, the
+// longitudes possibly past ±180.
+func (q GeoQuery) bounds() (minLat, maxLat, minLon, maxLon float64) {
+	halfHeight, halfWidth := q.Radius, q.Radius
+	if q.Box {
+		halfHeight, halfWidth = q.Height/2, q.Width/2
+	}
+	latDelta := halfHeight / util.EARTH_RADIUS_IN_METERS / util.DR
+	// a box is widest on the side nearer to the pole
+	nearPole := math.Min(math.Abs(q.Lat)+latDelta, 90)
+	lonDelta := 360.0
+	if cos := math.Cos(nearPole * util.DR); cos > 0 {
+		lonDelta = math.Min(halfWidth/util.EARTH_RADIUS_IN_METERS/cos/util.DR, 360)
+	}
+	return q.Lat - latDelta, q.Lat + latDelta, q.Lon - lonDelta, q.Lon + lonDelta
+}
 
-	candidates := make(map[string]struct{})
+// cells returns the score ranges of the geohash cells covering the area of
+// q, at the finest step where the area spans at most two cells each way.
+func (i *GeoIndex) cells(q GeoQuery) []ScoreRange[float64] {
+	const mercatorLatRange = 2 * 85.05112878
+	minLat, maxLat, minLon, maxLon := q.bounds()
 
-	for _, hash := range searchHashes {
-		// Create a score range for this geohash box
-		// The box represents all 52-bit hashes that start with this coarse hash
-		minScore := float64(hash << shift)
-		maxScore := float64((hash + 1) << shift)
+	step := i.bits / 2
+	for step > 1 && (360/float64(uint64(1)<<step) < maxLon-minLon ||
+		mercatorLatRange/float64(uint64(1)<<step) < maxLat-minLat) {
+		step -= 1
+	}
+	size := uint64(1) << step
 
-		// Query the sorted set for all points in this score range
-		nodes := i.set.RangeByScore(minScore, maxScore)
-		for _, node := range nodes {
-			candidates[node.Value] = struct{}{}
+	// columns from fromColumn on, wrapping around the antimeridian
+	fromColumn, columns := uint64(0), size
+	if maxLon-minLon < 360 {
+		if minLon < -180 {
+			minLon += 360
+		}
+		if maxLon >= 180 {
+			maxLon -= 360
 		}
+		toColumn := geohash.EncodeLon(maxLon, step)
+		fromColumn = geohash.EncodeLon(minLon, step)
+		columns = (toColumn-fromColumn+size)%size + 1
 	}
+	fromRow, toRow := geohash.EncodeLat(minLat, step), geohash.EncodeLat(maxLat, step)
 
-	var results []string
-	for key := range candidates {
-		keyLat, keyLon, ok := i.Get(key)
-		if !ok {
-			continue
+	shift := i.bits - 2*step
+	cells := make([]ScoreRange[float64], 0, columns*(toRow-fromRow+1))
+	for c := range columns {
+		x := (fromColumn + c) % size
+		for y := fromRow; y <= toRow; y += 1 {
+			hash := interleave(x, y, step)
+			cells = append(cells, ScoreRange[float64]{
+				Min:   float64(hash << shift),
+				Max:   float64((hash + 1) << shift),
+				MaxEx: true,
+			})
 		}
+	}
+	return cells
+}
 
-		distance := util.Haversine(lat, lon, keyLat, keyLon)
-		if distance <= radius {
-			results = append(results, key)
+// interleave returns the geohash of step bits each way of the cell at column
+// x and row y, longitude bits first as geohash.Encode does.
+func interleave(x, y uint64, step int) uint64 {
+	var hash uint64
+	for bit := step - 1; bit >= 0; bit -= 1 {
+		hash = hash<<2 | (x>>bit&1)<<1 | y>>bit&1
+	}
+	return hash
+}
+
+func sortGeoResults(results []GeoResult, order GeoSort) []GeoResult {
+	if order == GeoUnsorted {
+		return results
+	}
+	slices.SortFunc(results, func(a, b GeoResult) int {
+		if order == GeoDesc {
+			a, b = b, a
 		}
+		return cmp.Or(cmp.Compare(a.Dist, b.Dist), cmp.Compare(a.Member, b.Member))
+	})
+	return results
+}
+
+// geoHeap keeps the worst of its results on top, the farthest unless desc.
+type geoHeap struct {
+	items []GeoResult
+	desc  bool
+}
+
+// better reports whether a comes before b in the order of the results.
+func (h *geoHeap) better(a, b GeoResult) bool {
+	if h.desc {
+		return a.Dist > b.Dist
 	}
+	return a.Dist < b.Dist
+}
 
-	return results, nil
+func (h *geoHeap) Len() int           { return len(h.items) }
+func (h *geoHeap) Less(i, j int) bool { return h.better(h.items[j], h.items[i]) }
+func (h *geoHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *geoHeap) Push(x any)         { h.items = append(h.items, x.(GeoResult)) }
+func (h *geoHeap) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
 }
@@ -1,10 +1,15 @@
 package data_test
 
 import (
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/ttn-nguyen42/gedis/data"
 	"github.com/ttn-nguyen42/gedis/data/geohash"
+	"github.com/ttn-nguyen42/gedis/util"
 )
 
 func TestGeoIndex_SearchRadius(t *testing.T) {
@@ -99,3 +104,90 @@ func abs(x float64) float64 {
 	}
 	return x
 }
+
+func TestGeoIndex_SearchMatchesScan(t *testing.T) {
+	geoIndex := data.NewGeoIndex(52)
+	r := rand.New(rand.NewSource(1))
+	for i := range 3000 {
+		lat := r.Float64()*170 - 85
+		lon := r.Float64()*359.98 - 179.99
+		if _, err := geoIndex.Add(strconv.Itoa(i), lat, lon); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	centers := [][2]float64{{48.85, 2.35}, {0, 179.9}, {-10, -179.5}, {84, 30}, {-83, -120}}
+	for _, center := range centers {
+		for _, size := range []float64{1e4, 3e5, 2e6, 1.5e7} {
+			queries := []data.GeoQuery{
+				{Lat: center[0], Lon: center[1], Radius: size},
+				{Lat: center[0], Lon: center[1], Box: true, Width: size, Height: size / 2},
+			}
+			for _, q := range queries {
+				expected := make([]string, 0)
+				for _, node := range geoIndex.SortedSet().Range(0, geoIndex.SortedSet().Len()) {
+					lat, lon, _ := geoIndex.Get(node.Value)
+					if inArea(q, lat, lon) {
+						expected = append(expected, node.Value)
+					}
+				}
+				got := make([]string, 0)
+				for _, result := range geoIndex.Search(q) {
+					got = append(got, result.Member)
+				}
+				slices.Sort(expected)
+				slices.Sort(got)
+				if !slices.Equal(got, expected) {
+					t.Errorf("Search(%+v) found %d members, a scan finds %d", q, len(got), len(expected))
+				}
+			}
+		}
+	}
+}
+
+func inArea(q data.GeoQuery, lat, lon float64) bool {
+	if !q.Box {
+		return util.Haversine(q.Lat, q.Lon, lat, lon) <= q.Radius
+	}
+	return util.Haversine(q.Lat, lon, lat, lon) <= q.Height/2 &&
+		util.Haversine(lat, q.Lon, lat, lon) <= q.Width/2
+}
+
+func TestGeoIndex_SearchCount(t *testing.T) {
+	geoIndex := data.NewGeoIndex(52)
+	for i := range 10 {
+		geoIndex.Add(strconv.Itoa(i), 0, float64(i)*0.1)
+	}
+	members := func(results []data.GeoResult) string {
+		names := make([]string, len(results))
+		for i, result := range results {
+			names[i] = result.Member
+		}
+		return strings.Join(names, " ")
+	}
+
+	tests := []struct {
+		name     string
+		query    data.GeoQuery
+		expected string
+	}{
+		{"asc", data.GeoQuery{Sort: data.GeoAsc}, "0 1 2 3 4 5 6 7 8 9"},
+		{"desc", data.GeoQuery{Sort: data.GeoDesc}, "9 8 7 6 5 4 3 2 1 0"},
+		{"count sorts ascending", data.GeoQuery{Count: 3}, "0 1 2"},
+		{"count desc keeps the farthest", data.GeoQuery{Count: 3, Sort: data.GeoDesc}, "9 8 7"},
+		{"within radius", data.GeoQuery{Radius: 35000, Sort: data.GeoAsc}, "0 1 2 3"},
+	}
+	for _, tt := range tests {
+		q := tt.query
+		if q.Radius == 0 {
+			q.Radius = 1e6
+		}
+		if got := members(geoIndex.Search(q)); got != tt.expected {
+			t.Errorf("%s: Search() = %q, expected %q", tt.name, got, tt.expected)
+		}
+	}
+
+	if got := geoIndex.Search(data.GeoQuery{Radius: 1e6, Count: 4, Any: true}); len(got) != 4 {
+		t.Errorf("Search() with ANY found %d members, expected 4", len(got))
+	}
+}
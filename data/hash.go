@@ -0,0 +1,247 @@
+package data
+
+import (
+	"maps"
+	"slices"
+	"time"
+)
+
+// Hash maps fields to values, the container behind the H* commands. Fields
+// may have their own expiry, removed by EvictExpired.
+type Hash struct {
+	// entries hold the fields of a small hash, in the order they were
+	// added, until it outgrows Limits and fields takes over
+	entries []hashEntry
+	fields  map[string][]byte
+	expires map[string]time.Time
+	// nextExpiry is no later than the earliest time in expires, zero when
+	// no field has a TTL
+	nextExpiry time.Time
+}
+
+type hashEntry struct {
+	field string
+	value []byte
+}
+
+func NewHash() *Hash {
+	return &Hash{
+		entries: make([]hashEntry, 0),
+	}
+}
+
+// packed reports whether the hash still holds its fields in entries.
+func (h *Hash) packed() bool {
+	return h.fields == nil
+}
+
+func (h *Hash) find(field string) int {
+	for i := range h.entries {
+		if h.entries[i].field == field {
+			return i
+		}
+	}
+	return -1
+}
+
+// put stores value at field and reports whether the field is new, moving
+// the fields to the map once they no longer fit in entries.
+func (h *Hash) put(field string, value []byte) bool {
+	if h.packed() {
+		fits := len(field) <= Limits.HashMaxListpackValue && len(value) <= Limits.HashMaxListpackValue
+		i := h.find(field)
+		switch {
+		case i >= 0 && fits:
+			h.entries[i].value = value
+			return false
+		case i < 0 && fits && len(h.entries) < Limits.HashMaxListpackEntries:
+			h.entries = append(h.entries, hashEntry{field: field, value: value})
+			return true
+		}
+		h.fields = make(map[string][]byte, len(h.entries)+1)
+		for _, entry := range h.entries {
+			h.fields[entry.field] = entry.value
+		}
+		h.entries = nil
+	}
+	_, exists := h.fields[field]
+	h.fields[field] = value
+	return !exists
+}
+
+// remove deletes field, leaving its TTL to the caller.
+func (h *Hash) remove(field string) bool {
+	if h.packed() {
+		i := h.find(field)
+		if i < 0 {
+			return false
+		}
+		h.entries = slices.Delete(h.entries, i, i+1)
+		return true
+	}
+	_, exists := h.fields[field]
+	delete(h.fields, field)
+	return exists
+}
+
+// each calls fn with every field and its value until fn returns false.
+func (h *Hash) each(fn func(field string, value []byte) bool) {
+	if h.packed() {
+		for _, entry := range h.entries {
+			if !fn(entry.field, entry.value) {
+				return
+			}
+		}
+		return
+	}
+	for field, value := range h.fields {
+		if !fn(field, value) {
+			return
+		}
+	}
+}
+
+// Set stores value at field and reports whether the field is new. Like
+// HSET, it clears the TTL of the field.
+func (h *Hash) Set(field string, value []byte) bool {
+	delete(h.expires, field)
+	return h.put(field, value)
+}
+
+// Update stores value at field, keeping the TTL the field has, and reports
+// whether the field is new.
+func (h *Hash) Update(field string, value []byte) bool {
+	return h.put(field, value)
+}
+
+func (h *Hash) Get(field string) ([]byte, bool) {
+	if h.packed() {
+		if i := h.find(field); i >= 0 {
+			return h.entries[i].value, true
+		}
+		return nil, false
+	}
+	value, exists := h.fields[field]
+	return value, exists
+}
+
+func (h *Hash) Exists(field string) bool {
+	_, exists := h.Get(field)
+	return exists
+}
+
+func (h *Hash) Delete(field string) bool {
+	if !h.remove(field) {
+		return false
+	}
+	delete(h.expires, field)
+	return true
+}
+
+func (h *Hash) Len() int {
+	if h.packed() {
+		return len(h.entries)
+	}
+	return len(h.fields)
+}
+
+// Fields returns every field, in no particular order.
+func (h *Hash) Fields() []string {
+	fields := make([]string, 0, h.Len())
+	h.each(func(field string, _ []byte) bool {
+		fields = append(fields, field)
+		return true
+	})
+	return fields
+}
+
+// Clone returns a copy of the hash, in the same encoding. Values are shared,
+// not copied.
+func (h *Hash) Clone() *Hash {
+	clone := &Hash{
+		entries:    slices.Clone(h.entries),
+		fields:     maps.Clone(h.fields),
+		expires:    maps.Clone(h.expires),
+		nextExpiry: h.nextExpiry,
+	}
+	return clone
+}
+
+// RandomFields returns count distinct random fields, or every field when the
+// hash is smaller. A negative count returns -count fields that may repeat.
+func (h *Hash) RandomFields(count int) []string {
+	fields := h.Fields()
+	if count < 0 {
+		if len(fields) == 0 {
+			return []string{}
+		}
+		picked := make([]string, -count)
+		for i := range picked {
+			picked[i] = fields[seed.Intn(len(fields))]
+		}
+		return picked
+	}
+	if count >= len(fields) {
+		return fields
+	}
+	seed.Shuffle(len(fields), func(i, j int) {
+		fields[i], fields[j] = fields[j], fields[i]
+	})
+	return fields[:count]
+}
+
+// ExpireAt sets the time field expires at. It reports false when the field
+// does not exist.
+func (h *Hash) ExpireAt(field string, at time.Time) bool {
+	if !h.Exists(field) {
+		return false
+	}
+	if h.expires == nil {
+		h.expires = make(map[string]time.Time)
+	}
+	h.expires[field] = at
+	if h.nextExpiry.IsZero() || at.Before(h.nextExpiry) {
+		h.nextExpiry = at
+	}
+	return true
+}
+
+// ExpiresAt returns the time field expires at, if it has a TTL.
+func (h *Hash) ExpiresAt(field string) (time.Time, bool) {
+	at, exists := h.expires[field]
+	return at, exists
+}
+
+// Persist removes the TTL of field and reports whether it had one.
+func (h *Hash) Persist(field string) bool {
+	_, exists := h.expires[field]
+	delete(h.expires, field)
+	return exists
+}
+
+// Volatile reports whether some field has a TTL.
+func (h *Hash) Volatile() bool {
+	return len(h.expires) > 0
+}
+
+// EvictExpired removes the fields whose TTL has passed at now and returns
+// them. It is cheap when no field is due.
+func (h *Hash) EvictExpired(now time.Time) []string {
+	if h.nextExpiry.IsZero() || h.nextExpiry.After(now) {
+		return nil
+	}
+	evicted := make([]string, 0)
+	h.nextExpiry = time.Time{}
+	for field, at := range h.expires {
+		if !at.After(now) {
+			h.remove(field)
+			delete(h.expires, field)
+			evicted = append(evicted, field)
+			continue
+		}
+		if h.nextExpiry.IsZero() || at.Before(h.nextExpiry) {
+			h.nextExpiry = at
+		}
+	}
+	return evicted
+}
@@ -0,0 +1,93 @@
+package data
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestHash_SetGetDelete(t *testing.T) {
+	h := NewHash()
+	if !h.Set("a", []byte("1")) {
+		t.Error("Set should report a new field")
+	}
+	if h.Set("a", []byte("2")) {
+		t.Error("Set should not report an existing field as new")
+	}
+	if v, ok := h.Get("a"); !ok || string(v) != "2" {
+		t.Errorf("Get(a) = %v, %v, want 2, true", v, ok)
+	}
+	if h.Len() != 1 || !h.Exists("a") {
+		t.Errorf("hash should hold one field, got %d", h.Len())
+	}
+
+	if !h.Delete("a") {
+		t.Error("Delete should report an existing field")
+	}
+	if h.Delete("a") {
+		t.Error("Delete should not report a missing field")
+	}
+	if h.Len() != 0 {
+		t.Errorf("hash should be empty, got %d fields", h.Len())
+	}
+}
+
+func TestHash_Clone(t *testing.T) {
+	h := NewHash()
+	h.Set("a", []byte("1"))
+	h.Set("b", []byte("2"))
+
+	clone := h.Clone()
+	clone.Set("c", []byte("3"))
+	h.Delete("a")
+
+	fields := clone.Fields()
+	slices.Sort(fields)
+	if !slices.Equal(fields, []string{"a", "b", "c"}) {
+		t.Errorf("clone fields = %v", fields)
+	}
+	if h.Exists("c") {
+		t.Error("Setting on the clone should not change the original")
+	}
+}
+
+func TestHash_FieldExpiry(t *testing.T) {
+	now := time.Now()
+	h := NewHash()
+	h.Set("a", []byte("1"))
+	h.Set("b", []byte("2"))
+	h.Set("c", []byte("3"))
+
+	if h.ExpireAt("missing", now) {
+		t.Error("ExpireAt should not set a TTL on a missing field")
+	}
+	h.ExpireAt("a", now.Add(time.Second))
+	h.ExpireAt("b", now.Add(time.Minute))
+	h.ExpireAt("c", now.Add(time.Hour))
+	if !h.Persist("c") || h.Persist("c") {
+		t.Error("Persist should only report a field that had a TTL")
+	}
+
+	if evicted := h.EvictExpired(now); len(evicted) != 0 {
+		t.Errorf("EvictExpired() before any TTL = %v", evicted)
+	}
+	if evicted := h.EvictExpired(now.Add(2 * time.Second)); !slices.Equal(evicted, []string{"a"}) {
+		t.Errorf("EvictExpired() = %v, want [a]", evicted)
+	}
+	if h.Exists("a") || h.Len() != 2 {
+		t.Errorf("expired field should be removed, %d fields left", h.Len())
+	}
+
+	// HSET semantics: overwriting a field clears its TTL, Update keeps it
+	h.Update("b", []byte("20"))
+	if _, ok := h.ExpiresAt("b"); !ok {
+		t.Error("Update should keep the TTL")
+	}
+	h.Set("b", []byte("21"))
+	if _, ok := h.ExpiresAt("b"); ok || h.Volatile() {
+		t.Error("Set should clear the TTL")
+	}
+	if evicted := h.EvictExpired(now.Add(time.Hour)); len(evicted) != 0 {
+		t.Errorf("EvictExpired() = %v, want none", evicted)
+	}
+}
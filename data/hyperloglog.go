@@ -0,0 +1,445 @@
+package data
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// A HyperLogLog is kept in the byte layout Redis uses for its string values,
+// so that GET and SET (or DUMP and RESTORE) move them between servers:
+//
+//	+------+---+-----+----------+
+//	| HYLL | E | N/U | Cardin.  |
+//	+------+---+-----+----------+
+//
+// a 4 byte magic, the encoding, 3 unused bytes and the cached cardinality,
+// 8 bytes little endian whose most significant bit marks it as stale. The
+// registers follow, either dense (6 bits each) or sparse (run length
+// opcodes).
+const (
+	hllP         = 14
+	hllQ         = 64 - hllP
+	hllRegisters = 1 << hllP
+	hllBits      = 6
+	hllMaxReg    = 1<<hllBits - 1
+	hllHdrSize   = 16
+	hllDenseSize = hllHdrSize + (hllRegisters*hllBits+7)/8
+	hllAlphaInf  = 0.721347520444481703680
+	hllSeed      = 0xadc83b19
+
+	hllDense  = 0
+	hllSparse = 1
+
+	// the largest value and run length of the sparse opcodes
+	hllSparseValMax   = 32
+	hllSparseValLen   = 4
+	hllSparseZeroLen  = 64
+	hllSparseXZeroLen = 16384
+	// hllSparseMaxBytes is the size past which a sparse HyperLogLog turns
+	// dense, Redis' default hll-sparse-max-bytes
+	hllSparseMaxBytes = 3000
+)
+
+var (
+	ErrNotHyperLogLog = errors.New("Key is not a valid HyperLogLog string value.")
+	ErrHllCorrupted   = errors.New("Corrupted HLL object detected")
+)
+
+// HyperLogLog estimates the number of distinct elements added to it with a
+// standard error of 0.81%.
+type HyperLogLog struct {
+	b []byte
+}
+
+// NewHyperLogLog returns an empty HyperLogLog, in the sparse encoding.
+func NewHyperLogLog() *HyperLogLog {
+	b := make([]byte, hllHdrSize, hllHdrSize+2)
+	copy(b, "HYLL")
+	b[4] = hllSparse
+	// a single XZERO opcode covering every register
+	b = append(b, sparseXZero(hllRegisters)...)
+	return &HyperLogLog{b: b}
+}
+
+// ParseHyperLogLog wraps the string value b, checking that it holds a
+// HyperLogLog. b is used as is, not copied.
+func ParseHyperLogLog(b []byte) (*HyperLogLog, error) {
+	if len(b) < hllHdrSize || string(b[:4]) != "HYLL" || b[4] > hllSparse {
+		return nil, ErrNotHyperLogLog
+	}
+	if b[4] == hllDense && len(b) != hllDenseSize {
+		return nil, ErrNotHyperLogLog
+	}
+	return &HyperLogLog{b: b}, nil
+}
+
+// Bytes returns the string representation of the HyperLogLog.
+func (h *HyperLogLog) Bytes() []byte {
+	return h.b
+}
+
+// IsSparse reports whether the registers use the sparse encoding.
+func (h *HyperLogLog) IsSparse() bool {
+	return h.b[4] == hllSparse
+}
+
+func (h *HyperLogLog) invalidateCache() {
+	h.b[15] |= 0x80
+}
+
+// Add adds element and reports whether an estimate may have changed.
+func (h *HyperLogLog) Add(element []byte) (bool, error) {
+	index, count := hllPatLen(element)
+	if !h.IsSparse() {
+		if denseRegister(h.b[hllHdrSize:], index) >= count {
+			return false, nil
+		}
+		setDenseRegister(h.b[hllHdrSize:], index, count)
+		h.invalidateCache()
+		return true, nil
+	}
+
+	regs, err := h.Registers()
+	if err != nil {
+		return false, err
+	}
+	if regs[index] >= count {
+		return false, nil
+	}
+	regs[index] = count
+	h.setRegisters(regs, true)
+	h.invalidateCache()
+	return true, nil
+}
+
+// Registers returns the value of every register.
+func (h *HyperLogLog) Registers() ([]uint8, error) {
+	regs := make([]uint8, hllRegisters)
+	if !h.IsSparse() {
+		for i := range regs {
+			regs[i] = denseRegister(h.b[hllHdrSize:], i)
+		}
+		return regs, nil
+	}
+
+	i := 0
+	err := h.walkSparse(func(value uint8, run int) {
+		for j := 0; j < run; j += 1 {
+			regs[i] = value
+			i += 1
+		}
+	})
+	return regs, err
+}
+
+// walkSparse calls f with the value and run length of every sparse opcode,
+// after checking that the opcodes cover exactly every register.
+func (h *HyperLogLog) walkSparse(f func(value uint8, run int)) error {
+	p := h.b[hllHdrSize:]
+	type op struct {
+		value uint8
+		run   int
+	}
+	ops := make([]op, 0)
+	total := 0
+	for i := 0; i < len(p); {
+		var o op
+		switch {
+		case p[i]&0xc0 == 0x00: // ZERO 00xxxxxx
+			o.run = int(p[i]&0x3f) + 1
+			i += 1
+		case p[i]&0xc0 == 0x40: // XZERO 01xxxxxx yyyyyyyy
+			if i+1 >= len(p) {
+				return ErrHllCorrupted
+			}
+			o.run = (int(p[i]&0x3f)<<8 | int(p[i+1])) + 1
+			i += 2
+		default: // VAL 1vvvvvxx
+			o.value = (p[i]>>2)&0x1f + 1
+			o.run = int(p[i]&0x03) + 1
+			i += 1
+		}
+		total += o.run
+		if total > hllRegisters {
+			return ErrHllCorrupted
+		}
+		ops = append(ops, o)
+	}
+	if total != hllRegisters {
+		return ErrHllCorrupted
+	}
+	for _, o := range ops {
+		f(o.value, o.run)
+	}
+	return nil
+}
+
+// setRegisters replaces the registers, in the sparse encoding when sparse
+// is set and they fit in it, in the dense encoding otherwise.
+func (h *HyperLogLog) setRegisters(regs []uint8, sparse bool) {
+	hdr := h.b[:hllHdrSize:hllHdrSize]
+	if sparse {
+		if body, ok := encodeSparse(regs); ok && hllHdrSize+len(body) <= hllSparseMaxBytes {
+			h.b = append(hdr, body...)
+			h.b[4] = hllSparse
+			return
+		}
+	}
+	b := make([]byte, hllDenseSize)
+	copy(b, hdr)
+	b[4] = hllDense
+	for i, value := range regs {
+		setDenseRegister(b[hllHdrSize:], i, value)
+	}
+	h.b = b
+}
+
+// ToDense converts a sparse HyperLogLog to the dense encoding and reports
+// whether it was sparse.
+func (h *HyperLogLog) ToDense() (bool, error) {
+	if !h.IsSparse() {
+		return false, nil
+	}
+	regs, err := h.Registers()
+	if err != nil {
+		return false, err
+	}
+	h.setRegisters(regs, false)
+	return true, nil
+}
+
+// Count returns the estimated number of distinct elements, using the cached
+// value when it is still valid and caching it otherwise.
+func (h *HyperLogLog) Count() (uint64, error) {
+	if h.b[15]&0x80 == 0 {
+		return binary.LittleEndian.Uint64(h.b[8:16]), nil
+	}
+	regs, err := h.Registers()
+	if err != nil {
+		return 0, err
+	}
+	count := countRegisters(regs)
+	binary.LittleEndian.PutUint64(h.b[8:16], count)
+	return count, nil
+}
+
+// Merge sets every register of h to the maximum of its value and the one in
+// o. The result stays sparse only if both were sparse.
+func (h *HyperLogLog) Merge(o *HyperLogLog) error {
+	regs, err := h.Registers()
+	if err != nil {
+		return err
+	}
+	other, err := o.Registers()
+	if err != nil {
+		return err
+	}
+	for i, value := range other {
+		regs[i] = max(regs[i], value)
+	}
+	h.setRegisters(regs, h.IsSparse() && o.IsSparse())
+	h.invalidateCache()
+	return nil
+}
+
+// UnionCount estimates the number of distinct elements added to any of
+// hlls, without changing them.
+func UnionCount(hlls []*HyperLogLog) (uint64, error) {
+	regs := make([]uint8, hllRegisters)
+	for _, h := range hlls {
+		other, err := h.Registers()
+		if err != nil {
+			return 0, err
+		}
+		for i, value := range other {
+			regs[i] = max(regs[i], value)
+		}
+	}
+	return countRegisters(regs), nil
+}
+
+// countRegisters estimates the cardinality from the registers with the
+// improved estimator of Otmar Ertl, the one Redis uses.
+func countRegisters(regs []uint8) uint64 {
+	var histogram [64]int
+	for _, value := range regs {
+		histogram[value] += 1
+	}
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j -= 1 {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if prev == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if prev == z {
+			return z / 3
+		}
+	}
+}
+
+// DecodeSparse describes the sparse opcodes, the output of PFDEBUG DECODE.
+func (h *HyperLogLog) DecodeSparse() (string, error) {
+	if !h.IsSparse() {
+		return "", fmt.Errorf("HLL encoding is not sparse")
+	}
+	p := h.b[hllHdrSize:]
+	parts := make([]string, 0)
+	for i := 0; i < len(p); {
+		switch {
+		case p[i]&0xc0 == 0x00:
+			parts = append(parts, fmt.Sprintf("z:%d", int(p[i]&0x3f)+1))
+			i += 1
+		case p[i]&0xc0 == 0x40:
+			if i+1 >= len(p) {
+				return "", ErrHllCorrupted
+			}
+			parts = append(parts, fmt.Sprintf("Z:%d", (int(p[i]&0x3f)<<8|int(p[i+1]))+1))
+			i += 2
+		default:
+			parts = append(parts, fmt.Sprintf("v:%d,%d", (p[i]>>2)&0x1f+1, int(p[i]&0x03)+1))
+			i += 1
+		}
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// hllPatLen returns the register element hashes to and the length of the
+// run of zeros in the rest of the hash, plus one.
+func hllPatLen(element []byte) (int, uint8) {
+	hash := murmurHash64A(element, hllSeed)
+	index := int(hash & (hllRegisters - 1))
+	hash >>= hllP
+	// make sure the loop terminates
+	hash |= 1 << hllQ
+	count := uint8(1)
+	for bit := uint64(1); hash&bit == 0; bit <<= 1 {
+		count += 1
+	}
+	return index, count
+}
+
+func denseRegister(p []byte, index int) uint8 {
+	byteIdx := index * hllBits / 8
+	fb := uint(index * hllBits & 7)
+	v := uint(p[byteIdx]) >> fb
+	if byteIdx+1 < len(p) {
+		v |= uint(p[byteIdx+1]) << (8 - fb)
+	}
+	return uint8(v & hllMaxReg)
+}
+
+func setDenseRegister(p []byte, index int, value uint8) {
+	byteIdx := index * hllBits / 8
+	fb := uint(index * hllBits & 7)
+	v := uint(value)
+	p[byteIdx] &^= byte(hllMaxReg << fb)
+	p[byteIdx] |= byte(v << fb)
+	if byteIdx+1 < len(p) {
+		p[byteIdx+1] &^= byte(hllMaxReg >> (8 - fb))
+		p[byteIdx+1] |= byte(v >> (8 - fb))
+	}
+}
+
+func sparseXZero(run int) []byte {
+	run -= 1
+	return []byte{0x40 | byte(run>>8), byte(run)}
+}
+
+// encodeSparse encodes registers as sparse opcodes. ok is false when a
+// register is too large for the sparse encoding.
+func encodeSparse(regs []uint8) ([]byte, bool) {
+	body := make([]byte, 0)
+	for i := 0; i < len(regs); {
+		value, run := regs[i], 1
+		for i+run < len(regs) && regs[i+run] == value {
+			run += 1
+		}
+		i += run
+
+		if value > hllSparseValMax {
+			return nil, false
+		}
+		for run > 0 {
+			switch {
+			case value != 0:
+				n := min(run, hllSparseValLen)
+				body = append(body, 0x80|(value-1)<<2|byte(n-1))
+				run -= n
+			case run > hllSparseZeroLen:
+				n := min(run, hllSparseXZeroLen)
+				body = append(body, sparseXZero(n)...)
+				run -= n
+			default:
+				body = append(body, byte(run-1))
+				run = 0
+			}
+		}
+	}
+	return body, true
+}
+
+// murmurHash64A is the 64 bit MurmurHash2 by Austin Appleby, the hash Redis
+// uses for HyperLogLogs.
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const (
+		m = 0xc6a4a7935bd1e995
+		r = 47
+	)
+	h := seed ^ uint64(len(key))*m
+
+	n := len(key) / 8
+	for i := 0; i < n; i += 1 {
+		k := binary.LittleEndian.Uint64(key[i*8:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+	}
+
+	tail := key[n*8:]
+	for i := len(tail) - 1; i >= 0; i -= 1 {
+		h ^= uint64(tail[i]) << (8 * i)
+	}
+	if len(tail) > 0 {
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
@@ -0,0 +1,116 @@
+package data
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func addN(t *testing.T, h *HyperLogLog, prefix string, n int) {
+	t.Helper()
+	for i := 0; i < n; i += 1 {
+		if _, err := h.Add([]byte(prefix + strconv.Itoa(i))); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+}
+
+func TestHyperLogLog_Empty(t *testing.T) {
+	h := NewHyperLogLog()
+	if !h.IsSparse() || len(h.Bytes()) != hllHdrSize+2 {
+		t.Errorf("new HyperLogLog should be a single sparse opcode, got %d bytes", len(h.Bytes()))
+	}
+	if count, _ := h.Count(); count != 0 {
+		t.Errorf("Count() = %d, want 0", count)
+	}
+	if decoded, _ := h.DecodeSparse(); decoded != "Z:16384" {
+		t.Errorf("DecodeSparse() = %q", decoded)
+	}
+}
+
+func TestHyperLogLog_Add(t *testing.T) {
+	h := NewHyperLogLog()
+	if changed, _ := h.Add([]byte("a")); !changed {
+		t.Error("Add() of a new element should change a register")
+	}
+	if changed, _ := h.Add([]byte("a")); changed {
+		t.Error("Add() of the same element should change nothing")
+	}
+	addN(t, h, "x", 9)
+	if count, _ := h.Count(); count != 10 {
+		t.Errorf("Count() = %d, want 10", count)
+	}
+	if !h.IsSparse() {
+		t.Error("a small HyperLogLog should stay sparse")
+	}
+}
+
+func TestHyperLogLog_Accuracy(t *testing.T) {
+	for _, n := range []int{1000, 100000} {
+		h := NewHyperLogLog()
+		addN(t, h, "elem", n)
+		count, err := h.Count()
+		if err != nil {
+			t.Fatalf("Count() error = %v", err)
+		}
+		// five standard errors
+		if e := math.Abs(float64(count)-float64(n)) / float64(n); e > 5*0.0081 {
+			t.Errorf("Count() = %d for %d elements, error %.4f", count, n, e)
+		}
+	}
+}
+
+func TestHyperLogLog_SparseToDense(t *testing.T) {
+	sparse := NewHyperLogLog()
+	addN(t, sparse, "e", 3000)
+	if sparse.IsSparse() || len(sparse.Bytes()) != hllDenseSize {
+		t.Fatalf("HyperLogLog should turn dense past %d bytes", hllSparseMaxBytes)
+	}
+
+	small := NewHyperLogLog()
+	addN(t, small, "e", 200)
+	dense := NewHyperLogLog()
+	addN(t, dense, "e", 200)
+	if converted, _ := dense.ToDense(); !converted {
+		t.Fatal("ToDense() should convert a sparse HyperLogLog")
+	}
+	a, _ := small.Registers()
+	b, _ := dense.Registers()
+	if string(a) != string(b) {
+		t.Error("both encodings should hold the same registers")
+	}
+}
+
+func TestHyperLogLog_MergeAndParse(t *testing.T) {
+	a, b := NewHyperLogLog(), NewHyperLogLog()
+	addN(t, a, "a", 5000)
+	addN(t, b, "b", 5000)
+	addN(t, b, "a", 2500)
+	if err := a.Merge(b); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	count, _ := a.Count()
+	if e := math.Abs(float64(count)-10000) / 10000; e > 5*0.0081 {
+		t.Errorf("Count() of the union = %d, want about 10000", count)
+	}
+
+	parsed, err := ParseHyperLogLog([]byte(string(a.Bytes())))
+	if err != nil {
+		t.Fatalf("ParseHyperLogLog() error = %v", err)
+	}
+	if got, _ := parsed.Count(); got != count {
+		t.Errorf("parsed Count() = %d, want the cached %d", got, count)
+	}
+
+	for _, s := range []string{"", "hello", "HYLL\x02" + strings.Repeat("\x00", 11), "HYLL\x00" + strings.Repeat("\x00", 11)} {
+		if _, err := ParseHyperLogLog([]byte(s)); err != ErrNotHyperLogLog {
+			t.Errorf("ParseHyperLogLog(%q) error = %v", s, err)
+		}
+	}
+	// a stale cache and a single ZERO opcode covering one register
+	corrupted, _ := ParseHyperLogLog([]byte("HYLL\x01" + strings.Repeat("\x00", 10) + "\x80\x00"))
+	if _, err := corrupted.Count(); err != ErrHllCorrupted {
+		t.Errorf("Count() of a corrupted HyperLogLog error = %v", err)
+	}
+}
@@ -1,15 +1,38 @@
 package data
 
-type node struct {
-	value any
-	prev  *node
-	next  *node
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"slices"
+	"sync"
+)
+
+// chunk is a node of a list, packing a run of its elements together. A
+// compressed chunk holds no items, they are deflated in compressed.
+type chunk struct {
+	items      [][]byte
+	compressed []byte
+	// count is the number of elements and bytes their size once packed, see
+	// listpackEntrySize, both kept while the chunk is compressed
+	count int
+	bytes int
+	prev  *chunk
+	next  *chunk
 }
 
+// LinkedList is a quicklist: a doubly linked list of chunks, each packing as
+// many elements as Limits.ListMaxListpackSize allows. The chunks further
+// than Limits.ListCompressDepth from both ends are kept compressed.
 type LinkedList struct {
-	head *node
-	tail *node
-	size int
+	head   *chunk
+	tail   *chunk
+	size   int
+	chunks int
+	// quicklist is set once the list outgrows its first chunk, it is a
+	// listpack until then
+	quicklist bool
 }
 
 func NewLinkedList() *LinkedList {
@@ -20,109 +43,325 @@ func NewLinkedList() *LinkedList {
 	}
 }
 
-func (l *LinkedList) LeftPush(value any) {
-	l.lpush(value)
+// fits reports whether c can take count more elements taking bytes more,
+// count and bytes being negative when elements are replaced by smaller ones.
+// A chunk always takes a single element, however large.
+func (c *chunk) fits(count int, bytes int) bool {
+	return c.count+count <= 1 || Limits.listFits(c.count+count, c.bytes+bytes)
 }
 
-func (l *LinkedList) lpush(value any) {
-	n := &node{value: value, prev: nil, next: l.head}
-	if l.head != nil {
-		l.head.prev = n
+// values returns the elements of c, inflating them when c is compressed
+// without keeping them.
+func (c *chunk) values() [][]byte {
+	if c.compressed == nil {
+		return c.items
 	}
-	l.head = n
-	if l.tail == nil {
-		l.tail = n
+	return inflateChunk(c.compressed, c.count)
+}
+
+func (c *chunk) decompress() {
+	if c.compressed != nil {
+		c.items = inflateChunk(c.compressed, c.count)
+		c.compressed = nil
+	}
+}
+
+func (c *chunk) compress() {
+	if c.compressed == nil && c.count > 0 {
+		if compressed := deflateChunk(c.items, c.bytes); compressed != nil {
+			c.items, c.compressed = nil, compressed
+		}
+	}
+}
+
+func (c *chunk) insert(off int, value []byte) {
+	c.decompress()
+	c.items = slices.Insert(c.items, off, value)
+	c.count += 1
+	c.bytes += listpackEntrySize(len(value))
+}
+
+func (c *chunk) remove(off int) []byte {
+	c.decompress()
+	value := c.items[off]
+	c.items = slices.Delete(c.items, off, off+1)
+	c.count -= 1
+	c.bytes -= listpackEntrySize(len(value))
+	return value
+}
+
+// removeMatching removes the elements of c equal to value, at most limit of
+// them unless limit is zero, starting from the last one when fromTail, and
+// returns how many were removed.
+func (c *chunk) removeMatching(value []byte, limit int, fromTail bool) int {
+	c.decompress()
+	matches := make([]int, 0)
+	for i := range c.items {
+		idx := i
+		if fromTail {
+			idx = len(c.items) - 1 - i
+		}
+		if bytes.Equal(c.items[idx], value) {
+			matches = append(matches, idx)
+			if limit != 0 && len(matches) == limit {
+				break
+			}
+		}
+	}
+	// from the last index, for the others not to move
+	slices.Sort(matches)
+	for i := len(matches) - 1; i >= 0; i -= 1 {
+		c.items = slices.Delete(c.items, matches[i], matches[i]+1)
 	}
+	c.count -= len(matches)
+	c.bytes -= len(matches) * listpackEntrySize(len(value))
+	return len(matches)
+}
+
+// packedSize returns the bytes values take once packed.
+func packedSize(values [][]byte) int {
+	size := 0
+	for _, value := range values {
+		size += listpackEntrySize(len(value))
+	}
+	return size
+}
+
+// link puts c in the list right after prev, or first when prev is nil.
+func (l *LinkedList) link(c *chunk, prev *chunk) {
+	c.prev = prev
+	if prev != nil {
+		c.next = prev.next
+		prev.next = c
+	} else {
+		c.next = l.head
+		l.head = c
+	}
+	if c.next != nil {
+		c.next.prev = c
+	} else {
+		l.tail = c
+	}
+	l.chunks += 1
+	if l.chunks > 1 {
+		l.quicklist = true
+	}
+}
+
+// unlink removes c from the list.
+func (l *LinkedList) unlink(c *chunk) {
+	if c.prev != nil {
+		c.prev.next = c.next
+	} else {
+		l.head = c.next
+	}
+	if c.next != nil {
+		c.next.prev = c.prev
+	} else {
+		l.tail = c.prev
+	}
+	c.prev, c.next = nil, nil
+	l.chunks -= 1
+}
+
+// compress keeps the ListCompressDepth chunks at each end of the list
+// uncompressed and compresses the next one on each side, which a push or a
+// pop may just have moved to the interior. c is compressed as well when it is
+// an interior chunk, nil for none.
+func (l *LinkedList) compress(c *chunk) {
+	depth := Limits.ListCompressDepth
+	if depth <= 0 {
+		return
+	}
+	if l.chunks <= 2*depth {
+		for iter := l.head; iter != nil; iter = iter.next {
+			iter.decompress()
+		}
+		return
+	}
+	front, back := l.head, l.tail
+	for range depth {
+		front.decompress()
+		back.decompress()
+		if c == front || c == back {
+			c = nil
+		}
+		front, back = front.next, back.prev
+	}
+	front.compress()
+	back.compress()
+	if c != nil {
+		c.compress()
+	}
+}
+
+// locate returns the chunk holding the element at index, which must be
+// within the list, and the offset of the element in it, walking from the
+// nearer end.
+func (l *LinkedList) locate(index int) (*chunk, int) {
+	if index < l.size/2 {
+		c := l.head
+		for index >= c.count {
+			index -= c.count
+			c = c.next
+		}
+		return c, index
+	}
+	c, fromTail := l.tail, l.size-1-index
+	for fromTail >= c.count {
+		fromTail -= c.count
+		c = c.prev
+	}
+	return c, c.count - 1 - fromTail
+}
+
+// insertAt puts value before the element at off in c, or after the last one
+// when off is c.count, spilling to a neighbour or a new chunk when c is full.
+func (l *LinkedList) insertAt(c *chunk, off int, value []byte) {
+	size := listpackEntrySize(len(value))
+	switch {
+	case c.fits(1, size):
+	case off == 0 && c.prev != nil && c.prev.fits(1, size):
+		c, off = c.prev, c.prev.count
+	case off == c.count && c.next != nil && c.next.fits(1, size):
+		c, off = c.next, 0
+	case off == 0:
+		l.link(&chunk{}, c.prev)
+		c = c.prev
+	case off == c.count:
+		l.link(&chunk{}, c)
+		c, off = c.next, 0
+	default:
+		l.split(c, off)
+		l.insertAt(c, off, value)
+		return
+	}
+	c.insert(off, value)
 	l.size += 1
+	l.compress(c)
 }
 
-func (l *LinkedList) RightPush(value any) {
-	l.rpush(value)
+// split moves the elements of c from off on to a new chunk after it.
+func (l *LinkedList) split(c *chunk, off int) {
+	c.decompress()
+	rest := &chunk{items: slices.Clone(c.items[off:])}
+	rest.count, rest.bytes = len(rest.items), packedSize(rest.items)
+	c.items = slices.Clip(c.items[:off])
+	c.count, c.bytes = off, c.bytes-rest.bytes
+	l.link(rest, c)
+	l.compress(rest)
 }
 
-func (l *LinkedList) rpush(value any) {
-	n := &node{value: value, prev: l.tail, next: nil}
-	if l.tail != nil {
-		l.tail.next = n
+// removeAt removes the element at off in c and returns it.
+func (l *LinkedList) removeAt(c *chunk, off int) []byte {
+	value := c.remove(off)
+	l.size -= 1
+	if c.count == 0 {
+		l.unlink(c)
+		c = nil
 	}
-	l.tail = n
+	l.compress(c)
+	return value
+}
+
+// removeRange removes n elements from index on.
+func (l *LinkedList) removeRange(index int, n int) {
+	if n <= 0 {
+		return
+	}
+	c, off := l.locate(index)
+	l.size -= n
+	for n > 0 {
+		next := c.next
+		if off == 0 && n >= c.count {
+			n -= c.count
+			l.unlink(c)
+		} else {
+			k := min(n, c.count-off)
+			c.decompress()
+			c.bytes -= packedSize(c.items[off : off+k])
+			c.items = slices.Delete(c.items, off, off+k)
+			c.count -= k
+			n -= k
+			l.compress(c)
+		}
+		c, off = next, 0
+	}
+	l.compress(nil)
+}
+
+func (l *LinkedList) LeftPush(value []byte) {
+	l.lpush(value)
+}
+
+func (l *LinkedList) lpush(value []byte) {
 	if l.head == nil {
-		l.head = n
+		l.link(&chunk{}, nil)
 	}
-	l.size += 1
+	l.insertAt(l.head, 0, value)
+}
+
+func (l *LinkedList) RightPush(value []byte) {
+	l.rpush(value)
 }
 
-func (l *LinkedList) LeftPop() (any, bool) {
+func (l *LinkedList) rpush(value []byte) {
+	if l.tail == nil {
+		l.link(&chunk{}, nil)
+	}
+	l.insertAt(l.tail, l.tail.count, value)
+}
+
+func (l *LinkedList) LeftPop() ([]byte, bool) {
 	return l.lpop()
 }
 
-func (l *LinkedList) lpop() (any, bool) {
+func (l *LinkedList) lpop() ([]byte, bool) {
 	if l.head == nil {
 		return nil, false
 	}
-	value := l.head.value
-	l.head = l.head.next
-	if l.head != nil {
-		l.head.prev = nil
-	} else {
-		l.tail = nil
-	}
-	l.size -= 1
-	return value, true
+	return l.removeAt(l.head, 0), true
 }
 
-func (l *LinkedList) RightPop() (any, bool) {
+func (l *LinkedList) RightPop() ([]byte, bool) {
 	return l.rpop()
 }
 
-func (l *LinkedList) rpop() (any, bool) {
+func (l *LinkedList) rpop() ([]byte, bool) {
 	if l.tail == nil {
 		return nil, false
 	}
-	value := l.tail.value
-	l.tail = l.tail.prev
-	if l.tail != nil {
-		l.tail.next = nil
-	} else {
-		l.head = nil
-	}
-	l.size -= 1
-	return value, true
+	return l.removeAt(l.tail, l.tail.count-1), true
 }
 
-func (l *LinkedList) LeftRange(start, stop int) []any {
+func (l *LinkedList) LeftRange(start, stop int) [][]byte {
 	return l.lrange(start, stop)
 }
 
-func (l *LinkedList) lrange(start, stop int) []any {
+func (l *LinkedList) lrange(start, stop int) [][]byte {
+	size := l.Len()
 	if start < 0 {
-		start = l.size + start
+		start = size + start
 	}
 	if stop < 0 {
-		stop = l.size + stop
+		stop = size + stop
 	}
 	if start < 0 {
 		start = 0
 	}
-	if stop >= l.size {
-		stop = l.size - 1
+	if stop >= size {
+		stop = size - 1
 	}
-	if start > stop || l.head == nil {
-		return []any{}
+	if start > stop || size == 0 {
+		return [][]byte{}
 	}
 
-	result := make([]any, 0, stop-start+1)
-	curr := l.head
-	idx := 0
-	for curr != nil {
-		if idx >= start && idx <= stop {
-			result = append(result, curr.value)
-		}
-		if idx > stop {
-			break
-		}
-		curr = curr.next
-		idx += 1
+	n := stop - start + 1
+	result := make([][]byte, 0, n)
+	c, off := l.locate(start)
+	for ; len(result) < n; c, off = c.next, 0 {
+		values := c.values()[off:]
+		result = append(result, values[:min(len(values), n-len(result))]...)
 	}
 	return result
 }
@@ -131,99 +370,258 @@ func (l *LinkedList) Len() int {
 	return l.size
 }
 
-func (l *LinkedList) LeftIndex(index int) (any, bool) {
+func (l *LinkedList) LeftIndex(index int) ([]byte, bool) {
 	return l.leftIndex(index)
 }
 
-func (l *LinkedList) leftIndex(index int) (any, bool) {
+func (l *LinkedList) leftIndex(index int) ([]byte, bool) {
 	if index < 0 {
-		index = l.size + index
+		index = l.Len() + index
 	}
-	if index < 0 || index >= l.size {
+	if index < 0 || index >= l.Len() {
 		return nil, false
 	}
-
-	curr := l.head
-	for i := 0; i < index && curr != nil; i += 1 {
-		curr = curr.next
-	}
-	if curr == nil {
-		return nil, false
-	}
-	return curr.value, true
+	c, off := l.locate(index)
+	return c.values()[off], true
 }
 
-func (l *LinkedList) LeftSet(index int, value any) bool {
+func (l *LinkedList) LeftSet(index int, value []byte) bool {
 	if index < 0 {
-		index = l.size + index
+		index = l.Len() + index
 	}
-	if index < 0 || index >= l.size {
+	if index < 0 || index >= l.Len() {
 		return false
 	}
-
-	curr := l.head
-	for i := 0; i < index && curr != nil; i += 1 {
-		curr = curr.next
+	c, off := l.locate(index)
+	c.decompress()
+	grown := listpackEntrySize(len(value)) - listpackEntrySize(len(c.items[off]))
+	if c.fits(0, grown) {
+		c.items[off] = value
+		c.bytes += grown
+		l.compress(c)
+		return true
 	}
-	if curr == nil {
-		return false
+
+	l.removeAt(c, off)
+	if index == l.size {
+		l.rpush(value)
+	} else {
+		c, off = l.locate(index)
+		l.insertAt(c, off, value)
 	}
-	curr.value = value
 	return true
 }
 
 func (l *LinkedList) Trim(start, stop int) {
+	size := l.Len()
 	if start < 0 {
-		start = l.size + start
+		start = size + start
 	}
 	if stop < 0 {
-		stop = l.size + stop
+		stop = size + stop
 	}
 	if start < 0 {
 		start = 0
 	}
-	if stop >= l.size {
-		stop = l.size - 1
+	if stop >= size {
+		stop = size - 1
 	}
 
-	if start > stop || l.head == nil {
+	if start > stop {
 		// Empty the list
-		l.head = nil
-		l.tail = nil
-		l.size = 0
+		l.head, l.tail = nil, nil
+		l.size, l.chunks = 0, 0
 		return
 	}
+	l.removeRange(stop+1, size-stop-1)
+	l.removeRange(0, start)
+}
 
-	// Find the start node
-	curr := l.head
-	for i := 0; i < start && curr != nil; i += 1 {
-		curr = curr.next
+// Insert puts value right before or after the first element equal to pivot
+// and returns the new length of the list, or -1 when pivot is not found.
+func (l *LinkedList) Insert(pivot []byte, value []byte, before bool) int {
+	for c := l.head; c != nil; c = c.next {
+		off := slices.IndexFunc(c.values(), func(item []byte) bool { return bytes.Equal(item, pivot) })
+		if off < 0 {
+			continue
+		}
+		if !before {
+			off += 1
+		}
+		l.insertAt(c, off, value)
+		return l.size
 	}
-	if curr == nil {
-		return
+	return -1
+}
+
+// Remove removes the elements equal to value and returns how many were
+// removed: the first count ones from the head when count is positive, the
+// last -count ones from the tail when it is negative, all of them when it is
+// zero.
+func (l *LinkedList) Remove(value []byte, count int) int {
+	fromTail := count < 0
+	if fromTail {
+		count = -count
+	}
+	c := l.head
+	if fromTail {
+		c = l.tail
 	}
-	newHead := curr
 
-	// Find the stop node
-	for i := start; i < stop && curr != nil; i += 1 {
-		curr = curr.next
+	removed := 0
+	for c != nil && (count == 0 || removed < count) {
+		next := c.next
+		if fromTail {
+			next = c.prev
+		}
+		if slices.ContainsFunc(c.values(), func(item []byte) bool { return bytes.Equal(item, value) }) {
+			limit := 0
+			if count != 0 {
+				limit = count - removed
+			}
+			removed += c.removeMatching(value, limit, fromTail)
+			if c.count == 0 {
+				l.unlink(c)
+				c = nil
+			}
+			l.compress(c)
+		}
+		c = next
 	}
-	if curr == nil {
+	l.size -= removed
+	return removed
+}
+
+// Positions returns the indexes of the elements equal to value, as LPOS
+// does. The search starts at the rank-th match, counting from the tail when
+// rank is negative, returns at most count indexes, all of them when count is
+// zero, and compares at most maxLen elements, all of them when maxLen is
+// zero. Indexes always count from the head.
+func (l *LinkedList) Positions(value []byte, rank, count, maxLen int) []int {
+	fromTail := rank < 0
+	if fromTail {
+		rank = -rank
+	}
+	positions := make([]int, 0)
+	seen := 0
+	l.walk(fromTail, func(idx int, item []byte) bool {
+		if maxLen != 0 && seen >= maxLen {
+			return false
+		}
+		seen += 1
+		if !bytes.Equal(item, value) {
+			return true
+		}
+		if rank > 1 {
+			rank -= 1
+			return true
+		}
+		positions = append(positions, idx)
+		return count == 0 || len(positions) < count
+	})
+	return positions
+}
+
+// walk calls fn with the elements and their index from the head, starting
+// from the tail when fromTail, until fn returns false.
+func (l *LinkedList) walk(fromTail bool, fn func(idx int, value []byte) bool) {
+	if !fromTail {
+		idx := 0
+		for c := l.head; c != nil; c = c.next {
+			for _, value := range c.values() {
+				if !fn(idx, value) {
+					return
+				}
+				idx += 1
+			}
+		}
 		return
 	}
-	newTail := curr
 
-	// Disconnect nodes outside the range
-	if newHead.prev != nil {
-		newHead.prev.next = nil
-		newHead.prev = nil
+	idx := l.size - 1
+	for c := l.tail; c != nil; c = c.prev {
+		values := c.values()
+		for i := len(values) - 1; i >= 0; i -= 1 {
+			if !fn(idx, values[i]) {
+				return
+			}
+			idx -= 1
+		}
 	}
-	if newTail.next != nil {
-		newTail.next.prev = nil
-		newTail.next = nil
+}
+
+// Clone returns a copy of the list. Values are shared, not copied.
+func (l *LinkedList) Clone() *LinkedList {
+	clone := &LinkedList{size: l.size}
+	for c := l.head; c != nil; c = c.next {
+		clone.link(&chunk{
+			items:      slices.Clone(c.items),
+			compressed: c.compressed,
+			count:      c.count,
+			bytes:      c.bytes,
+		}, clone.tail)
+	}
+	clone.quicklist = l.quicklist
+	return clone
+}
+
+// minCompressBytes is the size under which a chunk is not worth compressing.
+const minCompressBytes = 48
+
+var (
+	flateWriters = sync.Pool{New: func() any {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	}}
+	flateReaders = sync.Pool{New: func() any {
+		return flate.NewReader(bytes.NewReader(nil))
+	}}
+)
+
+// deflateChunk compresses items, taking size once packed, into their
+// length-prefixed concatenation. It returns nil when that would not save
+// space.
+func deflateChunk(items [][]byte, size int) []byte {
+	if size < minCompressBytes {
+		return nil
+	}
+	var out bytes.Buffer
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+	w.Reset(&out)
+
+	var header [binary.MaxVarintLen64]byte
+	raw := 0
+	for _, item := range items {
+		n := binary.PutUvarint(header[:], uint64(len(item)))
+		w.Write(header[:n])
+		w.Write(item)
+		raw += n + len(item)
+	}
+	w.Close()
+	// like Redis, keep the chunk as is unless it shrinks by some bytes
+	if out.Len()+8 > raw {
+		return nil
+	}
+	return slices.Clip(out.Bytes())
+}
+
+// inflateChunk returns the count items deflateChunk compressed into data.
+func inflateChunk(data []byte, count int) [][]byte {
+	r := flateReaders.Get().(io.ReadCloser)
+	defer flateReaders.Put(r)
+	r.(flate.Resetter).Reset(bytes.NewReader(data), nil)
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		panic("data: corrupt compressed list chunk: " + err.Error())
 	}
 
-	l.head = newHead
-	l.tail = newTail
-	l.size = stop - start + 1
+	items := make([][]byte, 0, count)
+	for len(raw) > 0 {
+		n, k := binary.Uvarint(raw)
+		raw = raw[k:]
+		items = append(items, raw[:n:n])
+		raw = raw[n:]
+	}
+	return items
 }
@@ -0,0 +1,141 @@
+package data
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newList(values string) *LinkedList {
+	l := NewLinkedList()
+	for _, v := range strings.Fields(values) {
+		l.RightPush([]byte(v))
+	}
+	return l
+}
+
+func listString(l *LinkedList) string {
+	values := make([]string, 0, l.Len())
+	for _, v := range l.LeftRange(0, -1) {
+		values = append(values, string(v))
+	}
+	return strings.Join(values, " ")
+}
+
+func TestLinkedList_Insert(t *testing.T) {
+	l := newList("a b c")
+	if n := l.Insert([]byte("a"), []byte("x"), true); n != 4 {
+		t.Errorf("Insert() = %d, want 4", n)
+	}
+	l.Insert([]byte("c"), []byte("y"), false)
+	l.Insert([]byte("b"), []byte("z"), false)
+	if got := listString(l); got != "x a b z c y" {
+		t.Errorf("list = %q", got)
+	}
+	if n := l.Insert([]byte("missing"), []byte("w"), true); n != -1 || l.Len() != 6 {
+		t.Errorf("Insert() with a missing pivot = %d", n)
+	}
+	if v, _ := l.RightPop(); string(v) != "y" {
+		t.Errorf("tail = %q, want y", v)
+	}
+}
+
+func TestLinkedList_Remove(t *testing.T) {
+	tests := []struct {
+		count   int
+		removed int
+		want    string
+	}{
+		{2, 2, "b a c a"},
+		{-2, 2, "a a b c"},
+		{0, 4, "b c"},
+		{10, 4, "b c"},
+	}
+	for _, tt := range tests {
+		l := newList("a a b a c a")
+		if removed := l.Remove([]byte("a"), tt.count); removed != tt.removed {
+			t.Errorf("Remove(a, %d) = %d, want %d", tt.count, removed, tt.removed)
+		}
+		if got := listString(l); got != tt.want {
+			t.Errorf("Remove(a, %d) left %q, want %q", tt.count, got, tt.want)
+		}
+	}
+
+	l := newList("a a")
+	l.Remove([]byte("a"), 0)
+	if l.Len() != 0 || l.head != nil || l.tail != nil {
+		t.Error("removing every element should empty the list")
+	}
+}
+
+func TestLinkedList_Positions(t *testing.T) {
+	l := newList("a b c 1 2 3 c c")
+	tests := []struct {
+		rank, count, maxLen int
+		want                []int
+	}{
+		{1, 1, 0, []int{2}},
+		{1, 0, 0, []int{2, 6, 7}},
+		{2, 0, 0, []int{6, 7}},
+		{-1, 1, 0, []int{7}},
+		{-1, 2, 0, []int{7, 6}},
+		{1, 0, 3, []int{2}},
+		{-1, 0, 1, []int{7}},
+		{4, 0, 0, []int{}},
+	}
+	for _, tt := range tests {
+		if got := l.Positions([]byte("c"), tt.rank, tt.count, tt.maxLen); !slices.Equal(got, tt.want) {
+			t.Errorf("Positions(c, %d, %d, %d) = %v, want %v", tt.rank, tt.count, tt.maxLen, got, tt.want)
+		}
+	}
+}
+
+func benchmarkList(n int) *LinkedList {
+	l := NewLinkedList()
+	for i := range n {
+		l.RightPush([]byte("element:" + strconv.Itoa(i)))
+	}
+	return l
+}
+
+func BenchmarkLinkedList_RightPush(b *testing.B) {
+	b.ReportAllocs()
+	for range b.N {
+		benchmarkList(10_000)
+	}
+}
+
+func BenchmarkLinkedList_LeftIndex(b *testing.B) {
+	l := benchmarkList(100_000)
+	b.ResetTimer()
+	for i := range b.N {
+		l.LeftIndex(i * 7919 % l.Len())
+	}
+}
+
+func BenchmarkLinkedList_LeftSet(b *testing.B) {
+	l := benchmarkList(100_000)
+	value := []byte("element:changed")
+	b.ResetTimer()
+	for i := range b.N {
+		l.LeftSet(i*7919%l.Len(), value)
+	}
+}
+
+func BenchmarkLinkedList_LeftRange(b *testing.B) {
+	l := benchmarkList(100_000)
+	b.ResetTimer()
+	for i := range b.N {
+		start := i * 7919 % (l.Len() - 100)
+		l.LeftRange(start, start+99)
+	}
+}
+
+func BenchmarkLinkedList_Trim(b *testing.B) {
+	l := benchmarkList(100_000 + 2*b.N)
+	b.ResetTimer()
+	for range b.N {
+		l.Trim(1, -2)
+	}
+}
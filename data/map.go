@@ -1,7 +1,6 @@
 package data
 
 import (
-	"regexp"
 	"time"
 )
 
@@ -10,6 +9,7 @@ type HashMap struct {
 	// time.Time already uses monotonic clock for Add, Sub
 	// so clock drift safe here
 	expires map[any]time.Time
+	onEvict func(key any)
 }
 
 func NewHashMap() *HashMap {
@@ -19,6 +19,8 @@ func NewHashMap() *HashMap {
 	}
 }
 
+// Set stores value at key. A positive ttl sets the TTL in milliseconds, 0
+// keeps the TTL key already has and a negative ttl removes it.
 func (h *HashMap) Set(key any, value any, ttl int) bool {
 	_, exists := h.d[key]
 	var evicted bool
@@ -28,6 +30,8 @@ func (h *HashMap) Set(key any, value any, ttl int) bool {
 	h.d[key] = value
 	if ttl > 0 {
 		h.expires[key] = time.Now().Add(time.Duration(ttl) * time.Millisecond)
+	} else if ttl < 0 {
+		delete(h.expires, key)
 	}
 	return !evicted && exists
 }
@@ -65,6 +69,7 @@ func (h *HashMap) evict(key any) bool {
 	if expiresAt.Before(time.Now()) {
 		delete(h.d, key)
 		delete(h.expires, key)
+		h.evicted(key)
 		return true
 	}
 
@@ -82,20 +87,37 @@ func (h *HashMap) Evict() (int, bool) {
 	for _, key := range evictList {
 		delete(h.expires, key)
 		delete(h.d, key)
+		h.evicted(key)
 	}
 	return len(evictList), len(evictList) != 0
 }
 
-func (h *HashMap) List(pattern *regexp.Regexp) []string {
-	keys := make([]string, 0)
+// OnEvict registers a callback run every time a key is removed because its
+// TTL has passed, either lazily on access or by Evict.
+func (h *HashMap) OnEvict(f func(key any)) {
+	h.onEvict = f
+}
+
+func (h *HashMap) evicted(key any) {
+	if h.onEvict != nil {
+		h.onEvict(key)
+	}
+}
 
+// Len returns the number of keys, including expired keys that have not been
+// evicted yet.
+func (h *HashMap) Len() int {
+	return len(h.d)
+}
+
+// Keys returns every key that has not expired.
+func (h *HashMap) Keys() []any {
+	keys := make([]any, 0, len(h.d))
 	for key := range h.d {
 		if h.evict(key) {
 			continue
 		}
-		if strKey, ok := key.(string); ok && pattern.MatchString(strKey) {
-			keys = append(keys, strKey)
-		}
+		keys = append(keys, key)
 	}
 	return keys
 }
@@ -124,3 +146,25 @@ func (h *HashMap) SetExpiration(key any, ttl int) bool {
 	}
 	return true
 }
+
+// ExpiresAt returns the time key expires at, if it has a TTL.
+func (h *HashMap) ExpiresAt(key any) (time.Time, bool) {
+	if _, exists := h.d[key]; !exists {
+		return time.Time{}, false
+	}
+	expiresAt, exists := h.expires[key]
+	return expiresAt, exists
+}
+
+// ExpireAt sets key to expire at the given time, a zero time removes its TTL.
+func (h *HashMap) ExpireAt(key any, at time.Time) bool {
+	if _, exists := h.d[key]; !exists {
+		return false
+	}
+	if at.IsZero() {
+		delete(h.expires, key)
+	} else {
+		h.expires[key] = at
+	}
+	return true
+}
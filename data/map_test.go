@@ -0,0 +1,24 @@
+package data
+
+import "testing"
+
+func TestHashMap_SetTTL(t *testing.T) {
+	h := NewHashMap()
+	h.Set("k", "v", 10000)
+	if _, ok := h.ExpiresAt("k"); !ok {
+		t.Fatal("a positive ttl should set a TTL")
+	}
+
+	h.Set("k", "v2", 0)
+	if _, ok := h.ExpiresAt("k"); !ok {
+		t.Error("a zero ttl should keep the TTL")
+	}
+
+	h.Set("k", "v3", -1)
+	if _, ok := h.ExpiresAt("k"); ok {
+		t.Error("a negative ttl should remove the TTL")
+	}
+	if v, _ := h.Get("k"); v != "v3" {
+		t.Errorf("Get(k) = %v, want v3", v)
+	}
+}
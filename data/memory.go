@@ -0,0 +1,228 @@
+package data
+
+import (
+	"strconv"
+)
+
+// Approximate sizes, in bytes, of the Go runtime structures backing the
+// containers. MEMORY USAGE reports estimates built from these, not exact
+// allocation figures.
+const (
+	ptrSize         = 8
+	intSize         = 8
+	strHeaderSize   = 16
+	sliceHeaderSize = 24
+	ifaceSize       = 16
+	timeSize        = 24
+	// per entry cost of a Go map on top of its key and value: tophash,
+	// overflow pointers and the average empty slot at load factor 6.5/8
+	mapEntryOverhead = 8
+)
+
+// SizeOf estimates the number of bytes used by a single value held in a
+// container, including the interface header that stores it.
+func SizeOf(v any) int {
+	switch val := v.(type) {
+	case nil:
+		return ifaceSize
+	case string:
+		return ifaceSize + strHeaderSize + len(val)
+	case []byte:
+		return ifaceSize + sliceHeaderSize + cap(val)
+	case int, int64, uint64, float64:
+		return ifaceSize + intSize
+	default:
+		return ifaceSize + ptrSize
+	}
+}
+
+// StringEncoding reports the encoding Redis would pick for a string value,
+// as shown by OBJECT ENCODING.
+func StringEncoding(v any) string {
+	var str string
+	switch val := v.(type) {
+	case string:
+		str = val
+	case []byte:
+		str = string(val)
+	case int, int64:
+		return "int"
+	default:
+		return "raw"
+	}
+	if len(str) <= 20 {
+		if n, err := strconv.ParseInt(str, 10, 64); err == nil && strconv.FormatInt(n, 10) == str {
+			return "int"
+		}
+	}
+	if len(str) <= 44 {
+		return "embstr"
+	}
+	return "raw"
+}
+
+// KeyOverhead estimates the bytes a key costs in a database table, on top of
+// the size of its value.
+func KeyOverhead(key any) int {
+	return SizeOf(key) + ptrSize + mapEntryOverhead
+}
+
+// sampled extrapolates the total size of a container of n elements from the
+// sizes of its first samples elements. A non-positive samples count means
+// every element is visited.
+func sampled(n int, samples int, each func(visit func(size int) bool)) int {
+	if n == 0 {
+		return 0
+	}
+	total, seen := 0, 0
+	each(func(size int) bool {
+		total += size
+		seen += 1
+		return samples <= 0 || seen < samples
+	})
+	if seen == 0 || seen >= n {
+		return total
+	}
+	return total * n / seen
+}
+
+// MemoryUsage estimates the bytes held by the entry stored at key, including
+// its expiry record.
+func (h *HashMap) MemoryUsage(key any) (int, bool) {
+	val, exists := h.d[key]
+	if !exists {
+		return 0, false
+	}
+	size := SizeOf(key) + SizeOf(val) + mapEntryOverhead
+	if _, ok := h.expires[key]; ok {
+		size += SizeOf(key) + timeSize + mapEntryOverhead
+	}
+	return size, true
+}
+
+// ExpiresMemoryUsage estimates the bytes spent on the expiry table.
+func (h *HashMap) ExpiresMemoryUsage() int {
+	size := 0
+	for key := range h.expires {
+		size += SizeOf(key) + timeSize + mapEntryOverhead
+	}
+	return size
+}
+
+// MemoryUsage estimates the bytes held by the list, sampling at most
+// samples chunks.
+func (l *LinkedList) MemoryUsage(samples int) int {
+	size := 2*ptrSize + 2*intSize + 1
+	return size + sampled(l.chunks, samples, func(visit func(int) bool) {
+		for c := l.head; c != nil; c = c.next {
+			size := 2*sliceHeaderSize + 2*intSize + 2*ptrSize + cap(c.compressed)
+			for _, value := range c.items {
+				size += sliceHeaderSize + cap(value)
+			}
+			if !visit(size) {
+				return
+			}
+		}
+	})
+}
+
+// MemoryUsage estimates the bytes held by the set, sampling at most samples
+// members.
+func (s *Set) MemoryUsage(samples int) int {
+	size := sliceHeaderSize + ptrSize
+	if s.intset() {
+		return size + cap(s.ints)*intSize
+	}
+	return size + sampled(len(s.members), samples, func(visit func(int) bool) {
+		for member := range s.members {
+			if !visit(strHeaderSize + len(member) + 1 + mapEntryOverhead) {
+				return
+			}
+		}
+	})
+}
+
+// MemoryUsage estimates the bytes held by the hash, sampling at most samples
+// fields.
+func (h *Hash) MemoryUsage(samples int) int {
+	size := 2*sliceHeaderSize + 3*ptrSize + timeSize
+	entrySize := func(field string, value []byte) int {
+		return strHeaderSize + len(field) + SizeOf(value) + mapEntryOverhead
+	}
+	if h.packed() {
+		entrySize = func(field string, value []byte) int {
+			return strHeaderSize + len(field) + sliceHeaderSize + cap(value)
+		}
+	}
+	return size + sampled(h.Len(), samples, func(visit func(int) bool) {
+		h.each(func(field string, value []byte) bool {
+			return visit(entrySize(field, value))
+		})
+	})
+}
+
+// MemoryUsage estimates the bytes held by the sorted set, sampling at most
+// samples members. Each member costs its skip list column plus its entry in
+// the score index.
+func (s *SortedSet[S]) MemoryUsage(samples int) int {
+	colSize := func(c *column[S]) int {
+		return sliceHeaderSize + cap(c.cells)*(2*ptrSize+intSize) + strHeaderSize + len(c.value) + intSize
+	}
+	if s.packed() {
+		size := sliceHeaderSize + 3*ptrSize
+		return size + sampled(len(s.nodes), samples, func(visit func(int) bool) {
+			for _, node := range s.nodes {
+				if !visit(strHeaderSize + len(node.Value) + intSize) {
+					return
+				}
+			}
+		})
+	}
+	size := sliceHeaderSize + 3*ptrSize + colSize(s.head) + colSize(s.tail)
+	return size + sampled(len(s.scores), samples, func(visit func(int) bool) {
+		for iter := s.head.cells[0].next; iter != s.tail; iter = iter.cells[0].next {
+			if !visit(colSize(iter) + strHeaderSize + intSize + mapEntryOverhead) {
+				return
+			}
+		}
+	})
+}
+
+// Encoding reports the internal encoding of the list, as shown by OBJECT
+// ENCODING.
+func (l *LinkedList) Encoding() string {
+	if l.quicklist {
+		return "quicklist"
+	}
+	return "listpack"
+}
+
+// Encoding reports the internal encoding of the set, as shown by OBJECT
+// ENCODING.
+func (s *Set) Encoding() string {
+	if s.intset() {
+		return "intset"
+	}
+	return "hashtable"
+}
+
+// Encoding reports the internal encoding of the hash, as shown by OBJECT
+// ENCODING.
+func (h *Hash) Encoding() string {
+	switch {
+	case !h.packed():
+		return "hashtable"
+	case len(h.expires) > 0:
+		return "listpackex"
+	}
+	return "listpack"
+}
+
+// Encoding reports the internal encoding of the sorted set, as shown by
+// OBJECT ENCODING.
+func (s *SortedSet[S]) Encoding() string {
+	if s.packed() {
+		return "listpack"
+	}
+	return "skiplist"
+}
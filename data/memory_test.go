@@ -0,0 +1,54 @@
+package data_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ttn-nguyen42/gedis/data"
+)
+
+func TestStringEncoding(t *testing.T) {
+	tests := []struct {
+		value    any
+		expected string
+	}{
+		{[]byte("123"), "int"},
+		{[]byte("-7"), "int"},
+		{[]byte("007"), "embstr"},
+		{[]byte("hello"), "embstr"},
+		{"inline", "embstr"},
+		{[]byte(strings.Repeat("x", 45)), "raw"},
+	}
+
+	for _, tt := range tests {
+		if got := data.StringEncoding(tt.value); got != tt.expected {
+			t.Errorf("StringEncoding(%v) = %s, expected %s", tt.value, got, tt.expected)
+		}
+	}
+}
+
+func TestMemoryUsage_Sampling(t *testing.T) {
+	list := data.NewLinkedList()
+	for range 100 {
+		list.RightPush([]byte("0123456789"))
+	}
+
+	all := list.MemoryUsage(0)
+	sampled := list.MemoryUsage(5)
+	if all != sampled {
+		t.Fatalf("uniform list should extrapolate exactly, got %d sampled vs %d exact", sampled, all)
+	}
+
+	empty := data.NewLinkedList().MemoryUsage(5)
+	if all <= empty {
+		t.Fatalf("expected list with elements to use more than an empty one, got %d <= %d", all, empty)
+	}
+
+	set := data.NewSet()
+	set.Add("a")
+	small := set.MemoryUsage(0)
+	set.Add(strings.Repeat("b", 100))
+	if set.MemoryUsage(0) <= small {
+		t.Fatalf("expected set usage to grow with its members")
+	}
+}
@@ -1,22 +1,69 @@
 package data
 
+import (
+	"maps"
+	"slices"
+	"strconv"
+)
+
 type Set struct {
+	// ints hold the members of a set of integers, sorted, until it gets
+	// another member or outgrows Limits and members takes over
+	ints    []int64
 	members map[string]bool
 }
 
 func NewSet() *Set {
 	return &Set{
-		members: make(map[string]bool),
+		ints: make([]int64, 0),
 	}
 }
 
+// intset reports whether the set still holds its members in ints.
+func (s *Set) intset() bool {
+	return s.members == nil
+}
+
+// toHashtable moves the members from ints to the map.
+func (s *Set) toHashtable() {
+	s.members = make(map[string]bool, len(s.ints)+1)
+	for _, n := range s.ints {
+		s.members[strconv.FormatInt(n, 10)] = true
+	}
+	s.ints = nil
+}
+
 func (s *Set) Add(member string) bool {
+	if s.intset() {
+		if n, ok := parseIntsetMember(member); ok {
+			i, exists := slices.BinarySearch(s.ints, n)
+			if exists {
+				return false
+			}
+			if len(s.ints) < Limits.SetMaxIntsetEntries {
+				s.ints = slices.Insert(s.ints, i, n)
+				return true
+			}
+		}
+		s.toHashtable()
+	}
 	_, exists := s.members[member]
 	s.members[member] = true
 	return !exists
 }
 
 func (s *Set) Remove(member string) bool {
+	if s.intset() {
+		n, ok := parseIntsetMember(member)
+		if !ok {
+			return false
+		}
+		i, exists := slices.BinarySearch(s.ints, n)
+		if exists {
+			s.ints = slices.Delete(s.ints, i, i+1)
+		}
+		return exists
+	}
 	_, exists := s.members[member]
 	if exists {
 		delete(s.members, member)
@@ -25,61 +72,128 @@ func (s *Set) Remove(member string) bool {
 }
 
 func (s *Set) Contains(member string) bool {
+	if s.intset() {
+		n, ok := parseIntsetMember(member)
+		if !ok {
+			return false
+		}
+		_, exists := slices.BinarySearch(s.ints, n)
+		return exists
+	}
 	_, exists := s.members[member]
 	return exists
 }
 
 func (s *Set) Members() []string {
-	members := make([]string, 0, len(s.members))
-	for member := range s.members {
+	members := make([]string, 0, s.Len())
+	s.Each(func(member string, _ float64) bool {
 		members = append(members, member)
-	}
+		return true
+	})
 	return members
 }
 
+// Score returns 1 for the members of the set, for it to be used as a sorted
+// set input of Union, Inter and Diff.
+func (s *Set) Score(member string) (float64, bool) {
+	if s.Contains(member) {
+		return 1, true
+	}
+	return 0, false
+}
+
+// Each calls fn with every member, scoring 1, until fn returns false.
+func (s *Set) Each(fn func(member string, score float64) bool) {
+	if s.intset() {
+		for _, n := range s.ints {
+			if !fn(strconv.FormatInt(n, 10), 1) {
+				return
+			}
+		}
+		return
+	}
+	for member := range s.members {
+		if !fn(member, 1) {
+			return
+		}
+	}
+}
+
 func (s *Set) Len() int {
+	if s.intset() {
+		return len(s.ints)
+	}
 	return len(s.members)
 }
 
 func (s *Set) IsEmpty() bool {
-	return len(s.members) == 0
+	return s.Len() == 0
 }
 
 func (s *Set) Clear() {
-	s.members = make(map[string]bool)
+	s.ints = make([]int64, 0)
+	s.members = nil
 }
 
 func (s *Set) Intersect(other *Set) *Set {
 	result := NewSet()
-	for member := range s.members {
+	s.Each(func(member string, _ float64) bool {
 		if other.Contains(member) {
 			result.Add(member)
 		}
-	}
+		return true
+	})
 	return result
 }
 
 func (s *Set) Union(other *Set) *Set {
 	result := NewSet()
-	for member := range s.members {
-		result.Add(member)
-	}
-	for member := range other.members {
-		result.Add(member)
+	for _, set := range []*Set{s, other} {
+		set.Each(func(member string, _ float64) bool {
+			result.Add(member)
+			return true
+		})
 	}
 	return result
 }
 
 func (s *Set) Difference(other *Set) *Set {
 	result := NewSet()
-	for member := range s.members {
+	s.Each(func(member string, _ float64) bool {
 		if !other.Contains(member) {
 			result.Add(member)
 		}
-	}
+		return true
+	})
+	return result
+}
+
+// IntersectAll returns the members of all of sets. The smallest set is
+// walked and the others are looked up, so that the cost depends on it
+// rather than on the largest.
+func IntersectAll(sets []*Set) *Set {
+	result := NewSet()
+	eachInter(scoredSets(sets), func(member string, _ []float64) bool {
+		result.Add(member)
+		return true
+	})
 	return result
 }
 
+// IntersectCard returns the number of members of all of sets, counting up
+// to limit when it is positive.
+func IntersectCard(sets []*Set, limit int) int {
+	return InterCard(scoredSets(sets), limit)
+}
+
+func scoredSets(sets []*Set) []Scored {
+	scored := make([]Scored, len(sets))
+	for i, set := range sets {
+		scored[i] = set
+	}
+	return scored
+}
+
 func (s *Set) RandomMembers(count int) []string {
 	if count <= 0 {
 		return []string{}
@@ -115,3 +229,11 @@ func (s *Set) PopRandom(count int) []string {
 	}
 	return result
 }
+
+// Clone returns a copy of the set, in the same encoding.
+func (s *Set) Clone() *Set {
+	return &Set{
+		ints:    slices.Clone(s.ints),
+		members: maps.Clone(s.members),
+	}
+}
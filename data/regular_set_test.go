@@ -101,6 +101,29 @@ func TestSet_Operations(t *testing.T) {
 			t.Errorf("Difference failed, got %v", diff.Members())
 		}
 	})
+
+	t.Run("IntersectAll", func(t *testing.T) {
+		s3 := NewSet()
+		for _, member := range []string{"a", "b", "c", "d"} {
+			s3.Add(member)
+		}
+		inter := IntersectAll([]*Set{s3, s1, s2})
+		if inter.Len() != 1 || !inter.Contains("b") {
+			t.Errorf("IntersectAll failed, got %v", inter.Members())
+		}
+		if inter := IntersectAll([]*Set{s1, NewSet()}); !inter.IsEmpty() {
+			t.Errorf("IntersectAll with an empty set got %v", inter.Members())
+		}
+	})
+
+	t.Run("IntersectCard", func(t *testing.T) {
+		if got := IntersectCard([]*Set{s1, s1}, 0); got != 2 {
+			t.Errorf("IntersectCard() = %d, expected 2", got)
+		}
+		if got := IntersectCard([]*Set{s1, s1}, 1); got != 1 {
+			t.Errorf("IntersectCard(limit=1) = %d, expected 1", got)
+		}
+	})
 }
 
 func TestSet_Clear(t *testing.T) {
@@ -165,3 +188,23 @@ func TestSet_Random_EdgeCases(t *testing.T) {
 		t.Errorf("PopRandom(1) on empty set returned %d items", len(poppedEmpty))
 	}
 }
+
+func TestSet_Clone(t *testing.T) {
+	s := NewSet()
+	s.Add("a")
+	s.Add("b")
+
+	clone := s.Clone()
+	if clone.Len() != 2 || !clone.Contains("a") || !clone.Contains("b") {
+		t.Errorf("Clone() = %v, want [a b]", clone.Members())
+	}
+
+	clone.Add("c")
+	s.Remove("a")
+	if s.Contains("c") {
+		t.Error("Adding to the clone should not change the original")
+	}
+	if !clone.Contains("a") {
+		t.Error("Removing from the original should not change the clone")
+	}
+}
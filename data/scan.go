@@ -0,0 +1,163 @@
+package data
+
+import (
+	"cmp"
+	"container/heap"
+	"hash/fnv"
+	"iter"
+	"slices"
+)
+
+// scanHash places an element on the ring the scan cursors walk through.
+func scanHash(element string) uint64 {
+	h := fnv.New32a()
+	h.Write([]byte(element))
+	return uint64(h.Sum32())
+}
+
+type scanItem struct {
+	hash  uint64
+	value string
+}
+
+func compareScanItems(a, b scanItem) int {
+	return cmp.Or(cmp.Compare(a.hash, b.hash), cmp.Compare(a.value, b.value))
+}
+
+// scanHeap keeps the item with the highest hash of a page on top, the first
+// to leave when a lower one comes.
+type scanHeap []scanItem
+
+func (h scanHeap) Len() int           { return len(h) }
+func (h scanHeap) Less(i, j int) bool { return compareScanItems(h[i], h[j]) > 0 }
+func (h scanHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *scanHeap) Push(x any)        { *h = append(*h, x.(scanItem)) }
+func (h *scanHeap) Pop() any {
+	last := (*h)[len(*h)-1]
+	*h = (*h)[:len(*h)-1]
+	return last
+}
+
+// scan returns a page of about count elements of seq, resuming at cursor,
+// along with the cursor of the next page, 0 once the scan is complete.
+//
+// Elements are visited in the order of their hash rather than an order
+// that depends on the other elements, so that, like Redis' SCAN, every
+// element present for the whole scan is returned at least once even when
+// others are added or removed between calls. Elements sharing a hash are
+// never split over two pages. Nothing is kept between pages: each goes
+// through seq once, keeping the count lowest hashes from the cursor on.
+func scan(seq iter.Seq[string], cursor uint64, count int) ([]string, uint64) {
+	// cursor 0 starts the scan, any other cursor is the hash to resume at
+	// plus one
+	start := uint64(0)
+	if cursor > 0 {
+		start = cursor - 1
+	}
+	count = max(count, 1)
+
+	page := make(scanHeap, 0)
+	// next is the lowest hash left out of the page, where the next page
+	// resumes
+	next, more := uint64(0), false
+	leave := func(hash uint64) {
+		if !more || hash < next {
+			next, more = hash, true
+		}
+	}
+	for element := range seq {
+		it := scanItem{hash: scanHash(element), value: element}
+		switch {
+		case it.hash < start:
+		case len(page) < count:
+			heap.Push(&page, it)
+		case compareScanItems(it, page[0]) < 0:
+			leave(page[0].hash)
+			page[0] = it
+			heap.Fix(&page, 0)
+		default:
+			leave(it.hash)
+		}
+	}
+	slices.SortFunc(page, compareScanItems)
+
+	values := func(items []scanItem) []string {
+		out := make([]string, len(items))
+		for i, it := range items {
+			out[i] = it.value
+		}
+		return out
+	}
+	if !more {
+		return values(page), 0
+	}
+	// the elements sharing the hash of some left out go to the next page
+	// with them
+	kept := slices.IndexFunc(page, func(it scanItem) bool { return it.hash >= next })
+	if kept < 0 {
+		kept = len(page)
+	}
+	if kept > 0 {
+		return values(page[:kept]), next + 1
+	}
+	// a single hash has more than count elements, they are all returned
+	group := make([]string, 0)
+	for element := range seq {
+		if scanHash(element) == next {
+			group = append(group, element)
+		}
+	}
+	slices.Sort(group)
+	return group, next + 2
+}
+
+// scanWhole returns every element of seq as a single page, what a scan of a
+// collection in a compact encoding returns, as in Redis.
+func scanWhole(seq iter.Seq[string]) ([]string, uint64) {
+	return slices.Collect(seq), 0
+}
+
+// Scan returns a page of about count fields starting at cursor and the
+// cursor of the next page, 0 when every field has been returned. A hash
+// still in a listpack is returned whole.
+func (h *Hash) Scan(cursor uint64, count int) ([]string, uint64) {
+	fields := func(yield func(string) bool) {
+		h.each(func(field string, _ []byte) bool {
+			return yield(field)
+		})
+	}
+	if h.packed() {
+		return scanWhole(fields)
+	}
+	return scan(fields, cursor, count)
+}
+
+// Scan returns a page of about count members starting at cursor and the
+// cursor of the next page, 0 when every member has been returned. An
+// intset is returned whole.
+func (s *Set) Scan(cursor uint64, count int) ([]string, uint64) {
+	members := func(yield func(string) bool) {
+		s.Each(func(member string, _ float64) bool {
+			return yield(member)
+		})
+	}
+	if s.intset() {
+		return scanWhole(members)
+	}
+	return scan(members, cursor, count)
+}
+
+// Scan returns a page of about count members starting at cursor and the
+// cursor of the next page, 0 when every member has been returned. A sorted
+// set still in a listpack is returned whole.
+func (s *SortedSet[S]) Scan(cursor uint64, count int) ([]string, uint64) {
+	members := func(yield func(string) bool) {
+		s.Each(func(member string, _ S) bool {
+			return yield(member)
+		})
+	}
+	if s.packed() {
+		return scanWhole(members)
+	}
+	return scan(members, cursor, count)
+}
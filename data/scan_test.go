@@ -0,0 +1,226 @@
+package data
+
+import (
+	"fmt"
+	"testing"
+)
+
+func scanAll(t *testing.T, h *Hash, count int, between func(step int)) map[string]int {
+	t.Helper()
+	seen := make(map[string]int)
+	cursor := uint64(0)
+	for step := 0; ; step += 1 {
+		if step > 10000 {
+			t.Fatal("scan did not terminate")
+		}
+		page, next := h.Scan(cursor, count)
+		for _, field := range page {
+			seen[field] += 1
+		}
+		if next == 0 {
+			return seen
+		}
+		cursor = next
+		if between != nil {
+			between(step)
+		}
+	}
+}
+
+func TestHash_Scan(t *testing.T) {
+	h := NewHash()
+	for i := 0; i < 1000; i += 1 {
+		h.Set(fmt.Sprintf("field:%d", i), nil)
+	}
+
+	seen := scanAll(t, h, 10, nil)
+	if len(seen) != 1000 {
+		t.Fatalf("scan returned %d distinct fields, want 1000", len(seen))
+	}
+	for field, n := range seen {
+		if n != 1 {
+			t.Errorf("field %s returned %d times without concurrent changes", field, n)
+		}
+	}
+}
+
+func TestHash_ScanWhileModified(t *testing.T) {
+	h := NewHash()
+	for i := 0; i < 500; i += 1 {
+		h.Set(fmt.Sprintf("stable:%d", i), nil)
+		h.Set(fmt.Sprintf("removed:%d", i), nil)
+	}
+
+	seen := scanAll(t, h, 7, func(step int) {
+		h.Delete(fmt.Sprintf("removed:%d", step))
+		h.Set(fmt.Sprintf("added:%d", step), nil)
+	})
+	for i := 0; i < 500; i += 1 {
+		if seen[fmt.Sprintf("stable:%d", i)] == 0 {
+			t.Errorf("stable:%d was never returned", i)
+		}
+	}
+}
+
+func TestHash_ScanEmpty(t *testing.T) {
+	page, next := NewHash().Scan(0, 10)
+	if len(page) != 0 || next != 0 {
+		t.Errorf("Scan() on empty hash = %v, %d", page, next)
+	}
+}
+
+func TestHash_RandomFields(t *testing.T) {
+	h := NewHash()
+	h.Set("a", []byte("1"))
+	h.Set("b", []byte("2"))
+	h.Set("c", []byte("3"))
+
+	if got := h.RandomFields(2); len(got) != 2 || got[0] == got[1] {
+		t.Errorf("RandomFields(2) = %v, want 2 distinct fields", got)
+	}
+	if got := h.RandomFields(10); len(got) != 3 {
+		t.Errorf("RandomFields(10) = %v, want all 3 fields", got)
+	}
+	if got := h.RandomFields(-10); len(got) != 10 {
+		t.Errorf("RandomFields(-10) returned %d fields, want 10", len(got))
+	}
+	if got := NewHash().RandomFields(-3); len(got) != 0 {
+		t.Errorf("RandomFields(-3) on empty hash = %v", got)
+	}
+}
+
+func TestSortedSet_Scan(t *testing.T) {
+	s := NewSortedSet[float64]()
+	for i := 0; i < 300; i += 1 {
+		s.Insert(fmt.Sprintf("member:%d", i), float64(i%7))
+	}
+
+	seen := make(map[string]int)
+	cursor := uint64(0)
+	for {
+		page, next := s.Scan(cursor, 20)
+		for _, member := range page {
+			seen[member] += 1
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	if len(seen) != 300 {
+		t.Fatalf("scan returned %d distinct members, want 300", len(seen))
+	}
+}
+
+func TestSet_Scan(t *testing.T) {
+	s := NewSet()
+	for i := 0; i < 300; i += 1 {
+		s.Add(fmt.Sprintf("member:%d", i))
+	}
+
+	seen := make(map[string]int)
+	cursor := uint64(0)
+	for {
+		page, next := s.Scan(cursor, 20)
+		for _, member := range page {
+			seen[member] += 1
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	if len(seen) != 300 {
+		t.Fatalf("scan returned %d distinct members, want 300", len(seen))
+	}
+}
+
+// TestHash_ScanLarge walks a large hash while fields are deleted, each page
+// returning the fields whose hash follows the cursor.
+func TestHash_ScanLarge(t *testing.T) {
+	h := NewHash()
+	for i := 0; i < 100000; i += 1 {
+		h.Set(fmt.Sprintf("field:%d", i), nil)
+	}
+
+	seen := make(map[string]int)
+	deleted := make(map[string]bool)
+	cursor := uint64(0)
+	for step := 0; ; step += 1 {
+		page, next := h.Scan(cursor, 500)
+		for _, field := range page {
+			seen[field] += 1
+			if deleted[field] {
+				t.Errorf("field %s returned once deleted", field)
+			}
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+		if step%10 == 0 {
+			field := fmt.Sprintf("field:%d", step)
+			h.Delete(field)
+			deleted[field] = true
+		}
+	}
+	for i := 0; i < 100000; i += 1 {
+		field := fmt.Sprintf("field:%d", i)
+		if n := seen[field]; n != 1 && !deleted[field] {
+			t.Errorf("field %s returned %d times", field, n)
+		}
+	}
+}
+
+func TestScan_Compact(t *testing.T) {
+	h := NewHash()
+	s := NewSet()
+	z := NewSortedSet[float64]()
+	for i := 0; i < 50; i += 1 {
+		h.Set(fmt.Sprintf("field:%d", i), nil)
+		s.Add(fmt.Sprint(i))
+		z.Insert(fmt.Sprintf("member:%d", i), float64(i))
+	}
+
+	for name, scan := range map[string]func(uint64, int) ([]string, uint64){
+		"listpack hash":       h.Scan,
+		"intset":              s.Scan,
+		"listpack sorted set": z.Scan,
+	} {
+		if page, next := scan(0, 10); len(page) != 50 || next != 0 {
+			t.Errorf("%s: Scan() returned %d elements and cursor %d, expected all of them", name, len(page), next)
+		}
+	}
+}
+
+func TestSet_ScanSharedHash(t *testing.T) {
+	a, b := "member:836889", "member:1073210"
+	if scanHash(a) != scanHash(b) {
+		t.Fatalf("%s and %s do not share a hash", a, b)
+	}
+
+	s := NewSet()
+	for i := 0; i < 200; i += 1 {
+		s.Add(fmt.Sprintf("other:%d", i))
+	}
+	s.Add(a)
+	s.Add(b)
+
+	cursor, total := uint64(0), 0
+	for {
+		page, next := s.Scan(cursor, 1)
+		total += len(page)
+		for _, member := range page {
+			if (member == a || member == b) && len(page) != 2 {
+				t.Errorf("members sharing a hash split over pages, got %v", page)
+			}
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	if total != 202 {
+		t.Errorf("scan returned %d members, expected 202", total)
+	}
+}
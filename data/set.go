@@ -3,10 +3,13 @@ package data
 import (
 	"cmp"
 	"math/rand"
+	"slices"
 	"time"
 )
 
-const MAX_LEVEL = 20
+// MAX_LEVEL bounds the levels of the skip list, enough for about 2^32
+// members with a level added at every other column.
+const MAX_LEVEL = 32
 
 var seed = newSeededRand()
 
@@ -14,9 +17,13 @@ func newSeededRand() *rand.Rand {
 	return rand.New(rand.NewSource(time.Now().UnixNano()))
 }
 
+// cell links a column to its neighbours on one level. span is the number of
+// level 0 steps from the column to next, so that the rank of a column is
+// the sum of the spans followed to reach it from the head.
 type cell[S cmp.Ordered] struct {
 	prev *column[S]
 	next *column[S]
+	span int
 }
 
 type column[S cmp.Ordered] struct {
@@ -26,19 +33,51 @@ type column[S cmp.Ordered] struct {
 }
 
 type SortedSet[S cmp.Ordered] struct {
+	// nodes hold the members of a small set, in order, until it outgrows
+	// Limits and the skip list takes over
+	nodes  []Node[S]
 	head   *column[S]
 	tail   *column[S]
 	scores map[string]S
 }
 
 func NewSortedSet[S cmp.Ordered]() *SortedSet[S] {
-	set := &SortedSet[S]{
-		head:   nil,
-		tail:   nil,
-		scores: make(map[string]S),
+	return &SortedSet[S]{
+		nodes: make([]Node[S], 0),
 	}
-	set.init()
-	return set
+}
+
+// packed reports whether the set still holds its members in nodes.
+func (s *SortedSet[S]) packed() bool {
+	return s.head == nil
+}
+
+// toSkiplist moves the members from nodes to the skip list.
+func (s *SortedSet[S]) toSkiplist() {
+	nodes := s.nodes
+	s.nodes = nil
+	s.scores = make(map[string]S, len(nodes)+1)
+	s.init()
+	for _, node := range nodes {
+		s.insert(node.Value, node.Score)
+	}
+}
+
+// find returns the index of value in nodes, or -1.
+func (s *SortedSet[S]) find(value string) int {
+	for i := range s.nodes {
+		if s.nodes[i].Value == value {
+			return i
+		}
+	}
+	return -1
+}
+
+func compareNodes[S cmp.Ordered](l Node[S], r Node[S]) int {
+	return cmp.Or(
+		cmp.Compare(l.Score, r.Score),
+		cmp.Compare(l.Value, r.Value),
+	)
 }
 
 func (s *SortedSet[S]) init() {
@@ -49,6 +88,7 @@ func (s *SortedSet[S]) init() {
 		s.head.cells = append(s.head.cells, cell[S]{
 			prev: nil,
 			next: s.tail,
+			span: 1,
 		})
 		s.tail.cells = append(s.tail.cells, cell[S]{
 			prev: s.head,
@@ -58,11 +98,14 @@ func (s *SortedSet[S]) init() {
 }
 
 func (s *SortedSet[S]) Len() int {
+	if s.packed() {
+		return len(s.nodes)
+	}
 	return len(s.scores)
 }
 
 func (s *SortedSet[S]) IsEmpty() bool {
-	return len(s.scores) == 0
+	return s.Len() == 0
 }
 
 func (s *SortedSet[S]) Insert(data string, score S) bool {
@@ -70,6 +113,23 @@ func (s *SortedSet[S]) Insert(data string, score S) bool {
 }
 
 func (s *SortedSet[S]) insert(value string, score S) bool {
+	if s.packed() {
+		i := s.find(value)
+		if i >= 0 && s.nodes[i].Score == score {
+			return true
+		}
+		if len(value) <= Limits.ZsetMaxListpackValue && (i >= 0 || len(s.nodes) < Limits.ZsetMaxListpackEntries) {
+			if i >= 0 {
+				s.nodes = slices.Delete(s.nodes, i, i+1)
+			}
+			node := Node[S]{Score: score, Value: value}
+			at, _ := slices.BinarySearchFunc(s.nodes, node, compareNodes[S])
+			s.nodes = slices.Insert(s.nodes, at, node)
+			return i >= 0
+		}
+		s.toSkiplist()
+	}
+
 	oldScore, exists := s.scores[value]
 	if exists && oldScore == score {
 		return true
@@ -84,25 +144,28 @@ func (s *SortedSet[S]) insert(value string, score S) bool {
 	}
 
 	// level 0, add levels bottom up
-	col.cells = append(col.cells, cell[S]{nil, nil})
+	col.cells = append(col.cells, cell[S]{})
 	for len(col.cells) < MAX_LEVEL && s.shouldAddLevel() {
-		col.cells = append(col.cells, cell[S]{nil, nil})
+		col.cells = append(col.cells, cell[S]{})
 	}
 
-	iter := s.head
-	for lvl := MAX_LEVEL - 1; lvl >= 0; lvl -= 1 {
-		// find a lower bound
-		for iter.cells[lvl].next != s.tail && s.compare(iter.cells[lvl].next, col) < 0 {
-			iter = iter.cells[lvl].next
+	update, rank := s.predecessors(col)
+	for lvl := 0; lvl < MAX_LEVEL; lvl += 1 {
+		prev := update[lvl]
+		// the column skips past on the levels it is not part of
+		if lvl >= len(col.cells) {
+			prev.cells[lvl].span += 1
+			continue
 		}
-		// if the current column choose to be included on this level
-		if lvl < len(col.cells) {
-			next := iter.cells[lvl].next
-			iter.cells[lvl].next = col
-			next.cells[lvl].prev = col
-			col.cells[lvl].next = next
-			col.cells[lvl].prev = iter
+		next := prev.cells[lvl].next
+		col.cells[lvl] = cell[S]{
+			prev: prev,
+			next: next,
+			span: prev.cells[lvl].span - (rank[0] - rank[lvl]),
 		}
+		prev.cells[lvl].next = col
+		prev.cells[lvl].span = rank[0] - rank[lvl] + 1
+		next.cells[lvl].prev = col
 	}
 
 	s.scores[value] = score
@@ -110,6 +173,14 @@ func (s *SortedSet[S]) insert(value string, score S) bool {
 }
 
 func (s *SortedSet[S]) Remove(value string) bool {
+	if s.packed() {
+		i := s.find(value)
+		if i < 0 {
+			return false
+		}
+		s.nodes = slices.Delete(s.nodes, i, i+1)
+		return true
+	}
 	score, exists := s.scores[value]
 	if !exists {
 		return false
@@ -118,31 +189,131 @@ func (s *SortedSet[S]) Remove(value string) bool {
 }
 
 func (s *SortedSet[S]) remove(value string, score S) bool {
-	col := &column[S]{nil, value, score}
-
-	lbCol := s.lowerBound(score, value)
-	if s.compare(lbCol, col) != 0 {
+	update, _ := s.predecessors(&column[S]{nil, value, score})
+	col := update[0].cells[0].next
+	if col == s.tail || col.value != value || col.score != score {
 		return false
 	}
+	s.unlink(col, update)
+	return true
+}
 
+// predecessors returns the last column before col on each level, along
+// with its rank.
+func (s *SortedSet[S]) predecessors(col *column[S]) (update [MAX_LEVEL]*column[S], rank [MAX_LEVEL]int) {
 	iter := s.head
 	for lvl := MAX_LEVEL - 1; lvl >= 0; lvl -= 1 {
-		// find upper bound
-		for iter.cells[lvl].next != s.tail && s.compare(iter.cells[lvl].next, col) <= 0 {
+		if lvl < MAX_LEVEL-1 {
+			rank[lvl] = rank[lvl+1]
+		}
+		for iter.cells[lvl].next != s.tail && s.compare(iter.cells[lvl].next, col) < 0 {
+			rank[lvl] += iter.cells[lvl].span
 			iter = iter.cells[lvl].next
 		}
+		update[lvl] = iter
+	}
+	return update, rank
+}
+
+// unlink takes col out of every level, update holding the column before it
+// on each of them.
+func (s *SortedSet[S]) unlink(col *column[S], update [MAX_LEVEL]*column[S]) {
+	for lvl := 0; lvl < MAX_LEVEL; lvl += 1 {
+		prev := update[lvl]
+		if prev.cells[lvl].next != col {
+			prev.cells[lvl].span -= 1
+			continue
+		}
+		next := col.cells[lvl].next
+		prev.cells[lvl].span += col.cells[lvl].span - 1
+		prev.cells[lvl].next = next
+		next.cells[lvl].prev = prev
+	}
+	delete(s.scores, col.value)
+}
 
-		// connect prev with next
-		if iter == lbCol {
-			prev := iter.cells[lvl].prev
-			next := iter.cells[lvl].next
-			prev.cells[lvl].next = next
-			next.cells[lvl].prev = prev
+// byRank returns the column at rank, counted from 1, or the head for 0.
+func (s *SortedSet[S]) byRank(rank int) *column[S] {
+	traversed := 0
+	iter := s.head
+	for lvl := MAX_LEVEL - 1; lvl >= 0; lvl -= 1 {
+		for iter.cells[lvl].next != s.tail && traversed+iter.cells[lvl].span <= rank {
+			traversed += iter.cells[lvl].span
+			iter = iter.cells[lvl].next
 		}
 	}
+	return iter
+}
 
-	delete(s.scores, value)
-	return true
+// PopMin removes and returns up to count members with the lowest scores,
+// from the lowest.
+func (s *SortedSet[S]) PopMin(count int) []Node[S] {
+	if s.packed() {
+		n := min(count, len(s.nodes))
+		result := slices.Clone(s.nodes[:n])
+		s.nodes = slices.Delete(s.nodes, 0, n)
+		return result
+	}
+	result := make([]Node[S], 0, min(count, len(s.scores)))
+	for len(result) < count && !s.IsEmpty() {
+		first := s.head.cells[0].next
+		result = append(result, Node[S]{Score: first.score, Value: first.value})
+		s.remove(first.value, first.score)
+	}
+	return result
+}
+
+// PopMax removes and returns up to count members with the highest scores,
+// from the highest.
+func (s *SortedSet[S]) PopMax(count int) []Node[S] {
+	if s.packed() {
+		n := min(count, len(s.nodes))
+		result := slices.Clone(s.nodes[len(s.nodes)-n:])
+		slices.Reverse(result)
+		s.nodes = slices.Delete(s.nodes, len(s.nodes)-n, len(s.nodes))
+		return result
+	}
+	result := make([]Node[S], 0, min(count, len(s.scores)))
+	for len(result) < count && !s.IsEmpty() {
+		last := s.tail.cells[0].prev
+		result = append(result, Node[S]{Score: last.score, Value: last.value})
+		s.remove(last.value, last.score)
+	}
+	return result
+}
+
+// RandomMembers returns count distinct members picked at random, all of them
+// when the set is smaller, or -count members that may repeat when count is
+// negative.
+func (s *SortedSet[S]) RandomMembers(count int) []Node[S] {
+	size := s.Len()
+	if count < 0 {
+		if size == 0 {
+			return []Node[S]{}
+		}
+		picked := make([]Node[S], -count)
+		for i := range picked {
+			picked[i] = s.at(seed.Intn(size))
+		}
+		return picked
+	}
+	nodes := s.getRange(0, size)
+	if count >= size {
+		return nodes
+	}
+	seed.Shuffle(size, func(i, j int) {
+		nodes[i], nodes[j] = nodes[j], nodes[i]
+	})
+	return nodes[:count]
+}
+
+// at returns the member at index idx, which must be within the set.
+func (s *SortedSet[S]) at(idx int) Node[S] {
+	if s.packed() {
+		return s.nodes[idx]
+	}
+	col := s.byRank(idx + 1)
+	return Node[S]{Score: col.score, Value: col.value}
 }
 
 func (s *SortedSet[S]) shouldAddLevel() bool {
@@ -156,18 +327,6 @@ func (s *SortedSet[S]) compare(l *column[S], r *column[S]) int {
 	)
 }
 
-// lowerBound finds the largest column with a score that is smaller/equal than the score
-func (s *SortedSet[S]) lowerBound(score S, value string) *column[S] {
-	col := s.head
-	cmpCol := &column[S]{nil, value, score}
-	for lvl := MAX_LEVEL - 1; lvl >= 0; lvl -= 1 {
-		for col.cells[lvl].next != s.tail && s.compare(col.cells[lvl].next, cmpCol) < 0 {
-			col = col.cells[lvl].next
-		}
-	}
-	return col.cells[0].next
-}
-
 type Node[S cmp.Ordered] struct {
 	Score S
 	Value string
@@ -181,30 +340,60 @@ func (s *SortedSet[S]) getRange(lidx int, ridx int) []Node[S] {
 	if lidx >= ridx {
 		return nil
 	}
-	result := make([]Node[S], 0, ridx-lidx+1)
-	iter := s.head.cells[0].next
-	for i := 0; i < len(s.scores); i += 1 {
-		if iter == s.tail {
-			break
-		}
-		if i >= lidx && i < ridx {
-			result = append(result, Node[S]{
-				Score: iter.score,
-				Value: iter.value,
-			})
-		}
+	lidx, ridx = max(lidx, 0), min(ridx, s.Len())
+	result := make([]Node[S], 0, max(ridx-lidx, 0))
+	if lidx >= ridx {
+		return result
+	}
+	if s.packed() {
+		return append(result, s.nodes[lidx:ridx]...)
+	}
+	iter := s.byRank(lidx + 1)
+	for i := lidx; i < ridx; i += 1 {
+		result = append(result, Node[S]{
+			Score: iter.score,
+			Value: iter.value,
+		})
 		iter = iter.cells[0].next
 	}
-
 	return result
 }
 
 func (s *SortedSet[S]) Score(value string) (S, bool) {
+	if s.packed() {
+		if i := s.find(value); i >= 0 {
+			return s.nodes[i].Score, true
+		}
+		var zero S
+		return zero, false
+	}
 	score, exists := s.scores[value]
 	return score, exists
 }
 
+// Each calls fn with the members in order and their scores until fn
+// returns false. fn must not modify the set.
+func (s *SortedSet[S]) Each(fn func(value string, score S) bool) {
+	if s.packed() {
+		for _, node := range s.nodes {
+			if !fn(node.Value, node.Score) {
+				return
+			}
+		}
+		return
+	}
+	for iter := s.head.cells[0].next; iter != s.tail; iter = iter.cells[0].next {
+		if !fn(iter.value, iter.score) {
+			return
+		}
+	}
+}
+
 func (s *SortedSet[S]) Rank(value string) (int, bool) {
+	if s.packed() {
+		i := s.find(value)
+		return i, i >= 0
+	}
 	score, exists := s.scores[value]
 	if !exists {
 		return -1, false
@@ -213,34 +402,23 @@ func (s *SortedSet[S]) Rank(value string) (int, bool) {
 }
 
 func (s *SortedSet[S]) rank(value string, score S) int {
-	col := s.lowerBound(score, value)
-	if s.compare(col, &column[S]{nil, value, score}) != 0 {
-		panic("rank called on non-existing member")
-	}
+	target := &column[S]{nil, value, score}
 	rank := 0
-	for iter := s.head.cells[0].next; iter != col; iter = iter.cells[0].next {
-		rank += 1
+	iter := s.head
+	for lvl := MAX_LEVEL - 1; lvl >= 0; lvl -= 1 {
+		for iter.cells[lvl].next != s.tail && s.compare(iter.cells[lvl].next, target) <= 0 {
+			rank += iter.cells[lvl].span
+			iter = iter.cells[lvl].next
+		}
 	}
-	return rank
-}
-
-func (s *SortedSet[S]) RangeByScore(min S, max S) []Node[S] {
-	return s.getRangeByScore(min, max)
-}
-
-func (s *SortedSet[S]) getRangeByScore(min S, max S) []Node[S] {
-	result := make([]Node[S], 0)
-	iter := s.lowerBound(min, "")
-	for iter != s.tail && cmp.Compare(iter.score, max) <= 0 {
-		result = append(result, Node[S]{
-			Score: iter.score,
-			Value: iter.value,
-		})
-		iter = iter.cells[0].next
+	if iter == s.head || s.compare(iter, target) != 0 {
+		panic("rank called on non-existing member")
 	}
-	return result
+	return rank - 1
 }
 
+// ReverseRange returns the members from index lidx to ridx, excluded, with
+// the indexes counted from the highest score.
 func (s *SortedSet[S]) ReverseRange(lidx int, ridx int) []Node[S] {
 	return s.getReverseRange(lidx, ridx)
 }
@@ -249,46 +427,38 @@ func (s *SortedSet[S]) getReverseRange(lidx int, ridx int) []Node[S] {
 	if lidx >= ridx {
 		return nil
 	}
-	result := make([]Node[S], 0, ridx-lidx+1)
-	iter := s.tail.cells[0].prev
-	total := len(s.scores)
-	for i := total - 1; i >= 0; i -= 1 {
-		if iter == s.head {
-			break
-		}
-		if i >= lidx && i < ridx {
-			result = append(result, Node[S]{
-				Score: iter.score,
-				Value: iter.value,
-			})
+	lidx, ridx = max(lidx, 0), min(ridx, s.Len())
+	result := make([]Node[S], 0, max(ridx-lidx, 0))
+	if lidx >= ridx {
+		return result
+	}
+	if s.packed() {
+		for i := len(s.nodes) - 1 - lidx; i >= len(s.nodes)-ridx; i -= 1 {
+			result = append(result, s.nodes[i])
 		}
+		return result
+	}
+	iter := s.byRank(len(s.scores) - lidx)
+	for i := lidx; i < ridx; i += 1 {
+		result = append(result, Node[S]{
+			Score: iter.score,
+			Value: iter.value,
+		})
 		iter = iter.cells[0].prev
 	}
 	return result
 }
 
 func (s *SortedSet[S]) ReverseRank(value string) (int, bool) {
-	score, exists := s.scores[value]
+	rank, exists := s.Rank(value)
 	if !exists {
 		return -1, false
 	}
-	return s.reverseRank(value, score), true
-}
-
-func (s *SortedSet[S]) reverseRank(value string, score S) int {
-	col := s.lowerBound(score, value)
-	if s.compare(col, &column[S]{nil, value, score}) != 0 {
-		panic("reverseRank called on non-existing member")
-	}
-	rank := 0
-	for iter := s.tail.cells[0].prev; iter != col; iter = iter.cells[0].prev {
-		rank += 1
-	}
-	return rank
+	return s.Len() - 1 - rank, true
 }
 
 func (s *SortedSet[S]) IncrementScore(value string, delta S) (S, bool) {
-	score, exists := s.scores[value]
+	score, exists := s.Score(value)
 	if exists {
 		s.Remove(value)
 	}
@@ -297,24 +467,196 @@ func (s *SortedSet[S]) IncrementScore(value string, delta S) (S, bool) {
 	return newScore, exists
 }
 
-func (s *SortedSet[S]) CountByScore(min S, max S) int {
-	count := 0
-	iter := s.lowerBound(min, "")
-	for iter != s.tail && cmp.Compare(iter.score, max) <= 0 {
-		count++
-		iter = iter.cells[0].next
+// RemoveRange removes the members from index lidx to ridx, excluded, and
+// returns how many were removed.
+func (s *SortedSet[S]) RemoveRange(lidx int, ridx int) int {
+	lidx, ridx = max(lidx, 0), min(ridx, s.Len())
+	if lidx >= ridx {
+		return 0
+	}
+	if s.packed() {
+		s.nodes = slices.Delete(s.nodes, lidx, ridx)
+		return ridx - lidx
+	}
+
+	var update [MAX_LEVEL]*column[S]
+	traversed := 0
+	iter := s.head
+	for lvl := MAX_LEVEL - 1; lvl >= 0; lvl -= 1 {
+		for iter.cells[lvl].next != s.tail && traversed+iter.cells[lvl].span <= lidx {
+			traversed += iter.cells[lvl].span
+			iter = iter.cells[lvl].next
+		}
+		update[lvl] = iter
 	}
-	return count
-}
 
-func (s *SortedSet[S]) RemoveByScore(min S, max S) int {
-	removed := 0
-	iter := s.lowerBound(min, "")
-	for iter != s.tail && cmp.Compare(iter.score, max) <= 0 {
+	// the columns before the removed ones stay the same, only their spans
+	// shrink
+	iter = iter.cells[0].next
+	for i := lidx; i < ridx; i += 1 {
 		next := iter.cells[0].next
-		s.remove(iter.value, iter.score)
-		removed++
+		s.unlink(iter, update)
 		iter = next
 	}
-	return removed
+	return ridx - lidx
+}
+
+// ScoreRange is an interval of scores, each bound included unless marked
+// exclusive.
+type ScoreRange[S cmp.Ordered] struct {
+	Min, Max     S
+	MinEx, MaxEx bool
+}
+
+// belowMin reports whether score comes before the start of the range.
+func (r ScoreRange[S]) belowMin(score S) bool {
+	if r.MinEx {
+		return cmp.Compare(score, r.Min) <= 0
+	}
+	return cmp.Compare(score, r.Min) < 0
+}
+
+// withinMax reports whether score comes before the end of the range.
+func (r ScoreRange[S]) withinMax(score S) bool {
+	if r.MaxEx {
+		return cmp.Compare(score, r.Max) < 0
+	}
+	return cmp.Compare(score, r.Max) <= 0
+}
+
+// LexBound is a bound of a LexRange: a member, included unless Exclusive,
+// or for a non zero Inf the infinity below (-1) or above (1) all members.
+type LexBound struct {
+	Value     string
+	Exclusive bool
+	Inf       int
+}
+
+// LexRange is an interval of members in lexicographic order. It is only
+// meaningful when all the members share the same score.
+type LexRange struct {
+	Min, Max LexBound
+}
+
+func (r LexRange) belowMin(value string) bool {
+	switch {
+	case r.Min.Inf != 0:
+		return r.Min.Inf > 0
+	case r.Min.Exclusive:
+		return value <= r.Min.Value
+	default:
+		return value < r.Min.Value
+	}
+}
+
+func (r LexRange) withinMax(value string) bool {
+	switch {
+	case r.Max.Inf != 0:
+		return r.Max.Inf > 0
+	case r.Max.Exclusive:
+		return value < r.Max.Value
+	default:
+		return value <= r.Max.Value
+	}
+}
+
+// seek returns the index of the first member for which before is false,
+// before holding for all the members up to it and for none after.
+func (s *SortedSet[S]) seek(before func(score S, value string) bool) int {
+	if s.packed() {
+		idx, _ := slices.BinarySearchFunc(s.nodes, true, func(node Node[S], _ bool) int {
+			if before(node.Score, node.Value) {
+				return -1
+			}
+			return 1
+		})
+		return idx
+	}
+	idx := 0
+	iter := s.head
+	for lvl := MAX_LEVEL - 1; lvl >= 0; lvl -= 1 {
+		for iter.cells[lvl].next != s.tail && before(iter.cells[lvl].next.score, iter.cells[lvl].next.value) {
+			idx += iter.cells[lvl].span
+			iter = iter.cells[lvl].next
+		}
+	}
+	return idx
+}
+
+// scoreIndexes returns the indexes of the members with a score within r,
+// from lo to hi excluded.
+func (s *SortedSet[S]) scoreIndexes(r ScoreRange[S]) (lo int, hi int) {
+	lo = s.seek(func(score S, _ string) bool { return r.belowMin(score) })
+	hi = s.seek(func(score S, _ string) bool { return r.withinMax(score) })
+	return lo, max(lo, hi)
+}
+
+func (s *SortedSet[S]) lexIndexes(r LexRange) (lo int, hi int) {
+	lo = s.seek(func(_ S, value string) bool { return r.belowMin(value) })
+	hi = s.seek(func(_ S, value string) bool { return r.withinMax(value) })
+	return lo, max(lo, hi)
+}
+
+// indexRange returns the members from index lo to hi, excluded, from the
+// highest when reverse, skipping the first offset of them and keeping at
+// most count, all of them when count is negative.
+func (s *SortedSet[S]) indexRange(lo, hi int, reverse bool, offset, count int) []Node[S] {
+	if offset < 0 {
+		return []Node[S]{}
+	}
+	size := max(hi-lo-offset, 0)
+	if count >= 0 {
+		size = min(size, count)
+	}
+	if reverse {
+		from := s.Len() - hi + offset
+		return s.getReverseRange(from, from+size)
+	}
+	return s.getRange(lo+offset, lo+offset+size)
+}
+
+// RangeByScore returns the members with a score within r, in order or from
+// the highest when reverse, skipping the first offset of them and keeping
+// at most count, all of them when count is negative.
+func (s *SortedSet[S]) RangeByScore(r ScoreRange[S], reverse bool, offset, count int) []Node[S] {
+	lo, hi := s.scoreIndexes(r)
+	return s.indexRange(lo, hi, reverse, offset, count)
+}
+
+func (s *SortedSet[S]) CountByScore(r ScoreRange[S]) int {
+	lo, hi := s.scoreIndexes(r)
+	return hi - lo
+}
+
+func (s *SortedSet[S]) RemoveByScore(r ScoreRange[S]) int {
+	return s.RemoveRange(s.scoreIndexes(r))
+}
+
+// RangeByLex is RangeByScore for a range of members, see LexRange.
+func (s *SortedSet[S]) RangeByLex(r LexRange, reverse bool, offset, count int) []Node[S] {
+	lo, hi := s.lexIndexes(r)
+	return s.indexRange(lo, hi, reverse, offset, count)
+}
+
+func (s *SortedSet[S]) CountByLex(r LexRange) int {
+	lo, hi := s.lexIndexes(r)
+	return hi - lo
+}
+
+func (s *SortedSet[S]) RemoveByLex(r LexRange) int {
+	return s.RemoveRange(s.lexIndexes(r))
+}
+
+// Clone returns a copy of the sorted set with the same members and scores,
+// in the same encoding.
+func (s *SortedSet[S]) Clone() *SortedSet[S] {
+	if s.packed() {
+		return &SortedSet[S]{nodes: slices.Clone(s.nodes)}
+	}
+	clone := NewSortedSet[S]()
+	clone.toSkiplist()
+	for iter := s.head.cells[0].next; iter != s.tail; iter = iter.cells[0].next {
+		clone.insert(iter.value, iter.score)
+	}
+	return clone
 }
@@ -0,0 +1,150 @@
+package data
+
+import (
+	"math"
+	"slices"
+)
+
+// Scored is an input of the sorted set operations: a sorted set, or a plain
+// set whose members all score 1.
+type Scored interface {
+	Len() int
+	Score(member string) (float64, bool)
+	// Each calls fn with every member and its score until fn returns false.
+	Each(fn func(member string, score float64) bool)
+}
+
+// Aggregate is how the scores a member has in several inputs are combined.
+type Aggregate int
+
+const (
+	AggregateSum Aggregate = iota
+	AggregateMin
+	AggregateMax
+)
+
+func (a Aggregate) combine(acc float64, score float64) float64 {
+	switch a {
+	case AggregateMin:
+		return min(acc, score)
+	case AggregateMax:
+		return max(acc, score)
+	}
+	// adding opposite infinities scores 0 rather than NaN
+	if sum := acc + score; !math.IsNaN(sum) {
+		return sum
+	}
+	return 0
+}
+
+// weighted returns the score of a member of input i, given weights for
+// each input or nil for all of them weighing 1. An infinite score weighing
+// 0 scores 0.
+func weighted(weights []float64, i int, score float64) float64 {
+	if weights == nil {
+		return score
+	}
+	if product := score * weights[i]; !math.IsNaN(product) {
+		return product
+	}
+	return 0
+}
+
+// Union returns the members of any of sets, scored by combining their
+// weighted scores with agg.
+func Union(sets []Scored, weights []float64, agg Aggregate) *SortedSet[float64] {
+	scores := make(map[string]float64)
+	for i, set := range sets {
+		set.Each(func(member string, score float64) bool {
+			score = weighted(weights, i, score)
+			if acc, exists := scores[member]; exists {
+				score = agg.combine(acc, score)
+			}
+			scores[member] = score
+			return true
+		})
+	}
+
+	result := NewSortedSet[float64]()
+	for member, score := range scores {
+		result.insert(member, score)
+	}
+	return result
+}
+
+// Inter returns the members of all of sets, scored by combining their
+// weighted scores with agg.
+func Inter(sets []Scored, weights []float64, agg Aggregate) *SortedSet[float64] {
+	result := NewSortedSet[float64]()
+	eachInter(sets, func(member string, scores []float64) bool {
+		acc := weighted(weights, 0, scores[0])
+		for i := 1; i < len(scores); i += 1 {
+			acc = agg.combine(acc, weighted(weights, i, scores[i]))
+		}
+		result.insert(member, acc)
+		return true
+	})
+	return result
+}
+
+// InterCard returns the number of members of all of sets, counting up to
+// limit when it is positive.
+func InterCard(sets []Scored, limit int) int {
+	count := 0
+	eachInter(sets, func(string, []float64) bool {
+		count += 1
+		return limit <= 0 || count < limit
+	})
+	return count
+}
+
+// eachInter calls fn with the members of all of sets and their score in
+// each of them, in the order of sets, until fn returns false. The smallest
+// set is walked and the others are looked up.
+func eachInter(sets []Scored, fn func(member string, scores []float64) bool) {
+	if len(sets) == 0 {
+		return
+	}
+	order := make([]int, len(sets))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return sets[a].Len() - sets[b].Len()
+	})
+	if sets[order[0]].Len() == 0 {
+		return
+	}
+
+	scores := make([]float64, len(sets))
+	sets[order[0]].Each(func(member string, score float64) bool {
+		scores[order[0]] = score
+		for _, i := range order[1:] {
+			other, exists := sets[i].Score(member)
+			if !exists {
+				return true
+			}
+			scores[i] = other
+		}
+		return fn(member, scores)
+	})
+}
+
+// Diff returns the members of the first of sets missing from all the others,
+// with their score in the first.
+func Diff(sets []Scored) *SortedSet[float64] {
+	result := NewSortedSet[float64]()
+	if len(sets) == 0 {
+		return result
+	}
+	sets[0].Each(func(member string, score float64) bool {
+		for _, other := range sets[1:] {
+			if _, exists := other.Score(member); exists {
+				return true
+			}
+		}
+		result.insert(member, score)
+		return true
+	})
+	return result
+}
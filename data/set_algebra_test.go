@@ -0,0 +1,120 @@
+package data_test
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/ttn-nguyen42/gedis/data"
+)
+
+func sortedSetOf(members map[string]float64) *data.SortedSet[float64] {
+	set := data.NewSortedSet[float64]()
+	for member, score := range members {
+		set.Insert(member, score)
+	}
+	return set
+}
+
+func nodesOf(set *data.SortedSet[float64]) []data.Node[float64] {
+	return set.Range(0, set.Len())
+}
+
+func TestUnion(t *testing.T) {
+	a := sortedSetOf(map[string]float64{"a": 1, "b": 2})
+	b := sortedSetOf(map[string]float64{"b": 3, "c": 4})
+	plain := data.NewSet()
+	plain.Add("c")
+	plain.Add("d")
+
+	tests := []struct {
+		name     string
+		weights  []float64
+		agg      data.Aggregate
+		expected []data.Node[float64]
+	}{
+		{"sum", nil, data.AggregateSum, []data.Node[float64]{
+			{Value: "a", Score: 1}, {Value: "d", Score: 1}, {Value: "b", Score: 5}, {Value: "c", Score: 5},
+		}},
+		{"min", nil, data.AggregateMin, []data.Node[float64]{
+			{Value: "a", Score: 1}, {Value: "c", Score: 1}, {Value: "d", Score: 1}, {Value: "b", Score: 2},
+		}},
+		{"max weighted", []float64{2, 1, 10}, data.AggregateMax, []data.Node[float64]{
+			{Value: "a", Score: 2}, {Value: "b", Score: 4}, {Value: "c", Score: 10}, {Value: "d", Score: 10},
+		}},
+	}
+	for _, tt := range tests {
+		got := nodesOf(data.Union([]data.Scored{a, b, plain}, tt.weights, tt.agg))
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%s: Union() = %v, expected %v", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestUnion_Infinities(t *testing.T) {
+	a := sortedSetOf(map[string]float64{"x": math.Inf(1)})
+	b := sortedSetOf(map[string]float64{"x": math.Inf(-1)})
+
+	if got := nodesOf(data.Union([]data.Scored{a, b}, nil, data.AggregateSum)); got[0].Score != 0 {
+		t.Errorf("inf + -inf scored %v, expected 0", got[0].Score)
+	}
+	if got := nodesOf(data.Union([]data.Scored{a}, []float64{0}, data.AggregateSum)); got[0].Score != 0 {
+		t.Errorf("inf weighted 0 scored %v, expected 0", got[0].Score)
+	}
+}
+
+func TestInter(t *testing.T) {
+	a := sortedSetOf(map[string]float64{"a": 1, "b": 2, "c": 3})
+	b := sortedSetOf(map[string]float64{"b": 10, "c": 20, "d": 30})
+	plain := data.NewSet()
+	plain.Add("c")
+
+	got := nodesOf(data.Inter([]data.Scored{a, b}, []float64{1, 2}, data.AggregateSum))
+	expected := []data.Node[float64]{{Value: "b", Score: 22}, {Value: "c", Score: 43}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Inter() = %v, expected %v", got, expected)
+	}
+
+	// the smallest input is walked first, weights still apply in order
+	got = nodesOf(data.Inter([]data.Scored{a, b, plain}, []float64{1, 1, 100}, data.AggregateMax))
+	expected = []data.Node[float64]{{Value: "c", Score: 100}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Inter() = %v, expected %v", got, expected)
+	}
+
+	if got := data.Inter([]data.Scored{a, data.NewSet()}, nil, data.AggregateSum); got.Len() != 0 {
+		t.Errorf("Inter() with an empty input has %d members", got.Len())
+	}
+}
+
+func TestInterCard(t *testing.T) {
+	a := sortedSetOf(map[string]float64{"a": 1, "b": 2, "c": 3, "d": 4})
+	b := sortedSetOf(map[string]float64{"b": 1, "c": 2, "d": 3, "e": 4})
+
+	tests := []struct {
+		limit    int
+		expected int
+	}{
+		{0, 3},
+		{2, 2},
+		{10, 3},
+	}
+	for _, tt := range tests {
+		if got := data.InterCard([]data.Scored{a, b}, tt.limit); got != tt.expected {
+			t.Errorf("InterCard(limit=%d) = %d, expected %d", tt.limit, got, tt.expected)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := sortedSetOf(map[string]float64{"a": 1, "b": 2, "c": 3})
+	b := sortedSetOf(map[string]float64{"b": 10})
+	plain := data.NewSet()
+	plain.Add("c")
+
+	got := nodesOf(data.Diff([]data.Scored{a, b, plain}))
+	expected := []data.Node[float64]{{Value: "a", Score: 1}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Diff() = %v, expected %v", got, expected)
+	}
+}
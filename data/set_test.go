@@ -1,6 +1,12 @@
 package data_test
 
 import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/ttn-nguyen42/gedis/data"
@@ -389,3 +395,341 @@ func TestSortedSet_Rank_FloatingPointScores(t *testing.T) {
 		t.Fatalf("expected rank 1 for 'mid', got %d", rank)
 	}
 }
+
+func TestSortedSet_Clone(t *testing.T) {
+	s := data.NewSortedSet[float64]()
+	s.Insert("a", 1)
+	s.Insert("b", 2)
+	s.Insert("c", 3)
+
+	clone := s.Clone()
+	got := collectAll(clone)
+	if len(got) != 3 {
+		t.Fatalf("expected clone length 3, got %d", len(got))
+	}
+	for i, want := range []string{"a", "b", "c"} {
+		if got[i].Value != want || got[i].Score != float64(i+1) {
+			t.Fatalf("clone[%d] = %v, want %s:%d", i, got[i], want, i+1)
+		}
+	}
+
+	clone.Insert("a", 10)
+	if score, _ := s.Score("a"); score != 1 {
+		t.Fatalf("updating the clone changed the original score to %v", score)
+	}
+	if rank, _ := clone.Rank("a"); rank != 2 {
+		t.Fatalf("expected a to be last in the clone, got rank %d", rank)
+	}
+}
+
+func TestSortedSet_Pop(t *testing.T) {
+	s := data.NewSortedSet[float64]()
+	s.Insert("b", 2)
+	s.Insert("a", 1)
+	s.Insert("c", 3)
+	s.Insert("d", 4)
+
+	got := s.PopMin(2)
+	if len(got) != 2 || got[0].Value != "a" || got[1].Value != "b" {
+		t.Fatalf("PopMin(2) = %v, want a then b", got)
+	}
+	got = s.PopMax(1)
+	if len(got) != 1 || got[0].Value != "d" || got[0].Score != 4 {
+		t.Fatalf("PopMax(1) = %v, want d:4", got)
+	}
+	if got = s.PopMax(10); len(got) != 1 || got[0].Value != "c" {
+		t.Fatalf("PopMax(10) = %v, want only c", got)
+	}
+	if !s.IsEmpty() {
+		t.Fatalf("expected the set to be empty, %d members left", s.Len())
+	}
+	if got = s.PopMin(1); len(got) != 0 {
+		t.Fatalf("PopMin on an empty set = %v", got)
+	}
+}
+
+// sortedMembers returns the members of the reference map in sorted set order.
+func sortedMembers(ref map[string]float64) []data.Node[float64] {
+	nodes := make([]data.Node[float64], 0, len(ref))
+	for value, score := range ref {
+		nodes = append(nodes, data.Node[float64]{Score: score, Value: value})
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Score != nodes[j].Score {
+			return nodes[i].Score < nodes[j].Score
+		}
+		return nodes[i].Value < nodes[j].Value
+	})
+	return nodes
+}
+
+func TestSortedSet_RanksAfterRandomChanges(t *testing.T) {
+	s := data.NewSortedSet[float64]()
+	ref := make(map[string]float64)
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i += 1 {
+		value := fmt.Sprintf("m%d", rnd.Intn(1000))
+		switch rnd.Intn(4) {
+		case 0:
+			s.Remove(value)
+			delete(ref, value)
+		default:
+			score := float64(rnd.Intn(100))
+			s.Insert(value, score)
+			ref[value] = score
+		}
+	}
+
+	want := sortedMembers(ref)
+	if s.Len() != len(want) {
+		t.Fatalf("expected %d members, got %d", len(want), s.Len())
+	}
+	for i, node := range want {
+		if rank, _ := s.Rank(node.Value); rank != i {
+			t.Fatalf("Rank(%s) = %d, want %d", node.Value, rank, i)
+		}
+		if rank, _ := s.ReverseRank(node.Value); rank != len(want)-1-i {
+			t.Fatalf("ReverseRank(%s) = %d, want %d", node.Value, rank, len(want)-1-i)
+		}
+	}
+	for _, bounds := range [][2]int{{0, 10}, {100, 150}, {len(want) - 5, len(want) + 5}, {-3, 2}} {
+		got := s.Range(bounds[0], bounds[1])
+		lo, hi := max(bounds[0], 0), min(bounds[1], len(want))
+		if len(got) != hi-lo {
+			t.Fatalf("Range(%d, %d) returned %d members, want %d", bounds[0], bounds[1], len(got), hi-lo)
+		}
+		for i, node := range got {
+			if node != want[lo+i] {
+				t.Fatalf("Range(%d, %d)[%d] = %v, want %v", bounds[0], bounds[1], i, node, want[lo+i])
+			}
+		}
+	}
+}
+
+func TestSortedSet_ReverseRange(t *testing.T) {
+	s := data.NewSortedSet[float64]()
+	for i, value := range []string{"a", "b", "c", "d"} {
+		s.Insert(value, float64(i))
+	}
+
+	got := s.ReverseRange(0, 2)
+	if len(got) != 2 || got[0].Value != "d" || got[1].Value != "c" {
+		t.Fatalf("ReverseRange(0, 2) = %v, want d then c", got)
+	}
+	got = s.ReverseRange(3, 10)
+	if len(got) != 1 || got[0].Value != "a" {
+		t.Fatalf("ReverseRange(3, 10) = %v, want only a", got)
+	}
+	if got = s.ReverseRange(4, 6); len(got) != 0 {
+		t.Fatalf("ReverseRange past the end = %v", got)
+	}
+}
+
+func TestSortedSet_RemoveRange(t *testing.T) {
+	s := data.NewSortedSet[float64]()
+	for i := 0; i < 100; i += 1 {
+		s.Insert(fmt.Sprintf("m%02d", i), float64(i))
+	}
+
+	if removed := s.RemoveRange(10, 20); removed != 10 {
+		t.Fatalf("RemoveRange(10, 20) removed %d, want 10", removed)
+	}
+	if removed := s.RemoveRange(85, 200); removed != 5 {
+		t.Fatalf("RemoveRange(85, 200) removed %d, want 5", removed)
+	}
+	if s.Len() != 85 {
+		t.Fatalf("expected 85 members left, got %d", s.Len())
+	}
+	if _, ok := s.Score("m15"); ok {
+		t.Fatalf("m15 should have been removed")
+	}
+	if rank, _ := s.Rank("m20"); rank != 10 {
+		t.Fatalf("Rank(m20) = %d after the removal, want 10", rank)
+	}
+	if rank, _ := s.Rank("m89"); rank != 79 {
+		t.Fatalf("Rank(m89) = %d, want 79", rank)
+	}
+	if got := s.Range(84, 85); len(got) != 1 || got[0].Value != "m94" {
+		t.Fatalf("last member = %v, want m94", got)
+	}
+}
+
+const benchMembers = 1_000_000
+
+var benchSet = sync.OnceValue(func() *data.SortedSet[float64] {
+	s := data.NewSortedSet[float64]()
+	for i := 0; i < benchMembers; i += 1 {
+		s.Insert(fmt.Sprintf("member:%d", i), float64(i))
+	}
+	return s
+})
+
+func BenchmarkSortedSet_Rank(b *testing.B) {
+	s := benchSet()
+	b.ResetTimer()
+	for i := 0; i < b.N; i += 1 {
+		s.Rank(fmt.Sprintf("member:%d", i%benchMembers))
+	}
+}
+
+func BenchmarkSortedSet_ReverseRank(b *testing.B) {
+	s := benchSet()
+	b.ResetTimer()
+	for i := 0; i < b.N; i += 1 {
+		s.ReverseRank(fmt.Sprintf("member:%d", i%benchMembers))
+	}
+}
+
+func BenchmarkSortedSet_Range(b *testing.B) {
+	s := benchSet()
+	b.ResetTimer()
+	for i := 0; i < b.N; i += 1 {
+		s.Range(benchMembers-20, benchMembers-10)
+	}
+}
+
+func BenchmarkSortedSet_ReverseRange(b *testing.B) {
+	s := benchSet()
+	b.ResetTimer()
+	for i := 0; i < b.N; i += 1 {
+		s.ReverseRange(benchMembers/2, benchMembers/2+10)
+	}
+}
+
+func BenchmarkSortedSet_RemoveRange(b *testing.B) {
+	s := data.NewSortedSet[float64]()
+	for i := 0; i < b.N*10+benchMembers; i += 1 {
+		s.Insert(fmt.Sprintf("member:%d", i), float64(i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i += 1 {
+		s.RemoveRange(s.Len()/2, s.Len()/2+10)
+	}
+}
+
+func TestSortedSet_RangeByScore(t *testing.T) {
+	s := data.NewSortedSet[float64]()
+	for i, value := range []string{"a", "b", "c", "d", "e"} {
+		s.Insert(value, float64(i+1))
+	}
+	s.Insert("low", math.Inf(-1))
+
+	values := func(nodes []data.Node[float64]) string {
+		out := make([]string, len(nodes))
+		for i, node := range nodes {
+			out[i] = node.Value
+		}
+		return strings.Join(out, ",")
+	}
+	tests := []struct {
+		r       data.ScoreRange[float64]
+		reverse bool
+		offset  int
+		count   int
+		want    string
+	}{
+		{data.ScoreRange[float64]{Min: 2, Max: 4}, false, 0, -1, "b,c,d"},
+		{data.ScoreRange[float64]{Min: 2, Max: 4, MinEx: true, MaxEx: true}, false, 0, -1, "c"},
+		{data.ScoreRange[float64]{Min: math.Inf(-1), Max: math.Inf(1)}, false, 0, -1, "low,a,b,c,d,e"},
+		{data.ScoreRange[float64]{Min: math.Inf(-1), Max: 2, MinEx: true}, false, 0, -1, "a,b"},
+		{data.ScoreRange[float64]{Min: 1, Max: 5}, true, 0, -1, "e,d,c,b,a"},
+		{data.ScoreRange[float64]{Min: 1, Max: 5}, false, 1, 2, "b,c"},
+		{data.ScoreRange[float64]{Min: 1, Max: 5}, true, 1, 2, "d,c"},
+		{data.ScoreRange[float64]{Min: 1, Max: 5}, true, 4, 10, "a"},
+		{data.ScoreRange[float64]{Min: 1, Max: 5}, false, 5, 10, ""},
+		{data.ScoreRange[float64]{Min: 1, Max: 5}, false, -1, 10, ""},
+		{data.ScoreRange[float64]{Min: 4, Max: 2}, false, 0, -1, ""},
+		{data.ScoreRange[float64]{Min: 3, Max: 3, MaxEx: true}, false, 0, -1, ""},
+	}
+	for _, tt := range tests {
+		got := values(s.RangeByScore(tt.r, tt.reverse, tt.offset, tt.count))
+		if got != tt.want {
+			t.Errorf("RangeByScore(%+v, %v, %d, %d) = %q, want %q", tt.r, tt.reverse, tt.offset, tt.count, got, tt.want)
+		}
+		if tt.offset != 0 || tt.count >= 0 {
+			continue
+		}
+		want := 0
+		if tt.want != "" {
+			want = strings.Count(tt.want, ",") + 1
+		}
+		if n := s.CountByScore(tt.r); n != want {
+			t.Errorf("CountByScore(%+v) = %d, want %d", tt.r, n, want)
+		}
+	}
+
+	if removed := s.RemoveByScore(data.ScoreRange[float64]{Min: 1, Max: 4, MinEx: true}); removed != 3 {
+		t.Fatalf("RemoveByScore removed %d, want 3", removed)
+	}
+	if got := values(s.Range(0, s.Len())); got != "low,a,e" {
+		t.Fatalf("members left = %q, want low,a,e", got)
+	}
+}
+
+func TestSortedSet_RangeByLex(t *testing.T) {
+	s := data.NewSortedSet[float64]()
+	for _, value := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		s.Insert(value, 0)
+	}
+
+	incl := func(v string) data.LexBound { return data.LexBound{Value: v} }
+	excl := func(v string) data.LexBound { return data.LexBound{Value: v, Exclusive: true} }
+	negInf, posInf := data.LexBound{Inf: -1}, data.LexBound{Inf: 1}
+	tests := []struct {
+		r    data.LexRange
+		want int
+		rev  string
+	}{
+		{data.LexRange{Min: negInf, Max: excl("c")}, 2, "ba"},
+		{data.LexRange{Min: negInf, Max: incl("c")}, 3, "cba"},
+		{data.LexRange{Min: excl("aaa"), Max: excl("g")}, 5, "fedcb"},
+		{data.LexRange{Min: incl("e"), Max: posInf}, 3, "gfe"},
+		{data.LexRange{Min: posInf, Max: negInf}, 0, ""},
+		{data.LexRange{Min: incl("d"), Max: incl("b")}, 0, ""},
+	}
+	for _, tt := range tests {
+		if n := s.CountByLex(tt.r); n != tt.want {
+			t.Errorf("CountByLex(%+v) = %d, want %d", tt.r, n, tt.want)
+		}
+		rev := ""
+		for _, node := range s.RangeByLex(tt.r, true, 0, -1) {
+			rev += node.Value
+		}
+		if rev != tt.rev {
+			t.Errorf("RangeByLex(%+v, reverse) = %q, want %q", tt.r, rev, tt.rev)
+		}
+	}
+
+	if removed := s.RemoveByLex(data.LexRange{Min: excl("b"), Max: incl("e")}); removed != 3 {
+		t.Fatalf("RemoveByLex removed %d, want 3", removed)
+	}
+	if got := s.RangeByLex(data.LexRange{Min: negInf, Max: posInf}, false, 1, 2); len(got) != 2 || got[0].Value != "b" || got[1].Value != "f" {
+		t.Fatalf("RangeByLex with a limit = %v, want b then f", got)
+	}
+}
+
+func TestSortedSet_RandomMembers(t *testing.T) {
+	set := data.NewSortedSet[float64]()
+	set.Insert("a", 1)
+	set.Insert("b", 2)
+	set.Insert("c", 3)
+
+	if got := set.RandomMembers(2); len(got) != 2 || got[0] == got[1] {
+		t.Errorf("RandomMembers(2) = %v, want 2 distinct members", got)
+	}
+	if got := set.RandomMembers(10); len(got) != 3 {
+		t.Errorf("RandomMembers(10) = %v, want all 3 members", got)
+	}
+	got := set.RandomMembers(-10)
+	if len(got) != 10 {
+		t.Fatalf("RandomMembers(-10) returned %d members, want 10", len(got))
+	}
+	for _, node := range got {
+		if score, _ := set.Score(node.Value); score != node.Score {
+			t.Errorf("RandomMembers(-10) returned %v, score should be %v", node, score)
+		}
+	}
+	if got := data.NewSortedSet[float64]().RandomMembers(-3); len(got) != 0 {
+		t.Errorf("RandomMembers(-3) on empty set = %v", got)
+	}
+}
@@ -0,0 +1,262 @@
+package gedis
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"time"
+
+	gedis_types "github.com/ttn-nguyen42/gedis/gedis/types"
+	"github.com/ttn-nguyen42/gedis/resp"
+)
+
+// blockedClient is a command waiting on one or more keys of a database until
+// one of them can serve it, its timeout passes, CLIENT UNBLOCK releases it or
+// its client goes away.
+type blockedClient struct {
+	cmd  *gedis_types.Command
+	keys []string
+	// deadline is when the command gives up, zero to wait forever
+	deadline time.Time
+	// serve answers the command from key when the key holds what the
+	// command waits for, and reports whether it did
+	serve func(key string) bool
+}
+
+func (c *blockedClient) hasTimedOut(now time.Time) bool {
+	return !c.deadline.IsZero() && now.After(c.deadline)
+}
+
+// blockingOps are the clients blocked on the keys of a database. Every write
+// marks the key it touches as ready through database.notify, and the
+// clients waiting on ready keys are served once the write is over, see
+// Instance.handleReadyKeys.
+type blockingOps struct {
+	// waiters are the clients blocked on each key, in the order they blocked
+	waiters map[string][]*blockedClient
+	// clients are all the blocked clients, in the order they blocked
+	clients []*blockedClient
+	// ready are the keys with waiters written since they were last served,
+	// in the order they were first written
+	ready   []string
+	isReady map[string]struct{}
+}
+
+func newBlockingOps() *blockingOps {
+	return &blockingOps{
+		waiters: make(map[string][]*blockedClient),
+		clients: make([]*blockedClient, 0),
+		ready:   make([]string, 0),
+		isReady: make(map[string]struct{}),
+	}
+}
+
+// signalKeyAsReady queues key to have its waiters served, when it has any.
+func (b *blockingOps) signalKeyAsReady(key string) {
+	if _, ok := b.waiters[key]; !ok {
+		return
+	}
+	if _, ok := b.isReady[key]; ok {
+		return
+	}
+	b.isReady[key] = struct{}{}
+	b.ready = append(b.ready, key)
+}
+
+// signalExistingKeys queues every key some client of d is blocked on that
+// exists, the keys a SWAPDB may have brought in.
+func (d *database) signalExistingKeys() {
+	for key := range d.block.waiters {
+		if d.Exists(key) {
+			d.block.signalKeyAsReady(key)
+		}
+	}
+}
+
+// takeReady returns the keys signaled so far and forgets them.
+func (b *blockingOps) takeReady() []string {
+	if len(b.ready) == 0 {
+		return nil
+	}
+	ready := b.ready
+	b.ready = make([]string, 0)
+	clear(b.isReady)
+	return ready
+}
+
+func (b *blockingOps) add(c *blockedClient) {
+	b.clients = append(b.clients, c)
+	for _, key := range c.keys {
+		if !slices.Contains(b.waiters[key], c) {
+			b.waiters[key] = append(b.waiters[key], c)
+		}
+	}
+}
+
+func (b *blockingOps) remove(c *blockedClient) {
+	b.clients = slices.DeleteFunc(b.clients, func(o *blockedClient) bool { return o == c })
+	for _, key := range c.keys {
+		waiters := slices.DeleteFunc(b.waiters[key], func(o *blockedClient) bool { return o == c })
+		if len(waiters) == 0 {
+			delete(b.waiters, key)
+		} else {
+			b.waiters[key] = waiters
+		}
+	}
+}
+
+var (
+	errTimeoutNotFloat = fmt.Errorf("timeout is not a float or out of range")
+	errTimeoutNegative = fmt.Errorf("timeout is negative")
+)
+
+// parseBlockTimeout parses the timeout of a blocking command, in seconds,
+// into a deadline. A zero timeout waits forever and gives the zero time.
+func parseBlockTimeout(arg any) (time.Time, error) {
+	timeout, err := parseFloat(arg)
+	if err != nil || math.IsNaN(timeout) || math.IsInf(timeout, 0) ||
+		timeout > float64(math.MaxInt64/int64(time.Second)) {
+		return time.Time{}, errTimeoutNotFloat
+	}
+	if timeout < 0 {
+		return time.Time{}, errTimeoutNegative
+	}
+	if timeout == 0 {
+		return time.Time{}, nil
+	}
+	return time.Now().Add(time.Duration(timeout * float64(time.Second))), nil
+}
+
+// blockedReply is the reply of a blocking command giving up.
+var blockedReply = resp.Array{Size: -1}
+
+// block serves cmd from the first of keys able to, or parks it on all of
+// them until one can. Commands run by EXEC cannot wait and give up right
+// away instead, like on a timeout.
+func (h *handlers) block(cmd *gedis_types.Command, keys []string, deadline time.Time, serve func(key string) bool) {
+	for _, key := range keys {
+		if serve(key) {
+			return
+		}
+	}
+
+	if h.inExec {
+		cmd.WriteAny(blockedReply)
+		cmd.SetDone()
+		return
+	}
+
+	h.db.block.add(&blockedClient{
+		cmd:      cmd,
+		keys:     keys,
+		deadline: deadline,
+		serve:    serve,
+	})
+	h.info.GetClients().IncrBlockedClients()
+}
+
+func (h *handlers) unblock(c *blockedClient) {
+	h.db.block.remove(c)
+	h.info.GetClients().DecrBlockedClients()
+}
+
+// serveKey serves the clients blocked on key, first blocked first served,
+// for as long as the key holds what they wait for.
+func (h *handlers) serveKey(key string) {
+	now := time.Now()
+	for _, c := range slices.Clone(h.db.block.waiters[key]) {
+		// left for expireBlocked, the reply is already decided
+		if c.hasTimedOut(now) || c.cmd.ConnState.IsClosed() {
+			continue
+		}
+		if c.serve(key) {
+			log.Printf("served blocked command, type '%s', key=%s", c.cmd.Cmd.Cmd, key)
+			h.unblock(c)
+		}
+	}
+}
+
+// expireBlocked replies to the blocked commands whose timeout passed and
+// drops the ones of clients that went away.
+func (h *handlers) expireBlocked() {
+	if len(h.db.block.clients) == 0 {
+		return
+	}
+	now := time.Now()
+	for _, c := range slices.Clone(h.db.block.clients) {
+		switch {
+		case c.cmd.ConnState.IsClosed():
+			h.unblock(c)
+		case c.hasTimedOut(now):
+			c.cmd.WriteAny(blockedReply)
+			c.cmd.SetDone()
+			h.unblock(c)
+		}
+	}
+}
+
+// propagate replicates the effect of a blocking command once it is served,
+// as the command itself cannot be replayed on the replicas. It goes the way
+// of the commands processCmd replicates.
+func (h *handlers) propagate(name string, args ...any) {
+	h.inst.replicate(context.Background(), h.db.num, resp.BuildCommand(name, args...))
+}
+
+// handleReadyKeys serves the clients blocked on the keys written by the
+// last command. Serving them may write keys others wait on, like BLMOVE
+// does, so it goes on until no key is left ready.
+func (i *Instance) handleReadyKeys() {
+	for {
+		served := false
+		for idx, db := range i.dbs {
+			if db == nil {
+				continue
+			}
+			for _, key := range db.block.takeReady() {
+				i.handlers[idx].serveKey(key)
+				served = true
+			}
+		}
+		if !served {
+			return
+		}
+	}
+}
+
+// expireBlocked times out the blocked commands of every database.
+func (i *Instance) expireBlocked() {
+	for idx, db := range i.dbs {
+		if db != nil {
+			i.handlers[idx].expireBlocked()
+		}
+	}
+}
+
+// unblockClient releases the command client id is blocked on, as if it timed
+// out, or failing with an UNBLOCKED error. It reports whether the client was
+// blocked.
+func (i *Instance) unblockClient(id int64, withError bool) bool {
+	for idx, db := range i.dbs {
+		if db == nil {
+			continue
+		}
+		for _, c := range db.block.clients {
+			if c.cmd.ConnState.Id != id {
+				continue
+			}
+			if withError {
+				c.cmd.WriteAny(errUnblocked)
+			} else {
+				c.cmd.WriteAny(blockedReply)
+			}
+			c.cmd.SetDone()
+			i.handlers[idx].unblock(c)
+			return true
+		}
+	}
+	return false
+}
+
+var errUnblocked = resp.NewCodeErr("UNBLOCKED", "client unblocked via CLIENT UNBLOCK")
@@ -0,0 +1,109 @@
+package gedis
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ttn-nguyen42/gedis/data"
+	"github.com/ttn-nguyen42/gedis/util"
+)
+
+// config holds the parameters that can be changed at runtime with CONFIG SET.
+type config struct {
+	notifyKeyspaceEvents int
+}
+
+func newConfig() *config {
+	return &config{
+		notifyKeyspaceEvents: 0,
+	}
+}
+
+type configParam struct {
+	get func(c *config) string
+	set func(c *config, value string) error
+}
+
+var configParams = map[string]configParam{
+	"notify-keyspace-events": {
+		get: func(c *config) string {
+			return keyspaceEventsString(c.notifyKeyspaceEvents)
+		},
+		set: func(c *config, value string) error {
+			flags, err := parseKeyspaceEvents(value)
+			if err != nil {
+				return err
+			}
+			c.notifyKeyspaceEvents = flags
+			return nil
+		},
+	},
+	"hash-max-listpack-entries": limitParam(&data.Limits.HashMaxListpackEntries, 0),
+	"hash-max-listpack-value":   limitParam(&data.Limits.HashMaxListpackValue, 0),
+	"list-max-listpack-size":    limitParam(&data.Limits.ListMaxListpackSize, -5),
+	"list-compress-depth":       limitParam(&data.Limits.ListCompressDepth, 0),
+	"set-max-intset-entries":    limitParam(&data.Limits.SetMaxIntsetEntries, 0),
+	"zset-max-listpack-entries": limitParam(&data.Limits.ZsetMaxListpackEntries, 0),
+	"zset-max-listpack-value":   limitParam(&data.Limits.ZsetMaxListpackValue, 0),
+}
+
+func init() {
+	// the names the listpack parameters had when they were ziplists
+	for _, name := range []string{"hash-max-listpack-entries", "hash-max-listpack-value", "list-max-listpack-size", "zset-max-listpack-entries", "zset-max-listpack-value"} {
+		configParams[strings.Replace(name, "listpack", "ziplist", 1)] = configParams[name]
+	}
+}
+
+// limitParam is a parameter for one of the encoding limits, an integer no
+// lower than least.
+func limitParam(limit *int, least int) configParam {
+	return configParam{
+		get: func(*config) string {
+			return strconv.Itoa(*limit)
+		},
+		set: func(_ *config, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return errors.New("argument couldn't be parsed into an integer")
+			}
+			if n < least || n > math.MaxInt32 {
+				return fmt.Errorf("argument must be between %d and %d inclusive", least, math.MaxInt32)
+			}
+			*limit = n
+			return nil
+		},
+	}
+}
+
+// Get returns every parameter whose name matches the glob pattern, as a flat
+// list of name and value pairs sorted by name.
+func (c *config) Get(pattern string) []string {
+	names := make([]string, 0, len(configParams))
+	for name := range configParams {
+		if util.GlobMatchFold(pattern, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	pairs := make([]string, 0, 2*len(names))
+	for _, name := range names {
+		pairs = append(pairs, name, configParams[name].get(c))
+	}
+	return pairs
+}
+
+func (c *config) Set(name string, value string) error {
+	param, ok := configParams[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("%w: unknown option or number of arguments for CONFIG SET - '%s'", ErrInvalidArguments, name)
+	}
+	if err := param.set(c, value); err != nil {
+		return fmt.Errorf("%w: CONFIG SET failed (possibly related to argument '%s') - %w", ErrInvalidArguments, name, err)
+	}
+	return nil
+}
@@ -1,14 +1,82 @@
 package gedis
 
 import (
+	"errors"
 	"log"
+	"math/rand"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/ttn-nguyen42/gedis/data"
-	gedis_types "github.com/ttn-nguyen42/gedis/gedis/types"
 )
 
-type blockingOps struct {
-	blockLpop map[any][]*gedis_types.Command
+var errNotInteger = errors.New("value is not an integer or out of range")
+
+const (
+	lfuInitVal   = 5
+	lfuLogFactor = 10
+	lfuDecayTime = time.Minute
+)
+
+// keyAccess is the access metadata kept for every key, the equivalent of
+// the LRU/LFU bits Redis stores in each object. It backs OBJECT IDLETIME
+// and OBJECT FREQ.
+type keyAccess struct {
+	lastAccess time.Time
+	lastDecr   time.Time
+	counter    uint8
+}
+
+func newKeyAccess() *keyAccess {
+	now := time.Now()
+	return &keyAccess{
+		lastAccess: now,
+		lastDecr:   now,
+		counter:    lfuInitVal,
+	}
+}
+
+func (a *keyAccess) touch() {
+	now := time.Now()
+	a.decay(now)
+	a.lastAccess = now
+	if a.counter == 255 {
+		return
+	}
+	// logarithmic counter, the more hits a key has the less likely it is
+	// to be incremented again
+	base := float64(int(a.counter) - lfuInitVal)
+	if base < 0 {
+		base = 0
+	}
+	if rand.Float64() < 1.0/(base*lfuLogFactor+1) {
+		a.counter += 1
+	}
+}
+
+// decay subtracts one from the counter for every decay period elapsed since
+// the last decrement, like Redis' LFUDecrAndReturn.
+func (a *keyAccess) decay(now time.Time) {
+	periods := int(now.Sub(a.lastDecr) / lfuDecayTime)
+	if periods <= 0 {
+		return
+	}
+	if periods > int(a.counter) {
+		a.counter = 0
+	} else {
+		a.counter -= uint8(periods)
+	}
+	a.lastDecr = a.lastDecr.Add(time.Duration(periods) * lfuDecayTime)
+}
+
+func (a *keyAccess) idle() time.Duration {
+	return time.Since(a.lastAccess)
+}
+
+func (a *keyAccess) freq() int {
+	a.decay(time.Now())
+	return int(a.counter)
 }
 
 type database struct {
@@ -17,27 +85,483 @@ type database struct {
 	list  map[any]*data.LinkedList
 	ss    map[any]*data.SortedSet[float64]
 	set   map[any]*data.Set
+	hash  map[any]*data.Hash
 	gi    map[any]*data.GeoIndex
 	block *blockingOps
+	// access is the LRU/LFU metadata of every key, whatever its type
+	access map[any]*keyAccess
+	// expires holds the expiry of list, set, sorted set and hash keys,
+	// strings keep theirs in hm
+	expires map[any]time.Time
+	// volatileHashes are the hash keys with fields that have a TTL, the
+	// ones the active expiry cycle visits
+	volatileHashes map[any]struct{}
+	events         *notifier
+}
+
+func newDb(n int, events *notifier) *database {
+	db := &database{
+		num:            n,
+		hm:             data.NewHashMap(),
+		list:           make(map[any]*data.LinkedList),
+		ss:             make(map[any]*data.SortedSet[float64]),
+		set:            make(map[any]*data.Set),
+		hash:           make(map[any]*data.Hash),
+		gi:             make(map[any]*data.GeoIndex),
+		block:          newBlockingOps(),
+		access:         make(map[any]*keyAccess),
+		expires:        make(map[any]time.Time),
+		volatileHashes: make(map[any]struct{}),
+		events:         events,
+	}
+	db.hm.OnEvict(db.expired)
+	return db
+}
+
+// notify publishes a keyspace event for key, see notifier.notify. Every
+// write goes through it, so it also wakes up the clients blocked on key.
+func (d *database) notify(class int, event string, key any) {
+	d.events.notify(d.num, class, event, key)
+	if k, ok := key.(string); ok {
+		d.block.signalKeyAsReady(k)
+	}
+}
+
+func (d *database) expired(key any) {
+	d.forget(key)
+	d.notify(notifyExpired, "expired", key)
+}
+
+// expireIfNeeded removes the container stored at key once its TTL has
+// passed, and the fields of a hash whose own TTL has. It reports whether the
+// key was removed.
+func (d *database) expireIfNeeded(key any) bool {
+	expiresAt, exists := d.expires[key]
+	if !exists || expiresAt.After(time.Now()) {
+		return d.expireFields(key)
+	}
+	d.DeleteList(key)
+	d.DeleteSortedSet(key)
+	d.DeleteSet(key)
+	d.DeleteHash(key)
+	d.notify(notifyExpired, "expired", key)
+	return true
+}
+
+// expireFields removes the expired fields of the hash stored at key, and the
+// key itself once no field is left. It reports whether the key was removed.
+func (d *database) expireFields(key any) bool {
+	if _, volatile := d.volatileHashes[key]; !volatile {
+		return false
+	}
+	hash, exists := d.hash[key]
+	if !exists {
+		delete(d.volatileHashes, key)
+		return false
+	}
+	if len(hash.EvictExpired(time.Now())) == 0 {
+		return false
+	}
+	d.notify(notifyHash, "hexpired", key)
+	if hash.Len() == 0 {
+		d.DeleteHash(key)
+		d.notify(notifyGeneric, "del", key)
+		return true
+	}
+	if !hash.Volatile() {
+		delete(d.volatileHashes, key)
+	}
+	return false
+}
+
+// watchFields registers the hash at key with the active expiry cycle after
+// a TTL was given to one of its fields.
+func (d *database) watchFields(key any) {
+	if hash, exists := d.hash[key]; exists && hash.Volatile() {
+		d.volatileHashes[key] = struct{}{}
+	}
+}
+
+// touch records an access to key, creating its metadata when the key is new.
+func (d *database) touch(key any) {
+	acc, exists := d.access[key]
+	if !exists {
+		d.access[key] = newKeyAccess()
+		return
+	}
+	acc.touch()
+}
+
+// setAccess overrides the access metadata of key, as RESTORE IDLETIME and
+// FREQ do. Negative values are left as they are.
+func (d *database) setAccess(key any, idle time.Duration, freq int) {
+	acc, exists := d.access[key]
+	if !exists {
+		acc = newKeyAccess()
+		d.access[key] = acc
+	}
+	if idle >= 0 {
+		acc.lastAccess = time.Now().Add(-idle)
+	}
+	if freq >= 0 {
+		acc.counter = uint8(freq)
+		acc.lastDecr = time.Now()
+	}
+}
+
+func (d *database) forget(key any) {
+	delete(d.access, key)
+}
+
+// Access returns the access metadata of key without updating it.
+func (d *database) Access(key any) (*keyAccess, bool) {
+	if _, exists := d.lookup(key); !exists {
+		return nil, false
+	}
+	acc, exists := d.access[key]
+	if !exists {
+		acc = newKeyAccess()
+		d.access[key] = acc
+	}
+	return acc, true
+}
+
+// lookup returns the value stored at key whatever its type, without updating
+// its access metadata. Strings are returned as stored, other types as their
+// container.
+func (d *database) lookup(key any) (any, bool) {
+	if val, ok := d.hm.Get(key); ok {
+		return val, true
+	}
+	if d.expireIfNeeded(key) {
+		return nil, false
+	}
+	if list, ok := d.list[key]; ok {
+		return list, true
+	}
+	if ss, ok := d.ss[key]; ok {
+		return ss, true
+	}
+	if set, ok := d.set[key]; ok {
+		return set, true
+	}
+	if hash, ok := d.hash[key]; ok {
+		return hash, true
+	}
+	return nil, false
+}
+
+// Encoding reports how the value at key is stored, as shown by OBJECT ENCODING.
+func (d *database) Encoding(key any) (string, bool) {
+	val, exists := d.lookup(key)
+	if !exists {
+		return "", false
+	}
+	switch v := val.(type) {
+	case *data.LinkedList:
+		return v.Encoding(), true
+	case *data.SortedSet[float64]:
+		return v.Encoding(), true
+	case *data.Set:
+		return v.Encoding(), true
+	case *data.Hash:
+		return v.Encoding(), true
+	default:
+		return data.StringEncoding(v), true
+	}
+}
+
+// MemoryUsage estimates the bytes used by key and its value. Aggregate values
+// are extrapolated from at most samples elements, or all of them when
+// samples is 0.
+func (d *database) MemoryUsage(key any, samples int) (int, bool) {
+	val, exists := d.lookup(key)
+	if !exists {
+		return 0, false
+	}
+	var size int
+	switch v := val.(type) {
+	case *data.LinkedList:
+		size = v.MemoryUsage(samples)
+	case *data.SortedSet[float64]:
+		size = v.MemoryUsage(samples)
+	case *data.Set:
+		size = v.MemoryUsage(samples)
+	case *data.Hash:
+		size = v.MemoryUsage(samples)
+	default:
+		size, _ = d.hm.MemoryUsage(key)
+		return size, true
+	}
+	return size + data.KeyOverhead(key), true
+}
+
+func (d *database) Size() int {
+	return d.hm.Len() + len(d.list) + len(d.ss) + len(d.set) + len(d.hash)
 }
 
-func newDb(n int) *database {
-	return &database{
-		num:  n,
-		hm:   data.NewHashMap(),
-		list: make(map[any]*data.LinkedList),
-		ss:   make(map[any]*data.SortedSet[float64]),
-		set:  make(map[any]*data.Set),
-		gi:   make(map[any]*data.GeoIndex),
-		block: &blockingOps{
-			blockLpop: make(map[any][]*gedis_types.Command),
-		},
+// keys returns every key of the database, whatever its type.
+func (d *database) keys() []any {
+	keys := make([]any, 0, d.Size())
+	keys = append(keys, d.hm.Keys()...)
+	for key := range d.list {
+		keys = append(keys, key)
+	}
+	for key := range d.ss {
+		keys = append(keys, key)
+	}
+	for key := range d.set {
+		keys = append(keys, key)
+	}
+	for key := range d.hash {
+		keys = append(keys, key)
 	}
+	return slices.DeleteFunc(keys, d.expireIfNeeded)
 }
 
-func (d *database) EvictHashMap() {
-	n, evicted := d.hm.Evict()
-	if evicted {
+// datasetStats estimates the bytes used by the values of every key, and the
+// bytes spent on the main and expires key tables.
+func (d *database) datasetStats(samples int) (dataset int, main int, expires int) {
+	for _, key := range d.keys() {
+		size, _ := d.MemoryUsage(key, samples)
+		dataset += size
+		main += data.KeyOverhead(key)
+	}
+	return dataset, main, d.hm.ExpiresMemoryUsage()
+}
+
+// deleteIfEmpty removes key once the container stored there has no element
+// left, publishing the del event Redis sends when a container is emptied.
+func (d *database) deleteIfEmpty(key any) bool {
+	deleted := false
+	if list, ok := d.list[key]; ok && list.Len() == 0 {
+		deleted = d.DeleteList(key)
+	}
+	if ss, ok := d.ss[key]; ok && ss.Len() == 0 {
+		deleted = d.DeleteSortedSet(key)
+	}
+	if set, ok := d.set[key]; ok && set.Len() == 0 {
+		deleted = d.DeleteSet(key)
+	}
+	if hash, ok := d.hash[key]; ok && hash.Len() == 0 {
+		deleted = d.DeleteHash(key)
+	}
+	if deleted {
+		d.notify(notifyGeneric, "del", key)
+	}
+	return deleted
+}
+
+// entry is a value detached from its key, along with its expiry. It is how
+// RENAME, COPY and MOVE carry a value of any type to another key.
+type entry struct {
+	// value is the string value or the container stored at the key
+	value any
+	// expiresAt is zero when the key has no TTL
+	expiresAt time.Time
+}
+
+// get returns the entry stored at key without updating its access metadata.
+func (d *database) get(key any) (entry, bool) {
+	val, exists := d.lookup(key)
+	if !exists {
+		return entry{}, false
+	}
+	e := entry{value: val}
+	if expiresAt, ok := d.hm.ExpiresAt(key); ok {
+		e.expiresAt = expiresAt
+	}
+	if expiresAt, ok := d.expires[key]; ok {
+		e.expiresAt = expiresAt
+	}
+	return e, true
+}
+
+// put stores e at key, replacing whatever value key held before.
+func (d *database) put(key any, e entry) {
+	d.Delete(key)
+	switch v := e.value.(type) {
+	case *data.LinkedList:
+		d.list[key] = v
+	case *data.SortedSet[float64]:
+		d.ss[key] = v
+	case *data.Set:
+		d.set[key] = v
+	case *data.Hash:
+		d.hash[key] = v
+		d.watchFields(key)
+	default:
+		d.hm.Set(key, v, -1)
+		d.hm.ExpireAt(key, e.expiresAt)
+		d.touch(key)
+		return
+	}
+	if !e.expiresAt.IsZero() {
+		d.expires[key] = e.expiresAt
+	}
+	d.touch(key)
+}
+
+// clone returns a copy of e that shares nothing with the original.
+func (e entry) clone() entry {
+	switch v := e.value.(type) {
+	case *data.LinkedList:
+		e.value = v.Clone()
+	case *data.SortedSet[float64]:
+		e.value = v.Clone()
+	case *data.Set:
+		e.value = v.Clone()
+	case *data.Hash:
+		e.value = v.Clone()
+	}
+	return e
+}
+
+// Exists reports whether key holds a value of any type.
+func (d *database) Exists(key any) bool {
+	_, exists := d.lookup(key)
+	return exists
+}
+
+// Delete removes key whatever the type of its value.
+func (d *database) Delete(key any) bool {
+	return d.DeleteString(key) || d.DeleteList(key) ||
+		d.DeleteSortedSet(key) || d.DeleteSet(key) || d.DeleteHash(key)
+}
+
+// RandomKey returns a random key of the database.
+func (d *database) RandomKey() (any, bool) {
+	keys := d.keys()
+	if len(keys) == 0 {
+		return nil, false
+	}
+	return keys[rand.Intn(len(keys))], true
+}
+
+// Flush removes every key of the database.
+func (d *database) Flush() {
+	d.hm = data.NewHashMap()
+	d.hm.OnEvict(d.expired)
+	d.list = make(map[any]*data.LinkedList)
+	d.ss = make(map[any]*data.SortedSet[float64])
+	d.set = make(map[any]*data.Set)
+	d.hash = make(map[any]*data.Hash)
+	d.gi = make(map[any]*data.GeoIndex)
+	d.access = make(map[any]*keyAccess)
+	d.expires = make(map[any]time.Time)
+	d.volatileHashes = make(map[any]struct{})
+}
+
+// Swap exchanges the keys of d and o, as SWAPDB does. Clients blocked on
+// either database stay where they are, waiting on the keys swapped in, which
+// the caller signals.
+func (d *database) Swap(o *database) {
+	d.hm, o.hm = o.hm, d.hm
+	d.list, o.list = o.list, d.list
+	d.ss, o.ss = o.ss, d.ss
+	d.set, o.set = o.set, d.set
+	d.hash, o.hash = o.hash, d.hash
+	d.gi, o.gi = o.gi, d.gi
+	d.access, o.access = o.access, d.access
+	d.expires, o.expires = o.expires, d.expires
+	d.volatileHashes, o.volatileHashes = o.volatileHashes, d.volatileHashes
+	// expirations must be reported by the database now holding the keys
+	d.hm.OnEvict(d.expired)
+	o.hm.OnEvict(o.expired)
+}
+
+// GetString returns the bytes of the string stored at key. Counters kept in
+// the integer encoding are returned as their decimal form. The slice is the
+// stored one and must not be modified in place.
+func (d *database) GetString(key any) ([]byte, bool) {
+	val, exists := d.hm.Get(key)
+	if !exists {
+		return nil, false
+	}
+	d.touch(key)
+	switch v := val.(type) {
+	case int64:
+		return strconv.AppendInt(nil, v, 10), true
+	case []byte:
+		return v, true
+	}
+	return nil, false
+}
+
+// GetInt returns the string stored at key as an integer, without parsing it
+// again when it is kept in the integer encoding. It fails with errNotInteger
+// when the string is not the canonical form of a 64 bit integer.
+func (d *database) GetInt(key any) (int64, bool, error) {
+	val, exists := d.hm.Get(key)
+	if !exists {
+		return 0, false, nil
+	}
+	d.touch(key)
+	var str string
+	switch v := val.(type) {
+	case int64:
+		return v, true, nil
+	case []byte:
+		str = string(v)
+	default:
+		return 0, true, errNotInteger
+	}
+	n, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != str {
+		return 0, true, errNotInteger
+	}
+	return n, true, nil
+}
+
+// SetInt stores n at key in the integer encoding, keeping the TTL of key.
+func (d *database) SetInt(key any, n int64) {
+	d.hm.Set(key, n, 0)
+	d.touch(key)
+}
+
+// SetString stores value at key, ttl being handled like data.HashMap.Set.
+func (d *database) SetString(key any, value []byte, ttl int) bool {
+	exists := d.hm.Set(key, value, ttl)
+	d.touch(key)
+	return exists
+}
+
+// SetStringExpireAt stores value at key, to expire at the given time or never
+// when it is zero.
+func (d *database) SetStringExpireAt(key any, value []byte, at time.Time) bool {
+	exists := d.SetString(key, value, -1)
+	d.hm.ExpireAt(key, at)
+	return exists
+}
+
+// ExpireStringAt sets the time the string at key expires at, a zero time
+// removes its TTL.
+func (d *database) ExpireStringAt(key any, at time.Time) bool {
+	return d.hm.ExpireAt(key, at)
+}
+
+func (d *database) DeleteString(key any) bool {
+	_, exists := d.hm.Delete(key)
+	if exists {
+		d.forget(key)
+	}
+	return exists
+}
+
+// EvictExpired removes every key whose TTL has passed.
+func (d *database) EvictExpired() {
+	n, _ := d.hm.Evict()
+	for key := range d.expires {
+		if d.expireIfNeeded(key) {
+			n += 1
+		}
+	}
+	for key := range d.volatileHashes {
+		if d.expireFields(key) {
+			n += 1
+		}
+	}
+	if n > 0 {
 		log.Printf("evicted %d keys, db=%d", n, d.num)
 	}
 }
@@ -47,16 +571,24 @@ func (d *database) HashMap() *data.HashMap {
 }
 
 func (d *database) GetOrCreateList(key any) *data.LinkedList {
+	d.expireIfNeeded(key)
 	list, exists := d.list[key]
 	if !exists {
 		list = data.NewLinkedList()
 		d.list[key] = list
 	}
+	d.touch(key)
 	return list
 }
 
 func (d *database) GetList(key any) (*data.LinkedList, bool) {
+	if d.expireIfNeeded(key) {
+		return nil, false
+	}
 	list, exists := d.list[key]
+	if exists {
+		d.touch(key)
+	}
 	return list, exists
 }
 
@@ -64,21 +596,31 @@ func (d *database) DeleteList(key any) bool {
 	_, exists := d.list[key]
 	if exists {
 		delete(d.list, key)
+		delete(d.expires, key)
+		d.forget(key)
 	}
 	return exists
 }
 
 func (d *database) GetOrCreateSortedSet(key any) *data.SortedSet[float64] {
+	d.expireIfNeeded(key)
 	ss, exists := d.ss[key]
 	if !exists {
 		ss = data.NewSortedSet[float64]()
 		d.ss[key] = ss
 	}
+	d.touch(key)
 	return ss
 }
 
 func (d *database) GetSortedSet(key any) (*data.SortedSet[float64], bool) {
+	if d.expireIfNeeded(key) {
+		return nil, false
+	}
 	ss, exists := d.ss[key]
+	if exists {
+		d.touch(key)
+	}
 	return ss, exists
 }
 
@@ -86,21 +628,33 @@ func (d *database) DeleteSortedSet(key any) bool {
 	_, exists := d.ss[key]
 	if exists {
 		delete(d.ss, key)
+		delete(d.expires, key)
+		// a geo index is a view over the sorted set, drop it along
+		delete(d.gi, key)
+		d.forget(key)
 	}
 	return exists
 }
 
 func (d *database) GetOrCreateSet(key any) *data.Set {
+	d.expireIfNeeded(key)
 	set, exists := d.set[key]
 	if !exists {
 		set = data.NewSet()
 		d.set[key] = set
 	}
+	d.touch(key)
 	return set
 }
 
 func (d *database) GetSet(key any) (*data.Set, bool) {
+	if d.expireIfNeeded(key) {
+		return nil, false
+	}
 	set, exists := d.set[key]
+	if exists {
+		d.touch(key)
+	}
 	return set, exists
 }
 
@@ -108,10 +662,54 @@ func (d *database) DeleteSet(key any) bool {
 	_, exists := d.set[key]
 	if exists {
 		delete(d.set, key)
+		delete(d.expires, key)
+		d.forget(key)
 	}
 	return exists
 }
 
+func (d *database) GetOrCreateHash(key any) *data.Hash {
+	d.expireIfNeeded(key)
+	hash, exists := d.hash[key]
+	if !exists {
+		hash = data.NewHash()
+		d.hash[key] = hash
+	}
+	d.touch(key)
+	return hash
+}
+
+func (d *database) GetHash(key any) (*data.Hash, bool) {
+	if d.expireIfNeeded(key) {
+		return nil, false
+	}
+	hash, exists := d.hash[key]
+	if exists {
+		d.touch(key)
+	}
+	return hash, exists
+}
+
+func (d *database) DeleteHash(key any) bool {
+	_, exists := d.hash[key]
+	if exists {
+		delete(d.hash, key)
+		delete(d.expires, key)
+		delete(d.volatileHashes, key)
+		d.forget(key)
+	}
+	return exists
+}
+
+// GetHashField returns the value of field in the hash stored at key.
+func (d *database) GetHashField(key any, field string) ([]byte, bool) {
+	hash, exists := d.GetHash(key)
+	if !exists {
+		return nil, false
+	}
+	return hash.Get(field)
+}
+
 func (d *database) GetOrCreateGeoIndex(key any) *data.GeoIndex {
 	gi, exists := d.gi[key]
 	if !exists {
@@ -122,6 +720,20 @@ func (d *database) GetOrCreateGeoIndex(key any) *data.GeoIndex {
 	return gi
 }
 
+// GetGeoIndex returns the geo index over the sorted set stored at key.
+func (d *database) GetGeoIndex(key any) (*data.GeoIndex, bool) {
+	ss, exists := d.GetSortedSet(key)
+	if !exists {
+		return nil, false
+	}
+	gi, exists := d.gi[key]
+	if !exists {
+		gi = data.NewGeoIndexFromSet(52, ss)
+		d.gi[key] = gi
+	}
+	return gi, true
+}
+
 func (d *database) DeleteGeoIndex(key any) bool {
 	_, exists := d.gi[key]
 	if exists {
@@ -4,12 +4,14 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"runtime"
 	"time"
 
 	"github.com/ttn-nguyen42/gedis/data"
 	"github.com/ttn-nguyen42/gedis/gedis/info"
 	"github.com/ttn-nguyen42/gedis/gedis/repl"
 	gedis_types "github.com/ttn-nguyen42/gedis/gedis/types"
+	"github.com/ttn-nguyen42/gedis/resp"
 )
 
 type Instance struct {
@@ -21,8 +23,12 @@ type Instance struct {
 	round    int
 	options  *Options
 	ps       *pubsub
+	cfg      *config
+	events   *notifier
 	slave    *repl.Slave
 	master   *repl.Master
+	// heap in use once the instance is created, reported by MEMORY STATS
+	startupAlloc uint64
 }
 
 func NewInstance(cap int, opts ...Option) (*Instance, error) {
@@ -33,10 +39,12 @@ func NewInstance(cap int, opts ...Option) (*Instance, error) {
 		handlers: make(map[int]*handlers, 16),
 		round:    0,
 		ps:       newPubsub(),
+		cfg:      newConfig(),
 		options: &Options{
 			Role: "master",
 		},
 	}
+	inst.events = newNotifier(inst.ps, inst.cfg)
 	for _, opt := range opts {
 		opt(inst.options)
 	}
@@ -44,6 +52,10 @@ func NewInstance(cap int, opts ...Option) (*Instance, error) {
 	if err := inst.init(); err != nil {
 		return nil, err
 	}
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	inst.startupAlloc = ms.HeapAlloc
 	return inst, nil
 }
 
@@ -103,8 +115,9 @@ func (i *Instance) Run(ctx context.Context) error {
 func (i *Instance) loop(ctx context.Context) {
 	dbi := i.dbs[i.round%len(i.dbs)]
 	if dbi != nil {
-		dbi.EvictHashMap()
+		dbi.EvictExpired()
 	}
+	i.expireBlocked()
 
 	if i.isSlave() {
 		replCmds := i.slave.GetChanges(10)
@@ -161,17 +174,40 @@ func (i *Instance) initDb(idx int) error {
 		return fmt.Errorf("invalid database number, must between 0 and 16: %d", idx)
 	}
 	if i.dbs[idx] == nil {
-		i.dbs[idx] = newDb(idx)
-		i.handlers[idx] = newHandlers(i.dbs[idx], i.info, i.ps, i.master, i.slave)
+		i.dbs[idx] = newDb(idx, i.events)
+		i.handlers[idx] = newHandlers(i.dbs[idx], i.info, i.ps, i.master, i.slave, i)
 	}
 	return nil
 }
 
+// databases returns every database that has been initialized so far.
+func (i *Instance) databases() []*database {
+	dbs := make([]*database, 0, len(i.dbs))
+	for _, db := range i.dbs {
+		if db != nil {
+			dbs = append(dbs, db)
+		}
+	}
+	return dbs
+}
+
+// handlersOf returns the handlers of database idx, creating the database if
+// it has not been used yet.
+func (i *Instance) handlersOf(idx int) (*handlers, error) {
+	if err := i.initDb(idx); err != nil {
+		return nil, err
+	}
+	return i.handlers[idx], nil
+}
+
 func (i *Instance) processCmd(ctx context.Context, cmd *gedis_types.Command) {
 	dbn := cmd.Db()
 	i.initDb(dbn)
 
 	handlers := i.handlers[dbn]
+	// once the command is replicated, clients blocked on the keys it wrote
+	// are served, their own effects replicated after it
+	defer i.handleReadyKeys()
 
 	hdl, shouldReplicate, err := handlers.route(cmd)
 	if err != nil {
@@ -197,10 +233,17 @@ func (i *Instance) processCmd(ctx context.Context, cmd *gedis_types.Command) {
 		return
 	}
 
-	if i.isMaster() {
-		if err := i.master.Repl(ctx, dbn, cmd.Cmd); err != nil {
-			log.Printf("failed to replicate command to slaves: %v", err)
-		}
+	i.replicate(ctx, dbn, cmd.Cmd)
+}
+
+// replicate sends cmd, run on database dbn, to the replicas, the replication
+// offset advancing by its size. Replicas replicate nothing.
+func (i *Instance) replicate(ctx context.Context, dbn int, cmd resp.Command) {
+	if !i.isMaster() {
+		return
+	}
+	if err := i.master.Repl(ctx, dbn, cmd); err != nil {
+		log.Printf("failed to replicate command to slaves: %v", err)
 	}
 }
 
@@ -1,9 +1,12 @@
 package gedis
 
 import (
+	"cmp"
 	"fmt"
 	"log"
-	"regexp"
+	"math"
+	"math/big"
+	"runtime"
 	"slices"
 	"strconv"
 	"strings"
@@ -11,6 +14,7 @@ import (
 
 	"github.com/ttn-nguyen42/gedis/data"
 	"github.com/ttn-nguyen42/gedis/gedis/info"
+	"github.com/ttn-nguyen42/gedis/gedis/rdb"
 	"github.com/ttn-nguyen42/gedis/gedis/repl"
 	gedis_types "github.com/ttn-nguyen42/gedis/gedis/types"
 	"github.com/ttn-nguyen42/gedis/resp"
@@ -31,6 +35,14 @@ type handlerEntry struct {
 	shouldReplicate bool
 }
 
+// writingForms decides from their arguments whether the commands only some
+// forms of which write are replicated, overriding their entry.
+var writingForms = map[string]func(args []any) bool{
+	"sort":              sortStores,
+	"georadius":         geoRadiusStores(5),
+	"georadiusbymember": geoRadiusStores(4),
+}
+
 type handlers struct {
 	isSlave bool
 	master  *repl.Master
@@ -40,10 +52,15 @@ type handlers struct {
 	hmap    map[string]handlerEntry
 	waits   []*waitEntry
 	pubsub  *pubsub
+	inst    *Instance
+	// inExec is set while EXEC runs the queued commands, which must not
+	// block
+	inExec bool
 }
 
-func newHandlers(db *database, info *info.Info, pubsub *pubsub, master *repl.Master, slave *repl.Slave) *handlers {
+func newHandlers(db *database, info *info.Info, pubsub *pubsub, master *repl.Master, slave *repl.Slave, inst *Instance) *handlers {
 	hdl := &handlers{
+		inst:    inst,
 		db:      db,
 		info:    info,
 		hmap:    nil,
@@ -63,18 +80,57 @@ func (h *handlers) init() {
 		"echo":             {h.handleEcho, false},
 		"select":           {h.handleSelect, false},
 		"del":              {h.handleDel, true},
+		"unlink":           {h.handleDel, true},
+		"exists":           {h.handleExists, false},
+		"touch":            {h.handleTouch, false},
+		"randomkey":        {h.handleRandomKey, false},
+		"dbsize":           {h.handleDbSize, false},
+		"rename":           {h.handleRename, true},
+		"renamenx":         {h.handleRenameNx, true},
+		"copy":             {h.handleCopy, true},
+		"move":             {h.handleMove, true},
+		"swapdb":           {h.handleSwapDb, true},
+		"flushdb":          {h.handleFlushDb, true},
+		"flushall":         {h.handleFlushAll, true},
+		"dump":             {h.handleDump, false},
+		"restore":          {h.handleRestore, true},
+		"sort":             {h.handleSort, false},
+		"sort_ro":          {h.handleSortRo, false},
 		"set":              {h.handleSet, true},
 		"get":              {h.handleGet, false},
 		"mset":             {h.handleMSet, true},
 		"mget":             {h.handleMGet, false},
+		"setnx":            {h.handleSetNx, true},
+		"setex":            {h.handleSetEx, true},
+		"psetex":           {h.handlePSetEx, true},
+		"getset":           {h.handleGetSet, true},
+		"getdel":           {h.handleGetDel, true},
+		"getex":            {h.handleGetEx, true},
+		"msetnx":           {h.handleMSetNx, true},
 		"incrby":           {h.handleIncrBy, true},
 		"decrby":           {h.handleDecrBy, true},
+		"decr":             {h.handleDecr, true},
+		"incrbyfloat":      {h.handleIncrByFloat, true},
 		"append":           {h.handleAppend, true},
 		"strlen":           {h.handleStrLen, false},
 		"getrange":         {h.handleGetRange, false},
 		"setrange":         {h.handleSetRange, true},
+		"lcs":              {h.handleLcs, false},
+		"setbit":           {h.handleSetBit, true},
+		"getbit":           {h.handleGetBit, false},
+		"bitcount":         {h.handleBitCount, false},
+		"bitpos":           {h.handleBitPos, false},
+		"bitop":            {h.handleBitOp, true},
+		"bitfield":         {h.handleBitField, true},
+		"bitfield_ro":      {h.handleBitFieldRo, false},
+		"pfadd":            {h.handlePfAdd, true},
+		"pfcount":          {h.handlePfCount, false},
+		"pfmerge":          {h.handlePfMerge, true},
+		"pfdebug":          {h.handlePfDebug, true},
 		"rpush":            {h.handleRPush, true},
 		"lpush":            {h.handleLPush, true},
+		"lpushx":           {h.handleLPushX, true},
+		"rpushx":           {h.handleRPushX, true},
 		"lpop":             {h.handleLPop, true},
 		"rpop":             {h.handleRPop, true},
 		"lrange":           {h.handleLRange, false},
@@ -82,7 +138,17 @@ func (h *handlers) init() {
 		"lindex":           {h.handleLIndex, false},
 		"lset":             {h.handleLSet, true},
 		"ltrim":            {h.handleLTrim, true},
-		"blpop":            {h.handleBlockLpop, false},
+		"linsert":          {h.handleLInsert, true},
+		"lrem":             {h.handleLRem, true},
+		"lpos":             {h.handleLPos, false},
+		"lmove":            {h.handleLMove, true},
+		"rpoplpush":        {h.handleRPopLPush, true},
+		"lmpop":            {h.handleLMPop, true},
+		"blpop":            {h.handleBLPop, false},
+		"brpop":            {h.handleBRPop, false},
+		"blmove":           {h.handleBLMove, false},
+		"brpoplpush":       {h.handleBRPopLPush, false},
+		"blmpop":           {h.handleBLMPop, false},
 		"hset":             {h.handleHSet, true},
 		"hget":             {h.handleHGet, false},
 		"hmget":            {h.handleHMGet, false},
@@ -93,14 +159,36 @@ func (h *handlers) init() {
 		"hlen":             {h.handleHLen, false},
 		"hkeys":            {h.handleHKeys, false},
 		"hvals":            {h.handleHVals, false},
+		"hmset":            {h.handleHMSet, true},
+		"hsetnx":           {h.handleHSetNx, true},
+		"hincrbyfloat":     {h.handleHIncrByFloat, true},
+		"hstrlen":          {h.handleHStrLen, false},
+		"hrandfield":       {h.handleHRandField, false},
+		"hscan":            {h.handleHScan, false},
+		"hexpire":          {h.handleHExpire, true},
+		"hpexpire":         {h.handleHPExpire, true},
+		"hexpireat":        {h.handleHExpireAt, true},
+		"hpexpireat":       {h.handleHPExpireAt, true},
+		"httl":             {h.handleHTtl, false},
+		"hpttl":            {h.handleHPTtl, false},
+		"hexpiretime":      {h.handleHExpireTime, false},
+		"hpexpiretime":     {h.handleHPExpireTime, false},
+		"hpersist":         {h.handleHPersist, true},
+		"hgetex":           {h.handleHGetEx, true},
+		"hsetex":           {h.handleHSetEx, true},
+		"hgetdel":          {h.handleHGetDel, true},
 		"incr":             {h.handleIncr, true},
 		"multi":            {h.handleMulti, true},
 		"exec":             {h.handleExec, true},
 		"discard":          {h.handleDiscard, true},
 		"info":             {h.handleInfo, false},
+		"object":           {h.handleObject, false},
+		"memory":           {h.handleMemory, false},
+		"config":           {h.handleConfig, false},
 		"replconf":         {h.handleReplConf, false},
 		"psync":            {h.handlePsync, false},
 		"wait":             {h.handleWait, false},
+		"client":           {h.handleClient, false},
 		"subscribe":        {h.handleSubscribe, false},
 		"unsubscribe":      {h.handleUnsubscribe, false},
 		"publish":          {h.handlePublish, true},
@@ -116,7 +204,30 @@ func (h *handlers) init() {
 		"zincrby":          {h.handleZincrby, true},
 		"zcount":           {h.handleZcount, false},
 		"zrangebyscore":    {h.handleZrangebyscore, false},
+		"zrevrangebyscore": {h.handleZrevrangebyscore, false},
+		"zrangebylex":      {h.handleZrangebylex, false},
+		"zrevrangebylex":   {h.handleZrevrangebylex, false},
+		"zlexcount":        {h.handleZlexcount, false},
+		"zrangestore":      {h.handleZrangestore, true},
 		"zremrangebyscore": {h.handleZremrangebyscore, true},
+		"zremrangebyrank":  {h.handleZremrangebyrank, true},
+		"zremrangebylex":   {h.handleZremrangebylex, true},
+		"zunion":           {h.handleZunion, false},
+		"zunionstore":      {h.handleZunionstore, true},
+		"zinter":           {h.handleZinter, false},
+		"zinterstore":      {h.handleZinterstore, true},
+		"zdiff":            {h.handleZdiff, false},
+		"zdiffstore":       {h.handleZdiffstore, true},
+		"zintercard":       {h.handleZintercard, false},
+		"zpopmin":          {h.handleZPopMin, true},
+		"zpopmax":          {h.handleZPopMax, true},
+		"zmpop":            {h.handleZMPop, true},
+		"zrandmember":      {h.handleZrandmember, false},
+		"zmscore":          {h.handleZmscore, false},
+		"zscan":            {h.handleZscan, false},
+		"bzpopmin":         {h.handleBZPopMin, false},
+		"bzpopmax":         {h.handleBZPopMax, false},
+		"bzmpop":           {h.handleBZMPop, false},
 		"sadd":             {h.handleSadd, true},
 		"smembers":         {h.handleSmembers, false},
 		"sismember":        {h.handleSismember, false},
@@ -127,10 +238,25 @@ func (h *handlers) init() {
 		"sdiff":            {h.handleSdiff, false},
 		"srandmember":      {h.handleSrandmember, false},
 		"spop":             {h.handleSpop, true},
+		"sinterstore":      {h.handleSinterstore, true},
+		"sunionstore":      {h.handleSunionstore, true},
+		"sdiffstore":       {h.handleSdiffstore, true},
+		"sintercard":       {h.handleSintercard, false},
+		"smove":            {h.handleSmove, true},
+		"smismember":       {h.handleSmismember, false},
+		"sscan":            {h.handleSscan, false},
 		"geoadd":           {h.handleGeoAdd, true},
 		"geopos":           {h.handleGeoPos, false},
 		"geodist":          {h.handleGeoDist, false},
 		"geosearch":        {h.handleGeoSearch, false},
+		"geosearchstore":   {h.handleGeoSearchStore, true},
+		"geohash":          {h.handleGeoHash, false},
+
+		// the commands GEOSEARCH replaced, still sent by older clients
+		"georadius":            {h.handleGeoRadius, false},
+		"georadius_ro":         {h.handleGeoRadiusRO, false},
+		"georadiusbymember":    {h.handleGeoRadiusByMember, false},
+		"georadiusbymember_ro": {h.handleGeoRadiusByMemberRO, false},
 	}
 }
 
@@ -139,30 +265,70 @@ func parseBulkStr(arg any) (string, error) {
 	if !ok {
 		return "", fmt.Errorf("%w: expected bulk string, got %T", ErrInvalidArguments, arg)
 	}
-	return bulkStr.Value, nil
+	return string(bulkStr.Value), nil
 }
 
-func parseStr(arg any) (string, error) {
+// parseBytes returns the bytes of a command argument or of a stored value,
+// the two places a string comes from.
+func parseBytes(arg any) ([]byte, error) {
 	switch v := arg.(type) {
-	case string:
-		return v, nil
 	case resp.BulkStr:
 		return v.Value, nil
+	case []byte:
+		return v, nil
 	default:
-		return "", fmt.Errorf("%w: expected string or bulk string, got %T", ErrInvalidArguments, arg)
+		return nil, fmt.Errorf("%w: expected string or bulk string, got %T", ErrInvalidArguments, arg)
 	}
 }
 
-func parseInt(arg any) (int, error) {
-	var str string
-	bulkStr, ok := arg.(resp.BulkStr)
-	if !ok {
-		str, ok = arg.(string)
-		if !ok {
-			return 0, fmt.Errorf("%w: expected integer, got %T", ErrInvalidArguments, arg)
+// parseValues returns the bytes of each argument, the values a command
+// stores.
+func parseValues(args []any) ([][]byte, error) {
+	values := make([][]byte, len(args))
+	for i, arg := range args {
+		value, err := parseBytes(arg)
+		if err != nil {
+			return nil, err
 		}
-	} else {
-		str = bulkStr.Value
+		values[i] = value
+	}
+	return values, nil
+}
+
+// parseKeys returns the key named by each argument.
+func parseKeys(args []any) ([]string, error) {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		key, err := parseBulkStr(arg)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+	}
+	return keys, nil
+}
+
+// bytesArray returns the array of the bulk strings in items.
+func bytesArray(items [][]byte) resp.Array {
+	arr := resp.Array{Size: len(items), Items: make([]any, len(items))}
+	for i, item := range items {
+		arr.Items[i] = item
+	}
+	return arr
+}
+
+func parseStr(arg any) (string, error) {
+	b, err := parseBytes(arg)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func parseInt(arg any) (int, error) {
+	str, err := parseStr(arg)
+	if err != nil {
+		return 0, fmt.Errorf("%w: expected integer, got %T", ErrInvalidArguments, arg)
 	}
 	val, err := strconv.Atoi(str)
 	if err != nil {
@@ -172,11 +338,11 @@ func parseInt(arg any) (int, error) {
 }
 
 func parseFloat(arg any) (float64, error) {
-	bulkStr, ok := arg.(resp.BulkStr)
-	if !ok {
+	str, err := parseStr(arg)
+	if err != nil {
 		return 0, fmt.Errorf("%w: expected integer, got %T", ErrInvalidArguments, arg)
 	}
-	val, err := strconv.ParseFloat(bulkStr.Value, 64)
+	val, err := strconv.ParseFloat(str, 64)
 	if err != nil {
 		return 0, fmt.Errorf("%w: invalid float value", ErrInvalidArguments)
 	}
@@ -185,10 +351,14 @@ func parseFloat(arg any) (float64, error) {
 
 func (h *handlers) route(cmd *gedis_types.Command) (handler, bool, error) {
 	r := cmd.Cmd
-	entry, found := h.hmap[strings.ToLower(r.Cmd)]
+	name := strings.ToLower(r.Cmd)
+	entry, found := h.hmap[name]
 	if !found {
 		return nil, false, fmt.Errorf("%w: invalid command '%s'", resp.ErrProtocolError, r.Cmd)
 	}
+	if writes, ok := writingForms[name]; ok {
+		return entry.handler, writes(r.Args), nil
+	}
 	return entry.handler, entry.shouldReplicate, nil
 }
 
@@ -267,28 +437,9 @@ func (h *handlers) handleDel(cmd *gedis_types.Command) error {
 			return err
 		}
 
-		if _, ok := h.db.HashMap().Delete(key); ok {
-			deleted += 1
-			continue
-		}
-
-		if h.db.DeleteList(key) {
-			deleted += 1
-			continue
-		}
-
-		if h.db.DeleteSortedSet(key) {
-			deleted += 1
-			continue
-		}
-
-		if h.db.DeleteSet(key) {
-			deleted += 1
-			continue
-		}
-
-		if h.db.DeleteGeoIndex(key) {
+		if h.db.Delete(key) {
 			deleted += 1
+			h.db.notify(notifyGeneric, "del", key)
 		}
 	}
 
@@ -298,72 +449,122 @@ func (h *handlers) handleDel(cmd *gedis_types.Command) error {
 	return nil
 }
 
-func checkExpiry(args []any) (int, bool, error) {
-	if len(args) == 0 {
-		return -1, false, nil
-	}
-	if len(args) < 2 {
-		return -1, false, fmt.Errorf("%w: missing TTL duration", ErrInvalidArguments)
-	}
+// setOptions are the options of SET.
+type setOptions struct {
+	nx, xx  bool
+	get     bool
+	keepTTL bool
+	// expiresAt is zero when no expiry is given
+	expiresAt time.Time
+}
 
-	typeStr, err := parseBulkStr(args[0])
+// parseExpireAt converts the argument of an EX, PX, EXAT or PXAT option to
+// an absolute time. The time must be positive, name is the command reported
+// in the error otherwise.
+func parseExpireAt(unit string, arg any, name string) (time.Time, error) {
+	n, err := parseInt(arg)
 	if err != nil {
-		return -1, false, err
+		return time.Time{}, err
 	}
-
-	ttl, err := parseInt(args[1])
-	if err != nil {
-		return -1, false, err
+	invalid := fmt.Errorf("invalid expire time in '%s' command", strings.ToLower(name))
+	if n <= 0 {
+		return time.Time{}, invalid
 	}
-
-	var mod = 1
-	switch strings.ToLower(typeStr) {
-	case "ex":
-		mod = 1000
-	case "px":
-		mod = 1
-	default:
-		return -1, false, fmt.Errorf("%w: unknown TTL modifier: %v", ErrInvalidArguments, args[0])
+	millis := int64(n)
+	switch unit {
+	case "ex", "exat":
+		if millis > math.MaxInt64/1000 {
+			return time.Time{}, invalid
+		}
+		millis *= 1000
+	}
+	switch unit {
+	case "ex", "px":
+		if millis > math.MaxInt64-time.Now().UnixMilli() {
+			return time.Time{}, invalid
+		}
+		return time.Now().Add(time.Duration(millis) * time.Millisecond), nil
 	}
+	return time.UnixMilli(millis), nil
+}
 
-	return ttl * mod, true, nil
+// parseSetOptions parses the options of SET, accepted in any order:
+// NX, XX, GET, KEEPTTL and one of EX, PX, EXAT and PXAT.
+func parseSetOptions(args []any, name string) (setOptions, error) {
+	var (
+		opts        setOptions
+		hasExpiry   bool
+		syntaxError = fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+	)
+	for i := 0; i < len(args); i += 1 {
+		opt, err := parseStr(args[i])
+		if err != nil {
+			return opts, err
+		}
+		switch unit := strings.ToLower(opt); unit {
+		case "nx":
+			if opts.xx {
+				return opts, syntaxError
+			}
+			opts.nx = true
+		case "xx":
+			if opts.nx {
+				return opts, syntaxError
+			}
+			opts.xx = true
+		case "get":
+			opts.get = true
+		case "keepttl":
+			if hasExpiry {
+				return opts, syntaxError
+			}
+			opts.keepTTL, hasExpiry = true, true
+		case "ex", "px", "exat", "pxat":
+			if hasExpiry || i+1 >= len(args) {
+				return opts, syntaxError
+			}
+			if opts.expiresAt, err = parseExpireAt(unit, args[i+1], name); err != nil {
+				return opts, err
+			}
+			hasExpiry = true
+			i += 1
+		default:
+			return opts, syntaxError
+		}
+	}
+	return opts, nil
 }
 
-func (h *handlers) handleSet(cmd *gedis_types.Command) error {
+func (h *handlers) handleExists(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
 	args := cmd.Cmd.Args
-	if len(args) < 2 {
+	if len(args) < 1 {
 		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
 	}
 
-	if err := h.checkSlaveWrite(cmd); err != nil {
-		return err
-	}
-
-	key, err := parseBulkStr(args[0])
-	if err != nil {
-		return err
-	}
-	value := args[1]
-	ttl, _, err := checkExpiry(args[2:])
-	if err != nil {
-		return err
-	}
 	defer cmd.SetDone()
 	if h.checkInTx(cmd) {
 		return nil
 	}
 
-	h.db.HashMap().Set(key, value, ttl)
-	if h.shouldWriteOutput(cmd) {
-		cmd.WriteAny("OK")
+	count := 0
+	for _, arg := range args {
+		key, err := parseBulkStr(arg)
+		if err != nil {
+			return err
+		}
+		if h.db.Exists(key) {
+			count += 1
+		}
 	}
+
+	cmd.WriteAny(count)
 	return nil
 }
 
-func (h *handlers) handleGet(cmd *gedis_types.Command) error {
+func (h *handlers) handleTouch(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
@@ -371,134 +572,130 @@ func (h *handlers) handleGet(cmd *gedis_types.Command) error {
 	if len(args) < 1 {
 		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
 	}
+
 	defer cmd.SetDone()
 	if h.checkInTx(cmd) {
 		return nil
 	}
-	key, err := parseBulkStr(args[0])
-	if err != nil {
-		return err
+
+	touched := 0
+	for _, arg := range args {
+		key, err := parseBulkStr(arg)
+		if err != nil {
+			return err
+		}
+		if h.db.Exists(key) {
+			h.db.touch(key)
+			touched += 1
+		}
 	}
-	value, ok := h.db.HashMap().Get(key)
-	if !ok {
-		cmd.WriteAny(resp.BulkStr{Size: -1})
-		return nil
+
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(touched)
 	}
-	cmd.WriteAny(value)
 	return nil
 }
 
-func (h *handlers) handleMSet(cmd *gedis_types.Command) error {
+func (h *handlers) handleRandomKey(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
-	args := cmd.Cmd.Args
-	if len(args) < 2 || len(args)%2 != 0 {
-		return fmt.Errorf("%w: wrong number of arguments", ErrInvalidArguments)
-	}
-
-	if err := h.checkSlaveWrite(cmd); err != nil {
-		return err
-	}
 
 	defer cmd.SetDone()
 	if h.checkInTx(cmd) {
 		return nil
 	}
 
-	for i := 0; i < len(args); i += 2 {
-		key, err := parseBulkStr(args[i])
-		if err != nil {
-			return err
-		}
-		value := args[i+1]
-		h.db.HashMap().Set(key, value, -1)
-	}
-
-	if h.shouldWriteOutput(cmd) {
-		cmd.WriteAny("OK")
+	key, exists := h.db.RandomKey()
+	if !exists {
+		cmd.WriteAny(resp.BulkStr{Size: -1})
+		return nil
 	}
+	cmd.WriteAny(key)
 	return nil
 }
 
-func (h *handlers) handleMGet(cmd *gedis_types.Command) error {
+func (h *handlers) handleDbSize(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
-	args := cmd.Cmd.Args
-	if len(args) < 1 {
-		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
-	}
+
 	defer cmd.SetDone()
 	if h.checkInTx(cmd) {
 		return nil
 	}
 
-	items := make([]any, len(args))
-	for i, arg := range args {
-		key, err := parseBulkStr(arg)
-		if err != nil {
-			return err
-		}
-		value, ok := h.db.HashMap().Get(key)
-		if !ok {
-			items[i] = resp.BulkStr{Size: -1}
-		} else {
-			items[i] = value
-		}
-	}
-
-	cmd.WriteAny(resp.Array{Size: len(items), Items: items})
+	cmd.WriteAny(h.db.Size())
 	return nil
 }
 
-func (h *handlers) handleRPush(cmd *gedis_types.Command) error {
+func (h *handlers) handleRename(cmd *gedis_types.Command) error {
+	return h.rename(cmd, false)
+}
+
+func (h *handlers) handleRenameNx(cmd *gedis_types.Command) error {
+	return h.rename(cmd, true)
+}
+
+// rename implements RENAME and, when nx is set, RENAMENX. The value keeps its
+// TTL and replaces whatever the destination held.
+func (h *handlers) rename(cmd *gedis_types.Command, nx bool) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
 	args := cmd.Cmd.Args
-	if len(args) < 2 {
-		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	if len(args) != 2 {
+		return fmt.Errorf("%w: requires exactly 2 arguments", ErrInvalidArguments)
 	}
 
 	if err := h.checkSlaveWrite(cmd); err != nil {
 		return err
 	}
 
-	defer cmd.SetDone()
-	if h.checkInTx(cmd) {
-		return nil
+	src, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
 	}
-
-	key, err := parseBulkStr(args[0])
+	dst, err := parseBulkStr(args[1])
 	if err != nil {
 		return err
 	}
-	list := h.db.GetOrCreateList(key)
-	for _, value := range args[1:] {
-		list.RightPush(value)
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
 	}
 
-	if h.shouldWriteOutput(cmd) {
-		cmd.WriteAny(list.Len())
+	e, exists := h.db.get(src)
+	if !exists {
+		return fmt.Errorf("no such key")
 	}
 
-	blkRequests, ok := h.db.block.blockLpop[key]
-	if !ok {
+	if nx && h.db.Exists(dst) {
+		if h.shouldWriteOutput(cmd) {
+			cmd.WriteAny(0)
+		}
 		return nil
 	}
 
-	h.db.block.blockLpop[key] = slices.DeleteFunc(blkRequests, func(req *gedis_types.Command) bool {
-		ok := h.resolveBlockLpop(key, req)
-		if ok {
-			log.Printf("resolved blpop request, listKey=%s", key)
+	if src != dst {
+		h.db.Delete(src)
+		h.db.put(dst, e)
+		h.db.notify(notifyGeneric, "rename_from", src)
+		h.db.notify(notifyGeneric, "rename_to", dst)
+	}
+
+	if h.shouldWriteOutput(cmd) {
+		if nx {
+			cmd.WriteAny(1)
+		} else {
+			cmd.WriteAny("OK")
 		}
-		return ok
-	})
+	}
 	return nil
 }
 
-func (h *handlers) handleLPush(cmd *gedis_types.Command) error {
+func (h *handlers) handleCopy(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
@@ -511,295 +708,248 @@ func (h *handlers) handleLPush(cmd *gedis_types.Command) error {
 		return err
 	}
 
-	defer cmd.SetDone()
-	if h.checkInTx(cmd) {
-		return nil
+	src, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
 	}
-
-	key, err := parseBulkStr(args[0])
+	dst, err := parseBulkStr(args[1])
 	if err != nil {
 		return err
 	}
-	list := h.db.GetOrCreateList(key)
-	for _, value := range args[1:] {
-		list.LeftPush(value)
+
+	dbn := cmd.Db()
+	replace := false
+	for i := 2; i < len(args); i += 1 {
+		opt, err := parseStr(args[i])
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(opt) {
+		case "replace":
+			replace = true
+		case "db":
+			if i+1 >= len(args) {
+				return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+			}
+			dbn, err = parseInt(args[i+1])
+			if err != nil {
+				return err
+			}
+			i += 1
+		default:
+			return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+		}
 	}
 
-	if h.shouldWriteOutput(cmd) {
-		cmd.WriteAny(list.Len())
+	target, err := h.inst.handlersOf(dbn)
+	if err != nil {
+		return fmt.Errorf("%w: DB index is out of range", ErrInvalidArguments)
 	}
 
-	blkRequests, ok := h.db.block.blockLpop[key]
-	if !ok {
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
 		return nil
 	}
 
-	h.db.block.blockLpop[key] = slices.DeleteFunc(blkRequests, func(req *gedis_types.Command) bool {
-		ok := h.resolveBlockLpop(key, req)
-		if ok {
-			log.Printf("resolved blpop request, listKey=%s", key)
-		}
-		return ok
-	})
+	if target == h && src == dst {
+		return fmt.Errorf("source and destination objects are the same")
+	}
+
+	copied := 0
+	e, exists := h.db.get(src)
+	if exists && (replace || !target.db.Exists(dst)) {
+		target.db.put(dst, e.clone())
+		target.db.notify(notifyGeneric, "copy_to", dst)
+		copied = 1
+	}
+
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(copied)
+	}
 	return nil
 }
 
-func (h *handlers) handleLPop(cmd *gedis_types.Command) error {
+func (h *handlers) handleMove(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
 	args := cmd.Cmd.Args
-	if len(args) < 1 {
-		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	if len(args) != 2 {
+		return fmt.Errorf("%w: requires exactly 2 arguments", ErrInvalidArguments)
 	}
 
 	if err := h.checkSlaveWrite(cmd); err != nil {
 		return err
 	}
 
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+	dbn, err := parseInt(args[1])
+	if err != nil {
+		return err
+	}
+
+	target, err := h.inst.handlersOf(dbn)
+	if err != nil {
+		return fmt.Errorf("%w: DB index is out of range", ErrInvalidArguments)
+	}
+
 	defer cmd.SetDone()
 	if h.checkInTx(cmd) {
 		return nil
 	}
 
-	key, err := parseBulkStr(args[0])
-	if err != nil {
-		return err
+	if target == h {
+		return fmt.Errorf("source and destination objects are the same")
 	}
 
-	count := 1
-	if len(args) >= 2 {
-		var err error
-		count, err = parseInt(args[1])
-		if err != nil {
-			return err
-		}
+	moved := 0
+	e, exists := h.db.get(key)
+	if exists && !target.db.Exists(key) {
+		h.db.Delete(key)
+		target.db.put(key, e)
+		h.db.notify(notifyGeneric, "move_from", key)
+		target.db.notify(notifyGeneric, "move_to", key)
+		moved = 1
 	}
 
-	list, exists := h.db.GetList(key)
-	if !exists {
-		if h.shouldWriteOutput(cmd) {
-			cmd.WriteAny(resp.BulkStr{Size: -1})
-		}
-		return nil
-	}
-
-	if len(args) >= 2 {
-		items := make([]any, 0, count)
-		for i := 0; i < count; i += 1 {
-			value, ok := list.LeftPop()
-			if !ok {
-				break
-			}
-			items = append(items, value)
-		}
-		if list.Len() == 0 {
-			h.db.DeleteList(key)
-		}
-		if h.shouldWriteOutput(cmd) {
-			cmd.WriteAny(resp.Array{Size: len(items), Items: items})
-		}
-		return nil
-	}
-
-	value, ok := list.LeftPop()
-	if !ok {
-		if h.shouldWriteOutput(cmd) {
-			cmd.WriteAny(resp.BulkStr{Size: -1})
-		}
-		return nil
-	}
-	if list.Len() == 0 {
-		h.db.DeleteList(key)
-	}
 	if h.shouldWriteOutput(cmd) {
-		cmd.WriteAny(value)
+		cmd.WriteAny(moved)
 	}
 	return nil
 }
 
-func (h *handlers) handleRPop(cmd *gedis_types.Command) error {
+func (h *handlers) handleSwapDb(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
 	args := cmd.Cmd.Args
-	if len(args) < 1 {
-		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	if len(args) != 2 {
+		return fmt.Errorf("%w: requires exactly 2 arguments", ErrInvalidArguments)
 	}
 
 	if err := h.checkSlaveWrite(cmd); err != nil {
 		return err
 	}
 
-	defer cmd.SetDone()
-	if h.checkInTx(cmd) {
-		return nil
+	first, err := parseInt(args[0])
+	if err != nil {
+		return fmt.Errorf("%w: invalid first DB index", ErrInvalidArguments)
 	}
-
-	key, err := parseBulkStr(args[0])
+	second, err := parseInt(args[1])
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: invalid second DB index", ErrInvalidArguments)
 	}
 
-	count := 1
-	if len(args) >= 2 {
-		var err error
-		count, err = parseInt(args[1])
-		if err != nil {
-			return err
-		}
+	a, err := h.inst.handlersOf(first)
+	if err != nil {
+		return fmt.Errorf("%w: DB index is out of range", ErrInvalidArguments)
 	}
-
-	list, exists := h.db.GetList(key)
-	if !exists {
-		if h.shouldWriteOutput(cmd) {
-			cmd.WriteAny(resp.BulkStr{Size: -1})
-		}
-		return nil
+	b, err := h.inst.handlersOf(second)
+	if err != nil {
+		return fmt.Errorf("%w: DB index is out of range", ErrInvalidArguments)
 	}
 
-	if len(args) >= 2 {
-		items := make([]any, 0, count)
-		for i := 0; i < count; i += 1 {
-			value, ok := list.RightPop()
-			if !ok {
-				break
-			}
-			items = append(items, value)
-		}
-		if list.Len() == 0 {
-			h.db.DeleteList(key)
-		}
-		if h.shouldWriteOutput(cmd) {
-			cmd.WriteAny(resp.Array{Size: len(items), Items: items})
-		}
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
 		return nil
 	}
 
-	value, ok := list.RightPop()
-	if !ok {
-		if h.shouldWriteOutput(cmd) {
-			cmd.WriteAny(resp.BulkStr{Size: -1})
-		}
-		return nil
-	}
-	if list.Len() == 0 {
-		h.db.DeleteList(key)
+	if a != b {
+		a.db.Swap(b.db)
+		// clients blocked on either database may now find their key
+		a.db.signalExistingKeys()
+		b.db.signalExistingKeys()
 	}
+
 	if h.shouldWriteOutput(cmd) {
-		cmd.WriteAny(value)
+		cmd.WriteAny("OK")
 	}
 	return nil
 }
 
-// (handleQuit removed due to incomplete implementation and not part of the allowed command set.)
-
-func (h *handlers) handleLRange(cmd *gedis_types.Command) error {
-	if cmd.IsSubMode() {
-		return h.subModeErr(cmd)
-	}
-	args := cmd.Cmd.Args
-	if len(args) < 3 {
-		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+// parseFlushMode validates the optional ASYNC or SYNC argument of FLUSHDB
+// and FLUSHALL. Both flush synchronously.
+func parseFlushMode(args []any) error {
+	if len(args) > 1 {
+		return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
 	}
-	defer cmd.SetDone()
-	if h.checkInTx(cmd) {
+	if len(args) == 0 {
 		return nil
 	}
-	key, err := parseBulkStr(args[0])
-	if err != nil {
-		return err
-	}
-	start, err := parseInt(args[1])
-	if err != nil {
-		return err
-	}
-	stop, err := parseInt(args[2])
+	mode, err := parseStr(args[0])
 	if err != nil {
 		return err
 	}
-	list, exists := h.db.GetList(key)
-	if !exists {
-		cmd.WriteAny(resp.Array{Size: 0, Items: []any{}})
-		return nil
+	if !strings.EqualFold(mode, "async") && !strings.EqualFold(mode, "sync") {
+		return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
 	}
-	items := list.LeftRange(start, stop)
-	cmd.WriteAny(resp.Array{Size: len(items), Items: items})
 	return nil
 }
 
-func (h *handlers) handleLLen(cmd *gedis_types.Command) error {
+func (h *handlers) handleFlushDb(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
-	args := cmd.Cmd.Args
-	if len(args) < 1 {
-		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	if err := parseFlushMode(cmd.Cmd.Args); err != nil {
+		return err
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
 	}
+
 	defer cmd.SetDone()
 	if h.checkInTx(cmd) {
 		return nil
 	}
-	key, err := parseBulkStr(args[0])
-	if err != nil {
-		return err
-	}
-	list, exists := h.db.GetList(key)
-	if !exists {
-		cmd.WriteAny(0)
-		return nil
+
+	h.db.Flush()
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny("OK")
 	}
-	cmd.WriteAny(list.Len())
 	return nil
 }
 
-func (h *handlers) handleLIndex(cmd *gedis_types.Command) error {
+func (h *handlers) handleFlushAll(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
-	args := cmd.Cmd.Args
-	if len(args) < 2 {
-		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
-	}
-	defer cmd.SetDone()
-	if h.checkInTx(cmd) {
-		return nil
-	}
-	key, err := parseBulkStr(args[0])
-	if err != nil {
+	if err := parseFlushMode(cmd.Cmd.Args); err != nil {
 		return err
 	}
 
-	index, err := parseInt(args[1])
-	if err != nil {
+	if err := h.checkSlaveWrite(cmd); err != nil {
 		return err
 	}
 
-	list, exists := h.db.GetList(key)
-	if !exists {
-		cmd.WriteAny(resp.BulkStr{Size: -1})
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
 		return nil
 	}
 
-	value, ok := list.LeftIndex(index)
-	if !ok {
-		cmd.WriteAny(resp.BulkStr{Size: -1})
-		return nil
+	for _, db := range h.inst.databases() {
+		db.Flush()
+	}
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny("OK")
 	}
-	cmd.WriteAny(value)
 	return nil
 }
 
-func (h *handlers) handleLSet(cmd *gedis_types.Command) error {
+func (h *handlers) handleDump(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
 	args := cmd.Cmd.Args
-	if len(args) < 3 {
-		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	if len(args) != 1 {
+		return fmt.Errorf("%w: requires exactly 1 argument", ErrInvalidArguments)
 	}
 
-	if err := h.checkSlaveWrite(cmd); err != nil {
+	key, err := parseBulkStr(args[0])
+	if err != nil {
 		return err
 	}
 
@@ -808,35 +958,22 @@ func (h *handlers) handleLSet(cmd *gedis_types.Command) error {
 		return nil
 	}
 
-	key, err := parseBulkStr(args[0])
-	if err != nil {
-		return err
+	val, exists := h.db.lookup(key)
+	if !exists {
+		cmd.WriteAny(resp.BulkStr{Size: -1})
+		return nil
 	}
-
-	index, err := parseInt(args[1])
+	payload, err := rdb.Dump(val)
 	if err != nil {
 		return err
 	}
-
-	value := args[2]
-
-	list, exists := h.db.GetList(key)
-	if !exists {
-		return fmt.Errorf("no such key")
-	}
-
-	ok := list.LeftSet(index, value)
-	if !ok {
-		return fmt.Errorf("index out of range")
-	}
-
-	if h.shouldWriteOutput(cmd) {
-		cmd.WriteAny("OK")
-	}
+	cmd.WriteAny(resp.NewBulkStr(payload))
 	return nil
 }
 
-func (h *handlers) handleLTrim(cmd *gedis_types.Command) error {
+var errBusyKey = resp.NewCodeErr("BUSYKEY", "Target key name already exists.")
+
+func (h *handlers) handleRestore(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
@@ -849,216 +986,409 @@ func (h *handlers) handleLTrim(cmd *gedis_types.Command) error {
 		return err
 	}
 
-	defer cmd.SetDone()
-	if h.checkInTx(cmd) {
-		return nil
-	}
-
 	key, err := parseBulkStr(args[0])
 	if err != nil {
 		return err
 	}
-
-	start, err := parseInt(args[1])
+	ttl, err := parseInt(args[1])
 	if err != nil {
 		return err
 	}
-
-	stop, err := parseInt(args[2])
+	if ttl < 0 {
+		return fmt.Errorf("%w: Invalid TTL value, must be >= 0", ErrInvalidArguments)
+	}
+	payload, err := parseBulkStr(args[2])
 	if err != nil {
 		return err
 	}
 
-	list, exists := h.db.GetList(key)
-	if !exists {
-		// LTRIM on non-existent key is OK
-		if h.shouldWriteOutput(cmd) {
-			cmd.WriteAny("OK")
+	replace, absTtl := false, false
+	idle, freq := -1, -1
+	for i := 3; i < len(args); i += 1 {
+		opt, err := parseStr(args[i])
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(opt) {
+		case "replace":
+			replace = true
+		case "absttl":
+			absTtl = true
+		case "idletime":
+			if i+1 >= len(args) || freq >= 0 {
+				return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+			}
+			idle, err = parseInt(args[i+1])
+			if err != nil {
+				return err
+			}
+			if idle < 0 {
+				return fmt.Errorf("%w: Invalid IDLETIME value, must be >= 0", ErrInvalidArguments)
+			}
+			i += 1
+		case "freq":
+			if i+1 >= len(args) || idle >= 0 {
+				return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+			}
+			freq, err = parseInt(args[i+1])
+			if err != nil {
+				return err
+			}
+			if freq < 0 || freq > 255 {
+				return fmt.Errorf("%w: Invalid FREQ value, must be >= 0 and <= 255", ErrInvalidArguments)
+			}
+			i += 1
+		default:
+			return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
 		}
-		return nil
-	}
-
-	list.Trim(start, stop)
-
-	if h.shouldWriteOutput(cmd) {
-		cmd.WriteAny("OK")
-	}
-	return nil
-}
-
-func (h *handlers) handleBlockLpop(cmd *gedis_types.Command) error {
-	if cmd.IsSubMode() {
-		return h.subModeErr(cmd)
-	}
-	args := cmd.Cmd.Args
-	if len(args) < 2 {
-		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
 	}
 
+	defer cmd.SetDone()
 	if h.checkInTx(cmd) {
 		return nil
 	}
 
-	key, err := parseBulkStr(args[0])
-	if err != nil {
-		return err
+	if !replace && h.db.Exists(key) {
+		return errBusyKey
 	}
 
-	timeout, err := parseFloat(args[1])
+	val, err := rdb.Restore([]byte(payload))
 	if err != nil {
 		return err
 	}
 
-	if timeout != 0 {
-		cmd.SetTimeout(time.Now().Add(time.Duration(timeout * float64(time.Second))))
+	e := entry{value: val}
+	if ttl > 0 {
+		if absTtl {
+			e.expiresAt = time.UnixMilli(int64(ttl))
+		} else {
+			e.expiresAt = time.Now().Add(time.Duration(ttl) * time.Millisecond)
+		}
+	}
+
+	if !e.expiresAt.IsZero() && !e.expiresAt.After(time.Now()) {
+		// already expired, only the replaced value goes away
+		if h.db.Delete(key) {
+			h.db.notify(notifyGeneric, "del", key)
+		}
+	} else {
+		h.db.put(key, e)
+		h.db.setAccess(key, time.Duration(idle)*time.Second, freq)
+		h.db.notify(notifyGeneric, "restore", key)
 	}
 
-	ok := h.resolveBlockLpop(key, cmd)
-	if !ok {
-		h.db.block.blockLpop[key] = append(h.db.block.blockLpop[key], cmd)
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny("OK")
 	}
 	return nil
 }
 
-func (h *handlers) subModeErr(cmd *gedis_types.Command) error {
-	return fmt.Errorf("can't execute '%s' while in subscribe mode", strings.ToLower(cmd.Cmd.Cmd))
+func (h *handlers) handleSort(cmd *gedis_types.Command) error {
+	return h.sort(cmd, false)
 }
 
-func (h *handlers) resolveBlockLpop(key string, cmd *gedis_types.Command) (ok bool) {
-	if cmd.HasTimedOut() {
-		return true
-	}
+func (h *handlers) handleSortRo(cmd *gedis_types.Command) error {
+	return h.sort(cmd, true)
+}
 
-	list, exists := h.db.GetList(key)
-	if !exists {
-		return false
+// sortStores reports whether the SORT arguments args have a STORE option,
+// skipping the arguments of the other options.
+func sortStores(args []any) bool {
+	for i := 1; i < len(args); i += 1 {
+		opt, err := parseStr(args[i])
+		if err != nil {
+			return false
+		}
+		switch strings.ToLower(opt) {
+		case "store":
+			return true
+		case "limit":
+			i += 2
+		case "by", "get":
+			i += 1
+		}
 	}
+	return false
+}
 
-	if list.Len() == 0 {
-		return false
-	}
+// sortItem is an element being sorted along with the weight it is sorted by.
+type sortItem struct {
+	value string
+	// by is the value BY resolved to, nil when the key is missing
+	by    any
+	score float64
+}
 
-	defer cmd.SetDone()
-	pdata, _ := list.LeftPop()
-	cmd.WriteAny(resp.Array{Size: 2, Items: []any{key, pdata}})
-	return true
-}
-
-func (h *handlers) handleIncr(cmd *gedis_types.Command) error {
+// sort implements SORT and, when readOnly is set, SORT_RO which refuses
+// STORE.
+func (h *handlers) sort(cmd *gedis_types.Command, readOnly bool) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
 	args := cmd.Cmd.Args
-	if len(args) != 1 {
-		return fmt.Errorf("%w: requires exactly 1 argument", ErrInvalidArguments)
-	}
-
-	if err := h.checkSlaveWrite(cmd); err != nil {
-		return err
+	if len(args) < 1 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
 	}
 
 	key, err := parseBulkStr(args[0])
 	if err != nil {
 		return err
 	}
-	defer cmd.SetDone()
 
+	var (
+		by       string
+		dontSort bool
+		gets     []string
+		desc     bool
+		alpha    bool
+		store    string
+		hasStore bool
+		offset   = 0
+		count    = -1
+	)
+	for i := 1; i < len(args); i += 1 {
+		opt, err := parseStr(args[i])
+		if err != nil {
+			return err
+		}
+		remaining := len(args) - i - 1
+		switch strings.ToLower(opt) {
+		case "asc":
+			desc = false
+		case "desc":
+			desc = true
+		case "alpha":
+			alpha = true
+		case "limit":
+			if remaining < 2 {
+				return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+			}
+			if offset, err = parseInt(args[i+1]); err != nil {
+				return err
+			}
+			if count, err = parseInt(args[i+2]); err != nil {
+				return err
+			}
+			i += 2
+		case "by":
+			if remaining < 1 {
+				return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+			}
+			if by, err = parseBulkStr(args[i+1]); err != nil {
+				return err
+			}
+			// a pattern without '*' resolves to the same key for every
+			// element, nothing to sort by
+			dontSort = !strings.Contains(by, "*")
+			i += 1
+		case "get":
+			if remaining < 1 {
+				return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+			}
+			pattern, err := parseBulkStr(args[i+1])
+			if err != nil {
+				return err
+			}
+			gets = append(gets, pattern)
+			i += 1
+		case "store":
+			if remaining < 1 || readOnly {
+				return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+			}
+			if store, err = parseBulkStr(args[i+1]); err != nil {
+				return err
+			}
+			hasStore = true
+			i += 1
+		default:
+			return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+		}
+	}
+
+	if hasStore {
+		if err := h.checkSlaveWrite(cmd); err != nil {
+			return err
+		}
+	}
+
+	defer cmd.SetDone()
 	if h.checkInTx(cmd) {
 		return nil
 	}
 
-	val, ok := h.db.hm.Get(key)
-	if !ok {
-		h.db.HashMap().Set(key, resp.BulkStr{Size: 1, Value: "1"}, 0)
-		if h.shouldWriteOutput(cmd) {
-			cmd.WriteAny(1)
+	var values []string
+	if list, ok := h.db.GetList(key); ok {
+		for _, item := range list.LeftRange(0, -1) {
+			str, _ := parseStr(item)
+			values = append(values, str)
+		}
+	} else if set, ok := h.db.GetSet(key); ok {
+		values = set.Members()
+		if dontSort && hasStore {
+			// sets have no order, sort them so that the stored list is the
+			// same on replicas
+			dontSort, alpha = false, true
+		}
+	} else if ss, ok := h.db.GetSortedSet(key); ok {
+		for _, node := range ss.Range(0, ss.Len()) {
+			values = append(values, node.Value)
+		}
+		if dontSort && desc {
+			// unsorted, a sorted set still follows its own order, reversed
+			// by DESC
+			slices.Reverse(values)
 		}
-		return nil
 	}
-	switch val.(type) {
-	case resp.BulkStr, string:
-		num, err := parseInt(val)
-		if err != nil {
-			return fmt.Errorf("value is not an integer or out of range")
+
+	items := make([]sortItem, len(values))
+	for i, value := range values {
+		items[i].value = value
+		if dontSort {
+			continue
 		}
-		num += 1
-		numStr := fmt.Sprintf("%d", num)
-		h.db.HashMap().Set(key, resp.BulkStr{Size: len(numStr), Value: numStr}, 0)
-		if h.shouldWriteOutput(cmd) {
-			cmd.WriteAny(num)
+		weight := any([]byte(value))
+		if by != "" {
+			weight = h.lookupPattern(by, value)
 		}
-	default:
-		return fmt.Errorf("value is not an integer or out of range")
+		items[i].by = weight
+		if alpha || weight == nil {
+			continue
+		}
+		str, _ := parseStr(weight)
+		score, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+		if err != nil || math.IsNaN(score) {
+			return fmt.Errorf("One or more scores can't be converted into double")
+		}
+		items[i].score = score
 	}
 
-	return nil
-}
+	if !dontSort {
+		slices.SortStableFunc(items, func(a, b sortItem) int {
+			c := compareSortItems(a, b, alpha)
+			if desc {
+				return -c
+			}
+			return c
+		})
+	}
 
-func (h *handlers) handleIncrBy(cmd *gedis_types.Command) error {
-	if cmd.IsSubMode() {
-		return h.subModeErr(cmd)
+	start, end := offset, len(items)
+	if start < 0 {
+		start = 0
 	}
-	args := cmd.Cmd.Args
-	if len(args) != 2 {
-		return fmt.Errorf("%w: requires exactly 2 arguments", ErrInvalidArguments)
+	if start > len(items) {
+		start = len(items)
 	}
-
-	if err := h.checkSlaveWrite(cmd); err != nil {
-		return err
+	if count >= 0 && start+count < end {
+		end = start + count
 	}
+	items = items[start:end]
 
-	key, err := parseBulkStr(args[0])
-	if err != nil {
-		return err
+	out := make([]any, 0, len(items)*max(len(gets), 1))
+	for _, item := range items {
+		if len(gets) == 0 {
+			out = append(out, []byte(item.value))
+			continue
+		}
+		for _, pattern := range gets {
+			val := h.lookupPattern(pattern, item.value)
+			if val == nil {
+				val = resp.BulkStr{Size: -1}
+			}
+			out = append(out, val)
+		}
 	}
 
-	increment, err := parseInt(args[1])
-	if err != nil {
-		return fmt.Errorf("increment value is not an integer")
+	if !hasStore {
+		cmd.WriteAny(resp.Array{Size: len(out), Items: out})
+		return nil
 	}
 
-	defer cmd.SetDone()
+	h.db.Delete(store)
+	if len(out) > 0 {
+		list := h.db.GetOrCreateList(store)
+		for _, val := range out {
+			b, ok := val.([]byte)
+			if !ok {
+				// missing GET keys are stored as empty strings
+				b = []byte{}
+			}
+			list.RightPush(b)
+		}
+		h.db.notify(notifyList, "sortstore", store)
+	} else {
+		h.db.notify(notifyGeneric, "del", store)
+	}
 
-	if h.checkInTx(cmd) {
-		return nil
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(len(out))
 	}
+	return nil
+}
 
-	val, ok := h.db.hm.Get(key)
-	if !ok {
-		numStr := fmt.Sprintf("%d", increment)
-		h.db.HashMap().Set(key, resp.BulkStr{Size: len(numStr), Value: numStr}, 0)
-		if h.shouldWriteOutput(cmd) {
-			cmd.WriteAny(increment)
+// compareSortItems orders items by score, or by their BY value when alpha is
+// set, falling back to the elements themselves on ties.
+func compareSortItems(a, b sortItem, alpha bool) int {
+	if alpha {
+		switch {
+		case a.by == nil && b.by == nil:
+		case a.by == nil:
+			return -1
+		case b.by == nil:
+			return 1
+		default:
+			as, _ := parseStr(a.by)
+			bs, _ := parseStr(b.by)
+			if c := strings.Compare(as, bs); c != 0 {
+				return c
+			}
 		}
-		return nil
+	} else if c := cmp.Compare(a.score, b.score); c != 0 {
+		return c
 	}
+	return strings.Compare(a.value, b.value)
+}
 
-	switch val.(type) {
-	case resp.BulkStr, string:
-		num, err := parseInt(val)
-		if err != nil {
-			return fmt.Errorf("value is not an integer or out of range")
-		}
-		num += increment
-		numStr := fmt.Sprintf("%d", num)
-		h.db.HashMap().Set(key, resp.BulkStr{Size: len(numStr), Value: numStr}, 0)
-		if h.shouldWriteOutput(cmd) {
-			cmd.WriteAny(num)
-		}
-	default:
-		return fmt.Errorf("value is not an integer or out of range")
+// lookupPattern resolves a SORT BY or GET pattern for element: the first
+// '*' is replaced by the element, and a "->field" suffix reads a field of a
+// hash instead of a string key. "#" is the element itself. It returns nil
+// when the key or field does not exist.
+func (h *handlers) lookupPattern(pattern string, element string) any {
+	if pattern == "#" {
+		return []byte(element)
 	}
+	star := strings.IndexByte(pattern, '*')
+	if star < 0 {
+		return nil
+	}
+	rest, field := pattern[star+1:], ""
+	if arrow := strings.Index(rest, "->"); arrow >= 0 && arrow+2 < len(rest) {
+		rest, field = rest[:arrow], rest[arrow+2:]
+	}
+	key := pattern[:star] + element + rest
 
-	return nil
+	var (
+		val    any
+		exists bool
+	)
+	if field != "" {
+		val, exists = h.db.GetHashField(key, field)
+	} else {
+		val, exists = h.db.GetString(key)
+	}
+	if !exists {
+		return nil
+	}
+	return val
 }
 
-func (h *handlers) handleDecrBy(cmd *gedis_types.Command) error {
+func (h *handlers) handleSet(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
 	args := cmd.Cmd.Args
-	if len(args) != 2 {
-		return fmt.Errorf("%w: requires exactly 2 arguments", ErrInvalidArguments)
+	if len(args) < 2 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
 	}
 
 	if err := h.checkSlaveWrite(cmd); err != nil {
@@ -1069,132 +1399,126 @@ func (h *handlers) handleDecrBy(cmd *gedis_types.Command) error {
 	if err != nil {
 		return err
 	}
-
-	decrement, err := parseInt(args[1])
+	value, err := parseBytes(args[1])
 	if err != nil {
-		return fmt.Errorf("decrement value is not an integer")
+		return err
+	}
+	opts, err := parseSetOptions(args[2:], cmd.Cmd.Cmd)
+	if err != nil {
+		return err
 	}
-
 	defer cmd.SetDone()
-
 	if h.checkInTx(cmd) {
 		return nil
 	}
 
-	val, ok := h.db.hm.Get(key)
-	if !ok {
-		numStr := fmt.Sprintf("%d", -decrement)
-		h.db.HashMap().Set(key, resp.BulkStr{Size: len(numStr), Value: numStr}, 0)
-		if h.shouldWriteOutput(cmd) {
-			cmd.WriteAny(-decrement)
+	if opts.get {
+		if _, err := h.getString(key); err != nil {
+			return err
 		}
+	}
+	old, hadOld, stored := h.set(key, value, opts)
+	if !h.shouldWriteOutput(cmd) {
 		return nil
 	}
-
-	switch val.(type) {
-	case resp.BulkStr, string:
-		num, err := parseInt(val)
-		if err != nil {
-			return fmt.Errorf("value is not an integer or out of range")
-		}
-		num -= decrement
-		numStr := fmt.Sprintf("%d", num)
-		h.db.HashMap().Set(key, resp.BulkStr{Size: len(numStr), Value: numStr}, 0)
-		if h.shouldWriteOutput(cmd) {
-			cmd.WriteAny(num)
-		}
+	switch {
+	case opts.get && hadOld:
+		cmd.WriteAny(old)
+	case opts.get, !stored:
+		cmd.WriteAny(resp.BulkStr{Size: -1})
 	default:
-		return fmt.Errorf("value is not an integer or out of range")
+		cmd.WriteAny("OK")
 	}
-
 	return nil
 }
 
-func (h *handlers) handleAppend(cmd *gedis_types.Command) error {
-	if cmd.IsSubMode() {
-		return h.subModeErr(cmd)
-	}
-	args := cmd.Cmd.Args
-	if len(args) != 2 {
-		return fmt.Errorf("%w: requires exactly 2 arguments", ErrInvalidArguments)
-	}
+var errWrongType = resp.NewCodeErr("WRONGTYPE", "Operation against a key holding the wrong kind of value")
 
-	if err := h.checkSlaveWrite(cmd); err != nil {
-		return err
+// getString returns the string at key, nil when there is none, failing when
+// the key holds a value of another type.
+func (h *handlers) getString(key string) ([]byte, error) {
+	if value, exists := h.db.GetString(key); exists {
+		return value, nil
 	}
-
-	key, err := parseBulkStr(args[0])
-	if err != nil {
-		return err
+	if h.db.Exists(key) {
+		return nil, errWrongType
 	}
+	return nil, nil
+}
 
-	appendValue, err := parseBulkStr(args[1])
-	if err != nil {
-		return err
+// set stores value at key following the options of SET, replacing a value
+// of any type. It returns the string key held before, if any, and whether
+// value was stored, which NX and XX may prevent.
+func (h *handlers) set(key string, value []byte, opts setOptions) (old []byte, hadOld bool, stored bool) {
+	old, hadOld = h.db.GetString(key)
+	exists := h.db.Exists(key)
+	if (opts.nx && exists) || (opts.xx && !exists) {
+		return old, hadOld, false
 	}
-
-	defer cmd.SetDone()
-
-	if h.checkInTx(cmd) {
-		return nil
+	if exists && !hadOld {
+		h.db.Delete(key)
 	}
 
-	val, ok := h.db.hm.Get(key)
-	var newValue string
-	if !ok {
-		newValue = appendValue
+	if opts.keepTTL {
+		h.db.SetString(key, value, 0)
 	} else {
-		existingStr, err := parseStr(val)
-		if err != nil {
-			return fmt.Errorf("value is not a string")
-		}
-		newValue = existingStr + appendValue
+		h.db.SetStringExpireAt(key, value, opts.expiresAt)
 	}
-
-	h.db.HashMap().Set(key, resp.BulkStr{Size: len(newValue), Value: newValue}, 0)
-	if h.shouldWriteOutput(cmd) {
-		cmd.WriteAny(len(newValue))
+	h.db.notify(notifyString, "set", key)
+	if !opts.expiresAt.IsZero() {
+		h.db.notify(notifyGeneric, "expire", key)
 	}
-
-	return nil
+	return old, hadOld, true
 }
 
-func (h *handlers) handleStrLen(cmd *gedis_types.Command) error {
+func (h *handlers) handleSetNx(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
 	args := cmd.Cmd.Args
-	if len(args) != 1 {
-		return fmt.Errorf("%w: requires exactly 1 argument", ErrInvalidArguments)
+	if len(args) != 2 {
+		return fmt.Errorf("%w: requires exactly 2 arguments", ErrInvalidArguments)
 	}
 
-	key, err := parseBulkStr(args[0])
-	if err != nil {
+	if err := h.checkSlaveWrite(cmd); err != nil {
 		return err
 	}
 
 	defer cmd.SetDone()
-
 	if h.checkInTx(cmd) {
 		return nil
 	}
 
-	val, ok := h.db.hm.Get(key)
-	if !ok {
-		cmd.WriteAny(0)
-		return nil
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
 	}
-
-	str, err := parseStr(val)
+	value, err := parseBytes(args[1])
 	if err != nil {
-		return fmt.Errorf("value is not a string")
+		return err
 	}
 
-	cmd.WriteAny(len(str))
+	_, _, stored := h.set(key, value, setOptions{nx: true})
+	if h.shouldWriteOutput(cmd) {
+		if stored {
+			cmd.WriteAny(1)
+		} else {
+			cmd.WriteAny(0)
+		}
+	}
 	return nil
 }
 
-func (h *handlers) handleGetRange(cmd *gedis_types.Command) error {
+func (h *handlers) handleSetEx(cmd *gedis_types.Command) error {
+	return h.setex(cmd, "ex")
+}
+
+func (h *handlers) handlePSetEx(cmd *gedis_types.Command) error {
+	return h.setex(cmd, "px")
+}
+
+// setex implements SETEX and PSETEX, whose TTL comes before the value.
+func (h *handlers) setex(cmd *gedis_types.Command, unit string) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
@@ -1203,216 +1527,227 @@ func (h *handlers) handleGetRange(cmd *gedis_types.Command) error {
 		return fmt.Errorf("%w: requires exactly 3 arguments", ErrInvalidArguments)
 	}
 
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
 	key, err := parseBulkStr(args[0])
 	if err != nil {
 		return err
 	}
-
-	start, err := parseInt(args[1])
+	expiresAt, err := parseExpireAt(unit, args[1], cmd.Cmd.Cmd)
 	if err != nil {
 		return err
 	}
-
-	end, err := parseInt(args[2])
+	value, err := parseBytes(args[2])
 	if err != nil {
 		return err
 	}
 
 	defer cmd.SetDone()
-
 	if h.checkInTx(cmd) {
 		return nil
 	}
 
-	val, ok := h.db.hm.Get(key)
-	if !ok {
-		cmd.WriteAny(resp.BulkStr{Size: 0, Value: ""})
-		return nil
+	h.set(key, value, setOptions{expiresAt: expiresAt})
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny("OK")
 	}
+	return nil
+}
 
-	str, err := parseStr(val)
-	if err != nil {
-		return fmt.Errorf("value is not a string")
+func (h *handlers) handleGetSet(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) != 2 {
+		return fmt.Errorf("%w: requires exactly 2 arguments", ErrInvalidArguments)
 	}
 
-	strLen := len(str)
-	if start < 0 {
-		start = strLen + start
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
 	}
-	if end < 0 {
-		end = strLen + end
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
 	}
 
-	if start < 0 {
-		start = 0
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
 	}
-	if end >= strLen {
-		end = strLen - 1
+	value, err := parseBytes(args[1])
+	if err != nil {
+		return err
 	}
-
-	if start > end || start >= strLen {
-		cmd.WriteAny(resp.BulkStr{Size: 0, Value: ""})
-		return nil
+	if _, err := h.getString(key); err != nil {
+		return err
 	}
 
-	substr := str[start : end+1]
-	cmd.WriteAny(resp.BulkStr{Size: len(substr), Value: substr})
+	old, hadOld, _ := h.set(key, value, setOptions{})
+	if h.shouldWriteOutput(cmd) {
+		if hadOld {
+			cmd.WriteAny(old)
+		} else {
+			cmd.WriteAny(resp.BulkStr{Size: -1})
+		}
+	}
 	return nil
 }
 
-func (h *handlers) handleSetRange(cmd *gedis_types.Command) error {
+func (h *handlers) handleGetDel(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
 	args := cmd.Cmd.Args
-	if len(args) != 3 {
-		return fmt.Errorf("%w: requires exactly 3 arguments", ErrInvalidArguments)
+	if len(args) != 1 {
+		return fmt.Errorf("%w: requires exactly 1 argument", ErrInvalidArguments)
 	}
 
 	if err := h.checkSlaveWrite(cmd); err != nil {
 		return err
 	}
 
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
 	key, err := parseBulkStr(args[0])
 	if err != nil {
 		return err
 	}
 
-	offset, err := parseInt(args[1])
-	if err != nil {
-		return err
-	}
-
-	if offset < 0 {
-		return fmt.Errorf("offset is out of range")
-	}
-
-	replacement, err := parseBulkStr(args[2])
-	if err != nil {
-		return err
-	}
-
-	defer cmd.SetDone()
-
-	if h.checkInTx(cmd) {
-		return nil
-	}
-
-	val, ok := h.db.hm.Get(key)
-	var str string
-	if !ok {
-		str = ""
-	} else {
-		str, err = parseStr(val)
-		if err != nil {
-			return fmt.Errorf("value is not a string")
+	value, exists := h.db.GetString(key)
+	if !exists {
+		if h.shouldWriteOutput(cmd) {
+			cmd.WriteAny(resp.BulkStr{Size: -1})
 		}
+		return nil
 	}
-
-	// Extend string with null bytes if needed
-	if offset > len(str) {
-		str += strings.Repeat("\x00", offset-len(str))
-	}
-
-	// Replace characters
-	if offset+len(replacement) > len(str) {
-		str = str[:offset] + replacement
-	} else {
-		str = str[:offset] + replacement + str[offset+len(replacement):]
-	}
-
-	h.db.HashMap().Set(key, resp.BulkStr{Size: len(str), Value: str}, 0)
+	h.db.DeleteString(key)
+	h.db.notify(notifyGeneric, "del", key)
 	if h.shouldWriteOutput(cmd) {
-		cmd.WriteAny(len(str))
+		cmd.WriteAny(value)
 	}
-
 	return nil
 }
 
-func (h *handlers) handleHSet(cmd *gedis_types.Command) error {
+// handleGetEx replies with the value of a string and optionally sets (EX,
+// PX, EXAT, PXAT) or removes (PERSIST) its TTL.
+func (h *handlers) handleGetEx(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
 	args := cmd.Cmd.Args
-	if len(args) < 3 || len(args)%2 == 0 {
-		return fmt.Errorf("%w: wrong number of arguments", ErrInvalidArguments)
+	if len(args) < 1 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
 	}
 
 	if err := h.checkSlaveWrite(cmd); err != nil {
 		return err
 	}
 
-	defer cmd.SetDone()
-	if h.checkInTx(cmd) {
-		return nil
-	}
-
 	key, err := parseBulkStr(args[0])
 	if err != nil {
 		return err
 	}
 
-	added := 0
-	for i := 1; i < len(args); i += 2 {
-		field, err := parseBulkStr(args[i])
+	var (
+		expiresAt   time.Time
+		persist     bool
+		syntaxError = fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+	)
+	for i := 1; i < len(args); i += 1 {
+		opt, err := parseStr(args[i])
 		if err != nil {
 			return err
 		}
-		value := args[i+1]
-		compositeKey := fmt.Sprintf("%s:%s", key, field)
-		if !h.db.HashMap().Set(compositeKey, value, -1) {
-			added++
+		if persist || !expiresAt.IsZero() {
+			return syntaxError
+		}
+		switch unit := strings.ToLower(opt); unit {
+		case "ex", "px", "exat", "pxat":
+			if i+1 >= len(args) {
+				return syntaxError
+			}
+			if expiresAt, err = parseExpireAt(unit, args[i+1], cmd.Cmd.Cmd); err != nil {
+				return err
+			}
+			i += 1
+		case "persist":
+			persist = true
+		default:
+			return syntaxError
 		}
 	}
 
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	value, exists := h.db.GetString(key)
+	if !exists {
+		if h.shouldWriteOutput(cmd) {
+			cmd.WriteAny(resp.BulkStr{Size: -1})
+		}
+		return nil
+	}
+	switch {
+	case persist:
+		if _, volatile := h.db.HashMap().ExpiresAt(key); volatile {
+			h.db.ExpireStringAt(key, time.Time{})
+			h.db.notify(notifyGeneric, "persist", key)
+		}
+	case !expiresAt.IsZero():
+		h.db.ExpireStringAt(key, expiresAt)
+		h.db.notify(notifyGeneric, "expire", key)
+	}
 	if h.shouldWriteOutput(cmd) {
-		cmd.WriteAny(added)
+		cmd.WriteAny(value)
 	}
 	return nil
 }
 
-func (h *handlers) handleHGet(cmd *gedis_types.Command) error {
+func (h *handlers) handleGet(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
 	args := cmd.Cmd.Args
-	if len(args) < 2 {
+	if len(args) < 1 {
 		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
 	}
-
 	defer cmd.SetDone()
 	if h.checkInTx(cmd) {
 		return nil
 	}
-
 	key, err := parseBulkStr(args[0])
 	if err != nil {
 		return err
 	}
-
-	field, err := parseBulkStr(args[1])
-	if err != nil {
-		return err
-	}
-
-	compositeKey := fmt.Sprintf("%s:%s", key, field)
-	value, ok := h.db.HashMap().Get(compositeKey)
+	value, ok := h.db.GetString(key)
 	if !ok {
 		cmd.WriteAny(resp.BulkStr{Size: -1})
 		return nil
 	}
-
 	cmd.WriteAny(value)
 	return nil
 }
 
-func (h *handlers) handleHMGet(cmd *gedis_types.Command) error {
+func (h *handlers) handleMSet(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
 	args := cmd.Cmd.Args
-	if len(args) < 2 {
-		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	if len(args) < 2 || len(args)%2 != 0 {
+		return fmt.Errorf("%w: wrong number of arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
 	}
 
 	defer cmd.SetDone()
@@ -1420,76 +1755,33 @@ func (h *handlers) handleHMGet(cmd *gedis_types.Command) error {
 		return nil
 	}
 
-	key, err := parseBulkStr(args[0])
-	if err != nil {
-		return err
-	}
-
-	values := make([]any, len(args)-1)
-	for i := 1; i < len(args); i++ {
-		field, err := parseBulkStr(args[i])
+	for i := 0; i < len(args); i += 2 {
+		key, err := parseBulkStr(args[i])
 		if err != nil {
 			return err
 		}
-
-		compositeKey := fmt.Sprintf("%s:%s", key, field)
-		value, ok := h.db.HashMap().Get(compositeKey)
-		if !ok {
-			values[i-1] = resp.BulkStr{Size: -1}
-		} else {
-			values[i-1] = value
+		value, err := parseBytes(args[i+1])
+		if err != nil {
+			return err
 		}
+		h.set(key, value, setOptions{})
 	}
 
-	cmd.WriteAny(resp.Array{Size: len(values), Items: values})
-	return nil
-}
-
-func (h *handlers) handleHGetAll(cmd *gedis_types.Command) error {
-	if cmd.IsSubMode() {
-		return h.subModeErr(cmd)
-	}
-	args := cmd.Cmd.Args
-	if len(args) < 1 {
-		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
-	}
-
-	defer cmd.SetDone()
-	if h.checkInTx(cmd) {
-		return nil
-	}
-
-	key, err := parseBulkStr(args[0])
-	if err != nil {
-		return err
-	}
-
-	prefix := fmt.Sprintf("^%s:", regexp.QuoteMeta(key))
-	pattern, err := regexp.Compile(prefix)
-	if err != nil {
-		return err
-	}
-
-	matchingKeys := h.db.HashMap().List(pattern)
-	items := make([]any, 0, len(matchingKeys)*2)
-
-	for _, compositeKey := range matchingKeys {
-		field := strings.TrimPrefix(compositeKey, key+":")
-		value, _ := h.db.HashMap().Get(compositeKey)
-		items = append(items, field, value)
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny("OK")
 	}
-
-	cmd.WriteAny(resp.Array{Size: len(items), Items: items})
 	return nil
 }
 
-func (h *handlers) handleHIncrBy(cmd *gedis_types.Command) error {
+// handleMSetNx sets every key only when none of them exists, replying 1 when
+// the keys were set and 0 otherwise.
+func (h *handlers) handleMSetNx(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
 	args := cmd.Cmd.Args
-	if len(args) < 3 {
-		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	if len(args) < 2 || len(args)%2 != 0 {
+		return fmt.Errorf("%w: wrong number of arguments", ErrInvalidArguments)
 	}
 
 	if err := h.checkSlaveWrite(cmd); err != nil {
@@ -1501,80 +1793,87 @@ func (h *handlers) handleHIncrBy(cmd *gedis_types.Command) error {
 		return nil
 	}
 
-	key, err := parseBulkStr(args[0])
-	if err != nil {
-		return err
-	}
-
-	field, err := parseBulkStr(args[1])
-	if err != nil {
-		return err
-	}
-
-	increment, err := parseInt(args[2])
-	if err != nil {
-		return fmt.Errorf("increment value is not an integer")
-	}
-
-	compositeKey := fmt.Sprintf("%s:%s", key, field)
-	value, ok := h.db.HashMap().Get(compositeKey)
-
-	var newValue int
-	if !ok {
-		newValue = increment
-	} else {
-		currentVal, err := parseInt(value)
+	keys := make([]string, 0, len(args)/2)
+	values := make([][]byte, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		key, err := parseBulkStr(args[i])
 		if err != nil {
-			return fmt.Errorf("hash value is not an integer")
+			return err
+		}
+		value, err := parseBytes(args[i+1])
+		if err != nil {
+			return err
+		}
+		if h.db.Exists(key) {
+			if h.shouldWriteOutput(cmd) {
+				cmd.WriteAny(0)
+			}
+			return nil
 		}
-		newValue = currentVal + increment
+		keys = append(keys, key)
+		values = append(values, value)
 	}
 
-	numStr := fmt.Sprintf("%d", newValue)
-	h.db.HashMap().Set(compositeKey, resp.BulkStr{Size: len(numStr), Value: numStr}, 0)
-
+	for i, key := range keys {
+		h.set(key, values[i], setOptions{})
+	}
 	if h.shouldWriteOutput(cmd) {
-		cmd.WriteAny(newValue)
+		cmd.WriteAny(1)
 	}
 	return nil
 }
 
-func (h *handlers) handleHExists(cmd *gedis_types.Command) error {
+func (h *handlers) handleMGet(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
 	args := cmd.Cmd.Args
-	if len(args) < 2 {
+	if len(args) < 1 {
 		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
 	}
-
 	defer cmd.SetDone()
 	if h.checkInTx(cmd) {
 		return nil
 	}
 
-	key, err := parseBulkStr(args[0])
-	if err != nil {
-		return err
+	items := make([]any, len(args))
+	for i, arg := range args {
+		key, err := parseBulkStr(arg)
+		if err != nil {
+			return err
+		}
+		value, ok := h.db.GetString(key)
+		if !ok {
+			items[i] = resp.BulkStr{Size: -1}
+		} else {
+			items[i] = value
+		}
 	}
 
-	field, err := parseBulkStr(args[1])
-	if err != nil {
-		return err
-	}
+	cmd.WriteAny(resp.Array{Size: len(items), Items: items})
+	return nil
+}
 
-	compositeKey := fmt.Sprintf("%s:%s", key, field)
-	_, exists := h.db.HashMap().Get(compositeKey)
+func (h *handlers) handleRPush(cmd *gedis_types.Command) error {
+	return h.push(cmd, false, false)
+}
 
-	if exists {
-		cmd.WriteAny(1)
-	} else {
-		cmd.WriteAny(0)
-	}
-	return nil
+func (h *handlers) handleLPush(cmd *gedis_types.Command) error {
+	return h.push(cmd, true, false)
 }
 
-func (h *handlers) handleHDel(cmd *gedis_types.Command) error {
+func (h *handlers) handleRPushX(cmd *gedis_types.Command) error {
+	return h.push(cmd, false, true)
+}
+
+func (h *handlers) handleLPushX(cmd *gedis_types.Command) error {
+	return h.push(cmd, true, true)
+}
+
+// push adds elements to the head or the tail of a list and replies with its
+// new length. With onlyExisting, as LPUSHX and RPUSHX do, nothing is pushed
+// when the list does not exist.
+func (h *handlers) push(cmd *gedis_types.Command, left bool, onlyExisting bool) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
@@ -1596,27 +1895,40 @@ func (h *handlers) handleHDel(cmd *gedis_types.Command) error {
 	if err != nil {
 		return err
 	}
-
-	deleted := 0
-	for i := 1; i < len(args); i++ {
-		field, err := parseBulkStr(args[i])
-		if err != nil {
-			return err
+	values, err := parseValues(args[1:])
+	if err != nil {
+		return err
+	}
+	if _, exists := h.db.GetList(key); onlyExisting && !exists {
+		if h.shouldWriteOutput(cmd) {
+			cmd.WriteAny(0)
 		}
+		return nil
+	}
 
-		compositeKey := fmt.Sprintf("%s:%s", key, field)
-		if _, ok := h.db.HashMap().Delete(compositeKey); ok {
-			deleted++
-		}
+	list := h.db.GetOrCreateList(key)
+	for _, value := range values {
+		pushList(list, left, value)
 	}
+	h.db.notify(notifyList, listEvent("push", left), key)
 
 	if h.shouldWriteOutput(cmd) {
-		cmd.WriteAny(deleted)
+		cmd.WriteAny(list.Len())
 	}
 	return nil
 }
 
-func (h *handlers) handleHLen(cmd *gedis_types.Command) error {
+func (h *handlers) handleLPop(cmd *gedis_types.Command) error {
+	return h.pop(cmd, true)
+}
+
+func (h *handlers) handleRPop(cmd *gedis_types.Command) error {
+	return h.pop(cmd, false)
+}
+
+// pop removes an element from the head or the tail of a list or, given a
+// count, up to count elements replied as an array.
+func (h *handlers) pop(cmd *gedis_types.Command, left bool) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
@@ -1624,597 +1936,4900 @@ func (h *handlers) handleHLen(cmd *gedis_types.Command) error {
 	if len(args) < 1 {
 		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
 	}
+	if len(args) > 2 {
+		return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+	}
 
-	defer cmd.SetDone()
-	if h.checkInTx(cmd) {
-		return nil
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
 	}
 
 	key, err := parseBulkStr(args[0])
 	if err != nil {
 		return err
 	}
+	count, hasCount := 1, len(args) == 2
+	if hasCount {
+		if count, err = parseInt(args[1]); err != nil || count < 0 {
+			return errNotPositive
+		}
+	}
 
-	prefix := fmt.Sprintf("^%s:", regexp.QuoteMeta(key))
-	pattern, err := regexp.Compile(prefix)
-	if err != nil {
-		return err
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
 	}
 
-	matchingKeys := h.db.HashMap().List(pattern)
-	cmd.WriteAny(len(matchingKeys))
-	return nil
-}
-
-func (h *handlers) handleHKeys(cmd *gedis_types.Command) error {
-	if cmd.IsSubMode() {
-		return h.subModeErr(cmd)
-	}
-	args := cmd.Cmd.Args
-	if len(args) < 1 {
-		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	list, exists := h.db.GetList(key)
+	if !exists {
+		if !h.shouldWriteOutput(cmd) {
+			return nil
+		}
+		if hasCount {
+			cmd.WriteAny(resp.Array{Size: -1})
+		} else {
+			cmd.WriteAny(resp.BulkStr{Size: -1})
+		}
+		return nil
 	}
 
-	defer cmd.SetDone()
-	if h.checkInTx(cmd) {
+	items := popList(list, left, count)
+	if len(items) > 0 {
+		h.db.notify(notifyList, listEvent("pop", left), key)
+	}
+	h.db.deleteIfEmpty(key)
+	if !h.shouldWriteOutput(cmd) {
 		return nil
 	}
+	if hasCount {
+		cmd.WriteAny(bytesArray(items))
+	} else {
+		cmd.WriteAny(items[0])
+	}
+	return nil
+}
 
-	key, err := parseBulkStr(args[0])
-	if err != nil {
-		return err
+var errNotPositive = fmt.Errorf("value is out of range, must be positive")
+
+// listEvent names the keyspace event of a push or a pop at the head or the
+// tail of a list, like lpush or rpop.
+func listEvent(op string, left bool) string {
+	if left {
+		return "l" + op
 	}
+	return "r" + op
+}
 
-	prefix := fmt.Sprintf("^%s:", regexp.QuoteMeta(key))
-	pattern, err := regexp.Compile(prefix)
-	if err != nil {
-		return err
+func pushList(list *data.LinkedList, left bool, value []byte) {
+	if left {
+		list.LeftPush(value)
+	} else {
+		list.RightPush(value)
 	}
+}
 
-	matchingKeys := h.db.HashMap().List(pattern)
-	keys := make([]any, len(matchingKeys))
+// popList pops up to count elements from the head or the tail of list.
+func popList(list *data.LinkedList, left bool, count int) [][]byte {
+	items := make([][]byte, 0, min(count, list.Len()))
+	for len(items) < count {
+		var (
+			value []byte
+			ok    bool
+		)
+		if left {
+			value, ok = list.LeftPop()
+		} else {
+			value, ok = list.RightPop()
+		}
+		if !ok {
+			break
+		}
+		items = append(items, value)
+	}
+	return items
+}
 
-	for i, compositeKey := range matchingKeys {
-		field := strings.TrimPrefix(compositeKey, key+":")
-		keys[i] = field
+// parseListEnd parses the LEFT or RIGHT argument of LMOVE and LMPOP and
+// reports whether it is LEFT.
+func parseListEnd(arg any) (bool, error) {
+	end, err := parseStr(arg)
+	if err != nil {
+		return false, err
 	}
+	switch strings.ToLower(end) {
+	case "left":
+		return true, nil
+	case "right":
+		return false, nil
+	}
+	return false, fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+}
 
-	cmd.WriteAny(resp.Array{Size: len(keys), Items: keys})
-	return nil
+// listEndName is the LEFT or RIGHT argument naming an end of a list.
+func listEndName(left bool) string {
+	if left {
+		return "LEFT"
+	}
+	return "RIGHT"
 }
 
-func (h *handlers) handleHVals(cmd *gedis_types.Command) error {
+func (h *handlers) handleLInsert(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
 	args := cmd.Cmd.Args
-	if len(args) < 1 {
-		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	if len(args) != 4 {
+		return fmt.Errorf("%w: requires exactly 4 arguments", ErrInvalidArguments)
 	}
 
-	defer cmd.SetDone()
-	if h.checkInTx(cmd) {
-		return nil
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
 	}
 
 	key, err := parseBulkStr(args[0])
 	if err != nil {
 		return err
 	}
-
-	prefix := fmt.Sprintf("^%s:", regexp.QuoteMeta(key))
-	pattern, err := regexp.Compile(prefix)
+	where, err := parseStr(args[1])
+	if err != nil {
+		return err
+	}
+	var before bool
+	switch strings.ToLower(where) {
+	case "before":
+		before = true
+	case "after":
+	default:
+		return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+	}
+	pivot, err := parseBytes(args[2])
+	if err != nil {
+		return err
+	}
+	value, err := parseBytes(args[3])
 	if err != nil {
 		return err
 	}
 
-	matchingKeys := h.db.HashMap().List(pattern)
-	values := make([]any, len(matchingKeys))
-
-	for i, compositeKey := range matchingKeys {
-		value, _ := h.db.HashMap().Get(compositeKey)
-		values[i] = value
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
 	}
 
-	cmd.WriteAny(resp.Array{Size: len(values), Items: values})
+	list, exists := h.db.GetList(key)
+	if !exists {
+		if h.shouldWriteOutput(cmd) {
+			cmd.WriteAny(0)
+		}
+		return nil
+	}
+	n := list.Insert(pivot, value, before)
+	if n > 0 {
+		h.db.notify(notifyList, "linsert", key)
+	}
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(n)
+	}
 	return nil
 }
 
-func (h *handlers) handleMulti(cmd *gedis_types.Command) error {
+// handleLRem removes the elements equal to a value, from the head when the
+// count is positive, from the tail when it is negative, all of them when it
+// is zero.
+func (h *handlers) handleLRem(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
+	args := cmd.Cmd.Args
+	if len(args) != 3 {
+		return fmt.Errorf("%w: requires exactly 3 arguments", ErrInvalidArguments)
+	}
 
 	if err := h.checkSlaveWrite(cmd); err != nil {
 		return err
 	}
 
-	state := cmd.ConnState
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+	count, err := parseInt(args[1])
+	if err != nil {
+		return err
+	}
+	value, err := parseBytes(args[2])
+	if err != nil {
+		return err
+	}
 
 	defer cmd.SetDone()
-	if state.InTransaction {
-		return fmt.Errorf("MULTI calls cannot be nested")
+	if h.checkInTx(cmd) {
+		return nil
 	}
 
-	state.InTransaction = true
+	removed := 0
+	if list, exists := h.db.GetList(key); exists {
+		removed = list.Remove(value, count)
+	}
+	if removed > 0 {
+		h.db.notify(notifyList, "lrem", key)
+		h.db.deleteIfEmpty(key)
+	}
 	if h.shouldWriteOutput(cmd) {
-		cmd.WriteAny("OK")
+		cmd.WriteAny(removed)
 	}
 	return nil
 }
 
-func (h *handlers) handleExec(cmd *gedis_types.Command) error {
+// handleLPos returns the index of an element of a list. RANK picks which
+// match to start from, negative ranks searching from the tail, COUNT asks for
+// several indexes and MAXLEN bounds the number of elements compared.
+func (h *handlers) handleLPos(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
+	args := cmd.Cmd.Args
+	if len(args) < 2 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
 
-	if err := h.checkSlaveWrite(cmd); err != nil {
+	key, err := parseBulkStr(args[0])
+	if err != nil {
 		return err
 	}
-
-	state := cmd.ConnState
-
-	defer cmd.SetDone()
-	if !state.InTransaction {
-		return fmt.Errorf("EXEC without MULTI")
+	value, err := parseBytes(args[1])
+	if err != nil {
+		return err
 	}
-	defer func() {
-		state.Tx = make([]*gedis_types.Command, 0)
-	}()
-
-	bufs := make([]any, 0, len(state.Tx))
-	state.InTransaction = false
-
-	for _, op := range state.Tx {
-		hdl, _, err := h.route(op)
+	rank, count, maxLen, hasCount := 1, 0, 0, false
+	for i := 2; i < len(args); i += 2 {
+		opt, err := parseStr(args[i])
 		if err != nil {
 			return err
 		}
-		err = hdl(op)
+		if i+1 >= len(args) {
+			return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+		}
+		n, err := parseInt(args[i+1])
 		if err != nil {
-			cmd.SetDone()
-			bufs = append(bufs, err)
-		} else {
-			bufs = append(bufs, op.Output())
+			return err
+		}
+		switch strings.ToLower(opt) {
+		case "rank":
+			if n == 0 {
+				return fmt.Errorf("RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+			}
+			rank = n
+		case "count":
+			if n < 0 {
+				return fmt.Errorf("COUNT can't be negative")
+			}
+			count, hasCount = n, true
+		case "maxlen":
+			if n < 0 {
+				return fmt.Errorf("MAXLEN can't be negative")
+			}
+			maxLen = n
+		default:
+			return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
 		}
 	}
 
-	if h.shouldWriteOutput(cmd) {
-		arr := resp.Array{Size: len(bufs), Items: bufs}
-		cmd.WriteAny(arr)
-	}
-	return nil
-}
-
-func (h *handlers) checkInTx(cmd *gedis_types.Command) bool {
-	state := cmd.ConnState
-	if state.InTransaction {
-		state.Tx = append(state.Tx, cmd.Copy())
-		cmd.WriteAny("QUEUED")
-		return true
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
 	}
-	return false
-}
 
-func (h *handlers) shouldWriteOutput(cmd *gedis_types.Command) bool {
-
-	if h.isSlave {
-		return !cmd.IsRepl()
+	var positions []int
+	if list, exists := h.db.GetList(key); exists {
+		limit := count
+		if !hasCount {
+			limit = 1
+		}
+		positions = list.Positions(value, rank, limit, maxLen)
 	}
-
-	return true
-}
-
-func (h *handlers) checkSlaveWrite(cmd *gedis_types.Command) error {
-	if h.isSlave && !cmd.IsRepl() {
-		return fmt.Errorf("READONLY You can't write against a read only replica")
+	if hasCount {
+		items := make([]any, len(positions))
+		for i, pos := range positions {
+			items[i] = pos
+		}
+		cmd.WriteAny(resp.Array{Size: len(items), Items: items})
+	} else if len(positions) == 0 {
+		cmd.WriteAny(resp.BulkStr{Size: -1})
+	} else {
+		cmd.WriteAny(positions[0])
 	}
 	return nil
 }
 
-func (h *handlers) handleDiscard(cmd *gedis_types.Command) error {
+func (h *handlers) handleLMove(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
+	args := cmd.Cmd.Args
+	if len(args) != 4 {
+		return fmt.Errorf("%w: requires exactly 4 arguments", ErrInvalidArguments)
+	}
 
 	if err := h.checkSlaveWrite(cmd); err != nil {
 		return err
 	}
 
-	defer cmd.SetDone()
-
-	state := cmd.ConnState
-
-	if !state.InTransaction {
-		return fmt.Errorf("DISCARD without MULTI")
+	src, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
 	}
-
-	if h.shouldWriteOutput(cmd) {
-		cmd.WriteAny("OK")
+	dst, err := parseBulkStr(args[1])
+	if err != nil {
+		return err
 	}
-
-	state.InTransaction = false
-	state.Tx = make([]*gedis_types.Command, 0)
-	return nil
-}
-
-func (h *handlers) handleInfo(cmd *gedis_types.Command) error {
-	if cmd.IsSubMode() {
-		return h.subModeErr(cmd)
+	fromLeft, err := parseListEnd(args[2])
+	if err != nil {
+		return err
+	}
+	toLeft, err := parseListEnd(args[3])
+	if err != nil {
+		return err
 	}
+
 	defer cmd.SetDone()
 	if h.checkInTx(cmd) {
-		return fmt.Errorf("INFO not available during transaction")
-	}
-	args := cmd.Cmd.Args
-	if len(args) > 0 {
-		section, err := parseBulkStr(args[0])
-		if err != nil {
-			return err
-		}
-		fields := h.info.Fields()
-		for _, field := range fields {
-			if strings.EqualFold(section, field.Name) {
-				str := fmt.Sprintf("# %s\n%v\n", field.Name, field.Value)
-				bs := resp.BulkStr{Size: len(str), Value: str}
-				cmd.WriteAny(bs)
-				return nil
-			}
-		}
-		return fmt.Errorf("unknown section '%s'", section)
+		return nil
 	}
 
-	str := h.info.String()
-	bs := resp.BulkStr{Size: len(str), Value: str}
-	cmd.WriteAny(bs)
+	h.writeMoved(cmd, h.lmove(src, dst, fromLeft, toLeft))
 	return nil
 }
 
-func (h *handlers) handleReplConf(cmd *gedis_types.Command) error {
+func (h *handlers) handleRPopLPush(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
-	defer cmd.SetDone()
+	args := cmd.Cmd.Args
+	if len(args) != 2 {
+		return fmt.Errorf("%w: requires exactly 2 arguments", ErrInvalidArguments)
+	}
 
-	if h.checkInTx(cmd) {
-		return nil
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
 	}
-	args := cmd.Cmd.Args
 
-	if len(args) < 2 {
-		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	src, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
 	}
-	subcmd, err := parseStr(args[0])
+	dst, err := parseBulkStr(args[1])
 	if err != nil {
 		return err
 	}
 
-	state := cmd.ConnState
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
 
-	switch strings.ToLower(subcmd) {
-	case "listening-port":
-		if h.isSlave {
-			return fmt.Errorf("%w: REPLCONF only valid on a master", ErrInvalidArguments)
-		}
+	h.writeMoved(cmd, h.lmove(src, dst, false, true))
+	return nil
+}
 
-		theirPort, err := parseInt(args[1])
+// lmove pops an element from one end of the list at src and pushes it to
+// one end of the list at dst, which may be the same list. It returns nil
+// when src does not exist.
+func (h *handlers) lmove(src, dst string, fromLeft, toLeft bool) []byte {
+	list, exists := h.db.GetList(src)
+	if !exists {
+		return nil
+	}
+	value := popList(list, fromLeft, 1)[0]
+	h.db.notify(notifyList, listEvent("pop", fromLeft), src)
+	// the source is deleted after the push as it may be the destination
+	pushList(h.db.GetOrCreateList(dst), toLeft, value)
+	h.db.notify(notifyList, listEvent("push", toLeft), dst)
+	h.db.deleteIfEmpty(src)
+	return value
+}
+
+func (h *handlers) writeMoved(cmd *gedis_types.Command, value []byte) {
+	if !h.shouldWriteOutput(cmd) {
+		return
+	}
+	if value == nil {
+		cmd.WriteAny(resp.BulkStr{Size: -1})
+	} else {
+		cmd.WriteAny(value)
+	}
+}
+
+// handleLMPop pops up to COUNT elements, one by default, from the first
+// non empty list among the given keys.
+func (h *handlers) handleLMPop(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 3 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	keys, left, count, err := parseMPopArgs(args, parseListEnd)
+	if err != nil {
+		return err
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	for _, key := range keys {
+		list, exists := h.db.GetList(key)
+		if !exists {
+			continue
+		}
+		items := popList(list, left, count)
+		h.db.notify(notifyList, listEvent("pop", left), key)
+		h.db.deleteIfEmpty(key)
+		if h.shouldWriteOutput(cmd) {
+			cmd.WriteAny(resp.Array{Size: 2, Items: []any{
+				resp.BulkStr{Size: len(key), Value: []byte(key)},
+				bytesArray(items),
+			}})
+		}
+		return nil
+	}
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(resp.Array{Size: -1})
+	}
+	return nil
+}
+
+// parseMPopArgs parses the "numkeys key [key ...] end [COUNT count]"
+// arguments of LMPOP and ZMPOP, parseEnd telling which end the elements are
+// popped from.
+func parseMPopArgs(args []any, parseEnd func(arg any) (bool, error)) (keys []string, left bool, count int, err error) {
+	numKeys, err := parseInt(args[0])
+	if err != nil || numKeys <= 0 {
+		return nil, false, 0, fmt.Errorf("numkeys should be greater than 0")
+	}
+	if numKeys > len(args)-2 {
+		return nil, false, 0, fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+	}
+	for _, arg := range args[1 : numKeys+1] {
+		key, err := parseBulkStr(arg)
+		if err != nil {
+			return nil, false, 0, err
+		}
+		keys = append(keys, key)
+	}
+	if left, err = parseEnd(args[numKeys+1]); err != nil {
+		return nil, false, 0, err
+	}
+
+	count = 1
+	rest := args[numKeys+2:]
+	switch {
+	case len(rest) == 0:
+	case len(rest) == 2:
+		if opt, _ := parseStr(rest[0]); !strings.EqualFold(opt, "count") {
+			return nil, false, 0, fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+		}
+		if count, err = parseInt(rest[1]); err != nil || count <= 0 {
+			return nil, false, 0, fmt.Errorf("count should be greater than 0")
+		}
+	default:
+		return nil, false, 0, fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+	}
+	return keys, left, count, nil
+}
+
+// (handleQuit removed due to incomplete implementation and not part of the allowed command set.)
+
+func (h *handlers) handleLRange(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 3 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+	start, err := parseInt(args[1])
+	if err != nil {
+		return err
+	}
+	stop, err := parseInt(args[2])
+	if err != nil {
+		return err
+	}
+	list, exists := h.db.GetList(key)
+	if !exists {
+		cmd.WriteAny(resp.Array{Size: 0, Items: []any{}})
+		return nil
+	}
+	cmd.WriteAny(bytesArray(list.LeftRange(start, stop)))
+	return nil
+}
+
+func (h *handlers) handleLLen(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 1 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+	list, exists := h.db.GetList(key)
+	if !exists {
+		cmd.WriteAny(0)
+		return nil
+	}
+	cmd.WriteAny(list.Len())
+	return nil
+}
+
+func (h *handlers) handleLIndex(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 2 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+
+	index, err := parseInt(args[1])
+	if err != nil {
+		return err
+	}
+
+	list, exists := h.db.GetList(key)
+	if !exists {
+		cmd.WriteAny(resp.BulkStr{Size: -1})
+		return nil
+	}
+
+	value, ok := list.LeftIndex(index)
+	if !ok {
+		cmd.WriteAny(resp.BulkStr{Size: -1})
+		return nil
+	}
+	cmd.WriteAny(value)
+	return nil
+}
+
+func (h *handlers) handleLSet(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 3 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+
+	index, err := parseInt(args[1])
+	if err != nil {
+		return err
+	}
+
+	value, err := parseBytes(args[2])
+	if err != nil {
+		return err
+	}
+
+	list, exists := h.db.GetList(key)
+	if !exists {
+		return fmt.Errorf("no such key")
+	}
+
+	ok := list.LeftSet(index, value)
+	if !ok {
+		return fmt.Errorf("index out of range")
+	}
+
+	h.db.notify(notifyList, "lset", key)
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny("OK")
+	}
+	return nil
+}
+
+func (h *handlers) handleLTrim(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 3 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+
+	start, err := parseInt(args[1])
+	if err != nil {
+		return err
+	}
+
+	stop, err := parseInt(args[2])
+	if err != nil {
+		return err
+	}
+
+	list, exists := h.db.GetList(key)
+	if !exists {
+		// LTRIM on non-existent key is OK
+		if h.shouldWriteOutput(cmd) {
+			cmd.WriteAny("OK")
+		}
+		return nil
+	}
+
+	list.Trim(start, stop)
+	h.db.notify(notifyList, "ltrim", key)
+	h.db.deleteIfEmpty(key)
+
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny("OK")
+	}
+	return nil
+}
+
+func (h *handlers) handleBLPop(cmd *gedis_types.Command) error {
+	return h.bpop(cmd, true)
+}
+
+func (h *handlers) handleBRPop(cmd *gedis_types.Command) error {
+	return h.bpop(cmd, false)
+}
+
+// bpop pops an element from the head or the tail of the first non empty
+// list among the keys, waiting for one to get an element when they are all
+// empty.
+func (h *handlers) bpop(cmd *gedis_types.Command, left bool) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 2 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	keys, err := parseKeys(args[:len(args)-1])
+	if err != nil {
+		return err
+	}
+	deadline, err := parseBlockTimeout(args[len(args)-1])
+	if err != nil {
+		return err
+	}
+
+	if h.checkInTx(cmd) {
+		cmd.SetDone()
+		return nil
+	}
+
+	h.block(cmd, keys, deadline, func(key string) bool {
+		list, exists := h.db.GetList(key)
+		if !exists || list.Len() == 0 {
+			return false
+		}
+		value := popList(list, left, 1)[0]
+		h.db.notify(notifyList, listEvent("pop", left), key)
+		h.db.deleteIfEmpty(key)
+		h.propagate(strings.ToUpper(listEvent("pop", left)), key)
+
+		cmd.WriteAny(resp.Array{Size: 2, Items: []any{key, value}})
+		cmd.SetDone()
+		return true
+	})
+	return nil
+}
+
+func (h *handlers) handleBLMove(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) != 5 {
+		return fmt.Errorf("%w: requires exactly 5 arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	src, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+	dst, err := parseBulkStr(args[1])
+	if err != nil {
+		return err
+	}
+	fromLeft, err := parseListEnd(args[2])
+	if err != nil {
+		return err
+	}
+	toLeft, err := parseListEnd(args[3])
+	if err != nil {
+		return err
+	}
+	deadline, err := parseBlockTimeout(args[4])
+	if err != nil {
+		return err
+	}
+
+	if h.checkInTx(cmd) {
+		cmd.SetDone()
+		return nil
+	}
+
+	h.blmove(cmd, src, dst, fromLeft, toLeft, deadline)
+	return nil
+}
+
+func (h *handlers) handleBRPopLPush(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) != 3 {
+		return fmt.Errorf("%w: requires exactly 3 arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	src, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+	dst, err := parseBulkStr(args[1])
+	if err != nil {
+		return err
+	}
+	deadline, err := parseBlockTimeout(args[2])
+	if err != nil {
+		return err
+	}
+
+	if h.checkInTx(cmd) {
+		cmd.SetDone()
+		return nil
+	}
+
+	h.blmove(cmd, src, dst, false, true, deadline)
+	return nil
+}
+
+// blmove is LMOVE waiting for the list at src to get an element when it is
+// empty.
+func (h *handlers) blmove(cmd *gedis_types.Command, src, dst string, fromLeft, toLeft bool, deadline time.Time) {
+	h.block(cmd, []string{src}, deadline, func(key string) bool {
+		if list, exists := h.db.GetList(src); !exists || list.Len() == 0 {
+			return false
+		}
+		value := h.lmove(src, dst, fromLeft, toLeft)
+		h.propagate("LMOVE", src, dst, listEndName(fromLeft), listEndName(toLeft))
+
+		cmd.WriteAny(value)
+		cmd.SetDone()
+		return true
+	})
+}
+
+// handleBLMPop is LMPOP waiting for one of the lists to get an element when
+// they are all empty.
+func (h *handlers) handleBLMPop(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 4 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	deadline, err := parseBlockTimeout(args[0])
+	if err != nil {
+		return err
+	}
+	keys, left, count, err := parseMPopArgs(args[1:], parseListEnd)
+	if err != nil {
+		return err
+	}
+
+	if h.checkInTx(cmd) {
+		cmd.SetDone()
+		return nil
+	}
+
+	h.block(cmd, keys, deadline, func(key string) bool {
+		list, exists := h.db.GetList(key)
+		if !exists || list.Len() == 0 {
+			return false
+		}
+		items := popList(list, left, count)
+		h.db.notify(notifyList, listEvent("pop", left), key)
+		h.db.deleteIfEmpty(key)
+		h.propagate(strings.ToUpper(listEvent("pop", left)), key, strconv.Itoa(len(items)))
+
+		cmd.WriteAny(resp.Array{Size: 2, Items: []any{key, bytesArray(items)}})
+		cmd.SetDone()
+		return true
+	})
+	return nil
+}
+
+func (h *handlers) subModeErr(cmd *gedis_types.Command) error {
+	return fmt.Errorf("can't execute '%s' while in subscribe mode", strings.ToLower(cmd.Cmd.Cmd))
+}
+
+var errIncrOverflow = fmt.Errorf("increment or decrement would overflow")
+
+// addInt64 adds incr to value, failing instead of wrapping around.
+func addInt64(value, incr int64) (int64, error) {
+	if (incr < 0 && value < 0 && incr < math.MinInt64-value) ||
+		(incr > 0 && value > 0 && incr > math.MaxInt64-value) {
+		return 0, errIncrOverflow
+	}
+	return value + incr, nil
+}
+
+// incrBy adds incr to the counter at key, a missing key counting as 0, and
+// replies with the new value. Counters are kept in the integer encoding.
+func (h *handlers) incrBy(cmd *gedis_types.Command, key string, incr int64) error {
+	value, _, err := h.db.GetInt(key)
+	if err != nil {
+		return err
+	}
+	value, err = addInt64(value, incr)
+	if err != nil {
+		return err
+	}
+
+	h.db.SetInt(key, value)
+	h.db.notify(notifyString, "incrby", key)
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(int(value))
+	}
+	return nil
+}
+
+func (h *handlers) handleIncr(cmd *gedis_types.Command) error {
+	return h.incrDecr(cmd, 1)
+}
+
+func (h *handlers) handleDecr(cmd *gedis_types.Command) error {
+	return h.incrDecr(cmd, -1)
+}
+
+// incrDecr implements INCR and DECR, which take no increment.
+func (h *handlers) incrDecr(cmd *gedis_types.Command, incr int64) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) != 1 {
+		return fmt.Errorf("%w: requires exactly 1 argument", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+	defer cmd.SetDone()
+
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	return h.incrBy(cmd, key, incr)
+}
+
+func (h *handlers) handleIncrBy(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) != 2 {
+		return fmt.Errorf("%w: requires exactly 2 arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+
+	increment, err := parseInt(args[1])
+	if err != nil {
+		return fmt.Errorf("increment value is not an integer")
+	}
+
+	defer cmd.SetDone()
+
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	return h.incrBy(cmd, key, int64(increment))
+}
+
+func (h *handlers) handleDecrBy(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) != 2 {
+		return fmt.Errorf("%w: requires exactly 2 arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+
+	decrement, err := parseInt(args[1])
+	if err != nil {
+		return fmt.Errorf("decrement value is not an integer")
+	}
+	// the opposite of the smallest integer does not fit in one
+	if decrement == math.MinInt64 {
+		return fmt.Errorf("decrement would overflow")
+	}
+
+	defer cmd.SetDone()
+
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	return h.incrBy(cmd, key, -int64(decrement))
+}
+
+// handleIncrByFloat adds a floating point increment to a string, computing
+// with the precision of a long double like Redis, and stores the result in
+// its decimal form.
+func (h *handlers) handleIncrByFloat(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) != 2 {
+		return fmt.Errorf("%w: requires exactly 2 arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+	incrStr, err := parseStr(args[1])
+	if err != nil {
+		return err
+	}
+	incr, err := util.ParseLongDouble(incrStr)
+	if err != nil {
+		return err
+	}
+
+	defer cmd.SetDone()
+
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	current := new(big.Float)
+	if val, exists := h.db.GetString(key); exists {
+		if current, err = util.ParseLongDouble(string(val)); err != nil {
+			return err
+		}
+	}
+	sum, err := util.AddLongDouble(current, incr)
+	if err != nil {
+		return err
+	}
+
+	num := []byte(util.FormatLongDouble(sum))
+	h.db.SetString(key, num, 0)
+	h.db.notify(notifyString, "incrbyfloat", key)
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(num)
+	}
+	return nil
+}
+
+func (h *handlers) handleAppend(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) != 2 {
+		return fmt.Errorf("%w: requires exactly 2 arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+
+	appendValue, err := parseBytes(args[1])
+	if err != nil {
+		return err
+	}
+
+	defer cmd.SetDone()
+
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	// the stored value is never appended to in place, see getBytes
+	val, _ := h.db.GetString(key)
+	if len(val)+len(appendValue) > maxStringSize {
+		return errStringTooLong
+	}
+	newValue := make([]byte, 0, len(val)+len(appendValue))
+	newValue = append(append(newValue, val...), appendValue...)
+
+	h.db.SetString(key, newValue, 0)
+	h.db.notify(notifyString, "append", key)
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(len(newValue))
+	}
+
+	return nil
+}
+
+func (h *handlers) handleStrLen(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) != 1 {
+		return fmt.Errorf("%w: requires exactly 1 argument", ErrInvalidArguments)
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+
+	defer cmd.SetDone()
+
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	val, _ := h.db.GetString(key)
+	cmd.WriteAny(len(val))
+	return nil
+}
+
+func (h *handlers) handleGetRange(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) != 3 {
+		return fmt.Errorf("%w: requires exactly 3 arguments", ErrInvalidArguments)
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+
+	start, err := parseInt(args[1])
+	if err != nil {
+		return err
+	}
+
+	end, err := parseInt(args[2])
+	if err != nil {
+		return err
+	}
+
+	defer cmd.SetDone()
+
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	str, _ := h.db.GetString(key)
+	strLen := len(str)
+	if start < 0 {
+		start = strLen + start
+	}
+	if end < 0 {
+		end = strLen + end
+	}
+
+	if start < 0 {
+		start = 0
+	}
+	if end >= strLen {
+		end = strLen - 1
+	}
+
+	if start > end || start >= strLen {
+		cmd.WriteAny(resp.BulkStr{Size: 0, Value: []byte{}})
+		return nil
+	}
+
+	cmd.WriteAny(str[start : end+1])
+	return nil
+}
+
+// maxStringSize is the longest string APPEND and SETRANGE may build, Redis'
+// default proto-max-bulk-len.
+const maxStringSize = 512 << 20
+
+var errStringTooLong = fmt.Errorf("string exceeds maximum allowed size (proto-max-bulk-len)")
+
+func (h *handlers) handleSetRange(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) != 3 {
+		return fmt.Errorf("%w: requires exactly 3 arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+
+	offset, err := parseInt(args[1])
+	if err != nil {
+		return err
+	}
+
+	if offset < 0 {
+		return fmt.Errorf("offset is out of range")
+	}
+
+	replacement, err := parseBytes(args[2])
+	if err != nil {
+		return err
+	}
+	if len(replacement) > 0 && offset+len(replacement) > maxStringSize {
+		return errStringTooLong
+	}
+
+	defer cmd.SetDone()
+
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	str, _ := h.getBytes(key)
+	if len(replacement) == 0 {
+		// nothing is written, not even the padding
+		if h.shouldWriteOutput(cmd) {
+			cmd.WriteAny(len(str))
+		}
+		return nil
+	}
+	// Extend string with null bytes if needed
+	if end := offset + len(replacement); end > len(str) {
+		str = append(str, make([]byte, end-len(str))...)
+	}
+	copy(str[offset:], replacement)
+
+	h.db.SetString(key, str, 0)
+	h.db.notify(notifyString, "setrange", key)
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(len(str))
+	}
+
+	return nil
+}
+
+// handleLcs returns the longest common subsequence of two strings, its
+// length with LEN, or the matched ranges with IDX.
+func (h *handlers) handleLcs(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 2 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	keys := make([]string, 2)
+	for i := range keys {
+		key, err := parseBulkStr(args[i])
+		if err != nil {
+			return err
+		}
+		keys[i] = key
+	}
+	var getLen, getIdx, withMatchLen bool
+	minMatchLen := 0
+	for i := 2; i < len(args); i += 1 {
+		opt, err := parseStr(args[i])
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(opt) {
+		case "len":
+			getLen = true
+		case "idx":
+			getIdx = true
+		case "withmatchlen":
+			withMatchLen = true
+		case "minmatchlen":
+			if i+1 >= len(args) {
+				return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+			}
+			i += 1
+			if minMatchLen, err = parseInt(args[i]); err != nil {
+				return err
+			}
+			minMatchLen = max(minMatchLen, 0)
+		default:
+			return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+		}
+	}
+	if getLen && getIdx {
+		return fmt.Errorf("If you want both the length and indexes, please just use IDX.")
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	a, _ := h.db.GetString(keys[0])
+	b, _ := h.db.GetString(keys[1])
+	if getLen {
+		cmd.WriteAny(util.LcsLen(a, b))
+		return nil
+	}
+	lcs, matches, err := util.Lcs(a, b)
+	if err != nil {
+		return err
+	}
+	if !getIdx {
+		cmd.WriteAny(resp.NewBulkStr(lcs))
+		return nil
+	}
+
+	items := make([]any, 0, len(matches))
+	for _, m := range matches {
+		if m.Len() < minMatchLen {
+			continue
+		}
+		item := []any{
+			resp.Array{Size: 2, Items: []any{m.AStart, m.AEnd}},
+			resp.Array{Size: 2, Items: []any{m.BStart, m.BEnd}},
+		}
+		if withMatchLen {
+			item = append(item, m.Len())
+		}
+		items = append(items, resp.Array{Size: len(item), Items: item})
+	}
+	cmd.WriteAny(resp.Array{Size: 4, Items: []any{
+		resp.BulkStr{Size: 7, Value: []byte("matches")},
+		resp.Array{Size: len(items), Items: items},
+		resp.BulkStr{Size: 3, Value: []byte("len")},
+		len(lcs),
+	}})
+	return nil
+}
+
+var errBitOffset = fmt.Errorf("bit offset is not an integer or out of range")
+
+// getBytes returns a copy of the string stored at key, which the caller may
+// modify. Stored strings are never modified in place as they may be shared
+// with the commands that set them.
+func (h *handlers) getBytes(key string) ([]byte, bool) {
+	val, exists := h.db.GetString(key)
+	if !exists {
+		return nil, false
+	}
+	return slices.Clone(val), true
+}
+
+// setBytes stores b as the string at key, keeping its TTL.
+func (h *handlers) setBytes(key string, b []byte) {
+	h.db.SetString(key, b, 0)
+}
+
+func parseBitOffset(arg any) (uint64, error) {
+	offset, err := parseInt(arg)
+	if err != nil || offset < 0 || offset > data.MaxBitOffset {
+		return 0, errBitOffset
+	}
+	return uint64(offset), nil
+}
+
+// parseBitUnit parses the BYTE or BIT option of BITCOUNT and BITPOS and
+// reports whether the range is in bits.
+func parseBitUnit(arg any) (bool, error) {
+	unit, err := parseStr(arg)
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(unit) {
+	case "byte":
+		return false, nil
+	case "bit":
+		return true, nil
+	}
+	return false, fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+}
+
+func (h *handlers) handleSetBit(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) != 3 {
+		return fmt.Errorf("%w: requires exactly 3 arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+	offset, err := parseBitOffset(args[1])
+	if err != nil {
+		return err
+	}
+	bit, err := parseInt(args[2])
+	if err != nil || (bit != 0 && bit != 1) {
+		return fmt.Errorf("bit is not an integer or out of range")
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	b, _ := h.getBytes(key)
+	b, old := data.SetBit(b, offset, bit)
+	h.setBytes(key, b)
+	h.db.notify(notifyString, "setbit", key)
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(old)
+	}
+	return nil
+}
+
+func (h *handlers) handleGetBit(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) != 2 {
+		return fmt.Errorf("%w: requires exactly 2 arguments", ErrInvalidArguments)
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+	offset, err := parseBitOffset(args[1])
+	if err != nil {
+		return err
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	b, _ := h.db.GetString(key)
+	cmd.WriteAny(data.GetBit(b, offset))
+	return nil
+}
+
+// handleBitCount counts the set bits of a string, optionally between a
+// start and an end in bytes or, with BIT, in bits.
+func (h *handlers) handleBitCount(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 1 || len(args) == 2 || len(args) > 4 {
+		return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+	start, end, inBits := 0, -1, false
+	if len(args) >= 3 {
+		if start, err = parseInt(args[1]); err != nil {
+			return err
+		}
+		if end, err = parseInt(args[2]); err != nil {
+			return err
+		}
+	}
+	if len(args) == 4 {
+		if inBits, err = parseBitUnit(args[3]); err != nil {
+			return err
+		}
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	b, _ := h.db.GetString(key)
+	cmd.WriteAny(data.BitCount(b, int64(start), int64(end), inBits))
+	return nil
+}
+
+// handleBitPos finds the first bit set to 0 or 1 in a string, optionally
+// between a start and an end in bytes or, with BIT, in bits.
+func (h *handlers) handleBitPos(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 2 || len(args) > 5 {
+		return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+	bit, err := parseInt(args[1])
+	if err != nil || (bit != 0 && bit != 1) {
+		return fmt.Errorf("The bit argument must be 1 or 0.")
+	}
+	start, end, endGiven, inBits := 0, -1, false, false
+	if len(args) >= 3 {
+		if start, err = parseInt(args[2]); err != nil {
+			return err
+		}
+	}
+	if len(args) >= 4 {
+		if end, err = parseInt(args[3]); err != nil {
+			return err
+		}
+		endGiven = true
+	}
+	if len(args) == 5 {
+		if inBits, err = parseBitUnit(args[4]); err != nil {
+			return err
+		}
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	b, exists := h.db.GetString(key)
+	if !exists {
+		// a missing key is an empty string, with every bit clear
+		if bit == 1 {
+			cmd.WriteAny(-1)
+		} else {
+			cmd.WriteAny(0)
+		}
+		return nil
+	}
+	cmd.WriteAny(int(data.BitPos(b, bit, int64(start), int64(end), endGiven, inBits)))
+	return nil
+}
+
+// handleBitOp stores the result of a bitwise operation between strings, and
+// replies with its length.
+func (h *handlers) handleBitOp(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 3 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	opStr, err := parseStr(args[0])
+	if err != nil {
+		return err
+	}
+	op := strings.ToLower(opStr)
+	switch op {
+	case "and", "or", "xor":
+	case "not":
+		if len(args) != 3 {
+			return fmt.Errorf("BITOP NOT must be called with a single source key.")
+		}
+	case "diff":
+		if len(args) < 4 {
+			return fmt.Errorf("BITOP DIFF must be called with at least two source keys.")
+		}
+	default:
+		return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+	}
+	dest, err := parseBulkStr(args[1])
+	if err != nil {
+		return err
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	srcs := make([][]byte, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		key, err := parseBulkStr(arg)
+		if err != nil {
+			return err
+		}
+		b, _ := h.db.GetString(key)
+		srcs = append(srcs, b)
+	}
+
+	result := data.BitOp(op, srcs)
+	if len(result) == 0 {
+		if h.db.Delete(dest) {
+			h.db.notify(notifyGeneric, "del", dest)
+		}
+	} else {
+		h.db.Delete(dest)
+		h.db.SetString(dest, result, -1)
+		h.db.notify(notifyString, "set", dest)
+	}
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(len(result))
+	}
+	return nil
+}
+
+// bitfieldOp is one GET, SET or INCRBY subcommand of BITFIELD.
+type bitfieldOp struct {
+	kind     string
+	typ      data.BitfieldType
+	offset   uint64
+	value    int64
+	overflow data.BitfieldOverflow
+}
+
+// parseBitfieldOps parses the subcommands of BITFIELD. An OVERFLOW applies
+// to the SET and INCRBY subcommands that follow it.
+func parseBitfieldOps(args []any, readOnly bool) ([]bitfieldOp, error) {
+	ops := make([]bitfieldOp, 0)
+	overflow := data.OverflowWrap
+	syntaxError := fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+	for i := 0; i < len(args); {
+		sub, err := parseStr(args[i])
+		if err != nil {
+			return nil, err
+		}
+		kind := strings.ToLower(sub)
+		if readOnly && kind != "get" {
+			return nil, fmt.Errorf("BITFIELD_RO only supports the GET subcommand")
+		}
+
+		if kind == "overflow" {
+			if i+1 >= len(args) {
+				return nil, syntaxError
+			}
+			mode, err := parseStr(args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			switch strings.ToLower(mode) {
+			case "wrap":
+				overflow = data.OverflowWrap
+			case "sat":
+				overflow = data.OverflowSat
+			case "fail":
+				overflow = data.OverflowFail
+			default:
+				return nil, fmt.Errorf("Invalid OVERFLOW type specified")
+			}
+			i += 2
+			continue
+		}
+
+		width := 3
+		switch kind {
+		case "get":
+		case "set", "incrby":
+			width = 4
+		default:
+			return nil, syntaxError
+		}
+		if i+width > len(args) {
+			return nil, syntaxError
+		}
+
+		op := bitfieldOp{kind: kind, overflow: overflow}
+		typStr, err := parseStr(args[i+1])
+		if err != nil {
+			return nil, err
+		}
+		if op.typ, err = data.ParseBitfieldType(typStr); err != nil {
+			return nil, err
+		}
+		offsetStr, err := parseStr(args[i+2])
+		if err != nil {
+			return nil, err
+		}
+		// "#n" is the offset of the n-th integer of that type
+		multiplier := uint64(1)
+		if strings.HasPrefix(offsetStr, "#") {
+			offsetStr, multiplier = offsetStr[1:], uint64(op.typ.Width)
+		}
+		offset, err := strconv.ParseUint(offsetStr, 10, 64)
+		if err != nil || offset > data.MaxBitOffset/multiplier {
+			return nil, errBitOffset
+		}
+		op.offset = offset * multiplier
+		if op.offset+uint64(op.typ.Width)-1 > data.MaxBitOffset {
+			return nil, errBitOffset
+		}
+		if width == 4 {
+			value, err := parseInt(args[i+3])
+			if err != nil {
+				return nil, err
+			}
+			op.value = int64(value)
+		}
+		ops = append(ops, op)
+		i += width
+	}
+	return ops, nil
+}
+
+func (h *handlers) handleBitField(cmd *gedis_types.Command) error {
+	return h.bitfield(cmd, false)
+}
+
+func (h *handlers) handleBitFieldRo(cmd *gedis_types.Command) error {
+	return h.bitfield(cmd, true)
+}
+
+// bitfield runs the subcommands of BITFIELD in order, replying with the
+// value read by GET, the previous value for SET and the new value for
+// INCRBY, or nil when OVERFLOW FAIL prevented a write.
+func (h *handlers) bitfield(cmd *gedis_types.Command, readOnly bool) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 1 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	if !readOnly {
+		if err := h.checkSlaveWrite(cmd); err != nil {
+			return err
+		}
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+	ops, err := parseBitfieldOps(args[1:], readOnly)
+	if err != nil {
+		return err
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	b, _ := h.getBytes(key)
+	items := make([]any, 0, len(ops))
+	changed := false
+	for _, op := range ops {
+		current := data.GetBitfield(b, op.offset, op.typ)
+		var (
+			value int64
+			ok    bool
+		)
+		switch op.kind {
+		case "get":
+			items = append(items, int(current))
+			continue
+		case "set":
+			value, ok = op.typ.Add(op.value, 0, op.overflow)
+		case "incrby":
+			value, ok = op.typ.Add(current, op.value, op.overflow)
+		}
+		if !ok {
+			items = append(items, resp.BulkStr{Size: -1})
+			continue
+		}
+		b = data.SetBitfield(b, op.offset, op.typ, value)
+		changed = true
+		if op.kind == "set" {
+			items = append(items, int(current))
+		} else {
+			items = append(items, int(value))
+		}
+	}
+
+	if changed {
+		h.setBytes(key, b)
+		h.db.notify(notifyString, "setbit", key)
+	}
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(resp.Array{Size: len(items), Items: items})
+	}
+	return nil
+}
+
+// getHyperLogLog returns the HyperLogLog stored as a string at key.
+func (h *handlers) getHyperLogLog(key string) (*data.HyperLogLog, bool, error) {
+	b, exists := h.getBytes(key)
+	if !exists {
+		return nil, false, nil
+	}
+	hll, err := data.ParseHyperLogLog(b)
+	if err != nil {
+		return nil, false, err
+	}
+	return hll, true, nil
+}
+
+func (h *handlers) handlePfAdd(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 1 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+	hll, exists, err := h.getHyperLogLog(key)
+	if err != nil {
+		return err
+	}
+	// creating the key counts as a change even without elements
+	changed := !exists
+	if !exists {
+		hll = data.NewHyperLogLog()
+	}
+	for _, arg := range args[1:] {
+		element, err := parseStr(arg)
+		if err != nil {
+			return err
+		}
+		added, err := hll.Add([]byte(element))
+		if err != nil {
+			return err
+		}
+		changed = changed || added
+	}
+
+	if changed {
+		h.setBytes(key, hll.Bytes())
+		h.db.notify(notifyString, "pfadd", key)
+	}
+	if h.shouldWriteOutput(cmd) {
+		if changed {
+			cmd.WriteAny(1)
+		} else {
+			cmd.WriteAny(0)
+		}
+	}
+	return nil
+}
+
+// handlePfCount estimates the number of distinct elements of a HyperLogLog,
+// or of the union of several ones.
+func (h *handlers) handlePfCount(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 1 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	hlls := make([]*data.HyperLogLog, 0, len(args))
+	for _, arg := range args {
+		key, err := parseBulkStr(arg)
+		if err != nil {
+			return err
+		}
+		hll, exists, err := h.getHyperLogLog(key)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if len(args) == 1 {
+			// a single key may use and refresh the cached cardinality
+			count, err := hll.Count()
+			if err != nil {
+				return err
+			}
+			h.setBytes(key, hll.Bytes())
+			cmd.WriteAny(int(count))
+			return nil
+		}
+		hlls = append(hlls, hll)
+	}
+
+	count, err := data.UnionCount(hlls)
+	if err != nil {
+		return err
+	}
+	cmd.WriteAny(int(count))
+	return nil
+}
+
+// handlePfMerge stores the union of HyperLogLogs in the destination, which
+// takes part in the union when it exists.
+func (h *handlers) handlePfMerge(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 1 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	dest, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+	merged, exists, err := h.getHyperLogLog(dest)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		merged = data.NewHyperLogLog()
+	}
+	for _, arg := range args[1:] {
+		key, err := parseBulkStr(arg)
+		if err != nil {
+			return err
+		}
+		hll, exists, err := h.getHyperLogLog(key)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if err := merged.Merge(hll); err != nil {
+			return err
+		}
+	}
+
+	h.setBytes(dest, merged.Bytes())
+	h.db.notify(notifyString, "pfadd", dest)
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny("OK")
+	}
+	return nil
+}
+
+// handlePfDebug inspects the representation of a HyperLogLog: GETREG lists
+// the registers, DECODE the sparse opcodes, ENCODING tells sparse from dense
+// and TODENSE converts to the dense encoding.
+func (h *handlers) handlePfDebug(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) != 2 {
+		return fmt.Errorf("%w: requires exactly 2 arguments", ErrInvalidArguments)
+	}
+
+	subcmd, err := parseStr(args[0])
+	if err != nil {
+		return err
+	}
+	key, err := parseBulkStr(args[1])
+	if err != nil {
+		return err
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	hll, exists, err := h.getHyperLogLog(key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("The specified key does not exist")
+	}
+
+	switch strings.ToLower(subcmd) {
+	case "getreg":
+		// like Redis, reading the registers leaves the key dense
+		if converted, err := hll.ToDense(); err != nil {
+			return err
+		} else if converted {
+			h.setBytes(key, hll.Bytes())
+		}
+		regs, err := hll.Registers()
+		if err != nil {
+			return err
+		}
+		items := make([]any, len(regs))
+		for i, value := range regs {
+			items[i] = int(value)
+		}
+		cmd.WriteAny(resp.Array{Size: len(items), Items: items})
+	case "decode":
+		decoded, err := hll.DecodeSparse()
+		if err != nil {
+			return err
+		}
+		cmd.WriteAny(resp.BulkStr{Size: len(decoded), Value: []byte(decoded)})
+	case "encoding":
+		if hll.IsSparse() {
+			cmd.WriteAny("sparse")
+		} else {
+			cmd.WriteAny("dense")
+		}
+	case "todense":
+		converted, err := hll.ToDense()
+		if err != nil {
+			return err
+		}
+		if converted {
+			h.setBytes(key, hll.Bytes())
+			cmd.WriteAny(1)
+		} else {
+			cmd.WriteAny(0)
+		}
+	default:
+		return fmt.Errorf("Unknown PFDEBUG subcommand '%s'", subcmd)
+	}
+	return nil
+}
+
+func (h *handlers) handleHSet(cmd *gedis_types.Command) error {
+	return h.hset(cmd, false)
+}
+
+// handleHMSet is the legacy form of HSET, replying OK instead of the number
+// of fields added.
+func (h *handlers) handleHMSet(cmd *gedis_types.Command) error {
+	return h.hset(cmd, true)
+}
+
+func (h *handlers) hset(cmd *gedis_types.Command, legacy bool) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 3 || len(args)%2 == 0 {
+		return fmt.Errorf("%w: wrong number of arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+
+	hash := h.db.GetOrCreateHash(key)
+	added := 0
+	for i := 1; i < len(args); i += 2 {
+		field, err := parseBulkStr(args[i])
+		if err != nil {
+			return err
+		}
+		value, err := parseBytes(args[i+1])
+		if err != nil {
+			return err
+		}
+		if hash.Set(field, value) {
+			added++
+		}
+	}
+
+	h.db.notify(notifyHash, "hset", key)
+	if h.shouldWriteOutput(cmd) {
+		if legacy {
+			cmd.WriteAny("OK")
+		} else {
+			cmd.WriteAny(added)
+		}
+	}
+	return nil
+}
+
+func (h *handlers) handleHGet(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 2 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+
+	field, err := parseBulkStr(args[1])
+	if err != nil {
+		return err
+	}
+
+	value, ok := h.db.GetHashField(key, field)
+	if !ok {
+		cmd.WriteAny(resp.BulkStr{Size: -1})
+		return nil
+	}
+
+	cmd.WriteAny(value)
+	return nil
+}
+
+func (h *handlers) handleHMGet(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 2 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+
+	hash, _ := h.db.GetHash(key)
+	values := make([]any, len(args)-1)
+	for i := 1; i < len(args); i++ {
+		field, err := parseBulkStr(args[i])
+		if err != nil {
+			return err
+		}
+
+		if hash == nil {
+			values[i-1] = resp.BulkStr{Size: -1}
+			continue
+		}
+		value, ok := hash.Get(field)
+		if !ok {
+			values[i-1] = resp.BulkStr{Size: -1}
+		} else {
+			values[i-1] = value
+		}
+	}
+
+	cmd.WriteAny(resp.Array{Size: len(values), Items: values})
+	return nil
+}
+
+func (h *handlers) handleHGetAll(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 1 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+
+	items := make([]any, 0)
+	if hash, ok := h.db.GetHash(key); ok {
+		for _, field := range hash.Fields() {
+			value, _ := hash.Get(field)
+			items = append(items, field, value)
+		}
+	}
+
+	cmd.WriteAny(resp.Array{Size: len(items), Items: items})
+	return nil
+}
+
+func (h *handlers) handleHIncrBy(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 3 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+
+	field, err := parseBulkStr(args[1])
+	if err != nil {
+		return err
+	}
+
+	increment, err := parseInt(args[2])
+	if err != nil {
+		return fmt.Errorf("increment value is not an integer")
+	}
+
+	value, ok := h.db.GetHashField(key, field)
+
+	current := 0
+	if ok {
+		current, err = parseInt(value)
+		if err != nil {
+			return fmt.Errorf("hash value is not an integer")
+		}
+	}
+	newValue, err := addInt64(int64(current), int64(increment))
+	if err != nil {
+		return err
+	}
+
+	h.db.GetOrCreateHash(key).Update(field, strconv.AppendInt(nil, newValue, 10))
+
+	h.db.notify(notifyHash, "hincrby", key)
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(int(newValue))
+	}
+	return nil
+}
+
+func (h *handlers) handleHExists(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 2 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+
+	field, err := parseBulkStr(args[1])
+	if err != nil {
+		return err
+	}
+
+	_, exists := h.db.GetHashField(key, field)
+
+	if exists {
+		cmd.WriteAny(1)
+	} else {
+		cmd.WriteAny(0)
+	}
+	return nil
+}
+
+func (h *handlers) handleHDel(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 2 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+
+	deleted := 0
+	hash, exists := h.db.GetHash(key)
+	for i := 1; exists && i < len(args); i++ {
+		field, err := parseBulkStr(args[i])
+		if err != nil {
+			return err
+		}
+
+		if hash.Delete(field) {
+			deleted++
+		}
+	}
+
+	if deleted > 0 {
+		h.db.notify(notifyHash, "hdel", key)
+		h.db.deleteIfEmpty(key)
+	}
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(deleted)
+	}
+	return nil
+}
+
+func (h *handlers) handleHLen(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 1 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+
+	length := 0
+	if hash, ok := h.db.GetHash(key); ok {
+		length = hash.Len()
+	}
+	cmd.WriteAny(length)
+	return nil
+}
+
+func (h *handlers) handleHKeys(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 1 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+
+	keys := make([]any, 0)
+	if hash, ok := h.db.GetHash(key); ok {
+		for _, field := range hash.Fields() {
+			keys = append(keys, field)
+		}
+	}
+
+	cmd.WriteAny(resp.Array{Size: len(keys), Items: keys})
+	return nil
+}
+
+func (h *handlers) handleHVals(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 1 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+
+	values := make([]any, 0)
+	if hash, ok := h.db.GetHash(key); ok {
+		for _, field := range hash.Fields() {
+			value, _ := hash.Get(field)
+			values = append(values, value)
+		}
+	}
+
+	cmd.WriteAny(resp.Array{Size: len(values), Items: values})
+	return nil
+}
+
+func (h *handlers) handleHSetNx(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) != 3 {
+		return fmt.Errorf("%w: requires exactly 3 arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+	field, err := parseBulkStr(args[1])
+	if err != nil {
+		return err
+	}
+
+	if _, exists := h.db.GetHashField(key, field); exists {
+		if h.shouldWriteOutput(cmd) {
+			cmd.WriteAny(0)
+		}
+		return nil
+	}
+
+	value, err := parseBytes(args[2])
+	if err != nil {
+		return err
+	}
+	h.db.GetOrCreateHash(key).Set(field, value)
+	h.db.notify(notifyHash, "hset", key)
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(1)
+	}
+	return nil
+}
+
+func (h *handlers) handleHIncrByFloat(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) != 3 {
+		return fmt.Errorf("%w: requires exactly 3 arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+	field, err := parseBulkStr(args[1])
+	if err != nil {
+		return err
+	}
+	incrStr, err := parseBulkStr(args[2])
+	if err != nil {
+		return err
+	}
+	incr, err := util.ParseLongDouble(incrStr)
+	if err != nil {
+		return err
+	}
+
+	current := new(big.Float)
+	if value, exists := h.db.GetHashField(key, field); exists {
+		str, _ := parseStr(value)
+		current, err = util.ParseLongDouble(str)
+		if err != nil {
+			return fmt.Errorf("hash value is not a float")
+		}
+	}
+
+	sum, err := util.AddLongDouble(current, incr)
+	if err != nil {
+		return err
+	}
+
+	num := []byte(util.FormatLongDouble(sum))
+	h.db.GetOrCreateHash(key).Update(field, num)
+	h.db.notify(notifyHash, "hincrbyfloat", key)
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(num)
+	}
+	return nil
+}
+
+func (h *handlers) handleHStrLen(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) != 2 {
+		return fmt.Errorf("%w: requires exactly 2 arguments", ErrInvalidArguments)
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+	field, err := parseBulkStr(args[1])
+	if err != nil {
+		return err
+	}
+
+	length := 0
+	if value, exists := h.db.GetHashField(key, field); exists {
+		str, _ := parseStr(value)
+		length = len(str)
+	}
+	cmd.WriteAny(length)
+	return nil
+}
+
+func (h *handlers) handleHRandField(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 1 || len(args) > 3 {
+		return fmt.Errorf("%w: wrong number of arguments", ErrInvalidArguments)
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+
+	count, withValues := 0, false
+	if len(args) >= 2 {
+		count, err = parseInt(args[1])
+		if err != nil {
+			return err
+		}
+	}
+	if len(args) == 3 {
+		opt, err := parseStr(args[2])
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(opt, "withvalues") {
+			return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+		}
+		withValues = true
+	}
+
+	hash, exists := h.db.GetHash(key)
+	if len(args) == 1 {
+		// without a count the reply is a single field, not an array
+		if !exists {
+			cmd.WriteAny(resp.BulkStr{Size: -1})
+			return nil
+		}
+		field := hash.RandomFields(1)[0]
+		cmd.WriteAny(resp.BulkStr{Size: len(field), Value: []byte(field)})
+		return nil
+	}
+
+	items := make([]any, 0)
+	if exists {
+		for _, field := range hash.RandomFields(count) {
+			items = append(items, field)
+			if withValues {
+				value, _ := hash.Get(field)
+				items = append(items, value)
+			}
+		}
+	}
+	cmd.WriteAny(resp.Array{Size: len(items), Items: items})
+	return nil
+}
+
+// scanOptions are the cursor and options of HSCAN, SSCAN and ZSCAN.
+type scanOptions struct {
+	cursor   uint64
+	match    string
+	count    int
+	noValues bool
+}
+
+// parseScanOptions parses "cursor [MATCH pattern] [COUNT count]" and, when
+// noValues is allowed, the NOVALUES flag of HSCAN.
+func parseScanOptions(args []any, allowNoValues bool) (scanOptions, error) {
+	opts := scanOptions{match: "*", count: 10}
+	cursor, err := parseStr(args[0])
+	if err != nil {
+		return opts, err
+	}
+	opts.cursor, err = strconv.ParseUint(cursor, 10, 64)
+	if err != nil {
+		return opts, fmt.Errorf("invalid cursor")
+	}
+
+	for i := 1; i < len(args); i += 1 {
+		opt, err := parseStr(args[i])
+		if err != nil {
+			return opts, err
+		}
+		switch {
+		case strings.EqualFold(opt, "match") && i+1 < len(args):
+			if opts.match, err = parseBulkStr(args[i+1]); err != nil {
+				return opts, err
+			}
+			i += 1
+		case strings.EqualFold(opt, "count") && i+1 < len(args):
+			if opts.count, err = parseInt(args[i+1]); err != nil {
+				return opts, err
+			}
+			if opts.count < 1 {
+				return opts, fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+			}
+			i += 1
+		case strings.EqualFold(opt, "novalues") && allowNoValues:
+			opts.noValues = true
+		default:
+			return opts, fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+		}
+	}
+	return opts, nil
+}
+
+// scanReply builds the two element reply of the SCAN family: the next
+// cursor and the page of elements.
+func scanReply(next uint64, items []any) resp.Array {
+	cursor := strconv.FormatUint(next, 10)
+	return resp.Array{Size: 2, Items: []any{
+		resp.BulkStr{Size: len(cursor), Value: []byte(cursor)},
+		resp.Array{Size: len(items), Items: items},
+	}}
+}
+
+func (h *handlers) handleHScan(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 2 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+	opts, err := parseScanOptions(args[1:], true)
+	if err != nil {
+		return err
+	}
+
+	hash, exists := h.db.GetHash(key)
+	if !exists {
+		cmd.WriteAny(scanReply(0, []any{}))
+		return nil
+	}
+
+	fields, next := hash.Scan(opts.cursor, opts.count)
+	items := make([]any, 0, 2*len(fields))
+	for _, field := range fields {
+		if !util.GlobMatch(opts.match, field) {
+			continue
+		}
+		items = append(items, field)
+		if !opts.noValues {
+			value, _ := hash.Get(field)
+			items = append(items, value)
+		}
+	}
+	cmd.WriteAny(scanReply(next, items))
+	return nil
+}
+
+// maxFieldExpireMillis is the latest expiry a hash field accepts, in
+// milliseconds since the epoch, the limit of Redis' 48 bit expiry times.
+const maxFieldExpireMillis = 1<<48 - 1
+
+var errFieldExpireTime = fmt.Errorf("invalid expire time, must be >= 0 and <= %d", maxFieldExpireMillis)
+
+// parseFieldExpireAt converts the time argument of the hash field TTL
+// commands to an absolute time. unit is one of ex, px, exat and pxat.
+func parseFieldExpireAt(arg any, unit string) (time.Time, error) {
+	n, err := parseInt(arg)
+	if err != nil {
+		return time.Time{}, err
+	}
+	millis := int64(n)
+	if unit == "ex" || unit == "exat" {
+		if millis > maxFieldExpireMillis/1000 {
+			return time.Time{}, errFieldExpireTime
+		}
+		millis *= 1000
+	}
+	if unit == "ex" || unit == "px" {
+		if millis >= 0 {
+			millis += time.Now().UnixMilli()
+		}
+	}
+	if millis < 0 || millis > maxFieldExpireMillis {
+		return time.Time{}, errFieldExpireTime
+	}
+	return time.UnixMilli(millis), nil
+}
+
+// parseFieldList parses the "FIELDS numfields field..." block that ends the
+// hash field TTL commands. With width 2 every field is followed by a value,
+// returned in values.
+func parseFieldList(args []any, width int) (fields []string, values [][]byte, err error) {
+	if len(args) < 2 {
+		return nil, nil, fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+	if opt, _ := parseStr(args[0]); !strings.EqualFold(opt, "fields") {
+		return nil, nil, fmt.Errorf("mandatory argument FIELDS is missing or not at the right position")
+	}
+	n, err := parseInt(args[1])
+	if err != nil {
+		return nil, nil, err
+	}
+	if n <= 0 {
+		return nil, nil, fmt.Errorf("numfields should be greater than 0")
+	}
+	if len(args)-2 != n*width {
+		return nil, nil, fmt.Errorf("the numfields parameter must match the number of arguments")
+	}
+	for i := 2; i < len(args); i += width {
+		field, err := parseBulkStr(args[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		fields = append(fields, field)
+		if width == 2 {
+			value, err := parseBytes(args[i+1])
+			if err != nil {
+				return nil, nil, err
+			}
+			values = append(values, value)
+		}
+	}
+	return fields, values, nil
+}
+
+// expireField gives field the expiry at, deleting it right away when at has
+// already passed. It reports whether the field was deleted.
+func (h *handlers) expireField(key string, hash *data.Hash, field string, at time.Time) bool {
+	if !at.After(time.Now()) {
+		return hash.Delete(field)
+	}
+	hash.ExpireAt(field, at)
+	h.db.watchFields(key)
+	return false
+}
+
+func (h *handlers) handleHExpire(cmd *gedis_types.Command) error {
+	return h.hexpire(cmd, "ex")
+}
+
+func (h *handlers) handleHPExpire(cmd *gedis_types.Command) error {
+	return h.hexpire(cmd, "px")
+}
+
+func (h *handlers) handleHExpireAt(cmd *gedis_types.Command) error {
+	return h.hexpire(cmd, "exat")
+}
+
+func (h *handlers) handleHPExpireAt(cmd *gedis_types.Command) error {
+	return h.hexpire(cmd, "pxat")
+}
+
+// hexpire sets the TTL of hash fields. Every field gets a reply: -2 when it
+// does not exist, 0 when the NX, XX, GT or LT condition is not met, 1 when
+// the TTL is set and 2 when the time has already passed and the field is
+// deleted.
+func (h *handlers) hexpire(cmd *gedis_types.Command, unit string) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 4 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+	at, err := parseFieldExpireAt(args[1], unit)
+	if err != nil {
+		return err
+	}
+
+	rest, cond := args[2:], ""
+	if opt, _ := parseStr(rest[0]); !strings.EqualFold(opt, "fields") {
+		switch cond = strings.ToLower(opt); cond {
+		case "nx", "xx", "gt", "lt":
+			rest = rest[1:]
+		default:
+			return fmt.Errorf("%w: unsupported option %s", ErrInvalidArguments, opt)
+		}
+	}
+	fields, _, err := parseFieldList(rest, 1)
+	if err != nil {
+		return err
+	}
+
+	hash, exists := h.db.GetHash(key)
+	items := make([]any, 0, len(fields))
+	updated, deleted := false, false
+	for _, field := range fields {
+		if !exists || !hash.Exists(field) {
+			items = append(items, -2)
+			continue
+		}
+		current, volatile := hash.ExpiresAt(field)
+		// a field without TTL counts as never expiring
+		met := true
+		switch cond {
+		case "nx":
+			met = !volatile
+		case "xx":
+			met = volatile
+		case "gt":
+			met = volatile && at.After(current)
+		case "lt":
+			met = !volatile || at.Before(current)
+		}
+		if !met {
+			items = append(items, 0)
+			continue
+		}
+		if h.expireField(key, hash, field, at) {
+			deleted = true
+			items = append(items, 2)
+			continue
+		}
+		updated = true
+		items = append(items, 1)
+	}
+
+	if updated {
+		h.db.notify(notifyHash, "hexpire", key)
+	}
+	if deleted {
+		h.db.notify(notifyHash, "hdel", key)
+		h.db.deleteIfEmpty(key)
+	}
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(resp.Array{Size: len(items), Items: items})
+	}
+	return nil
+}
+
+func (h *handlers) handleHTtl(cmd *gedis_types.Command) error {
+	return h.httl(cmd, time.Second, false)
+}
+
+func (h *handlers) handleHPTtl(cmd *gedis_types.Command) error {
+	return h.httl(cmd, time.Millisecond, false)
+}
+
+func (h *handlers) handleHExpireTime(cmd *gedis_types.Command) error {
+	return h.httl(cmd, time.Second, true)
+}
+
+func (h *handlers) handleHPExpireTime(cmd *gedis_types.Command) error {
+	return h.httl(cmd, time.Millisecond, true)
+}
+
+// httl replies with the remaining TTL of hash fields, or their expiry as a
+// unix time when absolute is set, in the given unit. It is -2 for a field
+// that does not exist and -1 for one without TTL.
+func (h *handlers) httl(cmd *gedis_types.Command, unit time.Duration, absolute bool) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 3 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+	fields, _, err := parseFieldList(args[1:], 1)
+	if err != nil {
+		return err
+	}
+
+	hash, exists := h.db.GetHash(key)
+	items := make([]any, 0, len(fields))
+	for _, field := range fields {
+		if !exists || !hash.Exists(field) {
+			items = append(items, -2)
+			continue
+		}
+		at, volatile := hash.ExpiresAt(field)
+		switch {
+		case !volatile:
+			items = append(items, -1)
+		case absolute:
+			items = append(items, int(at.UnixMilli()/unit.Milliseconds()))
+		default:
+			// rounded to the closest unit, like TTL
+			remaining := time.Until(at) + unit/2
+			items = append(items, int(remaining/unit))
+		}
+	}
+	cmd.WriteAny(resp.Array{Size: len(items), Items: items})
+	return nil
+}
+
+// handleHPersist removes the TTL of hash fields, replying for every field
+// -2 when it does not exist, -1 when it had no TTL and 1 otherwise.
+func (h *handlers) handleHPersist(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 3 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+	fields, _, err := parseFieldList(args[1:], 1)
+	if err != nil {
+		return err
+	}
+
+	hash, exists := h.db.GetHash(key)
+	items := make([]any, 0, len(fields))
+	persisted := false
+	for _, field := range fields {
+		switch {
+		case !exists || !hash.Exists(field):
+			items = append(items, -2)
+		case hash.Persist(field):
+			persisted = true
+			items = append(items, 1)
+		default:
+			items = append(items, -1)
+		}
+	}
+
+	if persisted {
+		h.db.notify(notifyHash, "hpersist", key)
+	}
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(resp.Array{Size: len(items), Items: items})
+	}
+	return nil
+}
+
+// handleHGetEx replies with the values of hash fields and optionally sets
+// (EX, PX, EXAT, PXAT) or removes (PERSIST) their TTL.
+func (h *handlers) handleHGetEx(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 3 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+
+	var (
+		at      time.Time
+		persist bool
+		rest    = args[1:]
+	)
+	if opt, _ := parseStr(rest[0]); !strings.EqualFold(opt, "fields") {
+		switch unit := strings.ToLower(opt); unit {
+		case "ex", "px", "exat", "pxat":
+			if len(rest) < 2 {
+				return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+			}
+			if at, err = parseFieldExpireAt(rest[1], unit); err != nil {
+				return err
+			}
+			rest = rest[2:]
+		case "persist":
+			persist = true
+			rest = rest[1:]
+		default:
+			return fmt.Errorf("%w: unsupported option %s", ErrInvalidArguments, opt)
+		}
+	}
+	fields, _, err := parseFieldList(rest, 1)
+	if err != nil {
+		return err
+	}
+
+	hash, exists := h.db.GetHash(key)
+	items := make([]any, 0, len(fields))
+	updated, deleted, persisted := false, false, false
+	for _, field := range fields {
+		value, ok := any(nil), false
+		if exists {
+			value, ok = hash.Get(field)
+		}
+		if !ok {
+			items = append(items, resp.BulkStr{Size: -1})
+			continue
+		}
+		items = append(items, value)
+		switch {
+		case persist:
+			persisted = hash.Persist(field) || persisted
+		case !at.IsZero():
+			if h.expireField(key, hash, field, at) {
+				deleted = true
+			} else {
+				updated = true
+			}
+		}
+	}
+
+	if updated {
+		h.db.notify(notifyHash, "hexpire", key)
+	}
+	if persisted {
+		h.db.notify(notifyHash, "hpersist", key)
+	}
+	if deleted {
+		h.db.notify(notifyHash, "hdel", key)
+		h.db.deleteIfEmpty(key)
+	}
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(resp.Array{Size: len(items), Items: items})
+	}
+	return nil
+}
+
+// handleHSetEx sets hash fields along with their TTL. With FNX the fields
+// are only set when none exists, with FXX when all of them do. It replies 1
+// when the fields were set and 0 otherwise.
+func (h *handlers) handleHSetEx(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 4 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+
+	var (
+		at          time.Time
+		keepTTL     bool
+		cond        string
+		hasExpiry   bool
+		rest        = args[1:]
+		syntaxError = fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+	)
+	for len(rest) > 0 {
+		opt, _ := parseStr(rest[0])
+		if strings.EqualFold(opt, "fields") {
+			break
+		}
+		switch unit := strings.ToLower(opt); unit {
+		case "fnx", "fxx":
+			if cond != "" {
+				return syntaxError
+			}
+			cond = unit
+			rest = rest[1:]
+		case "ex", "px", "exat", "pxat":
+			if hasExpiry || len(rest) < 2 {
+				return syntaxError
+			}
+			if at, err = parseFieldExpireAt(rest[1], unit); err != nil {
+				return err
+			}
+			hasExpiry = true
+			rest = rest[2:]
+		case "keepttl":
+			if hasExpiry {
+				return syntaxError
+			}
+			keepTTL, hasExpiry = true, true
+			rest = rest[1:]
+		default:
+			return fmt.Errorf("%w: unsupported option %s", ErrInvalidArguments, opt)
+		}
+	}
+	fields, values, err := parseFieldList(rest, 2)
+	if err != nil {
+		return err
+	}
+
+	hash, exists := h.db.GetHash(key)
+	if cond != "" {
+		for _, field := range fields {
+			present := exists && hash.Exists(field)
+			if (cond == "fnx" && present) || (cond == "fxx" && !present) {
+				if h.shouldWriteOutput(cmd) {
+					cmd.WriteAny(0)
+				}
+				return nil
+			}
+		}
+	}
+
+	hash = h.db.GetOrCreateHash(key)
+	deleted := false
+	for i, field := range fields {
+		if keepTTL {
+			hash.Update(field, values[i])
+		} else {
+			hash.Set(field, values[i])
+		}
+		if !at.IsZero() && h.expireField(key, hash, field, at) {
+			deleted = true
+		}
+	}
+
+	h.db.notify(notifyHash, "hset", key)
+	if !at.IsZero() {
+		if deleted {
+			h.db.notify(notifyHash, "hdel", key)
+			h.db.deleteIfEmpty(key)
+		} else {
+			h.db.notify(notifyHash, "hexpire", key)
+		}
+	}
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(1)
+	}
+	return nil
+}
+
+// handleHGetDel replies with the values of hash fields and deletes them.
+func (h *handlers) handleHGetDel(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 3 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+	fields, _, err := parseFieldList(args[1:], 1)
+	if err != nil {
+		return err
+	}
+
+	hash, exists := h.db.GetHash(key)
+	items := make([]any, 0, len(fields))
+	deleted := false
+	for _, field := range fields {
+		value, ok := any(nil), false
+		if exists {
+			value, ok = hash.Get(field)
+		}
+		if !ok {
+			items = append(items, resp.BulkStr{Size: -1})
+			continue
+		}
+		items = append(items, value)
+		deleted = hash.Delete(field) || deleted
+	}
+
+	if deleted {
+		h.db.notify(notifyHash, "hdel", key)
+		h.db.deleteIfEmpty(key)
+	}
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(resp.Array{Size: len(items), Items: items})
+	}
+	return nil
+}
+
+func (h *handlers) handleMulti(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	state := cmd.ConnState
+
+	defer cmd.SetDone()
+	if state.InTransaction {
+		return fmt.Errorf("MULTI calls cannot be nested")
+	}
+
+	state.InTransaction = true
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny("OK")
+	}
+	return nil
+}
+
+func (h *handlers) handleExec(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	state := cmd.ConnState
+
+	defer cmd.SetDone()
+	if !state.InTransaction {
+		return fmt.Errorf("EXEC without MULTI")
+	}
+	defer func() {
+		state.Tx = make([]*gedis_types.Command, 0)
+	}()
+
+	bufs := make([]any, 0, len(state.Tx))
+	state.InTransaction = false
+	h.inExec = true
+	defer func() {
+		h.inExec = false
+	}()
+
+	for _, op := range state.Tx {
+		hdl, _, err := h.route(op)
+		if err != nil {
+			return err
+		}
+		err = hdl(op)
+		if err != nil {
+			cmd.SetDone()
+			bufs = append(bufs, err)
+		} else {
+			bufs = append(bufs, op.Output())
+		}
+	}
+
+	if h.shouldWriteOutput(cmd) {
+		arr := resp.Array{Size: len(bufs), Items: bufs}
+		cmd.WriteAny(arr)
+	}
+	return nil
+}
+
+func (h *handlers) checkInTx(cmd *gedis_types.Command) bool {
+	state := cmd.ConnState
+	if state.InTransaction {
+		state.Tx = append(state.Tx, cmd.Copy())
+		cmd.WriteAny("QUEUED")
+		return true
+	}
+	return false
+}
+
+func (h *handlers) shouldWriteOutput(cmd *gedis_types.Command) bool {
+
+	if h.isSlave {
+		return !cmd.IsRepl()
+	}
+
+	return true
+}
+
+func (h *handlers) checkSlaveWrite(cmd *gedis_types.Command) error {
+	if h.isSlave && !cmd.IsRepl() {
+		return fmt.Errorf("READONLY You can't write against a read only replica")
+	}
+	return nil
+}
+
+func (h *handlers) handleDiscard(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	defer cmd.SetDone()
+
+	state := cmd.ConnState
+
+	if !state.InTransaction {
+		return fmt.Errorf("DISCARD without MULTI")
+	}
+
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny("OK")
+	}
+
+	state.InTransaction = false
+	state.Tx = make([]*gedis_types.Command, 0)
+	return nil
+}
+
+func (h *handlers) handleInfo(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return fmt.Errorf("INFO not available during transaction")
+	}
+	args := cmd.Cmd.Args
+	if len(args) > 0 {
+		section, err := parseBulkStr(args[0])
+		if err != nil {
+			return err
+		}
+		fields := h.info.Fields()
+		for _, field := range fields {
+			if strings.EqualFold(section, field.Name) {
+				str := fmt.Sprintf("# %s\n%v\n", field.Name, field.Value)
+				bs := resp.BulkStr{Size: len(str), Value: []byte(str)}
+				cmd.WriteAny(bs)
+				return nil
+			}
+		}
+		return fmt.Errorf("unknown section '%s'", section)
+	}
+
+	str := h.info.String()
+	bs := resp.BulkStr{Size: len(str), Value: []byte(str)}
+	cmd.WriteAny(bs)
+	return nil
+}
+
+func (h *handlers) handleObject(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 1 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+	subcmd, err := parseStr(args[0])
+	if err != nil {
+		return err
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	if strings.EqualFold(subcmd, "help") {
+		cmd.WriteAny(helpLines(
+			"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"ENCODING <key>",
+			"    Return the kind of internal representation used in order to store the value",
+			"    associated with a <key>.",
+			"FREQ <key>",
+			"    Return the access frequency index of the <key>. The returned integer is",
+			"    proportional to the logarithm of the recent access frequency of the key.",
+			"IDLETIME <key>",
+			"    Return the idle time of the <key>, that is the approximated number of",
+			"    seconds elapsed since the last access to the key.",
+			"REFCOUNT <key>",
+			"    Return the number of references of the value associated with the specified",
+			"    <key>.",
+		))
+		return nil
+	}
+
+	if len(args) != 2 {
+		return fmt.Errorf("%w: wrong number of arguments for OBJECT %s", ErrInvalidArguments, strings.ToUpper(subcmd))
+	}
+	key, err := parseBulkStr(args[1])
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(subcmd) {
+	case "encoding":
+		enc, ok := h.db.Encoding(key)
+		if !ok {
+			cmd.WriteAny(resp.BulkStr{Size: -1})
+			return nil
+		}
+		cmd.WriteAny(resp.BulkStr{Size: len(enc), Value: []byte(enc)})
+	case "idletime":
+		acc, ok := h.db.Access(key)
+		if !ok {
+			cmd.WriteAny(resp.BulkStr{Size: -1})
+			return nil
+		}
+		cmd.WriteAny(int(acc.idle() / time.Second))
+	case "freq":
+		acc, ok := h.db.Access(key)
+		if !ok {
+			cmd.WriteAny(resp.BulkStr{Size: -1})
+			return nil
+		}
+		cmd.WriteAny(acc.freq())
+	case "refcount":
+		if _, ok := h.db.lookup(key); !ok {
+			cmd.WriteAny(resp.BulkStr{Size: -1})
+			return nil
+		}
+		cmd.WriteAny(1)
+	default:
+		return fmt.Errorf("%w: unknown OBJECT subcommand '%s'", ErrInvalidArguments, subcmd)
+	}
+	return nil
+}
+
+// memorySamples is the number of elements MEMORY USAGE looks at by default
+// when estimating the size of an aggregate value.
+const memorySamples = 5
+
+func (h *handlers) handleMemory(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 1 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+	subcmd, err := parseStr(args[0])
+	if err != nil {
+		return err
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	switch strings.ToLower(subcmd) {
+	case "help":
+		cmd.WriteAny(helpLines(
+			"MEMORY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"DOCTOR",
+			"    Return memory problems reports.",
+			"STATS",
+			"    Return information about the memory usage of the server.",
+			"USAGE <key> [SAMPLES <count>]",
+			"    Return memory in bytes used by <key> and its value. Nested values are",
+			"    sampled up to <count> times (default: 5, 0 means sample all).",
+		))
+	case "usage":
+		if len(args) != 2 && len(args) != 4 {
+			return fmt.Errorf("%w: wrong number of arguments for MEMORY USAGE", ErrInvalidArguments)
+		}
+		key, err := parseBulkStr(args[1])
+		if err != nil {
+			return err
+		}
+		samples := memorySamples
+		if len(args) == 4 {
+			opt, err := parseStr(args[2])
+			if err != nil {
+				return err
+			}
+			if !strings.EqualFold(opt, "samples") {
+				return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+			}
+			samples, err = parseInt(args[3])
+			if err != nil {
+				return err
+			}
+			if samples < 0 {
+				return fmt.Errorf("%w: SAMPLES must be positive", ErrInvalidArguments)
+			}
+		}
+		size, ok := h.db.MemoryUsage(key, samples)
+		if !ok {
+			cmd.WriteAny(resp.BulkStr{Size: -1})
+			return nil
+		}
+		cmd.WriteAny(size)
+	case "stats":
+		items := h.memoryStats()
+		cmd.WriteAny(resp.Array{Size: len(items), Items: items})
+	case "doctor":
+		report := h.memoryDoctor()
+		cmd.WriteAny(resp.BulkStr{Size: len(report), Value: []byte(report)})
+	default:
+		return fmt.Errorf("%w: unknown MEMORY subcommand '%s'", ErrInvalidArguments, subcmd)
+	}
+	return nil
+}
+
+func (h *handlers) memoryStats() []any {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	items := []any{
+		"total.allocated", int(ms.HeapAlloc),
+		"startup.allocated", int(h.inst.startupAlloc),
+	}
+
+	keys, dataset, overhead := 0, 0, int(h.inst.startupAlloc)
+	for _, db := range h.inst.databases() {
+		size, main, expires := db.datasetStats(memorySamples)
+		items = append(items, fmt.Sprintf("db.%d", db.num), resp.Array{
+			Size: 4,
+			Items: []any{
+				"overhead.hashtable.main", main,
+				"overhead.hashtable.expires", expires,
+			},
+		})
+		keys += db.Size()
+		dataset += size
+		overhead += main + expires
+	}
+
+	net := int(ms.HeapAlloc) - int(h.inst.startupAlloc)
+	bytesPerKey := 0
+	if keys > 0 && net > 0 {
+		bytesPerKey = net / keys
+	}
+	datasetPct := 0.0
+	if net > 0 {
+		datasetPct = float64(dataset) * 100 / float64(net)
+	}
+	fragmentation := 0.0
+	if ms.HeapAlloc > 0 {
+		fragmentation = float64(ms.HeapInuse) / float64(ms.HeapAlloc)
+	}
+
+	items = append(items,
+		"overhead.total", overhead,
+		"keys.count", keys,
+		"keys.bytes-per-key", bytesPerKey,
+		"dataset.bytes", dataset,
+		"dataset.percentage", strconv.FormatFloat(datasetPct, 'f', 2, 64),
+		"allocator.allocated", int(ms.HeapInuse),
+		"allocator.resident", int(ms.HeapSys),
+		"allocator-fragmentation.ratio", strconv.FormatFloat(fragmentation, 'f', 3, 64),
+	)
+	return items
+}
+
+func (h *handlers) memoryDoctor() string {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	keys, dataset := 0, 0
+	var bigKey any
+	bigSize := 0
+	for _, db := range h.inst.databases() {
+		for _, key := range db.keys() {
+			size, _ := db.MemoryUsage(key, memorySamples)
+			dataset += size
+			if size > bigSize {
+				bigKey, bigSize = key, size
+			}
+			keys += 1
+		}
+	}
+
+	if keys == 0 || ms.HeapAlloc < 5<<20 {
+		return "This instance is empty or is using very little memory, the issues detector can't be used in these conditions. Fill it with some data and try again.\n"
+	}
+
+	var issues []string
+	if ms.HeapSys > ms.HeapAlloc+(10<<20) && float64(ms.HeapSys)/float64(ms.HeapAlloc) > 1.4 {
+		issues = append(issues, fmt.Sprintf(
+			"High heap fragmentation: the Go runtime holds %d bytes of heap for %d bytes in use. This is expected after a large number of keys was removed, the runtime returns the memory to the OS over time.",
+			ms.HeapSys, ms.HeapAlloc))
+	}
+	if dataset > 1<<20 && bigSize*4 > dataset {
+		issues = append(issues, fmt.Sprintf(
+			"Big key: '%v' holds about %d bytes, more than a quarter of the dataset. Operations on it may be slow, consider splitting it.",
+			bigKey, bigSize))
+	}
+
+	if len(issues) == 0 {
+		return "No memory issues were detected in this instance.\n"
+	}
+
+	var sb strings.Builder
+	sb.WriteString("A few issues were detected in this instance memory:\n\n")
+	for _, issue := range issues {
+		sb.WriteString(" * ")
+		sb.WriteString(issue)
+		sb.WriteString("\n\n")
+	}
+	return sb.String()
+}
+
+// helpLines formats the reply of a HELP subcommand.
+func (h *handlers) handleConfig(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 1 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+	subcmd, err := parseStr(args[0])
+	if err != nil {
+		return err
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	switch strings.ToLower(subcmd) {
+	case "help":
+		cmd.WriteAny(helpLines(
+			"CONFIG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"GET <pattern>",
+			"    Return parameters matching the glob-like <pattern> and their values.",
+			"SET <directive> <value>",
+			"    Set the configuration <directive> to <value>.",
+		))
+	case "get":
+		if len(args) < 2 {
+			return fmt.Errorf("%w: wrong number of arguments for CONFIG GET", ErrInvalidArguments)
+		}
+		seen := make(map[string]bool)
+		items := make([]any, 0)
+		for _, arg := range args[1:] {
+			pattern, err := parseStr(arg)
+			if err != nil {
+				return err
+			}
+			pairs := h.inst.cfg.Get(pattern)
+			for i := 0; i < len(pairs); i += 2 {
+				if seen[pairs[i]] {
+					continue
+				}
+				seen[pairs[i]] = true
+				items = append(items, pairs[i], pairs[i+1])
+			}
+		}
+		cmd.WriteAny(resp.Array{Size: len(items), Items: items})
+	case "set":
+		if len(args) < 3 || len(args)%2 == 0 {
+			return fmt.Errorf("%w: wrong number of arguments for CONFIG SET", ErrInvalidArguments)
+		}
+		for i := 1; i < len(args); i += 2 {
+			name, err := parseStr(args[i])
+			if err != nil {
+				return err
+			}
+			value, err := parseStr(args[i+1])
+			if err != nil {
+				return err
+			}
+			if err := h.inst.cfg.Set(name, value); err != nil {
+				return err
+			}
+		}
+		cmd.WriteAny("OK")
+	default:
+		return fmt.Errorf("%w: unknown CONFIG subcommand '%s'", ErrInvalidArguments, subcmd)
+	}
+	return nil
+}
+
+func helpLines(lines ...string) resp.Array {
+	items := make([]any, len(lines))
+	for i, line := range lines {
+		items[i] = line
+	}
+	return resp.Array{Size: len(items), Items: items}
+}
+
+func (h *handlers) handleReplConf(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	defer cmd.SetDone()
+
+	if h.checkInTx(cmd) {
+		return nil
+	}
+	args := cmd.Cmd.Args
+
+	if len(args) < 2 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+	subcmd, err := parseStr(args[0])
+	if err != nil {
+		return err
+	}
+
+	state := cmd.ConnState
+
+	switch strings.ToLower(subcmd) {
+	case "listening-port":
+		if h.isSlave {
+			return fmt.Errorf("%w: REPLCONF only valid on a master", ErrInvalidArguments)
+		}
+
+		theirPort, err := parseInt(args[1])
 		if err != nil {
 			return fmt.Errorf("%w: invalid port number: %w", ErrInvalidArguments, err)
 		}
 
-		err = h.master.AddSlave(state.Conn, theirPort)
+		err = h.master.AddSlave(state.Conn, theirPort)
+		if err != nil {
+			return fmt.Errorf("%w: failed to add slave: %w", ErrInvalidArguments, err)
+		}
+
+		h.master.AddHandshakeStep(state.Conn.RemoteAddr().String(), repl.HandshakeListeningPort)
+
+		cmd.WriteAny("OK")
+	case "getack":
+		arg := args[1]
+		ackOffset := 0
+
+		argstr, err := parseBulkStr(arg)
+		if err != nil {
+			return fmt.Errorf("%w: invalid ACK offset: %w", ErrInvalidArguments, err)
+		}
+
+		if argstr != "*" {
+			argnum, err := parseInt(arg)
+			if err != nil {
+				return fmt.Errorf("%w: invalid ACK offset: %w", ErrInvalidArguments, err)
+			}
+			ackOffset = argnum
+		}
+
+		_ = ackOffset
+
+		offset := 0
+		if h.isSlave {
+			offset = h.slave.ReplOffset()
+		} else {
+			offset = int(h.master.ReplOffset())
+		}
+		res := resp.Command{
+			Cmd: "REPLCONF",
+			Args: []any{
+				"ACK",
+				fmt.Sprintf("%d", offset),
+			},
+		}
+		cmd.WriteAny(res.Array())
+
+	case "capa":
+		if h.isSlave {
+			return fmt.Errorf("%w: REPLCONF only valid on a master", ErrInvalidArguments)
+		}
+
+		addr := state.Conn.RemoteAddr().String()
+		if !h.master.HasHandshakeStep(addr, repl.HandshakeListeningPort) {
+			return fmt.Errorf("%w: REPLCONF capa must come after listening-port", ErrInvalidArguments)
+		}
+
+		proto, err := parseStr(args[1])
+		if err != nil {
+			return fmt.Errorf("%w: invalid capability: %w", ErrInvalidArguments, err)
+		}
+		exists := h.master.SetSlaveProto(state.Conn, proto)
+		if !exists {
+			return fmt.Errorf("%w: slave not registered yet", ErrInvalidArguments)
+		}
+
+		h.master.AddHandshakeStep(addr, repl.HandshakeCapa)
+		cmd.WriteAny("OK")
+
+	default:
+		return fmt.Errorf("%w: unknown REPLCONF subcommand '%s'", ErrInvalidArguments, subcmd)
+	}
+	return nil
+}
+
+func (h *handlers) handlePsync(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	defer cmd.SetDone()
+
+	if h.checkInTx(cmd) {
+		return fmt.Errorf("PSYNC cannot be in a transaction")
+	}
+	if h.isSlave {
+		return fmt.Errorf("PSYNC invalid for slave")
+	}
+
+	state := cmd.ConnState
+	addr := state.Conn.RemoteAddr().String()
+
+	if !h.master.HasHandshakeStep(addr, repl.HandshakeCapa) {
+		return fmt.Errorf("%w: PSYNC must come after REPLCONF capa", ErrInvalidArguments)
+	}
+
+	str := fmt.Sprintf("FULLRESYNC %s %d", h.master.ReplId(), 0)
+	cmd.WriteAny(str)
+
+	h.master.AddHandshakeStep(addr, repl.HandshakePsync)
+
+	cmd.SetDefer(func() {
+		log.Printf("handshake complete, upgrading connection to replication mode, addr=%s, replicaCount=%d",
+			cmd.ConnState.Conn.RemoteAddr(),
+			h.master.GetSlaveCount())
+		state.UpgradeToReplication()
+
+		h.master.StartSync(addr)
+	})
+
+	return nil
+}
+
+// handleClient implements the CLIENT subcommands about the connection
+// itself and the clients blocked on keys.
+func (h *handlers) handleClient(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 1 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+	subcmd, err := parseStr(args[0])
+	if err != nil {
+		return err
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	switch strings.ToLower(subcmd) {
+	case "help":
+		cmd.WriteAny(helpLines(
+			"CLIENT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"ID",
+			"    Return the ID of the current connection.",
+			"UNBLOCK <clientid> [TIMEOUT|ERROR]",
+			"    Unblock the specified blocked client.",
+		))
+	case "id":
+		if len(args) != 1 {
+			return fmt.Errorf("%w: wrong number of arguments for CLIENT ID", ErrInvalidArguments)
+		}
+		cmd.WriteAny(cmd.ConnState.Id)
+	case "unblock":
+		if len(args) != 2 && len(args) != 3 {
+			return fmt.Errorf("%w: wrong number of arguments for CLIENT UNBLOCK", ErrInvalidArguments)
+		}
+		id, err := parseInt(args[1])
+		if err != nil {
+			return fmt.Errorf("%w: value is not an integer or out of range", ErrInvalidArguments)
+		}
+		withError := false
+		if len(args) == 3 {
+			mode, _ := parseStr(args[2])
+			switch strings.ToLower(mode) {
+			case "timeout":
+			case "error":
+				withError = true
+			default:
+				return fmt.Errorf("CLIENT UNBLOCK reason should be TIMEOUT or ERROR")
+			}
+		}
+		if h.inst.unblockClient(int64(id), withError) {
+			cmd.WriteAny(1)
+		} else {
+			cmd.WriteAny(0)
+		}
+	default:
+		return fmt.Errorf("%w: unknown CLIENT subcommand '%s'", ErrInvalidArguments, subcmd)
+	}
+	return nil
+}
+
+func (h *handlers) handleWait(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	if h.checkInTx(cmd) {
+		return fmt.Errorf("WAIT cannot be in a transaction")
+	}
+
+	args := cmd.Cmd.Args
+	if len(args) < 2 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	if h.isSlave {
+		return fmt.Errorf("%w: WAIT invalid for replica", ErrInvalidArguments)
+	}
+
+	replicaCount, err := parseInt(args[0])
+	if err != nil {
+		return err
+	}
+
+	timeout, err := parseInt(args[1])
+	if err != nil {
+		return err
+	}
+
+	entry := &waitEntry{
+		cmd:   cmd,
+		count: replicaCount,
+	}
+
+	if timeout >= 0 {
+		deadline := time.Now().Add(time.Duration(timeout * int(time.Millisecond)))
+		cmd.SetTimeout(deadline)
+		cmd.SetTimeoutProducer(func() any {
+			return h.master.InsyncSlaveCount()
+		})
+	}
+
+	h.waits = append(h.waits, entry)
+	return nil
+}
+
+func (h *handlers) countWaits() int {
+	return len(h.waits)
+}
+
+func (h *handlers) resolveWaits(inSync int) {
+	remv := make([]int, 0, len(h.waits))
+	for i := 0; i < len(h.waits); i += 1 {
+		entry := h.waits[i]
+
+		if entry.cmd.HasTimedOut() {
+			remv = append(remv, i)
+			continue
+		}
+
+		if inSync >= entry.count {
+			defer entry.cmd.SetDone()
+			entry.cmd.WriteAny(inSync)
+			remv = append(remv, i)
+		}
+	}
+
+	for j := len(remv) - 1; j >= 0; j -= 1 {
+		idx := remv[j]
+		h.waits = append(h.waits[:idx], h.waits[idx+1:]...)
+	}
+}
+
+func (h *handlers) handleSubscribe(cmd *gedis_types.Command) error {
+	args := cmd.Cmd.Args
+	if len(args) < 1 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+	defer cmd.SetDone()
+
+	if h.checkInTx(cmd) {
+		return fmt.Errorf("SUBSCRIBE not allowed in transaction")
+	}
+
+	var subId string
+	if !cmd.IsSubMode() {
+		subId = util.RandomId(32)
+		cmd.ConnState.UpgradeToSubscription(subId)
+	} else {
+		subId = cmd.ConnState.SubId()
+	}
+
+	for _, arg := range args {
+		channel, err := parseBulkStr(arg)
 		if err != nil {
-			return fmt.Errorf("%w: failed to add slave: %w", ErrInvalidArguments, err)
+			return err
 		}
 
-		h.master.AddHandshakeStep(state.Conn.RemoteAddr().String(), repl.HandshakeListeningPort)
+		h.pubsub.subscribe(subId, channel, cmd.ConnState.Conn)
 
-		cmd.WriteAny("OK")
-	case "getack":
-		arg := args[1]
-		ackOffset := 0
+		cmd.WriteAny(resp.Array{
+			Size: 3,
+			Items: []any{
+				"subscribe",
+				channel,
+				h.pubsub.countSubs(subId),
+			},
+		})
+		log.Printf("new subscriber added, channel=%s, addr=%s", channel, cmd.ConnState.Conn.RemoteAddr())
+	}
+	return nil
+}
 
-		argstr, err := parseBulkStr(arg)
+func (h *handlers) handleUnsubscribe(cmd *gedis_types.Command) error {
+	args := cmd.Cmd.Args
+	defer cmd.SetDone()
+
+	if h.checkInTx(cmd) {
+		return fmt.Errorf("UNSUBSCRIBE not allowed in transaction")
+	}
+
+	if !cmd.IsSubMode() {
+		return fmt.Errorf("UNSUBSCRIBE not allowed outside subscribe mode")
+	}
+
+	subId := cmd.ConnState.SubId()
+
+	respArgs := make([]string, 0)
+
+	if len(args) == 0 {
+		unsubs := h.pubsub.unsubscribeAll(subId)
+		respArgs = append(respArgs, unsubs...)
+	}
+
+	for _, arg := range args {
+		channel, err := parseBulkStr(arg)
 		if err != nil {
-			return fmt.Errorf("%w: invalid ACK offset: %w", ErrInvalidArguments, err)
+			return err
 		}
+		h.pubsub.unsubscribe(subId, channel)
+		respArgs = append(respArgs, channel)
+	}
 
-		if argstr != "*" {
-			argnum, err := parseInt(arg)
-			if err != nil {
-				return fmt.Errorf("%w: invalid ACK offset: %w", ErrInvalidArguments, err)
-			}
-			ackOffset = argnum
+	currSubCount := h.pubsub.countSubs(subId)
+
+	arr := resp.Array{
+		Size:  len(respArgs) + 2,
+		Items: nil,
+	}
+	arr.Items = append(arr.Items, "unsubscribe")
+	for _, ch := range respArgs {
+		arr.Items = append(arr.Items, ch)
+	}
+	arr.Items = append(arr.Items, currSubCount)
+
+	cmd.WriteAny(arr)
+	return nil
+}
+
+func (h *handlers) handlePublish(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 2 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+	defer cmd.SetDone()
+
+	if h.checkInTx(cmd) {
+		return fmt.Errorf("PUBLISH not allowed in transaction")
+	}
+
+	channel, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+
+	message := args[1]
+
+	sentCount := h.pubsub.publish(channel, message)
+
+	cmd.WriteAny(sentCount)
+	return nil
+}
+
+func (h *handlers) handleQuit(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	defer cmd.SetDone()
+
+	if h.checkInTx(cmd) {
+		return fmt.Errorf("QUIT not allowed in transaction")
+	}
+
+	if cmd.ConnState.IsReplication() {
+		return fmt.Errorf("QUIT not allowed in replication")
+	}
+
+	cmd.WriteAny("OK")
+	if cmd.IsSubMode() {
+		cmd.ConnState.QuitSubscription()
+	}
+	return nil
+}
+
+var (
+	errNotFloat = fmt.Errorf("value is not a valid float")
+	errScoreNaN = fmt.Errorf("resulting score is not a number (NaN)")
+)
+
+// zaddFlags are the options of ZADD.
+type zaddFlags struct {
+	nx, xx, gt, lt, ch, incr bool
+}
+
+// parseZaddFlags parses the options leading the arguments of ZADD and
+// returns the score and member pairs that follow them.
+func parseZaddFlags(args []any) (zaddFlags, []any, error) {
+	var flags zaddFlags
+	i := 0
+	for ; i < len(args); i += 1 {
+		opt, _ := parseStr(args[i])
+		switch strings.ToLower(opt) {
+		case "nx":
+			flags.nx = true
+		case "xx":
+			flags.xx = true
+		case "gt":
+			flags.gt = true
+		case "lt":
+			flags.lt = true
+		case "ch":
+			flags.ch = true
+		case "incr":
+			flags.incr = true
+		default:
+			return flags, args[i:], nil
 		}
+	}
+	return flags, args[i:], nil
+}
 
-		_ = ackOffset
+// handleZadd adds members with their scores, or updates the scores of the
+// members already there. NX only adds and XX only updates, GT and LT only
+// update to a greater or a lower score. CH counts the updated members
+// along with the added ones, and INCR adds to the score of a single member,
+// replying with the new one.
+func (h *handlers) handleZadd(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 3 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
 
-		offset := 0
-		if h.isSlave {
-			offset = h.slave.ReplOffset()
-		} else {
-			offset = int(h.master.ReplOffset())
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+	flags, pairs, err := parseZaddFlags(args[1:])
+	if err != nil {
+		return err
+	}
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+	}
+	if flags.nx && flags.xx {
+		return fmt.Errorf("XX and NX options at the same time are not compatible")
+	}
+	if (flags.gt && flags.nx) || (flags.lt && flags.nx) || (flags.gt && flags.lt) {
+		return fmt.Errorf("GT, LT, and/or NX options at the same time are not compatible")
+	}
+	if flags.incr && len(pairs) > 2 {
+		return fmt.Errorf("INCR option supports a single increment-element pair")
+	}
+
+	scores := make([]float64, 0, len(pairs)/2)
+	members := make([]string, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		score, err := parseFloat(pairs[i])
+		if err != nil || math.IsNaN(score) {
+			return errNotFloat
 		}
-		res := resp.Command{
-			Cmd: "REPLCONF",
-			Args: []any{
-				"ACK",
-				fmt.Sprintf("%d", offset),
-			},
+		member, err := parseBulkStr(pairs[i+1])
+		if err != nil {
+			return err
 		}
-		cmd.WriteAny(res.Array())
+		scores = append(scores, score)
+		members = append(members, member)
+	}
 
-	case "capa":
-		if h.isSlave {
-			return fmt.Errorf("%w: REPLCONF only valid on a master", ErrInvalidArguments)
-		}
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
 
-		addr := state.Conn.RemoteAddr().String()
-		if !h.master.HasHandshakeStep(addr, repl.HandshakeListeningPort) {
-			return fmt.Errorf("%w: REPLCONF capa must come after listening-port", ErrInvalidArguments)
+	set, exists := h.db.GetSortedSet(key)
+	added, updated := 0, 0
+	// the score of the INCR member, unless its update was skipped
+	var incremented any = resp.BulkStr{Size: -1}
+	for i, member := range members {
+		score := scores[i]
+		old, found := 0.0, false
+		if exists {
+			old, found = set.Score(member)
+		}
+		if (found && flags.nx) || (!found && flags.xx) {
+			continue
+		}
+		if flags.incr {
+			if found {
+				score += old
+			}
+			if math.IsNaN(score) {
+				return errScoreNaN
+			}
+		}
+		if found && ((flags.gt && score <= old) || (flags.lt && score >= old)) {
+			continue
 		}
+		incremented = formatScore(score)
 
-		proto, err := parseStr(args[1])
-		if err != nil {
-			return fmt.Errorf("%w: invalid capability: %w", ErrInvalidArguments, err)
+		if found && old == score {
+			continue
 		}
-		exists := h.master.SetSlaveProto(state.Conn, proto)
 		if !exists {
-			return fmt.Errorf("%w: slave not registered yet", ErrInvalidArguments)
+			set, exists = h.db.GetOrCreateSortedSet(key), true
 		}
+		set.Insert(member, score)
+		if found {
+			updated += 1
+		} else {
+			added += 1
+		}
+	}
+	if added+updated > 0 {
+		if flags.incr {
+			h.db.notify(notifyZset, "zincr", key)
+		} else {
+			h.db.notify(notifyZset, "zadd", key)
+		}
+	}
 
-		h.master.AddHandshakeStep(addr, repl.HandshakeCapa)
-		cmd.WriteAny("OK")
-
-	default:
-		return fmt.Errorf("%w: unknown REPLCONF subcommand '%s'", ErrInvalidArguments, subcmd)
+	if h.shouldWriteOutput(cmd) {
+		switch {
+		case flags.incr:
+			cmd.WriteAny(incremented)
+		case flags.ch:
+			cmd.WriteAny(added + updated)
+		default:
+			cmd.WriteAny(added)
+		}
 	}
 	return nil
 }
-
-func (h *handlers) handlePsync(cmd *gedis_types.Command) error {
+func (h *handlers) handleZrank(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
-	defer cmd.SetDone()
 
+	defer cmd.SetDone()
 	if h.checkInTx(cmd) {
-		return fmt.Errorf("PSYNC cannot be in a transaction")
-	}
-	if h.isSlave {
-		return fmt.Errorf("PSYNC invalid for slave")
+		return nil
 	}
 
-	state := cmd.ConnState
-	addr := state.Conn.RemoteAddr().String()
+	args := cmd.Cmd.Args
+	if len(args) != 2 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
 
-	if !h.master.HasHandshakeStep(addr, repl.HandshakeCapa) {
-		return fmt.Errorf("%w: PSYNC must come after REPLCONF capa", ErrInvalidArguments)
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid key: %s", key)
 	}
 
-	str := fmt.Sprintf("FULLRESYNC %s %d", h.master.ReplId(), 0)
-	cmd.WriteAny(str)
+	member, err := parseBulkStr(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid member: %s", args[1])
+	}
 
-	h.master.AddHandshakeStep(addr, repl.HandshakePsync)
+	set, exists := h.db.GetSortedSet(key)
+	if !exists {
+		cmd.WriteAny(resp.BulkStr{Size: -1})
+		return nil
+	}
 
-	cmd.SetDefer(func() {
-		log.Printf("handshake complete, upgrading connection to replication mode, addr=%s, replicaCount=%d",
-			cmd.ConnState.Conn.RemoteAddr(),
-			h.master.GetSlaveCount())
-		state.UpgradeToReplication()
+	rank, ok := set.Rank(member)
+	if !ok {
+		cmd.WriteAny(resp.BulkStr{Size: -1})
+		return nil
+	}
 
-		h.master.StartSync(addr)
-	})
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(rank)
+	}
 
 	return nil
 }
 
-func (h *handlers) handleWait(cmd *gedis_types.Command) error {
-	if cmd.IsSubMode() {
-		return h.subModeErr(cmd)
+// zrangeKind is how the bounds of a sorted set range are read: as indexes,
+// scores or members.
+type zrangeKind int
+
+const (
+	zrangeAuto zrangeKind = iota
+	zrangeByRank
+	zrangeByScore
+	zrangeByLex
+)
+
+var (
+	errScoreRange = fmt.Errorf("min or max is not a float")
+	errLexRange   = fmt.Errorf("min or max not valid string range item")
+)
+
+// parseScoreBound parses a score bound of a range, exclusive when prefixed
+// with '('. Infinities are written -inf and +inf.
+func parseScoreBound(arg any) (float64, bool, error) {
+	str, err := parseStr(arg)
+	if err != nil {
+		return 0, false, errScoreRange
 	}
-	if h.checkInTx(cmd) {
-		return fmt.Errorf("WAIT cannot be in a transaction")
+	exclusive := strings.HasPrefix(str, "(")
+	if exclusive {
+		str = str[1:]
+	}
+	score, err := strconv.ParseFloat(str, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, false, errScoreRange
+	}
+	return score, exclusive, nil
+}
+
+func parseScoreRange(minArg, maxArg any) (data.ScoreRange[float64], error) {
+	var r data.ScoreRange[float64]
+	var err error
+	if r.Min, r.MinEx, err = parseScoreBound(minArg); err != nil {
+		return r, err
+	}
+	if r.Max, r.MaxEx, err = parseScoreBound(maxArg); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// parseLexBound parses a member bound of a range: '[' or '(' followed by
+// the member, included or not, or '-' and '+' for the infinities.
+func parseLexBound(arg any) (data.LexBound, error) {
+	str, err := parseStr(arg)
+	if err != nil || str == "" {
+		return data.LexBound{}, errLexRange
+	}
+	switch {
+	case str == "-":
+		return data.LexBound{Inf: -1}, nil
+	case str == "+":
+		return data.LexBound{Inf: 1}, nil
+	case str[0] == '[':
+		return data.LexBound{Value: str[1:]}, nil
+	case str[0] == '(':
+		return data.LexBound{Value: str[1:], Exclusive: true}, nil
+	}
+	return data.LexBound{}, errLexRange
+}
+
+func parseLexRange(minArg, maxArg any) (data.LexRange, error) {
+	var r data.LexRange
+	var err error
+	if r.Min, err = parseLexBound(minArg); err != nil {
+		return r, err
+	}
+	if r.Max, err = parseLexBound(maxArg); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// zrangeSpec is a range of a sorted set, as given to ZRANGE.
+type zrangeSpec struct {
+	kind        zrangeKind
+	start, stop int
+	scores      data.ScoreRange[float64]
+	lex         data.LexRange
+	reverse     bool
+	// offset and count are the LIMIT of a range by score or by member, a
+	// negative count meaning all the members
+	offset, count int
+}
+
+// nodes returns the members of set within the range.
+func (r *zrangeSpec) nodes(set *data.SortedSet[float64]) []data.Node[float64] {
+	switch r.kind {
+	case zrangeByScore:
+		return set.RangeByScore(r.scores, r.reverse, r.offset, r.count)
+	case zrangeByLex:
+		return set.RangeByLex(r.lex, r.reverse, r.offset, r.count)
+	}
+	start, stop := r.start, r.stop
+	if start < 0 {
+		start += set.Len()
+	}
+	if stop < 0 {
+		stop += set.Len()
+	}
+	if r.reverse {
+		return set.ReverseRange(start, stop+1)
+	}
+	return set.Range(start, stop+1)
+}
+
+// zrangeReply is the reply of the members of a range, along with their
+// scores when asked.
+func zrangeReply(nodes []data.Node[float64], withScores bool) resp.Array {
+	items := make([]any, 0, len(nodes)*2)
+	for _, node := range nodes {
+		items = append(items, node.Value)
+		if withScores {
+			items = append(items, formatScore(node.Score))
+		}
 	}
+	return resp.Array{Size: len(items), Items: items}
+}
 
+// zrange implements ZRANGE and ZRANGESTORE along with the older commands
+// they replace, which fix the kind of range and its direction instead of
+// taking the BYSCORE, BYLEX and REV options. The arguments are the key,
+// after the destination when storing, the bounds and the options.
+func (h *handlers) zrange(cmd *gedis_types.Command, kind zrangeKind, reverse bool, store bool) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
 	args := cmd.Cmd.Args
-	if len(args) < 2 {
+	first := 0
+	if store {
+		first = 1
+	}
+	if len(args) < first+3 {
 		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
 	}
 
-	if h.isSlave {
-		return fmt.Errorf("%w: WAIT invalid for replica", ErrInvalidArguments)
+	if store {
+		if err := h.checkSlaveWrite(cmd); err != nil {
+			return err
+		}
 	}
 
-	replicaCount, err := parseInt(args[0])
-	if err != nil {
-		return err
+	spec := zrangeSpec{kind: kind, reverse: reverse, count: -1}
+	withScores, limited := false, false
+	autoReverse := kind == zrangeAuto
+	opts := args[first+3:]
+	for i := 0; i < len(opts); i += 1 {
+		opt, _ := parseStr(opts[i])
+		switch opt = strings.ToLower(opt); {
+		case opt == "withscores" && !store:
+			withScores = true
+		case opt == "limit" && i+2 < len(opts):
+			offset, err := parseInt(opts[i+1])
+			if err != nil {
+				return errNotInteger
+			}
+			count, err := parseInt(opts[i+2])
+			if err != nil {
+				return errNotInteger
+			}
+			spec.offset, spec.count = offset, count
+			limited = true
+			i += 2
+		case opt == "rev" && autoReverse:
+			spec.reverse = true
+		case opt == "byscore" && spec.kind == zrangeAuto:
+			spec.kind = zrangeByScore
+		case opt == "bylex" && spec.kind == zrangeAuto:
+			spec.kind = zrangeByLex
+		default:
+			return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+		}
+	}
+	if spec.kind == zrangeAuto {
+		spec.kind = zrangeByRank
+	}
+	if limited && spec.kind == zrangeByRank {
+		return fmt.Errorf("%w: syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX", ErrInvalidArguments)
+	}
+	if withScores && spec.kind == zrangeByLex {
+		return fmt.Errorf("%w: syntax error, WITHSCORES not supported in combination with BYLEX", ErrInvalidArguments)
 	}
 
-	timeout, err := parseInt(args[1])
+	var dst string
+	if store {
+		var err error
+		if dst, err = parseBulkStr(args[0]); err != nil {
+			return err
+		}
+	}
+	key, err := parseBulkStr(args[first])
 	if err != nil {
 		return err
 	}
+	// a reversed range by score or member is given from max to min
+	minArg, maxArg := args[first+1], args[first+2]
+	if spec.reverse && spec.kind != zrangeByRank {
+		minArg, maxArg = maxArg, minArg
+	}
+	switch spec.kind {
+	case zrangeByRank:
+		if spec.start, err = parseInt(minArg); err != nil {
+			return errNotInteger
+		}
+		if spec.stop, err = parseInt(maxArg); err != nil {
+			return errNotInteger
+		}
+	case zrangeByScore:
+		if spec.scores, err = parseScoreRange(minArg, maxArg); err != nil {
+			return err
+		}
+	case zrangeByLex:
+		if spec.lex, err = parseLexRange(minArg, maxArg); err != nil {
+			return err
+		}
+	}
 
-	entry := &waitEntry{
-		cmd:   cmd,
-		count: replicaCount,
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
 	}
 
-	if timeout >= 0 {
-		deadline := time.Now().Add(time.Duration(timeout * int(time.Millisecond)))
-		cmd.SetTimeout(deadline)
-		cmd.SetTimeoutProducer(func() any {
-			return h.master.InsyncSlaveCount()
-		})
+	var nodes []data.Node[float64]
+	if set, exists := h.db.GetSortedSet(key); exists {
+		nodes = spec.nodes(set)
 	}
 
-	h.waits = append(h.waits, entry)
+	if !store {
+		cmd.WriteAny(zrangeReply(nodes, withScores))
+		return nil
+	}
+
+	if len(nodes) == 0 {
+		if h.db.Delete(dst) {
+			h.db.notify(notifyGeneric, "del", dst)
+		}
+	} else {
+		h.db.Delete(dst)
+		stored := h.db.GetOrCreateSortedSet(dst)
+		for _, node := range nodes {
+			stored.Insert(node.Value, node.Score)
+		}
+		h.db.notify(notifyZset, "zrangestore", dst)
+	}
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(len(nodes))
+	}
 	return nil
 }
 
-func (h *handlers) countWaits() int {
-	return len(h.waits)
+func (h *handlers) handleZrange(cmd *gedis_types.Command) error {
+	return h.zrange(cmd, zrangeAuto, false, false)
 }
 
-func (h *handlers) resolveWaits(inSync int) {
-	remv := make([]int, 0, len(h.waits))
-	for i := 0; i < len(h.waits); i += 1 {
-		entry := h.waits[i]
+func (h *handlers) handleZrangestore(cmd *gedis_types.Command) error {
+	return h.zrange(cmd, zrangeAuto, false, true)
+}
 
-		if entry.cmd.HasTimedOut() {
-			remv = append(remv, i)
-			continue
-		}
+func (h *handlers) handleZrevrange(cmd *gedis_types.Command) error {
+	return h.zrange(cmd, zrangeByRank, true, false)
+}
 
-		if inSync >= entry.count {
-			defer entry.cmd.SetDone()
-			entry.cmd.WriteAny(inSync)
-			remv = append(remv, i)
-		}
+func (h *handlers) handleZrangebyscore(cmd *gedis_types.Command) error {
+	return h.zrange(cmd, zrangeByScore, false, false)
+}
+
+func (h *handlers) handleZrevrangebyscore(cmd *gedis_types.Command) error {
+	return h.zrange(cmd, zrangeByScore, true, false)
+}
+
+func (h *handlers) handleZrangebylex(cmd *gedis_types.Command) error {
+	return h.zrange(cmd, zrangeByLex, false, false)
+}
+
+func (h *handlers) handleZrevrangebylex(cmd *gedis_types.Command) error {
+	return h.zrange(cmd, zrangeByLex, true, false)
+}
+
+func (h *handlers) handleZscore(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
 	}
 
-	for j := len(remv) - 1; j >= 0; j -= 1 {
-		idx := remv[j]
-		h.waits = append(h.waits[:idx], h.waits[idx+1:]...)
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
 	}
-}
 
-func (h *handlers) handleSubscribe(cmd *gedis_types.Command) error {
 	args := cmd.Cmd.Args
-	if len(args) < 1 {
+	if len(args) < 2 {
 		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
 	}
-	defer cmd.SetDone()
 
-	if h.checkInTx(cmd) {
-		return fmt.Errorf("SUBSCRIBE not allowed in transaction")
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid key: %s", key)
 	}
 
-	var subId string
-	if !cmd.IsSubMode() {
-		subId = util.RandomId(32)
-		cmd.ConnState.UpgradeToSubscription(subId)
-	} else {
-		subId = cmd.ConnState.SubId()
+	member, err := parseBulkStr(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid member: %s", args[1])
 	}
 
-	for _, arg := range args {
-		channel, err := parseBulkStr(arg)
-		if err != nil {
-			return err
+	set, exists := h.db.GetSortedSet(key)
+	if !exists {
+		if h.shouldWriteOutput(cmd) {
+			cmd.WriteAny(resp.BulkStr{Size: -1})
 		}
+		return nil
+	}
 
-		h.pubsub.subscribe(subId, channel, cmd.ConnState.Conn)
+	score, exists := set.Score(member)
+	if !exists {
+		if h.shouldWriteOutput(cmd) {
+			cmd.WriteAny(resp.BulkStr{Size: -1})
+		}
+		return nil
+	}
 
-		cmd.WriteAny(resp.Array{
-			Size: 3,
-			Items: []any{
-				"subscribe",
-				channel,
-				h.pubsub.countSubs(subId),
-			},
-		})
-		log.Printf("new subscriber added, channel=%s, addr=%s", channel, cmd.ConnState.Conn.RemoteAddr())
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(formatScore(score))
 	}
+
 	return nil
 }
 
-func (h *handlers) handleUnsubscribe(cmd *gedis_types.Command) error {
-	args := cmd.Cmd.Args
+func (h *handlers) handleZrem(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+
 	defer cmd.SetDone()
-
 	if h.checkInTx(cmd) {
-		return fmt.Errorf("UNSUBSCRIBE not allowed in transaction")
+		return nil
 	}
 
-	if !cmd.IsSubMode() {
-		return fmt.Errorf("UNSUBSCRIBE not allowed outside subscribe mode")
+	args := cmd.Cmd.Args
+	if len(args) < 2 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
 	}
 
-	subId := cmd.ConnState.SubId()
-
-	respArgs := make([]string, 0)
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid key: %s", key)
+	}
 
-	if len(args) == 0 {
-		unsubs := h.pubsub.unsubscribeAll(subId)
-		respArgs = append(respArgs, unsubs...)
+	set, exists := h.db.GetSortedSet(key)
+	if !exists {
+		if h.shouldWriteOutput(cmd) {
+			cmd.WriteAny(0)
+		}
+		return nil
 	}
 
-	for _, arg := range args {
-		channel, err := parseBulkStr(arg)
+	removed := 0
+
+	for i := 1; i < len(args); i += 1 {
+		member, err := parseBulkStr(args[i])
 		if err != nil {
-			return err
+			return fmt.Errorf("invalid member: %s", args[i])
 		}
-		h.pubsub.unsubscribe(subId, channel)
-		respArgs = append(respArgs, channel)
-	}
 
-	currSubCount := h.pubsub.countSubs(subId)
+		if set.Remove(member) {
+			removed += 1
+		}
+	}
 
-	arr := resp.Array{
-		Size:  len(respArgs) + 2,
-		Items: nil,
+	if removed > 0 {
+		h.db.notify(notifyZset, "zrem", key)
+		h.db.deleteIfEmpty(key)
 	}
-	arr.Items = append(arr.Items, "unsubscribe")
-	for _, ch := range respArgs {
-		arr.Items = append(arr.Items, ch)
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(removed)
 	}
-	arr.Items = append(arr.Items, currSubCount)
 
-	cmd.WriteAny(arr)
 	return nil
 }
 
-func (h *handlers) handlePublish(cmd *gedis_types.Command) error {
+func (h *handlers) handleZcard(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
-	args := cmd.Cmd.Args
-	if len(args) < 2 {
-		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
-	}
-	defer cmd.SetDone()
 
+	defer cmd.SetDone()
 	if h.checkInTx(cmd) {
-		return fmt.Errorf("PUBLISH not allowed in transaction")
+		return nil
 	}
 
-	channel, err := parseBulkStr(args[0])
+	args := cmd.Cmd.Args
+	if len(args) != 1 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	key, err := parseBulkStr(args[0])
 	if err != nil {
-		return err
+		return fmt.Errorf("invalid key: %s", key)
 	}
 
-	message := args[1]
+	set, exists := h.db.GetSortedSet(key)
+	if !exists {
+		if h.shouldWriteOutput(cmd) {
+			cmd.WriteAny(0)
+		}
+		return nil
+	}
 
-	sentCount := h.pubsub.publish(channel, message)
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(set.Len())
+	}
 
-	cmd.WriteAny(sentCount)
 	return nil
 }
 
-func (h *handlers) handleQuit(cmd *gedis_types.Command) error {
+func (h *handlers) handleZrevrank(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
-	defer cmd.SetDone()
 
+	defer cmd.SetDone()
 	if h.checkInTx(cmd) {
-		return fmt.Errorf("QUIT not allowed in transaction")
+		return nil
 	}
 
-	if cmd.ConnState.IsReplication() {
-		return fmt.Errorf("QUIT not allowed in replication")
+	args := cmd.Cmd.Args
+	if len(args) != 2 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
 	}
 
-	cmd.WriteAny("OK")
-	if cmd.IsSubMode() {
-		cmd.ConnState.QuitSubscription()
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid key: %s", key)
+	}
+
+	member, err := parseBulkStr(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid member: %s", args[1])
+	}
+
+	set, exists := h.db.GetSortedSet(key)
+	if !exists {
+		cmd.WriteAny(nil)
+		return nil
 	}
+
+	rank, exists := set.ReverseRank(member)
+	if !exists {
+		cmd.WriteAny(nil)
+		return nil
+	}
+
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(rank)
+	}
+
 	return nil
 }
 
-func (h *handlers) handleZadd(cmd *gedis_types.Command) error {
+func (h *handlers) handleZincrby(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
@@ -2225,7 +6840,7 @@ func (h *handlers) handleZadd(cmd *gedis_types.Command) error {
 	}
 
 	args := cmd.Cmd.Args
-	if len(args) < 3 || len(args)%2 == 0 {
+	if len(args) != 3 {
 		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
 	}
 
@@ -2234,210 +6849,407 @@ func (h *handlers) handleZadd(cmd *gedis_types.Command) error {
 		return fmt.Errorf("invalid key: %s", key)
 	}
 
-	set := h.db.GetOrCreateSortedSet(key)
-
-	inserted := 0
-
-	for i := 1; i < len(args); i += 2 {
-		score, err := parseFloat(args[i])
-		if err != nil {
-			return fmt.Errorf("invalid score: %s", args[i])
-		}
+	delta, err := parseFloat(args[1])
+	if err != nil || math.IsNaN(delta) {
+		return errNotFloat
+	}
 
-		member, err := parseBulkStr(args[i+1])
-		if err != nil {
-			return fmt.Errorf("invalid member: %s", args[i+1])
-		}
+	member, err := parseBulkStr(args[2])
+	if err != nil {
+		return fmt.Errorf("invalid member: %s", args[2])
+	}
 
-		if !set.Insert(member, score) {
-			inserted += 1
+	// +inf plus -inf is refused before anything is stored, a NaN score has
+	// no place in the order of the set
+	if set, exists := h.db.GetSortedSet(key); exists {
+		if old, found := set.Score(member); found && math.IsNaN(old+delta) {
+			return errScoreNaN
 		}
 	}
+	set := h.db.GetOrCreateSortedSet(key)
+
+	newScore, _ := set.IncrementScore(member, delta)
+	h.db.notify(notifyZset, "zincr", key)
 
 	if h.shouldWriteOutput(cmd) {
-		cmd.WriteAny(inserted)
+		cmd.WriteAny(formatScore(newScore))
 	}
 
 	return nil
 }
 
-func (h *handlers) handleZrank(cmd *gedis_types.Command) error {
+func (h *handlers) handleZcount(cmd *gedis_types.Command) error {
+	return h.zcount(cmd, zrangeByScore)
+}
+
+func (h *handlers) handleZlexcount(cmd *gedis_types.Command) error {
+	return h.zcount(cmd, zrangeByLex)
+}
+
+// zcount counts the members of a sorted set with a score, or for a range
+// of kind zrangeByLex a member, between min and max.
+func (h *handlers) zcount(cmd *gedis_types.Command, kind zrangeKind) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
+	args := cmd.Cmd.Args
+	if len(args) != 3 {
+		return fmt.Errorf("%w: requires exactly 3 arguments", ErrInvalidArguments)
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+	spec := zrangeSpec{kind: kind}
+	if kind == zrangeByLex {
+		spec.lex, err = parseLexRange(args[1], args[2])
+	} else {
+		spec.scores, err = parseScoreRange(args[1], args[2])
+	}
+	if err != nil {
+		return err
+	}
 
 	defer cmd.SetDone()
 	if h.checkInTx(cmd) {
 		return nil
 	}
 
+	count := 0
+	if set, exists := h.db.GetSortedSet(key); exists {
+		if kind == zrangeByLex {
+			count = set.CountByLex(spec.lex)
+		} else {
+			count = set.CountByScore(spec.scores)
+		}
+	}
+	cmd.WriteAny(count)
+	return nil
+}
+
+func (h *handlers) handleZremrangebyrank(cmd *gedis_types.Command) error {
+	return h.zremrange(cmd, zrangeByRank)
+}
+
+func (h *handlers) handleZremrangebyscore(cmd *gedis_types.Command) error {
+	return h.zremrange(cmd, zrangeByScore)
+}
+
+func (h *handlers) handleZremrangebylex(cmd *gedis_types.Command) error {
+	return h.zremrange(cmd, zrangeByLex)
+}
+
+// zremrange removes the members of a sorted set within a range of kind,
+// given by the min and max arguments, indexes being included and negative
+// ones counting from the end.
+func (h *handlers) zremrange(cmd *gedis_types.Command, kind zrangeKind) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
 	args := cmd.Cmd.Args
-	if len(args) != 2 {
-		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	if len(args) != 3 {
+		return fmt.Errorf("%w: requires exactly 3 arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
 	}
 
 	key, err := parseBulkStr(args[0])
 	if err != nil {
-		return fmt.Errorf("invalid key: %s", key)
+		return err
+	}
+	spec := zrangeSpec{kind: kind}
+	switch kind {
+	case zrangeByRank:
+		if spec.start, err = parseInt(args[1]); err != nil {
+			return errNotInteger
+		}
+		if spec.stop, err = parseInt(args[2]); err != nil {
+			return errNotInteger
+		}
+	case zrangeByScore:
+		spec.scores, err = parseScoreRange(args[1], args[2])
+	case zrangeByLex:
+		spec.lex, err = parseLexRange(args[1], args[2])
 	}
-
-	member, err := parseBulkStr(args[1])
 	if err != nil {
-		return fmt.Errorf("invalid member: %s", args[1])
+		return err
 	}
 
-	set, exists := h.db.GetSortedSet(key)
-	if !exists {
-		cmd.WriteAny(resp.BulkStr{Size: -1})
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
 		return nil
 	}
 
-	rank, ok := set.Rank(member)
-	if !ok {
-		cmd.WriteAny(resp.BulkStr{Size: -1})
-		return nil
+	removed := 0
+	if set, exists := h.db.GetSortedSet(key); exists {
+		switch kind {
+		case zrangeByRank:
+			start, stop := spec.start, spec.stop
+			if start < 0 {
+				start += set.Len()
+			}
+			if stop < 0 {
+				stop += set.Len()
+			}
+			removed = set.RemoveRange(start, stop+1)
+		case zrangeByScore:
+			removed = set.RemoveByScore(spec.scores)
+		case zrangeByLex:
+			removed = set.RemoveByLex(spec.lex)
+		}
+	}
+	if removed > 0 {
+		h.db.notify(notifyZset, zremrangeEvent[kind], key)
+		h.db.deleteIfEmpty(key)
 	}
 
 	if h.shouldWriteOutput(cmd) {
-		cmd.WriteAny(rank)
+		cmd.WriteAny(removed)
 	}
-
 	return nil
 }
 
-func (h *handlers) handleZrange(cmd *gedis_types.Command) error {
-	if cmd.IsSubMode() {
-		return h.subModeErr(cmd)
+var zremrangeEvent = map[zrangeKind]string{
+	zrangeByRank:  "zremrangebyrank",
+	zrangeByScore: "zremrangebyscore",
+	zrangeByLex:   "zremrangebylex",
+}
+
+// formatScore formats a sorted set score the way it is replied.
+func formatScore(score float64) []byte {
+	switch {
+	case math.IsInf(score, 1):
+		return []byte("inf")
+	case math.IsInf(score, -1):
+		return []byte("-inf")
 	}
+	return strconv.AppendFloat(nil, score, 'f', -1, 64)
+}
 
-	defer cmd.SetDone()
-	if h.checkInTx(cmd) {
-		return nil
+// setOp is the operation of the set and sorted set algebra commands.
+type setOp int
+
+const (
+	opUnion setOp = iota
+	opInter
+	opDiff
+)
+
+var zsetOpEvent = map[setOp]string{
+	opUnion: "zunionstore",
+	opInter: "zinterstore",
+	opDiff:  "zdiffstore",
+}
+
+// parseNumKeys parses the "numkeys key [key ...]" arguments of the commands
+// taking several keys followed by options, returning the options left.
+func parseNumKeys(args []any, name string) (keys []string, rest []any, err error) {
+	numKeys, err := parseInt(args[0])
+	if err != nil {
+		return nil, nil, errNotInteger
+	}
+	if numKeys <= 0 {
+		return nil, nil, fmt.Errorf("at least 1 input key is needed for '%s' command", name)
+	}
+	if numKeys > len(args)-1 {
+		return nil, nil, fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+	}
+	for _, arg := range args[1 : numKeys+1] {
+		key, err := parseBulkStr(arg)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, args[numKeys+1:], nil
+}
+
+// zsetInputs returns the sorted or plain sets stored at keys, missing keys
+// being empty sets.
+func (h *handlers) zsetInputs(keys []string) []data.Scored {
+	sets := make([]data.Scored, 0, len(keys))
+	for _, key := range keys {
+		if set, exists := h.db.GetSortedSet(key); exists {
+			sets = append(sets, set)
+		} else if set, exists := h.db.GetSet(key); exists {
+			sets = append(sets, set)
+		} else {
+			sets = append(sets, data.NewSet())
+		}
+	}
+	return sets
+}
+
+func (h *handlers) handleZunion(cmd *gedis_types.Command) error {
+	return h.zsetOp(cmd, opUnion, false)
+}
+
+func (h *handlers) handleZunionstore(cmd *gedis_types.Command) error {
+	return h.zsetOp(cmd, opUnion, true)
+}
+
+func (h *handlers) handleZinter(cmd *gedis_types.Command) error {
+	return h.zsetOp(cmd, opInter, false)
+}
+
+func (h *handlers) handleZinterstore(cmd *gedis_types.Command) error {
+	return h.zsetOp(cmd, opInter, true)
+}
+
+func (h *handlers) handleZdiff(cmd *gedis_types.Command) error {
+	return h.zsetOp(cmd, opDiff, false)
+}
+
+func (h *handlers) handleZdiffstore(cmd *gedis_types.Command) error {
+	return h.zsetOp(cmd, opDiff, true)
+}
+
+// zsetOp implements ZUNION, ZINTER and ZDIFF and their STORE variants, which
+// take the destination first and reply with its size instead of the members.
+// Plain sets are accepted as inputs, their members scoring 1.
+func (h *handlers) zsetOp(cmd *gedis_types.Command, op setOp, store bool) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
 	}
-
 	args := cmd.Cmd.Args
-	withScores := false
-	argCount := len(args)
-	if argCount >= 4 {
-		if str, ok := args[3].(string); ok && strings.ToUpper(str) == "WITHSCORES" {
-			withScores = true
-			argCount = 3
-		}
+	first := 0
+	if store {
+		first = 1
 	}
-	if argCount < 3 {
+	if len(args) < first+2 {
 		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
 	}
 
-	key, err := parseBulkStr(args[0])
-	if err != nil {
-		return fmt.Errorf("invalid key: %s", key)
+	if store {
+		if err := h.checkSlaveWrite(cmd); err != nil {
+			return err
+		}
 	}
 
-	start, err := parseInt(args[1])
-	if err != nil {
-		return fmt.Errorf("invalid start index: %s", args[1])
+	var dst string
+	if store {
+		var err error
+		if dst, err = parseBulkStr(args[0]); err != nil {
+			return err
+		}
 	}
-
-	stop, err := parseInt(args[2])
+	keys, opts, err := parseNumKeys(args[first:], strings.ToLower(cmd.Cmd.Cmd))
 	if err != nil {
-		return fmt.Errorf("invalid stop index: %s", args[2])
+		return err
 	}
 
-	set, exists := h.db.GetSortedSet(key)
-	if !exists {
-		cmd.WriteAny(resp.Array{Size: 0, Items: []any{}})
-		return nil
+	var weights []float64
+	agg := data.AggregateSum
+	withScores := false
+	for i := 0; i < len(opts); i += 1 {
+		opt, _ := parseStr(opts[i])
+		switch opt = strings.ToLower(opt); {
+		case opt == "weights" && op != opDiff && i+len(keys) < len(opts):
+			weights = make([]float64, len(keys))
+			for j := range weights {
+				weight, err := parseFloat(opts[i+1+j])
+				if err != nil || math.IsNaN(weight) {
+					return fmt.Errorf("weight value is not a float")
+				}
+				weights[j] = weight
+			}
+			i += len(keys)
+		case opt == "aggregate" && op != opDiff && i+1 < len(opts):
+			name, _ := parseStr(opts[i+1])
+			switch strings.ToLower(name) {
+			case "sum":
+				agg = data.AggregateSum
+			case "min":
+				agg = data.AggregateMin
+			case "max":
+				agg = data.AggregateMax
+			default:
+				return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+			}
+			i += 1
+		case opt == "withscores" && !store:
+			withScores = true
+		default:
+			return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+		}
 	}
 
-	if stop < 0 {
-		stop = set.Len() + stop
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
 	}
 
-	if start < 0 {
-		start = set.Len() + start
+	sets := h.zsetInputs(keys)
+	var result *data.SortedSet[float64]
+	switch op {
+	case opUnion:
+		result = data.Union(sets, weights, agg)
+	case opInter:
+		result = data.Inter(sets, weights, agg)
+	case opDiff:
+		result = data.Diff(sets)
 	}
 
-	if start > stop || start >= set.Len() {
-		cmd.WriteAny(resp.Array{Size: 0, Items: []any{}})
+	if !store {
+		cmd.WriteAny(zrangeReply(result.Range(0, result.Len()), withScores))
 		return nil
 	}
 
-	if stop >= set.Len() {
-		stop = set.Len() - 1
-	}
-
-	items := make([]any, 0, (stop-start+1)*(1+func() int {
-		if withScores {
-			return 1
-		} else {
-			return 0
-		}
-	}()))
-
-	nodes := set.Range(start, stop+1) // zrange is inclusive
-	for _, node := range nodes {
-		items = append(items, node.Value)
-		if withScores {
-			items = append(items, node.Score)
+	if result.IsEmpty() {
+		if h.db.Delete(dst) {
+			h.db.notify(notifyGeneric, "del", dst)
 		}
+	} else {
+		h.db.put(dst, entry{value: result})
+		h.db.notify(notifyZset, zsetOpEvent[op], dst)
+	}
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(result.Len())
 	}
-
-	cmd.WriteAny(resp.Array{Size: len(items), Items: items})
 	return nil
 }
 
-func (h *handlers) handleZscore(cmd *gedis_types.Command) error {
+func (h *handlers) handleZintercard(cmd *gedis_types.Command) error {
+	return h.intercard(cmd, func(keys []string, limit int) int {
+		return data.InterCard(h.zsetInputs(keys), limit)
+	})
+}
+
+// intercard implements ZINTERCARD and SINTERCARD, replying with the size of
+// the intersection of the keys given by count, counting up to the LIMIT
+// when given one.
+func (h *handlers) intercard(cmd *gedis_types.Command, count func(keys []string, limit int) int) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
-
-	defer cmd.SetDone()
-	if h.checkInTx(cmd) {
-		return nil
-	}
-
 	args := cmd.Cmd.Args
 	if len(args) < 2 {
 		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
 	}
 
-	key, err := parseBulkStr(args[0])
-	if err != nil {
-		return fmt.Errorf("invalid key: %s", key)
+	numKeys, err := parseInt(args[0])
+	if err != nil || numKeys <= 0 {
+		return fmt.Errorf("numkeys should be greater than 0")
 	}
-
-	member, err := parseBulkStr(args[1])
+	keys, opts, err := parseNumKeys(args, strings.ToLower(cmd.Cmd.Cmd))
 	if err != nil {
-		return fmt.Errorf("invalid member: %s", args[1])
+		return err
 	}
-
-	set, exists := h.db.GetSortedSet(key)
-	if !exists {
-		if h.shouldWriteOutput(cmd) {
-			cmd.WriteAny(resp.BulkStr{Size: -1})
+	limit := 0
+	switch {
+	case len(opts) == 0:
+	case len(opts) == 2:
+		if opt, _ := parseStr(opts[0]); !strings.EqualFold(opt, "limit") {
+			return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
 		}
-		return nil
-	}
-
-	score, exists := set.Score(member)
-	if !exists {
-		if h.shouldWriteOutput(cmd) {
-			cmd.WriteAny(resp.BulkStr{Size: -1})
+		if limit, err = parseInt(opts[1]); err != nil || limit < 0 {
+			return fmt.Errorf("LIMIT can't be negative")
 		}
-		return nil
-	}
-
-	if h.shouldWriteOutput(cmd) {
-		scoreStr := strconv.FormatFloat(score, 'f', -1, 64)
-		cmd.WriteAny(resp.BulkStr{Size: len(scoreStr), Value: scoreStr})
-	}
-
-	return nil
-}
-
-func (h *handlers) handleZrem(cmd *gedis_types.Command) error {
-	if cmd.IsSubMode() {
-		return h.subModeErr(cmd)
+	default:
+		return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
 	}
 
 	defer cmd.SetDone()
@@ -2445,399 +7257,398 @@ func (h *handlers) handleZrem(cmd *gedis_types.Command) error {
 		return nil
 	}
 
-	args := cmd.Cmd.Args
-	if len(args) < 2 {
-		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
-	}
-
-	key, err := parseBulkStr(args[0])
-	if err != nil {
-		return fmt.Errorf("invalid key: %s", key)
-	}
+	cmd.WriteAny(count(keys, limit))
+	return nil
+}
 
+// popSortedSet pops up to count members with the lowest scores, or the
+// highest, of the sorted set at key.
+func (h *handlers) popSortedSet(key string, max bool, count int) []data.Node[float64] {
 	set, exists := h.db.GetSortedSet(key)
 	if !exists {
-		if h.shouldWriteOutput(cmd) {
-			cmd.WriteAny(0)
-		}
 		return nil
 	}
+	var nodes []data.Node[float64]
+	if max {
+		nodes = set.PopMax(count)
+	} else {
+		nodes = set.PopMin(count)
+	}
+	if len(nodes) > 0 {
+		h.db.notify(notifyZset, zpopEvent(max), key)
+	}
+	h.db.deleteIfEmpty(key)
+	return nodes
+}
 
-	removed := 0
-
-	for i := 1; i < len(args); i += 1 {
-		member, err := parseBulkStr(args[i])
-		if err != nil {
-			return fmt.Errorf("invalid member: %s", args[i])
-		}
-
-		if set.Remove(member) {
-			removed += 1
-		}
+func zpopEvent(max bool) string {
+	if max {
+		return "zpopmax"
 	}
+	return "zpopmin"
+}
 
-	if h.shouldWriteOutput(cmd) {
-		cmd.WriteAny(removed)
+// propagateZpop replicates the members popped from key by a blocking
+// command as their removal.
+func (h *handlers) propagateZpop(key string, nodes []data.Node[float64]) {
+	args := make([]any, 0, 1+len(nodes))
+	args = append(args, key)
+	for _, node := range nodes {
+		args = append(args, node.Value)
 	}
+	h.propagate("ZREM", args...)
+}
 
-	return nil
+func (h *handlers) handleZPopMin(cmd *gedis_types.Command) error {
+	return h.zpop(cmd, false)
 }
 
-func (h *handlers) handleZcard(cmd *gedis_types.Command) error {
+func (h *handlers) handleZPopMax(cmd *gedis_types.Command) error {
+	return h.zpop(cmd, true)
+}
+
+// zpop pops up to count members, one by default, with the lowest or
+// the highest scores.
+func (h *handlers) zpop(cmd *gedis_types.Command, max bool) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
-
-	defer cmd.SetDone()
-	if h.checkInTx(cmd) {
-		return nil
+	args := cmd.Cmd.Args
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("%w: wrong number of arguments", ErrInvalidArguments)
 	}
 
-	args := cmd.Cmd.Args
-	if len(args) != 1 {
-		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
 	}
 
 	key, err := parseBulkStr(args[0])
 	if err != nil {
-		return fmt.Errorf("invalid key: %s", key)
+		return err
 	}
-
-	set, exists := h.db.GetSortedSet(key)
-	if !exists {
-		if h.shouldWriteOutput(cmd) {
-			cmd.WriteAny(0)
+	count := 1
+	if len(args) == 2 {
+		if count, err = parseInt(args[1]); err != nil {
+			return errNotInteger
+		}
+		if count < 0 {
+			return fmt.Errorf("value is out of range, must be positive")
 		}
+	}
+
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
 		return nil
 	}
 
+	var nodes []data.Node[float64]
+	if count > 0 {
+		nodes = h.popSortedSet(key, max, count)
+	}
 	if h.shouldWriteOutput(cmd) {
-		cmd.WriteAny(set.Len())
+		cmd.WriteAny(zrangeReply(nodes, true))
 	}
-
 	return nil
 }
 
-func (h *handlers) handleZrevrange(cmd *gedis_types.Command) error {
+// handleZMPop pops up to COUNT members, one by default, with the lowest or
+// the highest scores from the first non empty sorted set among the keys.
+func (h *handlers) handleZMPop(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
-
-	defer cmd.SetDone()
-	if h.checkInTx(cmd) {
-		return nil
-	}
-
 	args := cmd.Cmd.Args
-	withScores := false
-	argCount := len(args)
-	if argCount >= 4 {
-		if str, ok := args[3].(string); ok && strings.ToUpper(str) == "WITHSCORES" {
-			withScores = true
-			argCount = 3
-		}
-	}
-	if argCount < 3 {
+	if len(args) < 3 {
 		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
 	}
 
-	key, err := parseBulkStr(args[0])
-	if err != nil {
-		return fmt.Errorf("invalid key: %s", key)
-	}
-
-	start, err := parseInt(args[1])
-	if err != nil {
-		return fmt.Errorf("invalid start index: %s", args[1])
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
 	}
 
-	stop, err := parseInt(args[2])
+	keys, lowest, count, err := parseMPopArgs(args, parseZsetEnd)
 	if err != nil {
-		return fmt.Errorf("invalid stop index: %s", args[2])
+		return err
 	}
 
-	set, exists := h.db.GetSortedSet(key)
-	if !exists {
-		cmd.WriteAny(resp.Array{Size: 0, Items: []any{}})
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
 		return nil
 	}
 
-	if stop < 0 {
-		stop = set.Len() + stop
-	}
-
-	if start < 0 {
-		start = set.Len() + start
-	}
-
-	if start > stop || start >= set.Len() {
-		cmd.WriteAny(resp.Array{Size: 0, Items: []any{}})
+	for _, key := range keys {
+		nodes := h.popSortedSet(key, !lowest, count)
+		if len(nodes) == 0 {
+			continue
+		}
+		if h.shouldWriteOutput(cmd) {
+			cmd.WriteAny(zmpopReply(key, nodes))
+		}
 		return nil
 	}
-
-	if stop >= set.Len() {
-		stop = set.Len() - 1
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(resp.Array{Size: -1})
 	}
+	return nil
+}
 
-	items := make([]any, 0, (stop-start+1)*(1+func() int {
-		if withScores {
-			return 1
-		} else {
-			return 0
-		}
-	}()))
-
-	nodes := set.ReverseRange(start, stop+1) // zrevrange is inclusive
-	for _, node := range nodes {
-		items = append(items, node.Value)
-		if withScores {
-			items = append(items, node.Score)
-		}
+// zmpopReply is the reply of ZMPOP and BZMPOP: the key and the members
+// popped from it, each paired with its score.
+func zmpopReply(key string, nodes []data.Node[float64]) resp.Array {
+	members := make([]any, len(nodes))
+	for i, node := range nodes {
+		members[i] = resp.Array{Size: 2, Items: []any{node.Value, formatScore(node.Score)}}
 	}
-
-	cmd.WriteAny(resp.Array{Size: len(items), Items: items})
-	return nil
+	return resp.Array{Size: 2, Items: []any{key, resp.Array{Size: len(members), Items: members}}}
 }
 
-func (h *handlers) handleZrevrank(cmd *gedis_types.Command) error {
+func (h *handlers) handleZrandmember(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
+	args := cmd.Cmd.Args
+	if len(args) < 1 || len(args) > 3 {
+		return fmt.Errorf("%w: wrong number of arguments", ErrInvalidArguments)
+	}
 
 	defer cmd.SetDone()
 	if h.checkInTx(cmd) {
 		return nil
 	}
 
-	args := cmd.Cmd.Args
-	if len(args) != 2 {
-		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
-	}
-
 	key, err := parseBulkStr(args[0])
 	if err != nil {
-		return fmt.Errorf("invalid key: %s", key)
+		return err
 	}
 
-	member, err := parseBulkStr(args[1])
-	if err != nil {
-		return fmt.Errorf("invalid member: %s", args[1])
+	count, withScores := 0, false
+	if len(args) >= 2 {
+		count, err = parseInt(args[1])
+		if err != nil {
+			return err
+		}
 	}
-
-	set, exists := h.db.GetSortedSet(key)
-	if !exists {
-		cmd.WriteAny(nil)
-		return nil
+	if len(args) == 3 {
+		opt, err := parseStr(args[2])
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(opt, "withscores") {
+			return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+		}
+		withScores = true
 	}
 
-	rank, exists := set.ReverseRank(member)
-	if !exists {
-		cmd.WriteAny(nil)
+	set, exists := h.db.GetSortedSet(key)
+	if len(args) == 1 {
+		// without a count the reply is a single member, not an array
+		if !exists {
+			cmd.WriteAny(resp.BulkStr{Size: -1})
+			return nil
+		}
+		member := set.RandomMembers(1)[0].Value
+		cmd.WriteAny(resp.BulkStr{Size: len(member), Value: []byte(member)})
 		return nil
 	}
 
-	if h.shouldWriteOutput(cmd) {
-		cmd.WriteAny(rank)
+	var nodes []data.Node[float64]
+	if exists {
+		nodes = set.RandomMembers(count)
 	}
-
+	cmd.WriteAny(zrangeReply(nodes, withScores))
 	return nil
 }
 
-func (h *handlers) handleZincrby(cmd *gedis_types.Command) error {
+func (h *handlers) handleZmscore(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
+	args := cmd.Cmd.Args
+	if len(args) < 2 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
 
 	defer cmd.SetDone()
 	if h.checkInTx(cmd) {
 		return nil
 	}
 
-	args := cmd.Cmd.Args
-	if len(args) != 3 {
-		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
-	}
-
 	key, err := parseBulkStr(args[0])
 	if err != nil {
-		return fmt.Errorf("invalid key: %s", key)
-	}
-
-	delta, err := parseFloat(args[1])
-	if err != nil {
-		return fmt.Errorf("invalid increment: %s", args[1])
-	}
-
-	member, err := parseBulkStr(args[2])
-	if err != nil {
-		return fmt.Errorf("invalid member: %s", args[2])
+		return err
 	}
 
-	set := h.db.GetOrCreateSortedSet(key)
-
-	newScore, _ := set.IncrementScore(member, delta)
-
-	if h.shouldWriteOutput(cmd) {
-		cmd.WriteAny(newScore)
+	set, exists := h.db.GetSortedSet(key)
+	items := make([]any, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		member, err := parseBulkStr(arg)
+		if err != nil {
+			return err
+		}
+		score, found := 0.0, false
+		if exists {
+			score, found = set.Score(member)
+		}
+		if !found {
+			items = append(items, resp.BulkStr{Size: -1})
+			continue
+		}
+		items = append(items, formatScore(score))
 	}
-
+	cmd.WriteAny(resp.Array{Size: len(items), Items: items})
 	return nil
 }
 
-func (h *handlers) handleZcount(cmd *gedis_types.Command) error {
+func (h *handlers) handleZscan(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
+	args := cmd.Cmd.Args
+	if len(args) < 2 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
 
 	defer cmd.SetDone()
 	if h.checkInTx(cmd) {
 		return nil
 	}
 
-	args := cmd.Cmd.Args
-	if len(args) != 3 {
-		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
-	}
-
 	key, err := parseBulkStr(args[0])
 	if err != nil {
-		return fmt.Errorf("invalid key: %s", key)
-	}
-
-	min, err := parseFloat(args[1])
-	if err != nil {
-		return fmt.Errorf("invalid min score: %s", args[1])
+		return err
 	}
-
-	max, err := parseFloat(args[2])
+	opts, err := parseScanOptions(args[1:], false)
 	if err != nil {
-		return fmt.Errorf("invalid max score: %s", args[2])
+		return err
 	}
 
 	set, exists := h.db.GetSortedSet(key)
 	if !exists {
-		if h.shouldWriteOutput(cmd) {
-			cmd.WriteAny(0)
-		}
+		cmd.WriteAny(scanReply(0, []any{}))
 		return nil
 	}
 
-	count := set.CountByScore(min, max)
-
-	if h.shouldWriteOutput(cmd) {
-		cmd.WriteAny(count)
+	members, next := set.Scan(opts.cursor, opts.count)
+	items := make([]any, 0, 2*len(members))
+	for _, member := range members {
+		if !util.GlobMatch(opts.match, member) {
+			continue
+		}
+		score, _ := set.Score(member)
+		items = append(items, member, formatScore(score))
 	}
-
+	cmd.WriteAny(scanReply(next, items))
 	return nil
 }
+func (h *handlers) handleBZPopMin(cmd *gedis_types.Command) error {
+	return h.bzpop(cmd, false)
+}
 
-func (h *handlers) handleZrangebyscore(cmd *gedis_types.Command) error {
+func (h *handlers) handleBZPopMax(cmd *gedis_types.Command) error {
+	return h.bzpop(cmd, true)
+}
+
+// bzpop pops the member with the lowest score, or the highest, of the first
+// non empty sorted set among the keys, waiting for one to get a member when
+// they are all empty.
+func (h *handlers) bzpop(cmd *gedis_types.Command, max bool) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
-
-	defer cmd.SetDone()
-	if h.checkInTx(cmd) {
-		return nil
-	}
-
 	args := cmd.Cmd.Args
-	withScores := false
-	argCount := len(args)
-	if argCount >= 4 {
-		if str, ok := args[3].(string); ok && strings.ToUpper(str) == "WITHSCORES" {
-			withScores = true
-			argCount = 3
-		}
-	}
-	if argCount < 3 {
+	if len(args) < 2 {
 		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
 	}
 
-	key, err := parseBulkStr(args[0])
-	if err != nil {
-		return fmt.Errorf("invalid key: %s", key)
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
 	}
 
-	min, err := parseFloat(args[1])
+	keys, err := parseKeys(args[:len(args)-1])
 	if err != nil {
-		return fmt.Errorf("invalid min score: %s", args[1])
+		return err
 	}
-
-	max, err := parseFloat(args[2])
+	deadline, err := parseBlockTimeout(args[len(args)-1])
 	if err != nil {
-		return fmt.Errorf("invalid max score: %s", args[2])
+		return err
 	}
 
-	set, exists := h.db.GetSortedSet(key)
-	if !exists {
-		cmd.WriteAny(resp.Array{Size: 0, Items: []any{}})
+	if h.checkInTx(cmd) {
+		cmd.SetDone()
 		return nil
 	}
 
-	nodes := set.RangeByScore(min, max)
-	items := make([]any, 0, len(nodes)*(1+func() int {
-		if withScores {
-			return 1
-		} else {
-			return 0
-		}
-	}()))
-	for _, node := range nodes {
-		items = append(items, node.Value)
-		if withScores {
-			items = append(items, node.Score)
+	h.block(cmd, keys, deadline, func(key string) bool {
+		nodes := h.popSortedSet(key, max, 1)
+		if len(nodes) == 0 {
+			return false
 		}
-	}
+		h.propagateZpop(key, nodes)
 
-	cmd.WriteAny(resp.Array{Size: len(items), Items: items})
+		cmd.WriteAny(resp.Array{Size: 3, Items: []any{key, nodes[0].Value, formatScore(nodes[0].Score)}})
+		cmd.SetDone()
+		return true
+	})
 	return nil
 }
 
-func (h *handlers) handleZremrangebyscore(cmd *gedis_types.Command) error {
-	if cmd.IsSubMode() {
-		return h.subModeErr(cmd)
+// parseZsetEnd parses the MIN or MAX argument of ZMPOP and reports whether
+// it is MIN.
+func parseZsetEnd(arg any) (bool, error) {
+	end, err := parseStr(arg)
+	if err != nil {
+		return false, err
 	}
-
-	defer cmd.SetDone()
-	if h.checkInTx(cmd) {
-		return nil
+	switch strings.ToLower(end) {
+	case "min":
+		return true, nil
+	case "max":
+		return false, nil
 	}
+	return false, fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+}
 
+// handleBZMPop pops up to COUNT members, one by default, with the lowest
+// or the highest scores from the first non empty sorted set among the
+// keys, waiting for one to get a member when they are all empty.
+func (h *handlers) handleBZMPop(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
 	args := cmd.Cmd.Args
-	if len(args) != 3 {
+	if len(args) < 4 {
 		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
 	}
 
-	key, err := parseBulkStr(args[0])
-	if err != nil {
-		return fmt.Errorf("invalid key: %s", key)
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
 	}
 
-	min, err := parseFloat(args[1])
+	deadline, err := parseBlockTimeout(args[0])
 	if err != nil {
-		return fmt.Errorf("invalid min score: %s", args[1])
+		return err
 	}
-
-	max, err := parseFloat(args[2])
+	keys, lowest, count, err := parseMPopArgs(args[1:], parseZsetEnd)
 	if err != nil {
-		return fmt.Errorf("invalid max score: %s", args[2])
+		return err
 	}
 
-	set, exists := h.db.GetSortedSet(key)
-	if !exists {
-		if h.shouldWriteOutput(cmd) {
-			cmd.WriteAny(0)
-		}
+	if h.checkInTx(cmd) {
+		cmd.SetDone()
 		return nil
 	}
 
-	removed := set.RemoveByScore(min, max)
-
-	if h.shouldWriteOutput(cmd) {
-		cmd.WriteAny(removed)
-	}
+	h.block(cmd, keys, deadline, func(key string) bool {
+		nodes := h.popSortedSet(key, !lowest, count)
+		if len(nodes) == 0 {
+			return false
+		}
+		h.propagateZpop(key, nodes)
 
+		cmd.WriteAny(zmpopReply(key, nodes))
+		cmd.SetDone()
+		return true
+	})
 	return nil
 }
 
@@ -2875,6 +7686,9 @@ func (h *handlers) handleSadd(cmd *gedis_types.Command) error {
 		}
 	}
 
+	if added > 0 {
+		h.db.notify(notifySet, "sadd", key)
+	}
 	if h.shouldWriteOutput(cmd) {
 		cmd.WriteAny(added)
 	}
@@ -2911,7 +7725,7 @@ func (h *handlers) handleSmembers(cmd *gedis_types.Command) error {
 	members := set.Members()
 	items := make([]any, len(members))
 	for i, member := range members {
-		items[i] = resp.BulkStr{Size: len(member), Value: member}
+		items[i] = resp.BulkStr{Size: len(member), Value: []byte(member)}
 	}
 
 	cmd.WriteAny(resp.Array{Size: len(items), Items: items})
@@ -3003,6 +7817,10 @@ func (h *handlers) handleSrem(cmd *gedis_types.Command) error {
 		}
 	}
 
+	if removed > 0 {
+		h.db.notify(notifySet, "srem", key)
+		h.db.deleteIfEmpty(key)
+	}
 	if h.shouldWriteOutput(cmd) {
 		cmd.WriteAny(removed)
 	}
@@ -3045,155 +7863,260 @@ func (h *handlers) handleScard(cmd *gedis_types.Command) error {
 	return nil
 }
 
+// setInputs returns the sets stored at keys, missing keys being empty sets.
+func (h *handlers) setInputs(keys []string) []*data.Set {
+	sets := make([]*data.Set, len(keys))
+	for i, key := range keys {
+		set, exists := h.db.GetSet(key)
+		if !exists {
+			set = data.NewSet()
+		}
+		sets[i] = set
+	}
+	return sets
+}
+
+var setOpEvent = map[setOp]string{
+	opUnion: "sunionstore",
+	opInter: "sinterstore",
+	opDiff:  "sdiffstore",
+}
+
 func (h *handlers) handleSinter(cmd *gedis_types.Command) error {
+	return h.setAlgebra(cmd, opInter, false)
+}
+
+func (h *handlers) handleSinterstore(cmd *gedis_types.Command) error {
+	return h.setAlgebra(cmd, opInter, true)
+}
+
+func (h *handlers) handleSunion(cmd *gedis_types.Command) error {
+	return h.setAlgebra(cmd, opUnion, false)
+}
+
+func (h *handlers) handleSunionstore(cmd *gedis_types.Command) error {
+	return h.setAlgebra(cmd, opUnion, true)
+}
+
+func (h *handlers) handleSdiff(cmd *gedis_types.Command) error {
+	return h.setAlgebra(cmd, opDiff, false)
+}
+
+func (h *handlers) handleSdiffstore(cmd *gedis_types.Command) error {
+	return h.setAlgebra(cmd, opDiff, true)
+}
+
+// setAlgebra implements SINTER, SUNION and SDIFF and their STORE variants,
+// which take the destination first and reply with its size instead of the
+// members.
+func (h *handlers) setAlgebra(cmd *gedis_types.Command, op setOp, store bool) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
+	args := cmd.Cmd.Args
+	first := 0
+	if store {
+		first = 1
+	}
+	if len(args) < first+1 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	if store {
+		if err := h.checkSlaveWrite(cmd); err != nil {
+			return err
+		}
+	}
+
+	var dst string
+	if store {
+		var err error
+		if dst, err = parseBulkStr(args[0]); err != nil {
+			return err
+		}
+	}
+	keys, err := parseKeys(args[first:])
+	if err != nil {
+		return err
+	}
 
 	defer cmd.SetDone()
 	if h.checkInTx(cmd) {
 		return nil
 	}
 
-	args := cmd.Cmd.Args
-	if len(args) < 1 {
-		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
-	}
-
-	sets := make([]*data.Set, 0, len(args))
-	for _, arg := range args {
-		key, err := parseBulkStr(arg)
-		if err != nil {
-			return fmt.Errorf("invalid key: %s", arg)
+	sets := h.setInputs(keys)
+	var result *data.Set
+	switch op {
+	case opInter:
+		result = data.IntersectAll(sets)
+	case opUnion:
+		result = data.NewSet()
+		for _, set := range sets {
+			result = result.Union(set)
 		}
-
-		set, exists := h.db.GetSet(key)
-		if !exists {
-			cmd.WriteAny(resp.Array{Size: 0, Items: []any{}})
-			return nil
+	case opDiff:
+		result = sets[0]
+		for _, other := range sets[1:] {
+			result = result.Difference(other)
 		}
-		sets = append(sets, set)
 	}
 
-	if len(sets) == 0 {
-		cmd.WriteAny(resp.Array{Size: 0, Items: []any{}})
+	if !store {
+		cmd.WriteAny(membersArray(result.Members()))
 		return nil
 	}
 
-	result := sets[0]
-	for i := 1; i < len(sets); i++ {
-		result = result.Intersect(sets[i])
+	if result.IsEmpty() {
+		if h.db.Delete(dst) {
+			h.db.notify(notifyGeneric, "del", dst)
+		}
+	} else {
+		// a single SDIFF input is the stored set itself
+		if result == sets[0] {
+			result = result.Clone()
+		}
+		h.db.put(dst, entry{value: result})
+		h.db.notify(notifySet, setOpEvent[op], dst)
+	}
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(result.Len())
 	}
+	return nil
+}
 
-	members := result.Members()
-	items := make([]any, len(members))
+// membersArray returns the array of the bulk strings in members.
+func membersArray(members []string) resp.Array {
+	arr := resp.Array{Size: len(members), Items: make([]any, len(members))}
 	for i, member := range members {
-		items[i] = resp.BulkStr{Size: len(member), Value: member}
+		arr.Items[i] = resp.BulkStr{Size: len(member), Value: []byte(member)}
 	}
+	return arr
+}
 
-	cmd.WriteAny(resp.Array{Size: len(items), Items: items})
-	return nil
+func (h *handlers) handleSintercard(cmd *gedis_types.Command) error {
+	return h.intercard(cmd, func(keys []string, limit int) int {
+		return data.IntersectCard(h.setInputs(keys), limit)
+	})
 }
 
-func (h *handlers) handleSunion(cmd *gedis_types.Command) error {
+func (h *handlers) handleSmove(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
+	args := cmd.Cmd.Args
+	if len(args) != 3 {
+		return fmt.Errorf("%w: requires exactly 3 arguments", ErrInvalidArguments)
+	}
+
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
+	keys, err := parseKeys(args)
+	if err != nil {
+		return err
+	}
+	src, dst, member := keys[0], keys[1], keys[2]
 
 	defer cmd.SetDone()
 	if h.checkInTx(cmd) {
 		return nil
 	}
 
-	args := cmd.Cmd.Args
-	if len(args) < 1 {
-		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
-	}
-
-	sets := make([]*data.Set, 0, len(args))
-	for _, arg := range args {
-		key, err := parseBulkStr(arg)
-		if err != nil {
-			return fmt.Errorf("invalid key: %s", arg)
+	moved := 0
+	if set, exists := h.db.GetSet(src); exists && set.Contains(member) {
+		moved = 1
+		// moving within a set changes nothing
+		if src != dst {
+			set.Remove(member)
+			h.db.notify(notifySet, "srem", src)
+			h.db.deleteIfEmpty(src)
+			if h.db.GetOrCreateSet(dst).Add(member) {
+				h.db.notify(notifySet, "sadd", dst)
+			}
 		}
+	}
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(moved)
+	}
+	return nil
+}
 
-		set, exists := h.db.GetSet(key)
-		if !exists {
-			set = data.NewSet()
-		}
-		sets = append(sets, set)
+func (h *handlers) handleSmismember(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 2 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
 	}
 
-	if len(sets) == 0 {
-		cmd.WriteAny(resp.Array{Size: 0, Items: []any{}})
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
 		return nil
 	}
 
-	result := sets[0]
-	for i := 1; i < len(sets); i++ {
-		result = result.Union(sets[i])
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return err
+	}
+	members, err := parseKeys(args[1:])
+	if err != nil {
+		return err
 	}
 
-	members := result.Members()
+	set, exists := h.db.GetSet(key)
 	items := make([]any, len(members))
 	for i, member := range members {
-		items[i] = resp.BulkStr{Size: len(member), Value: member}
+		items[i] = 0
+		if exists && set.Contains(member) {
+			items[i] = 1
+		}
 	}
-
 	cmd.WriteAny(resp.Array{Size: len(items), Items: items})
 	return nil
 }
 
-func (h *handlers) handleSdiff(cmd *gedis_types.Command) error {
+func (h *handlers) handleSscan(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
-
-	defer cmd.SetDone()
-	if h.checkInTx(cmd) {
-		return nil
-	}
-
 	args := cmd.Cmd.Args
-	if len(args) < 1 {
+	if len(args) < 2 {
 		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
 	}
 
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
 	key, err := parseBulkStr(args[0])
 	if err != nil {
-		return fmt.Errorf("invalid key: %s", args[0])
+		return err
+	}
+	opts, err := parseScanOptions(args[1:], false)
+	if err != nil {
+		return err
 	}
 
 	set, exists := h.db.GetSet(key)
 	if !exists {
-		cmd.WriteAny(resp.Array{Size: 0, Items: []any{}})
+		cmd.WriteAny(scanReply(0, []any{}))
 		return nil
 	}
 
-	result := set
-	for i := 1; i < len(args); i++ {
-		key, err := parseBulkStr(args[i])
-		if err != nil {
-			return fmt.Errorf("invalid key: %s", args[i])
-		}
-
-		other, exists := h.db.GetSet(key)
-		if !exists {
-			continue
+	members, next := set.Scan(opts.cursor, opts.count)
+	items := make([]any, 0, len(members))
+	for _, member := range members {
+		if util.GlobMatch(opts.match, member) {
+			items = append(items, member)
 		}
-		result = result.Difference(other)
-	}
-
-	members := result.Members()
-	items := make([]any, len(members))
-	for i, member := range members {
-		items[i] = resp.BulkStr{Size: len(member), Value: member}
 	}
-
-	cmd.WriteAny(resp.Array{Size: len(items), Items: items})
+	cmd.WriteAny(scanReply(next, items))
 	return nil
 }
-
 func (h *handlers) handleSrandmember(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
@@ -3231,7 +8154,7 @@ func (h *handlers) handleSrandmember(cmd *gedis_types.Command) error {
 	members := set.RandomMembers(count)
 	items := make([]any, len(members))
 	for i, member := range members {
-		items[i] = resp.BulkStr{Size: len(member), Value: member}
+		items[i] = resp.BulkStr{Size: len(member), Value: []byte(member)}
 	}
 
 	if count == 1 && len(items) == 1 {
@@ -3281,9 +8204,13 @@ func (h *handlers) handleSpop(cmd *gedis_types.Command) error {
 	}
 
 	members := set.PopRandom(count)
+	if len(members) > 0 {
+		h.db.notify(notifySet, "spop", key)
+		h.db.deleteIfEmpty(key)
+	}
 	items := make([]any, len(members))
 	for i, member := range members {
-		items[i] = resp.BulkStr{Size: len(member), Value: member}
+		items[i] = resp.BulkStr{Size: len(member), Value: []byte(member)}
 	}
 
 	if count == 1 && len(items) == 1 {
@@ -3294,60 +8221,105 @@ func (h *handlers) handleSpop(cmd *gedis_types.Command) error {
 	return nil
 }
 
+// handleGeoAdd adds members at their positions, or moves the members
+// already there. NX only adds and XX only moves, CH counts the moved members
+// along with the added ones.
 func (h *handlers) handleGeoAdd(cmd *gedis_types.Command) error {
 	if cmd.IsSubMode() {
 		return h.subModeErr(cmd)
 	}
-
-	defer cmd.SetDone()
-	if h.checkInTx(cmd) {
-		return nil
-	}
-
 	args := cmd.Cmd.Args
-	if len(args) < 4 || (len(args)-1)%3 != 0 {
+	if len(args) < 4 {
 		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
 	}
 
+	if err := h.checkSlaveWrite(cmd); err != nil {
+		return err
+	}
+
 	key, err := parseBulkStr(args[0])
 	if err != nil {
 		return fmt.Errorf("invalid key: %s", key)
 	}
-
-	geoSet := h.db.GetOrCreateGeoIndex(key)
-
-	inserted := 0
-
-	for i := 1; i < len(args); i += 3 {
-		lon, err := parseFloat(args[i])
-		if err != nil {
-			return fmt.Errorf("invalid longitude: %s", args[i])
+	var nx, xx, ch bool
+	i := 1
+options:
+	for ; i < len(args); i += 1 {
+		opt, _ := parseStr(args[i])
+		switch strings.ToLower(opt) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "ch":
+			ch = true
+		default:
+			break options
 		}
+	}
+	triples := args[i:]
+	if len(triples) == 0 || len(triples)%3 != 0 {
+		return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+	}
+	if nx && xx {
+		return fmt.Errorf("XX and NX options at the same time are not compatible")
+	}
 
-		lat, err := parseFloat(args[i+1])
+	lons := make([]float64, 0, len(triples)/3)
+	lats := make([]float64, 0, len(triples)/3)
+	members := make([]string, 0, len(triples)/3)
+	for i := 0; i < len(triples); i += 3 {
+		lon, lat, err := parseGeoLonLat(triples[i], triples[i+1])
 		if err != nil {
-			return fmt.Errorf("invalid latitude: %s", args[i+1])
+			return err
 		}
-
-		member, err := parseBulkStr(args[i+2])
+		member, err := parseBulkStr(triples[i+2])
 		if err != nil {
-			return fmt.Errorf("invalid member: %s", args[i+2])
+			return fmt.Errorf("invalid member: %s", triples[i+2])
 		}
+		lons, lats, members = append(lons, lon), append(lats, lat), append(members, member)
+	}
 
-		isNew, err := geoSet.Add(member, lat, lon)
-		if err != nil {
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	// the key is only created once a member is added
+	geoSet, _ := h.db.GetGeoIndex(key)
+	added, moved := 0, 0
+	for i, member := range members {
+		var old float64
+		exists := false
+		if geoSet != nil {
+			old, exists = geoSet.SortedSet().Score(member)
+		}
+		if (nx && exists) || (xx && !exists) {
+			continue
+		}
+		if geoSet == nil {
+			geoSet = h.db.GetOrCreateGeoIndex(key)
+		}
+		if _, err := geoSet.Add(member, lats[i], lons[i]); err != nil {
 			return err
 		}
-
-		if !isNew {
-			inserted += 1
+		if !exists {
+			added += 1
+		} else if score, _ := geoSet.SortedSet().Score(member); score != old {
+			moved += 1
 		}
 	}
 
+	if added+moved > 0 {
+		h.db.notify(notifyZset, "zadd", key)
+	}
 	if h.shouldWriteOutput(cmd) {
-		cmd.WriteAny(inserted)
+		if ch {
+			cmd.WriteAny(added + moved)
+		} else {
+			cmd.WriteAny(added)
+		}
 	}
-
 	return nil
 }
 
@@ -3384,14 +8356,7 @@ func (h *handlers) handleGeoPos(cmd *gedis_types.Command) error {
 		if !ok {
 			items = append(items, resp.Array{Size: -1})
 		} else {
-			posArr := resp.Array{
-				Size: 2,
-				Items: []any{
-					fmt.Sprintf("%.6f", lon),
-					fmt.Sprintf("%.6f", lat),
-				},
-			}
-			items = append(items, posArr)
+			items = append(items, geoPosReply(lat, lon))
 		}
 	}
 
@@ -3438,92 +8403,505 @@ func (h *handlers) handleGeoDist(cmd *gedis_types.Command) error {
 
 	if h.shouldWriteOutput(cmd) {
 		distStr := fmt.Sprintf("%.6f", distance)
-		cmd.WriteAny(resp.BulkStr{Size: len(distStr), Value: distStr})
+		cmd.WriteAny(resp.BulkStr{Size: len(distStr), Value: []byte(distStr)})
 	}
 
 	return nil
 }
 
-func (h *handlers) handleGeoSearch(cmd *gedis_types.Command) error {
-	if cmd.IsSubMode() {
-		return h.subModeErr(cmd)
+// geoUnits are the meters in each unit the geo commands take.
+var geoUnits = map[string]float64{
+	"m":  1,
+	"km": 1000,
+	"ft": 0.3048,
+	"mi": 1609.34,
+}
+
+func parseGeoUnit(arg any) (float64, error) {
+	unit, _ := parseStr(arg)
+	meters, ok := geoUnits[strings.ToLower(unit)]
+	if !ok {
+		return 0, fmt.Errorf("unsupported unit provided. please use M, KM, FT, MI")
 	}
+	return meters, nil
+}
 
-	defer cmd.SetDone()
-	if h.checkInTx(cmd) {
+// parseGeoDistance parses the radius, width or height of a shape, which
+// cannot be negative.
+func parseGeoDistance(arg any, name string) (float64, error) {
+	dist, err := parseFloat(arg)
+	if err != nil {
+		return 0, fmt.Errorf("need numeric %s", name)
+	}
+	if dist < 0 {
+		return 0, fmt.Errorf("%s cannot be negative", name)
+	}
+	return dist, nil
+}
+
+// geoSyntax is what a geo search command takes on top of the options all
+// of them share.
+type geoSyntax struct {
+	name string
+	// search is set for the FROM and BY options of GEOSEARCH, the others
+	// take the center and the radius before their options
+	search bool
+	// store is set for the STORE and STOREDIST options of GEORADIUS,
+	// storeDist for the STOREDIST flag of GEOSEARCHSTORE
+	store     bool
+	storeDist bool
+}
+
+// geoSearchOptions are the options of GEOSEARCH and the commands like it.
+type geoSearchOptions struct {
+	query data.GeoQuery
+	// member is the center given with FROMMEMBER, looked up in the index
+	member     string
+	fromMember bool
+	fromLonLat bool
+	byRadius   bool
+	byBox      bool
+	// unit is the meters in the unit of the shape, which the distances are
+	// replied in
+	unit      float64
+	withCoord bool
+	withDist  bool
+	withHash  bool
+	// store is the key the results are stored at instead of replied, scored
+	// by their distance when storeDist
+	store     string
+	storeDist bool
+}
+
+// parseGeoLonLat parses a position, longitude first.
+func parseGeoLonLat(lonArg any, latArg any) (lon float64, lat float64, err error) {
+	if lon, err = parseFloat(lonArg); err != nil {
+		return 0, 0, errNotFloat
+	}
+	if lat, err = parseFloat(latArg); err != nil {
+		return 0, 0, errNotFloat
+	}
+	if lon < -180 || lon > 180 || lat < -85.05112878 || lat > 85.05112878 {
+		return 0, 0, fmt.Errorf("invalid longitude,latitude pair %f,%f", lon, lat)
+	}
+	return lon, lat, nil
+}
+
+// parseGeoRadius sets the radius of opts in the unit that follows it.
+func parseGeoRadius(opts *geoSearchOptions, radiusArg any, unitArg any) error {
+	radius, err := parseGeoDistance(radiusArg, "radius")
+	if err != nil {
+		return err
+	}
+	unit, err := parseGeoUnit(unitArg)
+	if err != nil {
+		return err
+	}
+	opts.query.Radius, opts.unit, opts.byRadius = radius*unit, unit, true
+	return nil
+}
+
+func parseGeoSearchOptions(args []any, syntax geoSyntax) (geoSearchOptions, error) {
+	opts := geoSearchOptions{unit: 1}
+	q := &opts.query
+	// need fails unless the option at i is followed by n arguments
+	need := func(i int, n int) error {
+		if i+n >= len(args) {
+			return fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+		}
+		return nil
+	}
+	for i := 0; i < len(args); i += 1 {
+		opt, _ := parseStr(args[i])
+		switch opt = strings.ToLower(opt); {
+		case opt == "frommember" && syntax.search:
+			if err := need(i, 1); err != nil {
+				return opts, err
+			}
+			member, err := parseBulkStr(args[i+1])
+			if err != nil {
+				return opts, err
+			}
+			opts.member, opts.fromMember = member, true
+			i += 1
+		case opt == "fromlonlat" && syntax.search:
+			if err := need(i, 2); err != nil {
+				return opts, err
+			}
+			lon, lat, err := parseGeoLonLat(args[i+1], args[i+2])
+			if err != nil {
+				return opts, err
+			}
+			q.Lon, q.Lat, opts.fromLonLat = lon, lat, true
+			i += 2
+		case opt == "byradius" && syntax.search:
+			if err := need(i, 2); err != nil {
+				return opts, err
+			}
+			if err := parseGeoRadius(&opts, args[i+1], args[i+2]); err != nil {
+				return opts, err
+			}
+			i += 2
+		case opt == "bybox" && syntax.search:
+			if err := need(i, 3); err != nil {
+				return opts, err
+			}
+			width, err := parseGeoDistance(args[i+1], "width")
+			if err != nil {
+				return opts, err
+			}
+			height, err := parseGeoDistance(args[i+2], "height")
+			if err != nil {
+				return opts, err
+			}
+			unit, err := parseGeoUnit(args[i+3])
+			if err != nil {
+				return opts, err
+			}
+			q.Box, q.Width, q.Height = true, width*unit, height*unit
+			opts.unit, opts.byBox = unit, true
+			i += 3
+		case opt == "asc":
+			q.Sort = data.GeoAsc
+		case opt == "desc":
+			q.Sort = data.GeoDesc
+		case opt == "count":
+			if err := need(i, 1); err != nil {
+				return opts, err
+			}
+			count, err := parseInt(args[i+1])
+			if err != nil {
+				return opts, errNotInteger
+			}
+			if count <= 0 {
+				return opts, fmt.Errorf("COUNT must be > 0")
+			}
+			q.Count = count
+			i += 1
+			if i+1 < len(args) {
+				if next, _ := parseStr(args[i+1]); strings.EqualFold(next, "any") {
+					q.Any = true
+					i += 1
+				}
+			}
+		case opt == "withcoord":
+			opts.withCoord = true
+		case opt == "withdist":
+			opts.withDist = true
+		case opt == "withhash":
+			opts.withHash = true
+		case opt == "storedist" && syntax.storeDist:
+			opts.storeDist = true
+		case (opt == "store" || opt == "storedist") && syntax.store:
+			if err := need(i, 1); err != nil {
+				return opts, err
+			}
+			store, err := parseBulkStr(args[i+1])
+			if err != nil {
+				return opts, err
+			}
+			opts.store, opts.storeDist = store, opt == "storedist"
+			i += 1
+		default:
+			return opts, fmt.Errorf("%w: syntax error", ErrInvalidArguments)
+		}
+	}
+
+	if syntax.search && opts.fromMember == opts.fromLonLat {
+		return opts, fmt.Errorf("exactly one of FROMMEMBER or FROMLONLAT can be specified for %s", syntax.name)
+	}
+	if syntax.search && opts.byRadius == opts.byBox {
+		return opts, fmt.Errorf("exactly one of BYRADIUS and BYBOX can be specified for %s", syntax.name)
+	}
+	if q.Any && q.Count == 0 {
+		return opts, fmt.Errorf("the ANY argument requires COUNT argument")
+	}
+	if (syntax.storeDist || opts.store != "") && (opts.withDist || opts.withHash || opts.withCoord) {
+		name := syntax.name
+		if !syntax.storeDist {
+			name = "STORE option in " + name
+		}
+		return opts, fmt.Errorf("%s is not compatible with WITHDIST, WITHHASH and WITHCOORD options", name)
+	}
+	return opts, nil
+}
+
+// geoSearch runs the search of opts on the geo index at key, finding nothing
+// when there is none.
+func (h *handlers) geoSearch(key string, opts geoSearchOptions) ([]data.GeoResult, error) {
+	index, exists := h.db.GetGeoIndex(key)
+	if !exists {
+		return nil, nil
+	}
+	q := opts.query
+	if opts.fromMember {
+		lat, lon, ok := index.Get(opts.member)
+		if !ok {
+			return nil, fmt.Errorf("could not decode requested zset member")
+		}
+		q.Lat, q.Lon = lat, lon
+	}
+	return index.Search(q), nil
+}
+
+// geoPosReply is the reply of a position, longitude first.
+func geoPosReply(lat, lon float64) resp.Array {
+	return resp.Array{
+		Size: 2,
+		Items: []any{
+			fmt.Sprintf("%.6f", lon),
+			fmt.Sprintf("%.6f", lat),
+		},
+	}
+}
+
+// geoSearchReply is the reply of the members found by a search, each along
+// with its distance, hash and position when asked.
+func geoSearchReply(results []data.GeoResult, opts geoSearchOptions) resp.Array {
+	items := make([]any, len(results))
+	for i, result := range results {
+		if !opts.withDist && !opts.withHash && !opts.withCoord {
+			items[i] = result.Member
+			continue
+		}
+		item := []any{result.Member}
+		if opts.withDist {
+			item = append(item, []byte(strconv.FormatFloat(result.Dist/opts.unit, 'f', 4, 64)))
+		}
+		if opts.withHash {
+			item = append(item, int(result.Hash))
+		}
+		if opts.withCoord {
+			item = append(item, geoPosReply(result.Lat, result.Lon))
+		}
+		items[i] = resp.Array{Size: len(item), Items: item}
+	}
+	return resp.Array{Size: len(items), Items: items}
+}
+
+// geoRespond runs the search of opts on the geo index at key and replies
+// with the results, or stores them at opts.store and replies with their
+// number, notifying event.
+func (h *handlers) geoRespond(cmd *gedis_types.Command, key string, opts geoSearchOptions, event string) error {
+	results, err := h.geoSearch(key, opts)
+	if err != nil {
+		return err
+	}
+	if opts.store == "" {
+		if h.shouldWriteOutput(cmd) {
+			cmd.WriteAny(geoSearchReply(results, opts))
+		}
 		return nil
 	}
 
+	if len(results) == 0 {
+		if h.db.Delete(opts.store) {
+			h.db.notify(notifyGeneric, "del", opts.store)
+		}
+	} else {
+		h.db.Delete(opts.store)
+		stored := h.db.GetOrCreateSortedSet(opts.store)
+		for _, result := range results {
+			score := float64(result.Hash)
+			if opts.storeDist {
+				score = result.Dist / opts.unit
+			}
+			stored.Insert(result.Member, score)
+		}
+		h.db.notify(notifyZset, event, opts.store)
+	}
+	if h.shouldWriteOutput(cmd) {
+		cmd.WriteAny(len(results))
+	}
+	return nil
+}
+
+// geosearch implements GEOSEARCH and GEOSEARCHSTORE, which takes the
+// destination before the key searched.
+func (h *handlers) geosearch(cmd *gedis_types.Command, store bool) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
 	args := cmd.Cmd.Args
-	if len(args) < 6 {
+	first := 0
+	if store {
+		first = 1
+	}
+	if len(args) < first+6 {
 		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
 	}
 
-	key, err := parseBulkStr(args[0])
+	syntax := geoSyntax{name: "GEOSEARCH", search: true}
+	var dst string
+	if store {
+		if err := h.checkSlaveWrite(cmd); err != nil {
+			return err
+		}
+		syntax = geoSyntax{name: "GEOSEARCHSTORE", search: true, storeDist: true}
+		var err error
+		if dst, err = parseBulkStr(args[0]); err != nil {
+			return err
+		}
+	}
+	key, err := parseBulkStr(args[first])
 	if err != nil {
 		return fmt.Errorf("invalid key: %s", key)
 	}
-
-	fromType, err := parseBulkStr(args[1])
-	if err != nil || strings.ToLower(fromType) != "fromlonlat" {
-		return fmt.Errorf("%w: expected FROMLONLAT", ErrInvalidArguments)
+	opts, err := parseGeoSearchOptions(args[first+1:], syntax)
+	if err != nil {
+		return err
 	}
+	opts.store = dst
 
-	lon, err := parseFloat(args[2])
-	if err != nil {
-		return fmt.Errorf("invalid longitude: %s", args[2])
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
 	}
+	return h.geoRespond(cmd, key, opts, "geosearchstore")
+}
 
-	lat, err := parseFloat(args[3])
-	if err != nil {
-		return fmt.Errorf("invalid latitude: %s", args[3])
+// handleGeoSearch replies with the members of the geo index at key within a
+// radius or a box around a member or a position. COUNT keeps the nearest
+// ones, or the farthest with DESC, unless ANY takes the first found.
+func (h *handlers) handleGeoSearch(cmd *gedis_types.Command) error {
+	return h.geosearch(cmd, false)
+}
+
+// handleGeoSearchStore stores what GEOSEARCH finds as a sorted set, scored
+// by the geohash of the members or by their distance with STOREDIST.
+func (h *handlers) handleGeoSearchStore(cmd *gedis_types.Command) error {
+	return h.geosearch(cmd, true)
+}
+
+// georadius implements the GEORADIUS family, which take the center, a
+// position or a member, and the radius before the options of GEOSEARCH. The
+// read only variants cannot STORE.
+// geoRadiusStores returns a function reporting whether the arguments of a
+// GEORADIUS command, its options starting at positional, have a STORE or
+// STOREDIST option.
+func geoRadiusStores(positional int) func(args []any) bool {
+	return func(args []any) bool {
+		for i := positional; i < len(args); i += 1 {
+			opt, err := parseStr(args[i])
+			if err != nil {
+				return false
+			}
+			switch strings.ToLower(opt) {
+			case "store", "storedist":
+				return true
+			case "count":
+				i += 1
+			}
+		}
+		return false
 	}
+}
 
-	// Parse BYRADIUS
-	byType, err := parseBulkStr(args[4])
-	if err != nil || strings.ToLower(byType) != "byradius" {
-		return fmt.Errorf("%w: expected BYRADIUS", ErrInvalidArguments)
+func (h *handlers) georadius(cmd *gedis_types.Command, byMember bool, readOnly bool) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	name, positional := "GEORADIUS", 5
+	if byMember {
+		name, positional = "GEORADIUSBYMEMBER", 4
+	}
+	if readOnly {
+		name += "_RO"
+	}
+	if len(args) < positional {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
 	}
 
-	radius, err := parseFloat(args[5])
+	key, err := parseBulkStr(args[0])
 	if err != nil {
-		return fmt.Errorf("invalid radius: %s", args[5])
+		return fmt.Errorf("invalid key: %s", key)
 	}
-
-	unit, err := parseBulkStr(args[6])
+	opts, err := parseGeoSearchOptions(args[positional:], geoSyntax{name: name, store: !readOnly})
 	if err != nil {
-		return fmt.Errorf("invalid unit: %s", args[6])
+		return err
+	}
+	if byMember {
+		if opts.member, err = parseBulkStr(args[1]); err != nil {
+			return err
+		}
+		opts.fromMember = true
+	} else {
+		if opts.query.Lon, opts.query.Lat, err = parseGeoLonLat(args[1], args[2]); err != nil {
+			return err
+		}
+		opts.fromLonLat = true
+	}
+	if err := parseGeoRadius(&opts, args[positional-2], args[positional-1]); err != nil {
+		return err
+	}
+	if opts.store != "" {
+		if err := h.checkSlaveWrite(cmd); err != nil {
+			return err
+		}
 	}
 
-	// Convert radius to meters (Haversine returns meters)
-	radiusInMeters := radius
-	switch strings.ToLower(unit) {
-	case "m":
-		radiusInMeters = radius
-	case "km":
-		radiusInMeters = radius * 1000
-	case "mi":
-		radiusInMeters = radius * 1609.34
-	case "ft":
-		radiusInMeters = radius * 0.3048
-	default:
-		return fmt.Errorf("unsupported unit '%s', use m, km, mi, or ft", unit)
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
 	}
+	return h.geoRespond(cmd, key, opts, "georadiusstore")
+}
 
-	geoSet := h.db.GetOrCreateGeoIndex(key)
+func (h *handlers) handleGeoRadius(cmd *gedis_types.Command) error {
+	return h.georadius(cmd, false, false)
+}
 
-	members, err := geoSet.SearchRadius(lat, lon, radiusInMeters)
+func (h *handlers) handleGeoRadiusRO(cmd *gedis_types.Command) error {
+	return h.georadius(cmd, false, true)
+}
+
+func (h *handlers) handleGeoRadiusByMember(cmd *gedis_types.Command) error {
+	return h.georadius(cmd, true, false)
+}
+
+func (h *handlers) handleGeoRadiusByMemberRO(cmd *gedis_types.Command) error {
+	return h.georadius(cmd, true, true)
+}
+
+// handleGeoHash replies with the standard geohash strings of the positions
+// of members, nil for those missing.
+func (h *handlers) handleGeoHash(cmd *gedis_types.Command) error {
+	if cmd.IsSubMode() {
+		return h.subModeErr(cmd)
+	}
+	args := cmd.Cmd.Args
+	if len(args) < 1 {
+		return fmt.Errorf("%w: not enough arguments", ErrInvalidArguments)
+	}
+
+	key, err := parseBulkStr(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid key: %s", key)
+	}
+	members, err := parseKeys(args[1:])
 	if err != nil {
 		return err
 	}
 
-	if h.shouldWriteOutput(cmd) {
-		items := make([]any, len(members))
-		for i, member := range members {
-			items[i] = member
+	defer cmd.SetDone()
+	if h.checkInTx(cmd) {
+		return nil
+	}
+
+	index, exists := h.db.GetGeoIndex(key)
+	items := make([]any, len(members))
+	for i, member := range members {
+		items[i] = resp.BulkStr{Size: -1}
+		if !exists {
+			continue
 		}
+		if hash, ok := index.Hash(member); ok {
+			items[i] = hash
+		}
+	}
+	if h.shouldWriteOutput(cmd) {
 		cmd.WriteAny(resp.Array{Size: len(items), Items: items})
 	}
-
 	return nil
 }
@@ -0,0 +1,371 @@
+package gedis
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	gedis_types "github.com/ttn-nguyen42/gedis/gedis/types"
+	"github.com/ttn-nguyen42/gedis/resp"
+)
+
+// testClient sends commands to an instance as a connection would, running
+// them right away in place of the core loop.
+type testClient struct {
+	inst  *Instance
+	state *gedis_types.ConnState
+}
+
+func newTestInstance(t *testing.T) *Instance {
+	inst, err := NewInstance(16)
+	if err != nil {
+		t.Fatalf("NewInstance() error = %v", err)
+	}
+	return inst
+}
+
+func newTestClient(inst *Instance) *testClient {
+	return &testClient{inst: inst, state: gedis_types.NewConnState(nil)}
+}
+
+// send runs the command made of args and returns it, its reply still empty
+// when the command blocked.
+func (c *testClient) send(args ...string) *gedis_types.Command {
+	items := make([]any, len(args)-1)
+	for i, arg := range args[1:] {
+		items[i] = resp.NewBulkStr([]byte(arg))
+	}
+	cmd := gedis_types.NewCommand(resp.BuildCommand(args[0], items...), c.state, "test")
+	c.inst.processCmd(context.Background(), cmd)
+	return cmd
+}
+
+// do runs the command made of args and returns its reply.
+func (c *testClient) do(args ...string) string {
+	return string(c.send(args...).Bytes())
+}
+
+// bulk returns the string a bulk string reply holds.
+func bulk(reply string) string {
+	header := strings.Index(reply, "\r\n")
+	return strings.TrimSuffix(reply[header+2:], "\r\n")
+}
+
+func TestRestore_BusyKey(t *testing.T) {
+	c := newTestClient(newTestInstance(t))
+	c.do("SET", "key", "value")
+	payload := bulk(c.do("DUMP", "key"))
+
+	if got := c.do("RESTORE", "key", "0", payload); got != "-BUSYKEY Target key name already exists.\r\n" {
+		t.Errorf("RESTORE on an existing key = %q", got)
+	}
+	if got := c.do("RESTORE", "key", "0", payload, "REPLACE"); got != "+OK\r\n" {
+		t.Errorf("RESTORE REPLACE = %q", got)
+	}
+}
+
+func TestSort_Replication(t *testing.T) {
+	inst := newTestInstance(t)
+	c := newTestClient(inst)
+	c.do("RPUSH", "list", "3", "1", "2")
+
+	for _, args := range [][]string{
+		{"SORT", "list"},
+		{"SORT", "list", "BY", "store", "GET", "store", "LIMIT", "0", "10"},
+		{"SORT_RO", "list", "DESC"},
+	} {
+		offset := inst.master.ReplOffset()
+		c.do(args...)
+		if got := inst.master.ReplOffset(); got != offset {
+			t.Errorf("%v replicated, offset went from %d to %d", args, offset, got)
+		}
+	}
+
+	offset := inst.master.ReplOffset()
+	if got := c.do("SORT", "list", "STORE", "sorted"); got != ":3\r\n" {
+		t.Fatalf("SORT STORE = %q", got)
+	}
+	if inst.master.ReplOffset() == offset {
+		t.Errorf("SORT STORE was not replicated")
+	}
+}
+
+func TestSwapDb_ServesBlocked(t *testing.T) {
+	inst := newTestInstance(t)
+	blocked, c := newTestClient(inst), newTestClient(inst)
+	blocked.do("SELECT", "1")
+	c.do("RPUSH", "list", "a")
+
+	blpop := blocked.send("BLPOP", "list", "0")
+	if blpop.IsDone() {
+		t.Fatalf("BLPOP on an empty database did not block, replied %q", blpop.Bytes())
+	}
+	offset := inst.master.ReplOffset()
+	if got := c.do("SWAPDB", "0", "1"); got != "+OK\r\n" {
+		t.Fatalf("SWAPDB = %q", got)
+	}
+	if got := string(blpop.Bytes()); got != "*2\r\n$4\r\nlist\r\n$1\r\na\r\n" {
+		t.Errorf("BLPOP once the list was swapped in = %q", got)
+	}
+
+	// the replicas get the SWAPDB, then the pop that served BLPOP
+	replicated := "*3\r\n$6\r\nSWAPDB\r\n$1\r\n0\r\n$1\r\n1\r\n" + "*2\r\n$4\r\nLPOP\r\n$4\r\nlist\r\n"
+	if got := inst.master.ReplOffset() - offset; got != int64(len(replicated)) {
+		t.Errorf("offset advanced by %d, expected %d", got, len(replicated))
+	}
+}
+
+func TestClientUnblock(t *testing.T) {
+	inst := newTestInstance(t)
+	blocked, c := newTestClient(inst), newTestClient(inst)
+	id := strconv.FormatInt(blocked.state.Id, 10)
+
+	tests := []struct {
+		reason   string
+		expected string
+	}{
+		{"TIMEOUT", "*-1\r\n"},
+		{"ERROR", "-UNBLOCKED client unblocked via CLIENT UNBLOCK\r\n"},
+	}
+	for _, tt := range tests {
+		blpop := blocked.send("BLPOP", "list", "0")
+		if got := c.do("CLIENT", "UNBLOCK", id, tt.reason); got != ":1\r\n" {
+			t.Fatalf("CLIENT UNBLOCK %s = %q", tt.reason, got)
+		}
+		if got := string(blpop.Bytes()); got != tt.expected {
+			t.Errorf("BLPOP unblocked with %s = %q, expected %q", tt.reason, got, tt.expected)
+		}
+	}
+	if got := c.do("CLIENT", "UNBLOCK", id); got != ":0\r\n" {
+		t.Errorf("CLIENT UNBLOCK on a client not blocked = %q", got)
+	}
+}
+
+func TestZscore_Infinity(t *testing.T) {
+	c := newTestClient(newTestInstance(t))
+	c.do("ZADD", "z", "-inf", "low", "+inf", "high", "1.5", "mid")
+
+	for member, expected := range map[string]string{"low": "-inf", "high": "inf", "mid": "1.5"} {
+		reply := "$" + strconv.Itoa(len(expected)) + "\r\n" + expected + "\r\n"
+		if got := c.do("ZSCORE", "z", member); got != reply {
+			t.Errorf("ZSCORE z %s = %q, expected %q", member, got, reply)
+		}
+		if got := c.do("ZMSCORE", "z", member); got != "*1\r\n"+reply {
+			t.Errorf("ZMSCORE z %s = %q, expected %q", member, got, "*1\r\n"+reply)
+		}
+		if got := c.do("ZINCRBY", "z", "0", member); got != reply {
+			t.Errorf("ZINCRBY z 0 %s = %q, expected %q", member, got, reply)
+		}
+	}
+}
+
+func TestZincrby_NaN(t *testing.T) {
+	c := newTestClient(newTestInstance(t))
+	c.do("ZADD", "z", "1", "a")
+
+	if got := c.do("ZINCRBY", "z", "+inf", "b"); got != "$3\r\ninf\r\n" {
+		t.Fatalf("ZINCRBY z +inf b = %q", got)
+	}
+	if got := c.do("ZINCRBY", "z", "-inf", "b"); got != "-ERR resulting score is not a number (NaN)\r\n" {
+		t.Errorf("ZINCRBY z -inf b = %q, expected a NaN error", got)
+	}
+	if got := c.do("ZINCRBY", "z", "nan", "a"); !strings.HasPrefix(got, "-ERR ") {
+		t.Errorf("ZINCRBY z nan a = %q, expected an error", got)
+	}
+	if got := c.do("ZRANGEBYSCORE", "z", "-inf", "+inf", "WITHSCORES"); got != "*4\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$3\r\ninf\r\n" {
+		t.Errorf("ZRANGEBYSCORE z -inf +inf WITHSCORES = %q", got)
+	}
+}
+
+func TestGeoRadius_Replication(t *testing.T) {
+	inst := newTestInstance(t)
+	c := newTestClient(inst)
+	c.do("GEOADD", "geo", "13.361389", "38.115556", "Palermo")
+
+	tests := []struct {
+		args       []string
+		replicated bool
+	}{
+		{[]string{"GEORADIUS", "geo", "15", "37", "200", "km", "COUNT", "1", "ASC"}, false},
+		{[]string{"GEORADIUSBYMEMBER", "geo", "Palermo", "10", "km", "WITHDIST"}, false},
+		{[]string{"GEORADIUS", "geo", "15", "37", "200", "km", "STORE", "dest"}, true},
+		{[]string{"GEORADIUSBYMEMBER", "geo", "Palermo", "10", "km", "STOREDIST", "dest"}, true},
+	}
+	for _, tt := range tests {
+		offset := inst.master.ReplOffset()
+		c.do(tt.args...)
+		if replicated := inst.master.ReplOffset() != offset; replicated != tt.replicated {
+			t.Errorf("%v replicated = %v, expected %v", tt.args, replicated, tt.replicated)
+		}
+	}
+}
+
+func TestSet_ReplacesAnyType(t *testing.T) {
+	c := newTestClient(newTestInstance(t))
+	wrongType := "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+
+	tests := []struct {
+		args     []string
+		expected string
+		// keys is the size of the database after the command, 0 when it
+		// must fail and leave the key as it was
+		keys int
+	}{
+		{[]string{"SET", "key", "v"}, "+OK\r\n", 1},
+		{[]string{"SET", "key", "v", "XX"}, "+OK\r\n", 1},
+		{[]string{"SET", "key", "v", "GET"}, wrongType, 0},
+		{[]string{"GETSET", "key", "v"}, wrongType, 0},
+		{[]string{"MSET", "other", "w", "key", "v"}, "+OK\r\n", 2},
+	}
+	for _, tt := range tests {
+		for _, create := range [][]string{{"RPUSH", "key", "x"}, {"HSET", "key", "f", "x"}} {
+			c.do("FLUSHDB")
+			c.do(create...)
+			if got := c.do(tt.args...); got != tt.expected {
+				t.Errorf("%v over %s = %q, expected %q", tt.args, create[0], got, tt.expected)
+			}
+			if tt.keys == 0 {
+				if c.do("LLEN", "key") == ":0\r\n" && c.do("HLEN", "key") == ":0\r\n" {
+					t.Errorf("%v over %s removed the %s", tt.args, create[0], create[0])
+				}
+				continue
+			}
+			if got := c.do("GET", "key"); got != "$1\r\nv\r\n" {
+				t.Errorf("GET after %v over %s = %q", tt.args, create[0], got)
+			}
+			if got := c.do("DBSIZE"); got != ":"+strconv.Itoa(tt.keys)+"\r\n" {
+				t.Errorf("DBSIZE after %v over %s = %q, expected %d", tt.args, create[0], got, tt.keys)
+			}
+			if c.do("LLEN", "key") != ":0\r\n" || c.do("HLEN", "key") != ":0\r\n" {
+				t.Errorf("%v over %s left the %s in place", tt.args, create[0], create[0])
+			}
+		}
+	}
+}
+
+// array returns the reply of an array of bulk strings, nil ones written as
+// "nil".
+func array(items ...string) string {
+	reply := "*" + strconv.Itoa(len(items)) + "\r\n"
+	for _, item := range items {
+		if item == "nil" {
+			reply += "$-1\r\n"
+			continue
+		}
+		reply += "$" + strconv.Itoa(len(item)) + "\r\n" + item + "\r\n"
+	}
+	return reply
+}
+
+func TestSort(t *testing.T) {
+	c := newTestClient(newTestInstance(t))
+	c.do("RPUSH", "list", "3", "1", "2", "10")
+	c.do("SADD", "set", "b", "a", "c")
+	c.do("ZADD", "zset", "3", "x", "1", "y", "2", "z")
+	for id, weight := range map[string]string{"1": "30", "2": "10", "3": "20"} {
+		c.do("SET", "weight_"+id, weight)
+		c.do("HSET", "obj_"+id, "rank", weight, "name", "n"+id)
+	}
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"SORT", "list"}, array("1", "2", "3", "10")},
+		{[]string{"SORT", "list", "DESC", "LIMIT", "1", "2"}, array("3", "2")},
+		{[]string{"SORT", "list", "ALPHA"}, array("1", "10", "2", "3")},
+		{[]string{"SORT", "list", "LIMIT", "3", "5"}, array("10")},
+		{[]string{"SORT", "list", "BY", "nosort"}, array("3", "1", "2", "10")},
+		{[]string{"SORT", "list", "BY", "weight_*"}, array("10", "2", "3", "1")},
+		{[]string{"SORT", "list", "BY", "obj_*->rank", "DESC"}, array("1", "3", "2", "10")},
+		{[]string{"SORT", "list", "BY", "weight_*", "GET", "#", "GET", "obj_*->name"},
+			array("10", "nil", "2", "n2", "3", "n3", "1", "n1")},
+		{[]string{"SORT", "set", "ALPHA", "DESC"}, array("c", "b", "a")},
+		{[]string{"SORT", "zset", "BY", "nosort"}, array("y", "z", "x")},
+		{[]string{"SORT", "zset", "BY", "nosort", "DESC", "LIMIT", "0", "2"}, array("x", "z")},
+		{[]string{"SORT", "zset", "ALPHA"}, array("x", "y", "z")},
+		{[]string{"SORT", "set"}, "-ERR One or more scores can't be converted into double\r\n"},
+		{[]string{"SORT", "missing"}, array()},
+		{[]string{"SORT_RO", "list", "STORE", "dest"}, "-ERR invalid arguments: syntax error\r\n"},
+	}
+	for _, tt := range tests {
+		if got := c.do(tt.args...); got != tt.expected {
+			t.Errorf("%v = %q, expected %q", tt.args, got, tt.expected)
+		}
+	}
+}
+
+func TestSort_Store(t *testing.T) {
+	c := newTestClient(newTestInstance(t))
+	c.do("RPUSH", "list", "3", "1", "2")
+	c.do("SADD", "set", "b", "a", "c")
+	c.do("ZADD", "zset", "3", "x", "1", "y", "2", "z")
+	c.do("HSET", "obj_1", "name", "one")
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"SORT", "list", "STORE", "dest"}, array("1", "2", "3")},
+		// a set has no order of its own, BY nosort still stores it sorted
+		{[]string{"SORT", "set", "BY", "nosort", "STORE", "dest"}, array("a", "b", "c")},
+		{[]string{"SORT", "zset", "BY", "nosort", "DESC", "STORE", "dest"}, array("x", "z", "y")},
+		// missing GET keys are stored as empty strings
+		{[]string{"SORT", "list", "GET", "obj_*->name", "STORE", "dest"}, array("one", "", "")},
+	}
+	for _, tt := range tests {
+		c.do("SET", "dest", "replaced")
+		if got, n := c.do(tt.args...), strings.Count(tt.expected, "$"); got != ":"+strconv.Itoa(n)+"\r\n" {
+			t.Errorf("%v = %q, expected %d", tt.args, got, n)
+		}
+		if got := c.do("LRANGE", "dest", "0", "-1"); got != tt.expected {
+			t.Errorf("LRANGE dest after %v = %q, expected %q", tt.args, got, tt.expected)
+		}
+	}
+
+	if got := c.do("SORT", "missing", "STORE", "dest"); got != ":0\r\n" {
+		t.Errorf("SORT missing STORE dest = %q", got)
+	}
+	if got := c.do("EXISTS", "dest"); got != ":0\r\n" {
+		t.Errorf("an empty SORT STORE left dest, EXISTS = %q", got)
+	}
+}
+
+func TestIncr_Overflow(t *testing.T) {
+	c := newTestClient(newTestInstance(t))
+	maxInt, minInt := strconv.FormatInt(math.MaxInt64, 10), strconv.FormatInt(math.MinInt64, 10)
+
+	tests := []struct {
+		value    string
+		args     []string
+		expected string
+	}{
+		{maxInt, []string{"INCR", "n"}, "-ERR increment or decrement would overflow\r\n"},
+		{maxInt, []string{"INCRBY", "n", "1"}, "-ERR increment or decrement would overflow\r\n"},
+		{minInt, []string{"DECR", "n"}, "-ERR increment or decrement would overflow\r\n"},
+		{"0", []string{"DECRBY", "n", minInt}, "-ERR decrement would overflow\r\n"},
+		{"-1", []string{"INCRBY", "n", minInt}, "-ERR increment or decrement would overflow\r\n"},
+		{"1.5", []string{"INCRBYFLOAT", "n", "inf"}, ""},
+		{"1.5", []string{"INCRBYFLOAT", "n", "1e5000"}, ""},
+		{"1.5", []string{"INCRBYFLOAT", "n", "abc"}, ""},
+		{"abc", []string{"INCRBYFLOAT", "n", "1"}, ""},
+		{"abc", []string{"INCR", "n"}, ""},
+	}
+	for _, tt := range tests {
+		c.do("SET", "n", tt.value)
+		got := c.do(tt.args...)
+		if !strings.HasPrefix(got, "-ERR ") || (tt.expected != "" && got != tt.expected) {
+			t.Errorf("%v on %s = %q, expected an error", tt.args, tt.value, got)
+		}
+		if got := c.do("GET", "n"); bulk(got) != tt.value {
+			t.Errorf("GET after %v on %s = %q, expected it untouched", tt.args, tt.value, got)
+		}
+	}
+
+	c.do("SET", "n", strconv.FormatInt(math.MaxInt64-1, 10))
+	if got := c.do("INCR", "n"); got != ":"+maxInt+"\r\n" {
+		t.Errorf("INCR up to the largest integer = %q", got)
+	}
+}
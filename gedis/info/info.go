@@ -257,6 +257,7 @@ func (s *Server) GetRedisVersion() string {
 type Clients struct {
 	mu               sync.RWMutex
 	ConnectedClients int `resp:"connected_clients"`
+	BlockedClients   int `resp:"blocked_clients"`
 }
 
 func (c *Clients) SetConnectedClients(count int) {
@@ -287,6 +288,22 @@ func (c *Clients) DecrConnectedClients() int {
 	return c.ConnectedClients
 }
 
+func (c *Clients) IncrBlockedClients() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.BlockedClients++
+	return c.BlockedClients
+}
+
+func (c *Clients) DecrBlockedClients() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.BlockedClients > 0 {
+		c.BlockedClients--
+	}
+	return c.BlockedClients
+}
+
 func (c *Clients) String() string {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -0,0 +1,109 @@
+package gedis
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Keyspace event classes, one bit per flag letter of notify-keyspace-events.
+const (
+	notifyKeyspace = 1 << iota // K
+	notifyKeyevent             // E
+	notifyGeneric              // g
+	notifyString               // $
+	notifyList                 // l
+	notifySet                  // s
+	notifyHash                 // h
+	notifyZset                 // z
+	notifyExpired              // x
+	notifyEvicted              // e
+
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet |
+		notifyHash | notifyZset | notifyExpired | notifyEvicted
+)
+
+var keyspaceEventFlags = []struct {
+	flag  byte
+	class int
+}{
+	{'g', notifyGeneric},
+	{'$', notifyString},
+	{'l', notifyList},
+	{'s', notifySet},
+	{'h', notifyHash},
+	{'z', notifyZset},
+	{'x', notifyExpired},
+	{'e', notifyEvicted},
+	{'K', notifyKeyspace},
+	{'E', notifyKeyevent},
+}
+
+func parseKeyspaceEvents(value string) (int, error) {
+	flags := 0
+	for i := 0; i < len(value); i += 1 {
+		if value[i] == 'A' {
+			flags |= notifyAll
+			continue
+		}
+		found := false
+		for _, f := range keyspaceEventFlags {
+			if f.flag == value[i] {
+				flags |= f.class
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("invalid event class character '%c'", value[i])
+		}
+	}
+	return flags, nil
+}
+
+func keyspaceEventsString(flags int) string {
+	var sb strings.Builder
+	for _, f := range keyspaceEventFlags {
+		if flags&notifyAll == notifyAll && f.class&notifyAll != 0 {
+			if f.class == notifyGeneric {
+				sb.WriteByte('A')
+			}
+			continue
+		}
+		if flags&f.class != 0 {
+			sb.WriteByte(f.flag)
+		}
+	}
+	return sb.String()
+}
+
+// notifier publishes keyspace events through pubsub, following the classes
+// enabled by notify-keyspace-events.
+type notifier struct {
+	ps  *pubsub
+	cfg *config
+}
+
+func newNotifier(ps *pubsub, cfg *config) *notifier {
+	return &notifier{
+		ps:  ps,
+		cfg: cfg,
+	}
+}
+
+// notify publishes event for key in database db, to the
+// __keyspace@<db>__:<key> channel when K is enabled and to the
+// __keyevent@<db>__:<event> channel when E is enabled.
+func (n *notifier) notify(db int, class int, event string, key any) {
+	flags := n.cfg.notifyKeyspaceEvents
+	if flags&class == 0 {
+		return
+	}
+	if flags&notifyKeyspace != 0 {
+		channel := fmt.Sprintf("__keyspace@%d__:%v", db, key)
+		n.ps.publish(channel, event)
+	}
+	if flags&notifyKeyevent != 0 {
+		channel := fmt.Sprintf("__keyevent@%d__:%s", db, event)
+		n.ps.publish(channel, fmt.Sprint(key))
+	}
+}
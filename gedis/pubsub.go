@@ -28,9 +28,14 @@ func newPubsub() *pubsub {
 }
 
 func (p *pubsub) publish(channel string, message any) int {
+	count := p.channelSubs(channel)
+	if count == 0 {
+		// nobody is listening, the message is dropped
+		return 0
+	}
 	q := p.initChannel(channel)
 	q.Enqueue(message)
-	return p.channelSubs(channel)
+	return count
 }
 
 func (p *pubsub) channelSubs(channel string) int {
@@ -102,22 +107,28 @@ func (p *pubsub) resolveSubs() {
 		if qu.IsEmpty() {
 			continue
 		}
-		message, _ := qu.Dequeue()
-		arr := p.produceMessage(channel, message)
 		remv := make(map[string]struct{}, 0)
 
-		for _, sub := range subscribers {
-			n, err := resp.WriteAnyTo(arr, sub.conn)
-			if err != nil {
-				if util.IsDisconnected(err) {
-					log.Printf("subscriber disconnected, id=%s, addr=%s", sub.id, sub.conn.RemoteAddr())
-					remv[sub.id] = struct{}{}
+		for !qu.IsEmpty() {
+			message, _ := qu.Dequeue()
+			arr := p.produceMessage(channel, message)
+
+			for _, sub := range subscribers {
+				if _, gone := remv[sub.id]; gone {
+					continue
+				}
+				n, err := resp.WriteAnyTo(arr, sub.conn)
+				if err != nil {
+					if util.IsDisconnected(err) {
+						log.Printf("subscriber disconnected, id=%s, addr=%s", sub.id, sub.conn.RemoteAddr())
+						remv[sub.id] = struct{}{}
+						continue
+					}
+					log.Printf("failed to write pubsub message to subscriber, id=%s, addr=%s, err=%s", sub.id, sub.conn.RemoteAddr(), err)
 					continue
 				}
-				log.Printf("failed to write pubsub message to subscriber, id=%s, addr=%s, err=%s", sub.id, sub.conn.RemoteAddr(), err)
-				continue
+				log.Printf("delivered message to subscriber, id=%s, addr=%s, n=%d", sub.id, sub.conn.RemoteAddr(), n)
 			}
-			log.Printf("delivered message to subscriber, id=%s, addr=%s, n=%d", sub.id, sub.conn.RemoteAddr(), n)
 		}
 
 		p.subs[channel] = slices.DeleteFunc(subscribers, func(s *sub) bool {
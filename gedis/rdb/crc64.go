@@ -0,0 +1,27 @@
+package rdb
+
+var crcTable = func() [256]uint64 {
+	const poly = 0x95ac9329ac4bc9b5 // 0xad93d23594c935a9 bit reversed
+	var table [256]uint64
+	for i := 0; i < 256; i += 1 {
+		crc := uint64(i)
+		for j := 0; j < 8; j += 1 {
+			if crc&1 == 1 {
+				crc = crc>>1 ^ poly
+			} else {
+				crc >>= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+// crc64 computes the Jones CRC-64 Redis appends to DUMP payloads: reflected
+// polynomial 0xad93d23594c935a9, zero initial value and no final xor.
+func crc64(crc uint64, b []byte) uint64 {
+	for _, c := range b {
+		crc = crcTable[byte(crc)^c] ^ crc>>8
+	}
+	return crc
+}
@@ -0,0 +1,484 @@
+// Package rdb serializes single values in the format of Redis' DUMP and
+// RESTORE commands: the RDB encoding of the value, a two byte RDB version
+// and a CRC-64 of everything before it.
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ttn-nguyen42/gedis/data"
+)
+
+const (
+	// Version is the RDB version written in payloads. Dumps only use types
+	// that Redis has been able to load since this version, except for hashes
+	// with field TTLs which need VersionFieldTTL.
+	Version = 9
+	// VersionFieldTTL is the RDB version of Redis 7.4, which added hashes
+	// with field TTLs.
+	VersionFieldTTL = 12
+	// MaxVersion is the newest RDB version accepted by Restore, the one of
+	// Redis 7.4.
+	MaxVersion = 12
+)
+
+// Value types, the first byte of a payload.
+const (
+	typeString         = 0
+	typeList           = 1
+	typeSet            = 2
+	typeZset           = 3
+	typeHash           = 4
+	typeZset2          = 5
+	typeListZiplist    = 10
+	typeSetIntset      = 11
+	typeZsetZiplist    = 12
+	typeHashZiplist    = 13
+	typeListQuicklist  = 14
+	typeHashListpack   = 16
+	typeZsetListpack   = 17
+	typeListQuicklist2 = 18
+	typeSetListpack    = 20
+	typeHashMetadata   = 24
+	typeHashListpackEx = 25
+)
+
+// Containers of the nodes of a version 2 quicklist.
+const (
+	quicklistNodePlain  = 1
+	quicklistNodePacked = 2
+)
+
+var (
+	ErrBadFormat  = errors.New("Bad data format")
+	ErrBadPayload = errors.New("DUMP payload version or checksum are wrong")
+)
+
+// Dump serializes value, a string or one of the data containers.
+func Dump(value any) ([]byte, error) {
+	w := &writer{}
+	version := uint16(Version)
+	switch v := value.(type) {
+	case *data.LinkedList:
+		w.writeByte(typeList)
+		items := v.LeftRange(0, -1)
+		w.writeLen(uint64(len(items)))
+		for _, item := range items {
+			str, ok := stringOf(item)
+			if !ok {
+				return nil, fmt.Errorf("cannot dump list element of type %T", item)
+			}
+			w.writeString(str)
+		}
+	case *data.Set:
+		w.writeByte(typeSet)
+		members := v.Members()
+		w.writeLen(uint64(len(members)))
+		for _, member := range members {
+			w.writeString(member)
+		}
+	case *data.SortedSet[float64]:
+		w.writeByte(typeZset2)
+		nodes := v.Range(0, v.Len())
+		w.writeLen(uint64(len(nodes)))
+		// from the greatest to the smallest, like Redis
+		for i := len(nodes) - 1; i >= 0; i -= 1 {
+			w.writeString(nodes[i].Value)
+			w.writeBinaryDouble(nodes[i].Score)
+		}
+	case *data.Hash:
+		if v.Volatile() {
+			version = VersionFieldTTL
+			if err := w.writeHashMetadata(v); err != nil {
+				return nil, err
+			}
+			break
+		}
+		w.writeByte(typeHash)
+		fields := v.Fields()
+		w.writeLen(uint64(len(fields)))
+		for _, field := range fields {
+			value, _ := v.Get(field)
+			str, ok := stringOf(value)
+			if !ok {
+				return nil, fmt.Errorf("cannot dump hash value of type %T", value)
+			}
+			w.writeString(field)
+			w.writeString(str)
+		}
+	default:
+		str, ok := stringOf(v)
+		if !ok {
+			return nil, fmt.Errorf("cannot dump value of type %T", v)
+		}
+		w.writeByte(typeString)
+		w.writeString(str)
+	}
+
+	w.buf = binary.LittleEndian.AppendUint16(w.buf, version)
+	w.buf = binary.LittleEndian.AppendUint64(w.buf, crc64(0, w.buf))
+	return w.buf, nil
+}
+
+// writeHashMetadata writes a hash with field TTLs. The earliest expiry comes
+// first, then every field is preceded by its expiry relative to it, plus one
+// so that 0 can stand for no TTL.
+func (w *writer) writeHashMetadata(hash *data.Hash) error {
+	fields := hash.Fields()
+	var minExpiry time.Time
+	for _, field := range fields {
+		if at, ok := hash.ExpiresAt(field); ok && (minExpiry.IsZero() || at.Before(minExpiry)) {
+			minExpiry = at
+		}
+	}
+
+	w.writeByte(typeHashMetadata)
+	w.writeMillis(minExpiry)
+	w.writeLen(uint64(len(fields)))
+	for _, field := range fields {
+		value, _ := hash.Get(field)
+		str, ok := stringOf(value)
+		if !ok {
+			return fmt.Errorf("cannot dump hash value of type %T", value)
+		}
+		ttl := uint64(0)
+		if at, ok := hash.ExpiresAt(field); ok {
+			ttl = uint64(at.UnixMilli()-minExpiry.UnixMilli()) + 1
+		}
+		w.writeLen(ttl)
+		w.writeString(field)
+		w.writeString(str)
+	}
+	return nil
+}
+
+// Restore decodes a payload made by Dump or by Redis. Strings are returned as
+// []byte, other types as their data container.
+func Restore(payload []byte) (any, error) {
+	if len(payload) < 10 {
+		return nil, ErrBadPayload
+	}
+	footer := len(payload) - 10
+	version := binary.LittleEndian.Uint16(payload[footer:])
+	if version > MaxVersion {
+		return nil, ErrBadPayload
+	}
+	checksum := binary.LittleEndian.Uint64(payload[footer+2:])
+	if crc64(0, payload[:footer+2]) != checksum {
+		return nil, ErrBadPayload
+	}
+
+	r := &reader{b: payload[:footer]}
+	value, err := r.readValue()
+	if err != nil {
+		return nil, err
+	}
+	if r.pos != len(r.b) {
+		return nil, ErrBadFormat
+	}
+	return value, nil
+}
+
+func (r *reader) readValue() (any, error) {
+	typ, err := r.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case typeString:
+		str, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		return []byte(str), nil
+	case typeList:
+		items, err := r.readStrings(1)
+		if err != nil {
+			return nil, err
+		}
+		return newList(items), nil
+	case typeListZiplist:
+		items, err := r.readPacked(decodeZiplist)
+		if err != nil {
+			return nil, err
+		}
+		return newList(items), nil
+	case typeListQuicklist, typeListQuicklist2:
+		items, err := r.readQuicklist(typ == typeListQuicklist2)
+		if err != nil {
+			return nil, err
+		}
+		return newList(items), nil
+	case typeSet:
+		members, err := r.readStrings(1)
+		if err != nil {
+			return nil, err
+		}
+		return newSet(members), nil
+	case typeSetIntset:
+		members, err := r.readPacked(decodeIntset)
+		if err != nil {
+			return nil, err
+		}
+		return newSet(members), nil
+	case typeSetListpack:
+		members, err := r.readPacked(decodeListpack)
+		if err != nil {
+			return nil, err
+		}
+		return newSet(members), nil
+	case typeZset, typeZset2:
+		return r.readZset(typ == typeZset2)
+	case typeZsetZiplist:
+		entries, err := r.readPacked(decodeZiplist)
+		if err != nil {
+			return nil, err
+		}
+		return newSortedSet(entries)
+	case typeZsetListpack:
+		entries, err := r.readPacked(decodeListpack)
+		if err != nil {
+			return nil, err
+		}
+		return newSortedSet(entries)
+	case typeHash:
+		entries, err := r.readStrings(2)
+		if err != nil {
+			return nil, err
+		}
+		return newHash(entries)
+	case typeHashZiplist:
+		entries, err := r.readPacked(decodeZiplist)
+		if err != nil {
+			return nil, err
+		}
+		return newHash(entries)
+	case typeHashListpack:
+		entries, err := r.readPacked(decodeListpack)
+		if err != nil {
+			return nil, err
+		}
+		return newHash(entries)
+	case typeHashMetadata:
+		return r.readHashMetadata()
+	case typeHashListpackEx:
+		// the earliest expiry is only a hint, every entry has its own
+		if _, err := r.readMillis(); err != nil {
+			return nil, err
+		}
+		entries, err := r.readPacked(decodeListpack)
+		if err != nil {
+			return nil, err
+		}
+		return newHashEx(entries)
+	}
+	return nil, ErrBadFormat
+}
+
+// readStrings reads a count of groups followed by count*width strings.
+func (r *reader) readStrings(width int) ([]string, error) {
+	n, err := r.readCount()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]string, 0, n*width)
+	for i := 0; i < n*width; i += 1 {
+		item, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// readPacked reads a string holding one of the compact encodings and
+// decodes it.
+func (r *reader) readPacked(decode func([]byte) ([]string, error)) ([]string, error) {
+	blob, err := r.readString()
+	if err != nil {
+		return nil, err
+	}
+	return decode([]byte(blob))
+}
+
+// readQuicklist reads the nodes of a quicklist, ziplists for the original
+// type and plain or listpack nodes for the second version.
+func (r *reader) readQuicklist(v2 bool) ([]string, error) {
+	n, err := r.readCount()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]string, 0, n)
+	for i := 0; i < n; i += 1 {
+		container := uint64(quicklistNodePacked)
+		if v2 {
+			container, _, err = r.readLen()
+			if err != nil {
+				return nil, err
+			}
+		}
+		blob, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case container == quicklistNodePlain:
+			items = append(items, blob)
+			continue
+		case container != quicklistNodePacked:
+			return nil, ErrBadFormat
+		}
+		decode := decodeZiplist
+		if v2 {
+			decode = decodeListpack
+		}
+		entries, err := decode([]byte(blob))
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, entries...)
+	}
+	return items, nil
+}
+
+func (r *reader) readZset(binaryScores bool) (any, error) {
+	n, err := r.readCount()
+	if err != nil {
+		return nil, err
+	}
+	ss := data.NewSortedSet[float64]()
+	for i := 0; i < n; i += 1 {
+		member, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		var score float64
+		if binaryScores {
+			score, err = r.readBinaryDouble()
+		} else {
+			score, err = r.readDouble()
+		}
+		if err != nil {
+			return nil, err
+		}
+		ss.Insert(member, score)
+	}
+	return ss, nil
+}
+
+// readHashMetadata reads a hash written by writeHashMetadata.
+func (r *reader) readHashMetadata() (*data.Hash, error) {
+	minExpiry, err := r.readMillis()
+	if err != nil {
+		return nil, err
+	}
+	n, err := r.readCount()
+	if err != nil {
+		return nil, err
+	}
+	hash := data.NewHash()
+	for i := 0; i < n; i += 1 {
+		ttl, _, err := r.readLen()
+		if err != nil {
+			return nil, err
+		}
+		field, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		value, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		hash.Set(field, []byte(value))
+		if ttl > 0 {
+			hash.ExpireAt(field, minExpiry.Add(time.Duration(ttl-1)*time.Millisecond))
+		}
+	}
+	return hash, nil
+}
+
+func newList(items []string) *data.LinkedList {
+	list := data.NewLinkedList()
+	for _, item := range items {
+		list.RightPush([]byte(item))
+	}
+	return list
+}
+
+func newSet(members []string) *data.Set {
+	set := data.NewSet()
+	for _, member := range members {
+		set.Add(member)
+	}
+	return set
+}
+
+// newSortedSet builds a sorted set from the member and score pairs of a
+// packed encoding.
+func newSortedSet(entries []string) (*data.SortedSet[float64], error) {
+	if len(entries)%2 != 0 {
+		return nil, ErrBadFormat
+	}
+	ss := data.NewSortedSet[float64]()
+	for i := 0; i < len(entries); i += 2 {
+		score, err := strconv.ParseFloat(entries[i+1], 64)
+		if err != nil {
+			return nil, ErrBadFormat
+		}
+		ss.Insert(entries[i], score)
+	}
+	return ss, nil
+}
+
+// newHash builds a hash from field and value pairs.
+func newHash(entries []string) (*data.Hash, error) {
+	if len(entries)%2 != 0 {
+		return nil, ErrBadFormat
+	}
+	hash := data.NewHash()
+	for i := 0; i < len(entries); i += 2 {
+		hash.Set(entries[i], []byte(entries[i+1]))
+	}
+	return hash, nil
+}
+
+// newHashEx builds a hash from field, value and expiry triplets, the expiry
+// being a unix time in milliseconds or 0 for none.
+func newHashEx(entries []string) (*data.Hash, error) {
+	if len(entries)%3 != 0 {
+		return nil, ErrBadFormat
+	}
+	hash := data.NewHash()
+	for i := 0; i < len(entries); i += 3 {
+		millis, err := strconv.ParseInt(entries[i+2], 10, 64)
+		if err != nil {
+			return nil, ErrBadFormat
+		}
+		hash.Set(entries[i], []byte(entries[i+1]))
+		if millis > 0 {
+			hash.ExpireAt(entries[i], time.UnixMilli(millis))
+		}
+	}
+	return hash, nil
+}
+
+func stringOf(v any) (string, bool) {
+	switch val := v.(type) {
+	case string:
+		return val, true
+	case []byte:
+		return string(val), true
+	case int:
+		return strconv.Itoa(val), true
+	case int64:
+		return strconv.FormatInt(val, 10), true
+	}
+	return "", false
+}
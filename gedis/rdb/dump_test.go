@@ -0,0 +1,347 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ttn-nguyen42/gedis/data"
+)
+
+// withFooter appends the RDB version and checksum to a serialized value.
+func withFooter(body []byte, version uint16) []byte {
+	b := binary.LittleEndian.AppendUint16(slices.Clone(body), version)
+	return binary.LittleEndian.AppendUint64(b, crc64(0, b))
+}
+
+// listpack builds a listpack out of already encoded entries, adding the
+// back length of each.
+func listpack(entries ...[]byte) []byte {
+	b := make([]byte, 6)
+	for _, e := range entries {
+		b = append(b, e...)
+		b = append(b, byte(len(e)))
+	}
+	b = append(b, 0xff)
+	binary.LittleEndian.PutUint32(b, uint32(len(b)))
+	binary.LittleEndian.PutUint16(b[4:], uint16(len(entries)))
+	return b
+}
+
+func lpStr(s string) []byte {
+	return append([]byte{0x80 | byte(len(s))}, s...)
+}
+
+// rdbStr serializes a short string with a 6 bit length prefix.
+func rdbStr(b []byte) []byte {
+	return append([]byte{byte(len(b))}, b...)
+}
+
+func listValues(t *testing.T, v any) []string {
+	t.Helper()
+	list, ok := v.(*data.LinkedList)
+	if !ok {
+		t.Fatalf("expected a list, got %T", v)
+	}
+	values := make([]string, 0, list.Len())
+	for _, item := range list.LeftRange(0, -1) {
+		values = append(values, string(item))
+	}
+	return values
+}
+
+func TestCrc64(t *testing.T) {
+	if got := crc64(0, []byte("123456789")); got != 0xe9c6d914c4b8d9ca {
+		t.Errorf("crc64() = %x, want e9c6d914c4b8d9ca", got)
+	}
+}
+
+func TestRestore_RedisPayload(t *testing.T) {
+	// DUMP of the value 10 taken from a Redis 7.0 server
+	payload := []byte("\x00\xc0\n\n\x00n\x9fWE\x0e\xaec\xbb")
+	v, err := Restore(payload)
+	if err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if got := string(v.([]byte)); got != "10" {
+		t.Errorf("Restore() = %q, want %q", got, "10")
+	}
+}
+
+func TestRestore_BadPayload(t *testing.T) {
+	good, err := Dump([]byte("hello"))
+	if err != nil {
+		t.Fatalf("Dump() error = %v", err)
+	}
+
+	corrupted := slices.Clone(good)
+	corrupted[2] ^= 0xff
+	newer := withFooter(good[:len(good)-10], MaxVersion+1)
+
+	for name, payload := range map[string][]byte{
+		"checksum": corrupted,
+		"version":  newer,
+		"short":    good[:5],
+	} {
+		if _, err := Restore(payload); !errors.Is(err, ErrBadPayload) {
+			t.Errorf("%s: Restore() error = %v, want %v", name, err, ErrBadPayload)
+		}
+	}
+
+	trailing := withFooter(append([]byte{typeString, 0x01, 'a'}, 'b'), Version)
+	if _, err := Restore(trailing); !errors.Is(err, ErrBadFormat) {
+		t.Errorf("Restore() with trailing bytes error = %v, want %v", err, ErrBadFormat)
+	}
+}
+
+func TestDump_RoundTrip(t *testing.T) {
+	long := make([]byte, 20000)
+	for i := range long {
+		long[i] = byte(i)
+	}
+
+	t.Run("strings", func(t *testing.T) {
+		for _, s := range []string{"", "10", "-129", "40000", "-2147483648", "4294967296", "010", string(long)} {
+			payload, err := Dump([]byte(s))
+			if err != nil {
+				t.Fatalf("Dump() error = %v", err)
+			}
+			v, err := Restore(payload)
+			if err != nil {
+				t.Fatalf("Restore() error = %v", err)
+			}
+			if got := string(v.([]byte)); got != s {
+				t.Errorf("round trip of %.20q gave %.20q", s, got)
+			}
+		}
+	})
+
+	t.Run("integer encoding", func(t *testing.T) {
+		payload, _ := Dump([]byte("10"))
+		if want := []byte{typeString, 0xc0, 10, Version, 0}; !slices.Equal(payload[:5], want) {
+			t.Errorf("Dump() = %x, want prefix %x", payload, want)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		list := data.NewLinkedList()
+		for _, s := range []string{"a", "1", string(long), "b"} {
+			list.RightPush([]byte(s))
+		}
+		payload, err := Dump(list)
+		if err != nil {
+			t.Fatalf("Dump() error = %v", err)
+		}
+		v, err := Restore(payload)
+		if err != nil {
+			t.Fatalf("Restore() error = %v", err)
+		}
+		if got := listValues(t, v); !slices.Equal(got, []string{"a", "1", string(long), "b"}) {
+			t.Errorf("round trip gave %d elements", len(got))
+		}
+	})
+
+	t.Run("set", func(t *testing.T) {
+		set := data.NewSet()
+		set.Add("x")
+		set.Add("42")
+		payload, err := Dump(set)
+		if err != nil {
+			t.Fatalf("Dump() error = %v", err)
+		}
+		v, err := Restore(payload)
+		if err != nil {
+			t.Fatalf("Restore() error = %v", err)
+		}
+		got := v.(*data.Set).Members()
+		slices.Sort(got)
+		if !slices.Equal(got, []string{"42", "x"}) {
+			t.Errorf("round trip gave %v", got)
+		}
+	})
+
+	t.Run("sorted set", func(t *testing.T) {
+		ss := data.NewSortedSet[float64]()
+		ss.Insert("a", 1.5)
+		ss.Insert("b", -3)
+		ss.Insert("c", 1e300)
+		payload, err := Dump(ss)
+		if err != nil {
+			t.Fatalf("Dump() error = %v", err)
+		}
+		v, err := Restore(payload)
+		if err != nil {
+			t.Fatalf("Restore() error = %v", err)
+		}
+		got := v.(*data.SortedSet[float64]).Range(0, 3)
+		want := []data.Node[float64]{{Score: -3, Value: "b"}, {Score: 1.5, Value: "a"}, {Score: 1e300, Value: "c"}}
+		if !slices.Equal(got, want) {
+			t.Errorf("round trip gave %v, want %v", got, want)
+		}
+	})
+}
+
+func TestDump_RoundTripHash(t *testing.T) {
+	hash := data.NewHash()
+	hash.Set("name", []byte("bob"))
+	hash.Set("age", []byte("42"))
+	payload, err := Dump(hash)
+	if err != nil {
+		t.Fatalf("Dump() error = %v", err)
+	}
+	if payload[0] != typeHash {
+		t.Errorf("Dump() type = %d, want %d", payload[0], typeHash)
+	}
+	v, err := Restore(payload)
+	if err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	got := v.(*data.Hash)
+	for field, want := range map[string]string{"name": "bob", "age": "42"} {
+		if value, _ := got.Get(field); string(value) != want {
+			t.Errorf("field %s = %v, want %s", field, value, want)
+		}
+	}
+	if got.Len() != 2 {
+		t.Errorf("restored hash has %d fields, want 2", got.Len())
+	}
+}
+
+func TestDump_RoundTripHashFieldTTL(t *testing.T) {
+	at := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli())
+	hash := data.NewHash()
+	hash.Set("session", []byte("abc"))
+	hash.Set("name", []byte("bob"))
+	hash.ExpireAt("session", at)
+
+	payload, err := Dump(hash)
+	if err != nil {
+		t.Fatalf("Dump() error = %v", err)
+	}
+	if payload[0] != typeHashMetadata {
+		t.Errorf("Dump() type = %d, want %d", payload[0], typeHashMetadata)
+	}
+	if version := binary.LittleEndian.Uint16(payload[len(payload)-10:]); version != VersionFieldTTL {
+		t.Errorf("Dump() version = %d, want %d", version, VersionFieldTTL)
+	}
+
+	v, err := Restore(payload)
+	if err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	got := v.(*data.Hash)
+	if expiresAt, ok := got.ExpiresAt("session"); !ok || !expiresAt.Equal(at) {
+		t.Errorf("session expires at %v, want %v", expiresAt, at)
+	}
+	if _, ok := got.ExpiresAt("name"); ok || got.Len() != 2 {
+		t.Errorf("name should have no TTL, hash has %d fields", got.Len())
+	}
+}
+
+func TestRestore_CompactEncodings(t *testing.T) {
+	t.Run("hash listpack", func(t *testing.T) {
+		lp := listpack(lpStr("f"), lpStr("v"), lpStr("n"), []byte{0x05})
+		v, err := Restore(withFooter(append([]byte{typeHashListpack}, rdbStr(lp)...), 11))
+		if err != nil {
+			t.Fatalf("Restore() error = %v", err)
+		}
+		hash := v.(*data.Hash)
+		f, _ := hash.Get("f")
+		n, _ := hash.Get("n")
+		if hash.Len() != 2 || string(f) != "v" || string(n) != "5" {
+			t.Errorf("Restore() = %v, %v", f, n)
+		}
+	})
+
+	t.Run("hash listpack with TTLs", func(t *testing.T) {
+		lp := listpack(lpStr("f"), lpStr("v"), lpStr("4102444800000"), lpStr("g"), lpStr("w"), []byte{0x00})
+		body := append([]byte{typeHashListpackEx}, make([]byte, 8)...)
+		v, err := Restore(withFooter(append(body, rdbStr(lp)...), 12))
+		if err != nil {
+			t.Fatalf("Restore() error = %v", err)
+		}
+		hash := v.(*data.Hash)
+		at, ok := hash.ExpiresAt("f")
+		if hash.Len() != 2 || !ok || at.UnixMilli() != 4102444800000 {
+			t.Errorf("Restore() f expires at %v, %v", at, ok)
+		}
+		if _, ok := hash.ExpiresAt("g"); ok {
+			t.Error("g should have no TTL")
+		}
+	})
+
+	t.Run("zset listpack", func(t *testing.T) {
+		lp := listpack(
+			lpStr("a"), []byte{0x01},
+			lpStr("b"), lpStr("-2.5"),
+			lpStr("c"), []byte{0xdf, 0x9c}, // 13 bit -100
+			lpStr("d"), []byte{0xf1, 0x2c, 0x01}, // 16 bit 300
+		)
+		v, err := Restore(withFooter(append([]byte{typeZsetListpack}, rdbStr(lp)...), 11))
+		if err != nil {
+			t.Fatalf("Restore() error = %v", err)
+		}
+		got := v.(*data.SortedSet[float64]).Range(0, 4)
+		want := []data.Node[float64]{{Score: -100, Value: "c"}, {Score: -2.5, Value: "b"}, {Score: 1, Value: "a"}, {Score: 300, Value: "d"}}
+		if !slices.Equal(got, want) {
+			t.Errorf("Restore() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("quicklist 2", func(t *testing.T) {
+		body := []byte{typeListQuicklist2, 2}
+		body = append(body, quicklistNodePacked)
+		body = append(body, rdbStr(listpack(lpStr("a"), []byte{0x07}))...)
+		body = append(body, quicklistNodePlain)
+		body = append(body, rdbStr([]byte("big"))...)
+		v, err := Restore(withFooter(body, 11))
+		if err != nil {
+			t.Fatalf("Restore() error = %v", err)
+		}
+		if got := listValues(t, v); !slices.Equal(got, []string{"a", "7", "big"}) {
+			t.Errorf("Restore() = %v", got)
+		}
+	})
+
+	t.Run("ziplist", func(t *testing.T) {
+		zl := []byte{0, 0, 0, 0, 0, 0, 0, 0, 3, 0}
+		zl = append(zl, 0x00, 0x02, 'h', 'i')   // prevlen, 6 bit string
+		zl = append(zl, 0x04, 0xf3)             // prevlen, immediate 2
+		zl = append(zl, 0x02, 0xc0, 0x18, 0xfc) // prevlen, int16 -1000
+		zl = append(zl, 0xff)
+		binary.LittleEndian.PutUint32(zl, uint32(len(zl)))
+		v, err := Restore(withFooter(append([]byte{typeListZiplist}, rdbStr(zl)...), 9))
+		if err != nil {
+			t.Fatalf("Restore() error = %v", err)
+		}
+		if got := listValues(t, v); !slices.Equal(got, []string{"hi", "2", "-1000"}) {
+			t.Errorf("Restore() = %v", got)
+		}
+	})
+
+	t.Run("intset", func(t *testing.T) {
+		is := []byte{2, 0, 0, 0, 2, 0, 0, 0, 0xff, 0xff, 0x05, 0x00}
+		v, err := Restore(withFooter(append([]byte{typeSetIntset}, rdbStr(is)...), 9))
+		if err != nil {
+			t.Fatalf("Restore() error = %v", err)
+		}
+		got := v.(*data.Set).Members()
+		slices.Sort(got)
+		if !slices.Equal(got, []string{"-1", "5"}) {
+			t.Errorf("Restore() = %v", got)
+		}
+	})
+
+	t.Run("lzf string", func(t *testing.T) {
+		body := []byte{typeString, 0xc3, 6, 9, 0x02, 'a', 'b', 'c', 0x80, 0x02}
+		v, err := Restore(withFooter(body, 9))
+		if err != nil {
+			t.Fatalf("Restore() error = %v", err)
+		}
+		if got := string(v.([]byte)); got != "abcabcabc" {
+			t.Errorf("Restore() = %q", got)
+		}
+	})
+}
@@ -0,0 +1,256 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/ttn-nguyen42/gedis/util"
+)
+
+// Length prefixes, the two most significant bits of the first byte select
+// the format.
+const (
+	len6Bit  = 0
+	len14Bit = 1
+	len32Bit = 0x80
+	len64Bit = 0x81
+	lenEnc   = 3
+)
+
+// Special string encodings, used when the length prefix is lenEnc.
+const (
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLzf   = 3
+)
+
+type writer struct {
+	buf []byte
+}
+
+func (w *writer) writeByte(b byte) {
+	w.buf = append(w.buf, b)
+}
+
+func (w *writer) writeLen(n uint64) {
+	switch {
+	case n < 1<<6:
+		w.buf = append(w.buf, byte(n)|len6Bit<<6)
+	case n < 1<<14:
+		w.buf = append(w.buf, byte(n>>8)|len14Bit<<6, byte(n))
+	case n <= math.MaxUint32:
+		w.buf = append(w.buf, len32Bit)
+		w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(n))
+	default:
+		w.buf = append(w.buf, len64Bit)
+		w.buf = binary.BigEndian.AppendUint64(w.buf, n)
+	}
+}
+
+// writeString writes s, as an integer when it is the canonical form of one
+// that fits in 32 bits, like Redis does.
+func (w *writer) writeString(s string) {
+	if len(s) <= 11 {
+		if n, err := strconv.ParseInt(s, 10, 32); err == nil && strconv.FormatInt(n, 10) == s {
+			w.writeInt(n)
+			return
+		}
+	}
+	w.writeLen(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *writer) writeInt(n int64) {
+	switch {
+	case n >= math.MinInt8 && n <= math.MaxInt8:
+		w.buf = append(w.buf, lenEnc<<6|encInt8, byte(n))
+	case n >= math.MinInt16 && n <= math.MaxInt16:
+		w.buf = append(w.buf, lenEnc<<6|encInt16)
+		w.buf = binary.LittleEndian.AppendUint16(w.buf, uint16(n))
+	default:
+		w.buf = append(w.buf, lenEnc<<6|encInt32)
+		w.buf = binary.LittleEndian.AppendUint32(w.buf, uint32(n))
+	}
+}
+
+func (w *writer) writeBinaryDouble(f float64) {
+	w.buf = binary.LittleEndian.AppendUint64(w.buf, math.Float64bits(f))
+}
+
+// writeMillis writes a unix time in milliseconds as eight little endian
+// bytes.
+func (w *writer) writeMillis(t time.Time) {
+	w.buf = binary.LittleEndian.AppendUint64(w.buf, uint64(t.UnixMilli()))
+}
+
+type reader struct {
+	b   []byte
+	pos int
+}
+
+func (r *reader) readByte() (byte, error) {
+	if r.pos >= len(r.b) {
+		return 0, ErrBadFormat
+	}
+	c := r.b[r.pos]
+	r.pos += 1
+	return c, nil
+}
+
+func (r *reader) readN(n uint64) ([]byte, error) {
+	if n > uint64(len(r.b)-r.pos) {
+		return nil, ErrBadFormat
+	}
+	b := r.b[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b, nil
+}
+
+// readLen reads a length prefix. When encoded is set, n is one of the
+// special string encodings instead of a length.
+func (r *reader) readLen() (n uint64, encoded bool, err error) {
+	c, err := r.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch c >> 6 {
+	case len6Bit:
+		return uint64(c & 0x3f), false, nil
+	case len14Bit:
+		next, err := r.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(c&0x3f)<<8 | uint64(next), false, nil
+	case lenEnc:
+		return uint64(c & 0x3f), true, nil
+	}
+	switch c {
+	case len32Bit:
+		b, err := r.readN(4)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(b)), false, nil
+	case len64Bit:
+		b, err := r.readN(8)
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(b), false, nil
+	}
+	return 0, false, ErrBadFormat
+}
+
+// readCount reads a length prefix that counts elements.
+func (r *reader) readCount() (int, error) {
+	n, encoded, err := r.readLen()
+	if err != nil {
+		return 0, err
+	}
+	if encoded || n > uint64(len(r.b)) {
+		// every element takes at least one byte
+		return 0, ErrBadFormat
+	}
+	return int(n), nil
+}
+
+func (r *reader) readString() (string, error) {
+	n, encoded, err := r.readLen()
+	if err != nil {
+		return "", err
+	}
+	if !encoded {
+		b, err := r.readN(n)
+		return string(b), err
+	}
+
+	switch n {
+	case encInt8:
+		b, err := r.readN(1)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int8(b[0]))), nil
+	case encInt16:
+		b, err := r.readN(2)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(b)))), nil
+	case encInt32:
+		b, err := r.readN(4)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(b)))), nil
+	case encLzf:
+		clen, _, err := r.readLen()
+		if err != nil {
+			return "", err
+		}
+		ulen, _, err := r.readLen()
+		if err != nil {
+			return "", err
+		}
+		compressed, err := r.readN(clen)
+		if err != nil {
+			return "", err
+		}
+		if ulen > 512<<20 {
+			return "", ErrBadFormat
+		}
+		b, err := util.LzfDecompress(compressed, int(ulen))
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", ErrBadFormat, err)
+		}
+		return string(b), nil
+	}
+	return "", ErrBadFormat
+}
+
+func (r *reader) readBinaryDouble() (float64, error) {
+	b, err := r.readN(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+}
+
+func (r *reader) readMillis() (time.Time, error) {
+	b, err := r.readN(8)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(int64(binary.LittleEndian.Uint64(b))), nil
+}
+
+// readDouble reads a score of the old ZSET type, stored as text behind a
+// one byte length where 253 to 255 stand for NaN, +inf and -inf.
+func (r *reader) readDouble() (float64, error) {
+	n, err := r.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	b, err := r.readN(uint64(n))
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		return 0, ErrBadFormat
+	}
+	return f, nil
+}
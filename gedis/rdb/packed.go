@@ -0,0 +1,224 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+// The compact encodings Redis uses for small containers. They are only
+// decoded: gedis always dumps the plain types, which every Redis version
+// can load.
+
+// decodeListpack returns the entries of a listpack, integers formatted as
+// decimal strings.
+func decodeListpack(b []byte) ([]string, error) {
+	// total bytes (4) and number of entries (2)
+	if len(b) < 7 || int(binary.LittleEndian.Uint32(b)) != len(b) {
+		return nil, ErrBadFormat
+	}
+	entries := make([]string, 0, binary.LittleEndian.Uint16(b[4:]))
+	p := 6
+	for {
+		if p >= len(b) {
+			return nil, ErrBadFormat
+		}
+		c := b[p]
+		if c == 0xff {
+			break
+		}
+
+		var (
+			entry string
+			size  int // encoding and data, the back length excluded
+			err   error
+		)
+		switch {
+		case c&0x80 == 0:
+			entry, size = strconv.Itoa(int(c&0x7f)), 1
+		case c&0xc0 == 0x80:
+			n := int(c & 0x3f)
+			entry, err = sliceString(b, p+1, n)
+			size = 1 + n
+		case c&0xe0 == 0xc0:
+			if p+1 >= len(b) {
+				return nil, ErrBadFormat
+			}
+			entry, size = strconv.Itoa(int(signExtend(uint64(c&0x1f)<<8|uint64(b[p+1]), 13))), 2
+		case c&0xf0 == 0xe0:
+			if p+1 >= len(b) {
+				return nil, ErrBadFormat
+			}
+			n := int(c&0x0f)<<8 | int(b[p+1])
+			entry, err = sliceString(b, p+2, n)
+			size = 2 + n
+		case c == 0xf0:
+			if p+5 > len(b) {
+				return nil, ErrBadFormat
+			}
+			n := int(binary.LittleEndian.Uint32(b[p+1:]))
+			entry, err = sliceString(b, p+5, n)
+			size = 5 + n
+		case c >= 0xf1 && c <= 0xf4:
+			width := [...]int{2, 3, 4, 8}[c-0xf1]
+			var n int64
+			n, err = littleEndianInt(b, p+1, width)
+			entry, size = strconv.FormatInt(n, 10), 1+width
+		default:
+			return nil, ErrBadFormat
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+		p += size + backlenSize(size)
+	}
+	return entries, nil
+}
+
+// backlenSize is the number of bytes a listpack uses to store the length of
+// an entry of size bytes after it.
+func backlenSize(size int) int {
+	switch {
+	case size < 1<<7:
+		return 1
+	case size < 1<<14:
+		return 2
+	case size < 1<<21:
+		return 3
+	case size < 1<<28:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// decodeZiplist returns the entries of a ziplist, the encoding listpacks
+// replaced in Redis 7.
+func decodeZiplist(b []byte) ([]string, error) {
+	// total bytes (4), tail offset (4) and number of entries (2)
+	if len(b) < 11 || int(binary.LittleEndian.Uint32(b)) != len(b) {
+		return nil, ErrBadFormat
+	}
+	entries := make([]string, 0, binary.LittleEndian.Uint16(b[8:]))
+	p := 10
+	for {
+		if p >= len(b) {
+			return nil, ErrBadFormat
+		}
+		if b[p] == 0xff {
+			break
+		}
+		// skip the length of the previous entry
+		if b[p] == 0xfe {
+			p += 5
+		} else {
+			p += 1
+		}
+		if p >= len(b) {
+			return nil, ErrBadFormat
+		}
+
+		c := b[p]
+		var (
+			entry string
+			size  int
+			err   error
+		)
+		switch c >> 6 {
+		case 0:
+			n := int(c & 0x3f)
+			entry, err = sliceString(b, p+1, n)
+			size = 1 + n
+		case 1:
+			if p+1 >= len(b) {
+				return nil, ErrBadFormat
+			}
+			n := int(c&0x3f)<<8 | int(b[p+1])
+			entry, err = sliceString(b, p+2, n)
+			size = 2 + n
+		case 2:
+			if p+5 > len(b) {
+				return nil, ErrBadFormat
+			}
+			n := int(binary.BigEndian.Uint32(b[p+1:]))
+			entry, err = sliceString(b, p+5, n)
+			size = 5 + n
+		default:
+			var n int64
+			width := 0
+			switch c {
+			case 0xc0:
+				width = 2
+			case 0xd0:
+				width = 4
+			case 0xe0:
+				width = 8
+			case 0xf0:
+				width = 3
+			case 0xfe:
+				width = 1
+			default:
+				// 4 bit immediate, 0001 to 1101 stand for 0 to 12
+				if c < 0xf1 || c > 0xfd {
+					return nil, ErrBadFormat
+				}
+				n = int64(c&0x0f) - 1
+			}
+			if width > 0 {
+				n, err = littleEndianInt(b, p+1, width)
+			}
+			entry, size = strconv.FormatInt(n, 10), 1+width
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+		p += size
+	}
+	return entries, nil
+}
+
+// decodeIntset returns the members of an intset as decimal strings.
+func decodeIntset(b []byte) ([]string, error) {
+	if len(b) < 8 {
+		return nil, ErrBadFormat
+	}
+	width := int(binary.LittleEndian.Uint32(b))
+	n := int(binary.LittleEndian.Uint32(b[4:]))
+	if (width != 2 && width != 4 && width != 8) || len(b) != 8+n*width {
+		return nil, ErrBadFormat
+	}
+	members := make([]string, 0, n)
+	for i := 0; i < n; i += 1 {
+		v, err := littleEndianInt(b, 8+i*width, width)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, strconv.FormatInt(v, 10))
+	}
+	return members, nil
+}
+
+func sliceString(b []byte, p int, n int) (string, error) {
+	if p+n > len(b) {
+		return "", ErrBadFormat
+	}
+	return string(b[p : p+n]), nil
+}
+
+// littleEndianInt reads a signed integer of width bytes at p.
+func littleEndianInt(b []byte, p int, width int) (int64, error) {
+	if p+width > len(b) {
+		return 0, ErrBadFormat
+	}
+	var u uint64
+	for i := width - 1; i >= 0; i -= 1 {
+		u = u<<8 | uint64(b[p+i])
+	}
+	return signExtend(u, 8*width), nil
+}
+
+func signExtend(u uint64, bits int) int64 {
+	shift := 64 - bits
+	return int64(u<<shift) >> shift
+}
@@ -356,7 +356,7 @@ func (m *Master) AskOffsets(ctx context.Context) error {
 func (m *Master) askOffset(ctx context.Context, sd *slaveData) (int, int, error) {
 	getAck := resp.Command{
 		Cmd:  "REPLCONF",
-		Args: []any{"GETACK", resp.BulkStr{Value: "*", Size: 1}},
+		Args: []any{"GETACK", resp.BulkStr{Value: []byte("*"), Size: 1}},
 	}
 
 	r, n, err := sd.client.SendSync(ctx, getAck)
@@ -371,7 +371,7 @@ func (m *Master) askOffset(ctx context.Context, sd *slaveData) (int, int, error)
 	if !ok {
 		return -1, 0, fmt.Errorf("invalid REPLCONF GETACK offset from slave: %+v", arr.Items[1])
 	}
-	offset, err := strconv.Atoi(offsetStr.Value)
+	offset, err := strconv.Atoi(offsetStr.String())
 	if err != nil {
 		return -1, 0, fmt.Errorf("invalid REPLCONF GETACK offset value from slave: %v", offsetStr)
 	}
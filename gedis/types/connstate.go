@@ -2,9 +2,15 @@ package gedis_types
 
 import (
 	"net"
+	"sync/atomic"
 )
 
+// lastClientId is the id given to the last connection, ids start at 1.
+var lastClientId atomic.Int64
+
 type ConnState struct {
+	// Id identifies the connection, as reported by CLIENT ID
+	Id            int64
 	InTransaction bool
 	Tx            []*Command
 	DbNumber      int
@@ -12,10 +18,14 @@ type ConnState struct {
 	isRepl        bool
 	isSub         bool
 	subId         string
+	// closed is set by the connection goroutines once the client is gone,
+	// the core checks it to drop what the client waits on
+	closed atomic.Bool
 }
 
 func NewConnState(conn net.Conn) *ConnState {
 	return &ConnState{
+		Id:            lastClientId.Add(1),
 		InTransaction: false,
 		DbNumber:      0,
 		Tx:            make([]*Command, 0),
@@ -49,3 +59,11 @@ func (c *ConnState) QuitSubscription() {
 	c.isSub = false
 	c.subId = ""
 }
+
+func (c *ConnState) Close() {
+	c.closed.Store(true)
+}
+
+func (c *ConnState) IsClosed() bool {
+	return c.closed.Load()
+}
@@ -2,6 +2,7 @@ package resp
 
 import (
 	"fmt"
+	"io"
 )
 
 type Command struct {
@@ -14,6 +15,15 @@ func NewCommand(arr Array) (Command, error) {
 	return newCommand(arr.Items)
 }
 
+// BuildCommand makes the command name with args, its Size being the length
+// of its encoding as if it had been read from a stream.
+func BuildCommand(name string, args ...any) Command {
+	cmd := Command{Cmd: name, Args: args}
+	n, _ := cmd.Array().WriteTo(io.Discard)
+	cmd.Size = int(n)
+	return cmd
+}
+
 func newCommand(parts []any) (Command, error) {
 	if len(parts) == 0 {
 		return Command{}, nil
@@ -33,7 +43,7 @@ func bulkOrStr(str any) (string, error) {
 	case string:
 		return val, nil
 	case BulkStr:
-		return val.Value, nil
+		return string(val.Value), nil
 	default:
 		return "", fmt.Errorf("unknown string: %+v", str)
 	}
@@ -42,7 +52,9 @@ func bulkOrStr(str any) (string, error) {
 func toBulkStr(arg any) (BulkStr, error) {
 	switch val := arg.(type) {
 	case string:
-		return BulkStr{Size: len(val), Value: val}, nil
+		return BulkStr{Size: len(val), Value: []byte(val)}, nil
+	case []byte:
+		return NewBulkStr(val), nil
 	case BulkStr:
 		return val, nil
 	default:
@@ -55,7 +67,7 @@ func (c *Command) Array() Array {
 		Size:  1 + len(c.Args),
 		Items: make([]any, 0, 1+len(c.Args)),
 	}
-	arr.Items = append(arr.Items, BulkStr{Size: len(c.Cmd), Value: c.Cmd})
+	arr.Items = append(arr.Items, BulkStr{Size: len(c.Cmd), Value: []byte(c.Cmd)})
 	for _, arg := range c.Args {
 		str, err := toBulkStr(arg)
 		if err != nil {
@@ -2,6 +2,7 @@ package resp
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
@@ -32,15 +33,47 @@ type Set struct {
 type Err struct {
 	Size  int
 	Value string
+	// Code is the error code the reply starts with, ERR when empty
+	Code string
 }
 
+// CodeErr is an error with its own error code, such as BUSYKEY, replied in
+// place of the generic ERR.
+type CodeErr struct {
+	Code    string
+	Message string
+}
+
+func NewCodeErr(code string, message string) *CodeErr {
+	return &CodeErr{Code: code, Message: message}
+}
+
+func (e *CodeErr) Error() string {
+	return e.Code + " " + e.Message
+}
+
+// BulkStr is a binary-safe string. Its Value is written byte for byte, Size
+// is -1 for the null bulk string.
 type BulkStr struct {
 	Size  int
-	Value string
+	Value []byte
 	Null  bool
 }
 
+// NewBulkStr returns the bulk string holding b.
+func NewBulkStr(b []byte) BulkStr {
+	return BulkStr{Size: len(b), Value: b}
+}
+
+func (e BulkStr) String() string {
+	return string(e.Value)
+}
+
 func NewErr(err error) Err {
+	var coded *CodeErr
+	if errors.As(err, &coded) {
+		return Err{Size: len(coded.Message), Value: coded.Message, Code: coded.Code}
+	}
 	msg := err.Error()
 	return Err{Size: len(msg), Value: msg}
 }
@@ -131,7 +164,11 @@ func (s Set) WriteTo(w io.Writer) (n int64, err error) {
 
 func (e *Err) WriteTo(w io.Writer) (n int64, err error) {
 	var buf bytes.Buffer
-	ec, err := buf.WriteString("-ERR ")
+	code := e.Code
+	if code == "" {
+		code = "ERR"
+	}
+	ec, err := buf.WriteString("-" + code + " ")
 	if err != nil {
 		return n, err
 	}
@@ -150,15 +187,26 @@ func (e *Err) WriteTo(w io.Writer) (n int64, err error) {
 }
 
 func (e *BulkStr) WriteTo(w io.Writer) (n int64, err error) {
-	header := fmt.Sprintf("$%d\r\n", e.Size)
-	if e.Size >= 0 {
-		header = fmt.Sprintf("%s%s\r\n", header, e.Value)
+	if e.Size < 0 {
+		return writeBulk(w, nil, true)
 	}
-	if _, err := w.Write([]byte(header)); err != nil {
-		return n, err
+	return writeBulk(w, e.Value, false)
+}
+
+// writeBulk writes b as a bulk string, or the null bulk string.
+func writeBulk(w io.Writer, b []byte, null bool) (int64, error) {
+	out := make([]byte, 0, len(b)+16)
+	if null {
+		out = append(out, "$-1\r\n"...)
+	} else {
+		out = append(out, '$')
+		out = strconv.AppendInt(out, int64(len(b)), 10)
+		out = append(out, "\r\n"...)
+		out = append(out, b...)
+		out = append(out, "\r\n"...)
 	}
-	n += int64(len(header))
-	return n, nil
+	n, err := w.Write(out)
+	return int64(n), err
 }
 
 func WriteAnyTo(data any, out io.Writer) (n int64, err error) {
@@ -181,6 +229,8 @@ func WriteAnyTo(data any, out io.Writer) (n int64, err error) {
 		return val.WriteTo(out)
 	case BulkStr:
 		return val.WriteTo(out)
+	case []byte:
+		return writeBulk(out, val, false)
 	case int, int8, int16, int32, int64:
 		header := fmt.Sprintf(":%d\r\n", val)
 		if _, err := out.Write([]byte(header)); err != nil {
@@ -24,6 +24,11 @@ func TestErr_WriteTo(t *testing.T) {
 			err:      resp.Err{Size: 0, Value: ""},
 			expected: "-ERR \r\n",
 		},
+		{
+			name:     "error_code",
+			err:      resp.NewErr(resp.NewCodeErr("BUSYKEY", "Target key name already exists.")),
+			expected: "-BUSYKEY Target key name already exists.\r\n",
+		},
 	}
 
 	for _, tt := range tests {
@@ -84,6 +89,21 @@ func TestWriteAnyTo(t *testing.T) {
 			data:     nil,
 			expected: "_\r\n",
 		},
+		{
+			name:     "bytes",
+			data:     []byte("a\r\n\x00"),
+			expected: "$4\r\na\r\n\x00\r\n",
+		},
+		{
+			name:     "bulk string",
+			data:     resp.NewBulkStr([]byte("hi")),
+			expected: "$2\r\nhi\r\n",
+		},
+		{
+			name:     "null bulk string",
+			data:     resp.BulkStr{Size: -1},
+			expected: "$-1\r\n",
+		},
 		{
 			name:     "array",
 			data:     resp.Array{Size: 2, Items: []any{"foo", "bar"}},
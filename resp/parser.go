@@ -99,11 +99,14 @@ func (p *parser) parse() (any, error) {
 	case TokenTypeInteger:
 		return curr.Value.(int), nil
 	case TokenTypeBulkString:
-		str, err := p.parseBulkString(curr.Value.(int))
+		b, err := p.parseBulkString(curr.Value.(int))
 		if err != nil {
 			return nil, err
 		}
-		return BulkStr{Size: len(str), Value: str}, nil
+		if b == nil {
+			return BulkStr{Size: -1, Null: true}, nil
+		}
+		return BulkStr{Size: len(b), Value: b}, nil
 	case TokenTypeNull:
 		return nil, nil
 	case TokenTypeBoolean:
@@ -113,11 +116,11 @@ func (p *parser) parse() (any, error) {
 	case TokenTypeBigNumber:
 		return curr.Value.(*big.Int), nil
 	case TokenTypeBulkError:
-		str, err := p.parseBulkString(curr.Value.(int))
+		b, err := p.parseBulkString(curr.Value.(int))
 		if err != nil {
 			return nil, err
 		}
-		return Err{Value: str, Size: curr.Value.(int)}, nil
+		return Err{Value: string(b), Size: curr.Value.(int)}, nil
 	case TokenTypeVerbatimString:
 		str, err := p.parseVerbatimString(curr.Value.(int))
 		if err != nil {
@@ -149,39 +152,33 @@ func (p *parser) parse() (any, error) {
 	}
 }
 
+// parseInline splits an inline command on spaces, its arguments are bulk
+// strings like the ones of a RESP array.
 func (p *parser) parseInline(inl string) (Command, error) {
 	args := make([]any, 0)
 	for arg := range strings.SplitSeq(inl, " ") {
-		args = append(args, arg)
+		args = append(args, BulkStr{Size: len(arg), Value: []byte(arg)})
 	}
 	return newCommand(args)
 }
 
-func (p *parser) parseBulkString(size int) (string, error) {
-	if size <= 0 {
-		return "", nil
+// parseBulkString reads exactly size bytes followed by CRLF, so the payload
+// may hold any byte including CRLF. It returns nil for the null bulk string.
+func (p *parser) parseBulkString(size int) ([]byte, error) {
+	if size < 0 {
+		return nil, nil
 	}
-	sb := strings.Builder{}
-	i := 0
-	for sb.Len() < size {
-		if i > 0 {
-			sb.WriteString("\r\n")
-		}
-		nt, err := p.nextLiteral()
-		if err != nil {
-			if err == io.EOF {
-				return "", err
-			}
-			return "", fmt.Errorf("%w: %v", ErrInvalidToken, err)
-		}
-		sb.WriteString(nt.Literal)
-		i += 1
+	if size > _512MB {
+		return nil, ErrTooManyBytes
 	}
-	if sb.Len() > size {
-		return "", fmt.Errorf("%w: invalid bulk string size, got %v but actually %v", ErrInvalidToken, sb.Len(), size)
+	b, err := p.readBulk(size)
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
 	}
-
-	return sb.String(), nil
+	return b, nil
 }
 
 func (p *parser) parseVerbatimString(size int) (string, error) {
@@ -356,6 +353,20 @@ func (p *streamIter) next() (Token, error) {
 	return tok, nil
 }
 
+// readBulk reads size raw bytes and the CRLF ending them.
+func (p *streamIter) readBulk(size int) ([]byte, error) {
+	buf := make([]byte, size+2)
+	n, err := io.ReadFull(p.sc.str, buf)
+	p.sc.bytesRead += n
+	if err != nil {
+		return nil, err
+	}
+	if buf[size] != '\r' || buf[size+1] != '\n' {
+		return nil, fmt.Errorf("invalid bulk string size, expected CRLF after %d bytes", size)
+	}
+	return buf[:size], nil
+}
+
 func (p *streamIter) nextLiteral() (Token, error) {
 	if p.lastToken != nil {
 		tok := *p.lastToken
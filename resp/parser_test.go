@@ -1,6 +1,7 @@
 package resp_test
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 
@@ -19,7 +20,7 @@ func TestParseStream(t *testing.T) {
 			input: "*2\r\n$4\r\nLLEN\r\n$6\r\nmylist\r\n",
 			expected: resp.Command{
 				Cmd:  "LLEN",
-				Args: []any{resp.BulkStr{Size: 6, Value: "mylist"}},
+				Args: []any{resp.BulkStr{Size: 6, Value: []byte("mylist")}},
 			},
 			hasError: false,
 		},
@@ -37,10 +38,24 @@ func TestParseStream(t *testing.T) {
 			input: "EXISTS somekey\r\n",
 			expected: resp.Command{
 				Cmd:  "EXISTS",
-				Args: []any{"somekey"},
+				Args: []any{resp.BulkStr{Size: 7, Value: []byte("somekey")}},
 			},
 			hasError: false,
 		},
+		{
+			name:  "binary bulk string",
+			input: "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$6\r\n\x00\r\n\xff\r\r\r\n",
+			expected: resp.Command{
+				Cmd:  "SET",
+				Args: []any{resp.BulkStr{Size: 1, Value: []byte("k")}, resp.BulkStr{Size: 6, Value: []byte("\x00\r\n\xff\r\r")}},
+			},
+			hasError: false,
+		},
+		{
+			name:     "bulk string longer than its size",
+			input:    "*1\r\n$2\r\nPING\r\n",
+			hasError: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -63,10 +78,29 @@ func TestParseStream(t *testing.T) {
 				t.Errorf("args length: expected %d, got %d", len(tt.expected.Args), len(cmd.Args))
 			}
 			for i, arg := range cmd.Args {
-				if arg != tt.expected.Args[i] {
+				if !reflect.DeepEqual(arg, tt.expected.Args[i]) {
 					t.Errorf("arg %d: expected %v, got %v", i, tt.expected.Args[i], arg)
 				}
 			}
 		})
 	}
 }
+
+func TestBuildCommand(t *testing.T) {
+	built := resp.BuildCommand("LMOVE", "src", []byte("dst"), "LEFT", "RIGHT")
+
+	var out strings.Builder
+	if _, err := built.Array().WriteTo(&out); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	parsed, err := resp.ParseCmd(strings.NewReader(out.String()))
+	if err != nil {
+		t.Fatalf("ParseCmd() error = %v", err)
+	}
+	if built.Size != parsed.Size {
+		t.Errorf("size: built %d, parsed %d", built.Size, parsed.Size)
+	}
+	if parsed.Cmd != "LMOVE" || len(parsed.Args) != 4 {
+		t.Errorf("parsed %q with %d args", parsed.Cmd, len(parsed.Args))
+	}
+}
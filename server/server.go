@@ -122,6 +122,8 @@ func (s *Server) handleConn(baseCtx context.Context, conn net.Conn) {
 	go func() {
 		<-ctx.Done()
 		wg.Wait()
+		// commands the client is still blocked on are dropped by the core
+		state.connState.Close()
 		conn.Close()
 	}()
 
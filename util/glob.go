@@ -0,0 +1,120 @@
+package util
+
+// GlobMatch reports whether str matches the glob-style pattern, with the same
+// rules as Redis' stringmatchlen: '*' matches any sequence, '?' any single
+// byte, '[...]' a class of bytes (with '^' negation and 'a-z' ranges) and
+// '\' escapes the next byte.
+func GlobMatch(pattern, str string) bool {
+	return globMatch(pattern, str, false)
+}
+
+// GlobMatchFold is GlobMatch ignoring ASCII case.
+func GlobMatchFold(pattern, str string) bool {
+	return globMatch(pattern, str, true)
+}
+
+func globMatch(pattern, str string, fold bool) bool {
+	p, s := 0, 0
+	for p < len(pattern) {
+		switch pattern[p] {
+		case '*':
+			for p+1 < len(pattern) && pattern[p+1] == '*' {
+				p += 1
+			}
+			if p+1 == len(pattern) {
+				return true
+			}
+			for i := s; i <= len(str); i += 1 {
+				if globMatch(pattern[p+1:], str[i:], fold) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s >= len(str) {
+				return false
+			}
+			s += 1
+			p += 1
+		case '[':
+			if s >= len(str) {
+				return false
+			}
+			end, matched := matchClass(pattern, p+1, str[s], fold)
+			if !matched {
+				return false
+			}
+			p = end
+			s += 1
+		case '\\':
+			if p+1 < len(pattern) {
+				p += 1
+			}
+			fallthrough
+		default:
+			if s >= len(str) || !byteEq(pattern[p], str[s], fold) {
+				return false
+			}
+			s += 1
+			p += 1
+		}
+	}
+	return s == len(str)
+}
+
+// matchClass matches c against the class starting right after '[' at p. It
+// returns the index following the closing ']' and whether c is in the class.
+func matchClass(pattern string, p int, c byte, fold bool) (int, bool) {
+	not := false
+	if p < len(pattern) && pattern[p] == '^' {
+		not = true
+		p += 1
+	}
+	matched := false
+	for p < len(pattern) && pattern[p] != ']' {
+		switch {
+		case pattern[p] == '\\' && p+1 < len(pattern):
+			p += 1
+			if byteEq(pattern[p], c, fold) {
+				matched = true
+			}
+			p += 1
+		case p+2 < len(pattern) && pattern[p+1] == '-' && pattern[p+2] != ']':
+			lo, hi := pattern[p], pattern[p+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if fold {
+				lo, hi, c = lower(lo), lower(hi), lower(c)
+			}
+			if c >= lo && c <= hi {
+				matched = true
+			}
+			p += 3
+		default:
+			if byteEq(pattern[p], c, fold) {
+				matched = true
+			}
+			p += 1
+		}
+	}
+	if p < len(pattern) {
+		// skip the closing bracket
+		p += 1
+	}
+	return p, matched != not
+}
+
+func byteEq(a, b byte, fold bool) bool {
+	if fold {
+		return lower(a) == lower(b)
+	}
+	return a == b
+}
+
+func lower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + ('a' - 'A')
+	}
+	return c
+}
@@ -0,0 +1,49 @@
+package util_test
+
+import (
+	"testing"
+
+	"github.com/ttn-nguyen42/gedis/util"
+)
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		str     string
+		match   bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "heeeello", true},
+		{"h*llo", "hello world", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{"notify-*", "notify-keyspace-events", true},
+		{"*-max-*-entries", "hash-max-listpack-entries", true},
+		{`\*`, "*", true},
+		{`\*`, "a", false},
+		{"a*b*c", "abxbxc", true},
+		{"a*b*c", "abxbx", false},
+	}
+
+	for _, tt := range tests {
+		if got := util.GlobMatch(tt.pattern, tt.str); got != tt.match {
+			t.Errorf("GlobMatch(%q, %q) = %v, expected %v", tt.pattern, tt.str, got, tt.match)
+		}
+	}
+}
+
+func TestGlobMatchFold(t *testing.T) {
+	if !util.GlobMatchFold("NOTIFY-*", "notify-keyspace-events") {
+		t.Errorf("expected case insensitive match")
+	}
+	if util.GlobMatch("NOTIFY-*", "notify-keyspace-events") {
+		t.Errorf("expected case sensitive mismatch")
+	}
+}
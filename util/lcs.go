@@ -0,0 +1,140 @@
+package util
+
+import (
+	"errors"
+	"math"
+)
+
+// lcsMaxMemory bounds the memory LCS may use for its tables, Redis'
+// default proto-max-bulk-len.
+const lcsMaxMemory = 512 << 20
+
+var ErrLcsTooLarge = errors.New("Insufficient memory, transient memory for LCS exceeds proto-max-bulk-len")
+
+// LcsMatch is a run of consecutive common bytes, at a[AStart:AEnd+1] and
+// b[BStart:BEnd+1].
+type LcsMatch struct {
+	AStart, AEnd int
+	BStart, BEnd int
+}
+
+func (m LcsMatch) Len() int {
+	return m.AEnd - m.AStart + 1
+}
+
+// lcsRow computes the row of the LCS table for the first i bytes of a from
+// the row for the first i-1 bytes.
+func lcsRow(a, b []byte, i int, prev, cur []uint32) {
+	cur[0] = 0
+	for j := 1; j <= len(b); j += 1 {
+		if a[i-1] == b[j-1] {
+			cur[j] = prev[j-1] + 1
+		} else {
+			cur[j] = max(prev[j], cur[j-1])
+		}
+	}
+}
+
+// LcsLen returns the length of the longest common subsequence of a and b,
+// keeping only two rows of the table.
+func LcsLen(a, b []byte) int {
+	prev, cur := make([]uint32, len(b)+1), make([]uint32, len(b)+1)
+	for i := 1; i <= len(a); i += 1 {
+		lcsRow(a, b, i, prev, cur)
+		prev, cur = cur, prev
+	}
+	return int(prev[len(b)])
+}
+
+// Lcs returns the longest common subsequence of a and b along with the runs
+// of consecutive matches it is made of, from the last to the first, picked
+// the way Redis' LCS command does.
+//
+// Instead of the whole table, only every k-th row is kept and the rows in
+// between are recomputed block by block while walking back, which bounds
+// the memory to about 2*sqrt(len(a)) rows for twice the time.
+func Lcs(a, b []byte) ([]byte, []LcsMatch, error) {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return []byte{}, []LcsMatch{}, nil
+	}
+	k := max(int(math.Ceil(math.Sqrt(float64(n)))), 1)
+	kept := n/k + 1 + k + 1
+	if uint64(kept)*uint64(m+1)*4 > lcsMaxMemory {
+		return nil, nil, ErrLcsTooLarge
+	}
+
+	newRow := func() []uint32 { return make([]uint32, m+1) }
+	checkpoints := [][]uint32{newRow()}
+	prev, cur := newRow(), newRow()
+	for i := 1; i <= n; i += 1 {
+		lcsRow(a, b, i, prev, cur)
+		prev, cur = cur, prev
+		if i%k == 0 {
+			checkpoints = append(checkpoints, append([]uint32(nil), prev...))
+		}
+	}
+
+	// block holds the rows lo to lo+k, recomputed from a checkpoint
+	block := make([][]uint32, k+1)
+	for r := range block {
+		block[r] = newRow()
+	}
+	lo := -1
+	// rows returns the rows i-1 and i, which always share a block
+	rows := func(i int) ([]uint32, []uint32) {
+		if start := ((i - 1) / k) * k; start != lo {
+			lo = start
+			copy(block[0], checkpoints[lo/k])
+			for r := 1; r <= k && lo+r <= n; r += 1 {
+				lcsRow(a, b, lo+r, block[r-1], block[r])
+			}
+		}
+		return block[i-1-lo], block[i-lo]
+	}
+
+	_, last := rows(n)
+	idx := int(last[m])
+	lcs := make([]byte, idx)
+	matches := make([]LcsMatch, 0)
+	// match is the range being extended backward, empty when AStart is n
+	match := LcsMatch{AStart: n}
+	for i, j := n, m; i > 0 && j > 0; {
+		emit := false
+		if a[i-1] == b[j-1] {
+			lcs[idx-1] = a[i-1]
+			switch {
+			case match.AStart == n:
+				match = LcsMatch{AStart: i - 1, AEnd: i - 1, BStart: j - 1, BEnd: j - 1}
+			case match.AStart == i && match.BStart == j:
+				match.AStart -= 1
+				match.BStart -= 1
+			default:
+				emit = true
+			}
+			// the match reached the start of one of the strings
+			if match.AStart == 0 || match.BStart == 0 {
+				emit = true
+			}
+			idx -= 1
+			i -= 1
+			j -= 1
+		} else {
+			above, current := rows(i)
+			if above[j] > current[j-1] {
+				i -= 1
+			} else {
+				j -= 1
+			}
+			if match.AStart != n {
+				emit = true
+			}
+		}
+
+		if emit {
+			matches = append(matches, match)
+			match = LcsMatch{AStart: n}
+		}
+	}
+	return lcs, matches, nil
+}
@@ -0,0 +1,79 @@
+package util
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// lcsFullTable is the textbook algorithm keeping the whole table, the
+// reference the checkpointed one must agree with.
+func lcsFullTable(a, b string) string {
+	table := make([][]int, len(a)+1)
+	for i := range table {
+		table[i] = make([]int, len(b)+1)
+	}
+	for i := 1; i <= len(a); i += 1 {
+		for j := 1; j <= len(b); j += 1 {
+			if a[i-1] == b[j-1] {
+				table[i][j] = table[i-1][j-1] + 1
+			} else {
+				table[i][j] = max(table[i-1][j], table[i][j-1])
+			}
+		}
+	}
+	out := []byte{}
+	for i, j := len(a), len(b); i > 0 && j > 0; {
+		switch {
+		case a[i-1] == b[j-1]:
+			out = append([]byte{a[i-1]}, out...)
+			i, j = i-1, j-1
+		case table[i-1][j] > table[i][j-1]:
+			i -= 1
+		default:
+			j -= 1
+		}
+	}
+	return string(out)
+}
+
+func TestLcs_RedisExample(t *testing.T) {
+	a, b := []byte("ohmytext"), []byte("mynewtext")
+	lcs, matches, err := Lcs(a, b)
+	if err != nil {
+		t.Fatalf("Lcs() error = %v", err)
+	}
+	if string(lcs) != "mytext" {
+		t.Errorf("Lcs() = %q, want mytext", lcs)
+	}
+	want := []LcsMatch{{4, 7, 5, 8}, {2, 3, 0, 1}}
+	if !slices.Equal(matches, want) {
+		t.Errorf("Lcs() matches = %v, want %v", matches, want)
+	}
+	if n := LcsLen(a, b); n != 6 {
+		t.Errorf("LcsLen() = %d, want 6", n)
+	}
+}
+
+func TestLcs_MatchesFullTable(t *testing.T) {
+	tests := [][2]string{
+		{"", "abc"},
+		{"abc", ""},
+		{"a", "a"},
+		{"abcbdab", "bdcaba"},
+		{strings.Repeat("ab", 50), strings.Repeat("ba", 37)},
+		{"the quick brown fox jumps over the lazy dog", "pack my box with five dozen liquor jugs"},
+	}
+	for _, tt := range tests {
+		lcs, _, err := Lcs([]byte(tt[0]), []byte(tt[1]))
+		if err != nil {
+			t.Fatalf("Lcs() error = %v", err)
+		}
+		if want := lcsFullTable(tt[0], tt[1]); string(lcs) != want {
+			t.Errorf("Lcs(%q, %q) = %q, want %q", tt[0], tt[1], lcs, want)
+		}
+		if n := LcsLen([]byte(tt[0]), []byte(tt[1])); n != len(lcs) {
+			t.Errorf("LcsLen(%q, %q) = %d, want %d", tt[0], tt[1], n, len(lcs))
+		}
+	}
+}
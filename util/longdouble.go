@@ -0,0 +1,61 @@
+package util
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// Redis does float increments in C long double, which on x86-64 is the x87
+// extended format: a 64 bit mantissa and a 15 bit exponent.
+const (
+	longDoublePrec   = 64
+	longDoubleMaxExp = 16384
+)
+
+var (
+	ErrNotFloat        = errors.New("value is not a valid float")
+	ErrFloatOverflow   = errors.New("increment would produce NaN or Infinity")
+	longDoubleRounding = big.ToNearestEven
+)
+
+// ParseLongDouble parses s as Redis' string2ld does: no surrounding spaces,
+// no NaN, with the precision of a long double.
+func ParseLongDouble(s string) (*big.Float, error) {
+	if s == "" || strings.TrimSpace(s) != s {
+		return nil, ErrNotFloat
+	}
+	f, _, err := big.ParseFloat(s, 10, longDoublePrec, longDoubleRounding)
+	if err != nil {
+		return nil, ErrNotFloat
+	}
+	return f, nil
+}
+
+// AddLongDouble adds incr to value with the rounding of a long double. Like
+// Redis it refuses results that would be infinite.
+func AddLongDouble(value, incr *big.Float) (*big.Float, error) {
+	if value.IsInf() || incr.IsInf() {
+		return nil, ErrFloatOverflow
+	}
+	sum := new(big.Float).SetPrec(longDoublePrec).SetMode(longDoubleRounding)
+	sum.Add(value, incr)
+	if sum.MantExp(nil) > longDoubleMaxExp {
+		return nil, ErrFloatOverflow
+	}
+	return sum, nil
+}
+
+// FormatLongDouble formats f as Redis replies to INCRBYFLOAT: 17 decimal
+// places with trailing zeros removed, never in exponent notation.
+func FormatLongDouble(f *big.Float) string {
+	s := f.Text('f', 17)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(s, "0")
+		s = strings.TrimSuffix(s, ".")
+	}
+	if s == "-0" {
+		return "0"
+	}
+	return s
+}
@@ -0,0 +1,59 @@
+package util_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ttn-nguyen42/gedis/util"
+)
+
+func TestAddLongDouble(t *testing.T) {
+	tests := []struct {
+		value, incr string
+		want        string
+	}{
+		{"10.50", "0.1", "10.6"},
+		{"0.1", "0.2", "0.3"},
+		{"5.0e3", "2.0e2", "5200"},
+		{"0", "-0", "0"},
+		{"1", "-1.5", "-0.5"},
+		{"3", "1e18", "1000000000000000003"},
+	}
+	for _, tt := range tests {
+		value, err := util.ParseLongDouble(tt.value)
+		if err != nil {
+			t.Fatalf("ParseLongDouble(%q) error = %v", tt.value, err)
+		}
+		incr, err := util.ParseLongDouble(tt.incr)
+		if err != nil {
+			t.Fatalf("ParseLongDouble(%q) error = %v", tt.incr, err)
+		}
+		sum, err := util.AddLongDouble(value, incr)
+		if err != nil {
+			t.Fatalf("AddLongDouble(%s, %s) error = %v", tt.value, tt.incr, err)
+		}
+		if got := util.FormatLongDouble(sum); got != tt.want {
+			t.Errorf("%s + %s = %s, want %s", tt.value, tt.incr, got, tt.want)
+		}
+	}
+}
+
+func TestParseLongDouble_Invalid(t *testing.T) {
+	for _, s := range []string{"", "abc", " 1", "1 ", "nan", "1.2.3"} {
+		if _, err := util.ParseLongDouble(s); !errors.Is(err, util.ErrNotFloat) {
+			t.Errorf("ParseLongDouble(%q) error = %v, want %v", s, err, util.ErrNotFloat)
+		}
+	}
+}
+
+func TestAddLongDouble_Overflow(t *testing.T) {
+	huge, _ := util.ParseLongDouble("1e4932")
+	inf, _ := util.ParseLongDouble("inf")
+	one, _ := util.ParseLongDouble("1")
+	if _, err := util.AddLongDouble(huge, huge); !errors.Is(err, util.ErrFloatOverflow) {
+		t.Errorf("AddLongDouble(1e4932, 1e4932) error = %v, want %v", err, util.ErrFloatOverflow)
+	}
+	if _, err := util.AddLongDouble(one, inf); !errors.Is(err, util.ErrFloatOverflow) {
+		t.Errorf("AddLongDouble(1, inf) error = %v, want %v", err, util.ErrFloatOverflow)
+	}
+}
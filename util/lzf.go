@@ -0,0 +1,54 @@
+package util
+
+import "errors"
+
+var ErrLzfCorrupted = errors.New("corrupted lzf data")
+
+// LzfDecompress inflates data compressed with liblzf, the compressor used by
+// Redis for RDB strings, into a buffer of exactly size bytes.
+func LzfDecompress(in []byte, size int) ([]byte, error) {
+	out := make([]byte, 0, size)
+	ip := 0
+	for ip < len(in) {
+		ctrl := int(in[ip])
+		ip += 1
+
+		if ctrl < 1<<5 {
+			// literal run of ctrl+1 bytes
+			n := ctrl + 1
+			if ip+n > len(in) || len(out)+n > size {
+				return nil, ErrLzfCorrupted
+			}
+			out = append(out, in[ip:ip+n]...)
+			ip += n
+			continue
+		}
+
+		// back reference
+		n := ctrl >> 5
+		if n == 7 {
+			if ip >= len(in) {
+				return nil, ErrLzfCorrupted
+			}
+			n += int(in[ip])
+			ip += 1
+		}
+		if ip >= len(in) {
+			return nil, ErrLzfCorrupted
+		}
+		ref := len(out) - ((ctrl & 0x1f) << 8) - 1 - int(in[ip])
+		ip += 1
+		n += 2
+		if ref < 0 || len(out)+n > size {
+			return nil, ErrLzfCorrupted
+		}
+		// the reference may overlap the bytes being written
+		for i := 0; i < n; i += 1 {
+			out = append(out, out[ref+i])
+		}
+	}
+	if len(out) != size {
+		return nil, ErrLzfCorrupted
+	}
+	return out, nil
+}
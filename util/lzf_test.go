@@ -0,0 +1,27 @@
+package util_test
+
+import (
+	"testing"
+
+	"github.com/ttn-nguyen42/gedis/util"
+)
+
+func TestLzfDecompress(t *testing.T) {
+	// literal "abc" followed by a 6 byte back reference 3 bytes behind,
+	// overlapping the bytes it produces
+	in := []byte{0x02, 'a', 'b', 'c', 0x80, 0x02}
+	out, err := util.LzfDecompress(in, 9)
+	if err != nil {
+		t.Fatalf("LzfDecompress() error = %v", err)
+	}
+	if string(out) != "abcabcabc" {
+		t.Errorf("LzfDecompress() = %q, want %q", out, "abcabcabc")
+	}
+
+	if _, err := util.LzfDecompress(in, 8); err == nil {
+		t.Error("LzfDecompress() with a wrong size should fail")
+	}
+	if _, err := util.LzfDecompress([]byte{0x80, 0x05}, 3); err == nil {
+		t.Error("LzfDecompress() with a reference before the start should fail")
+	}
+}